@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"desafio-api/api/middleware"
+	"desafio-api/internal/audit"
+	"desafio-api/internal/domain"
+)
+
+type ProviderAdminService interface {
+	ProviderStatuses() []domain.ProviderStatus
+	SetProviderMode(actor domain.Actor, providerID string, mode domain.ProviderMode) (*domain.ProviderStatus, error)
+	ResetProvider(actor domain.Actor, providerID string) (*domain.ProviderStatus, error)
+	AuditEvents(filter domain.AuditFilter) ([]domain.AuditEvent, error)
+}
+
+type AdminHandler struct {
+	service ProviderAdminService
+}
+
+func NewAdminHandler(service ProviderAdminService) *AdminHandler {
+	return &AdminHandler{
+		service: service,
+	}
+}
+
+func (h *AdminHandler) ListProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": h.service.ProviderStatuses()})
+}
+
+// OpenProvider takes the provider out of rotation.
+func (h *AdminHandler) OpenProvider(c *gin.Context) {
+	h.setProviderMode(c, domain.ProviderModeForcedOpen)
+}
+
+// CloseProvider keeps the provider in rotation whatever its breaker says.
+func (h *AdminHandler) CloseProvider(c *gin.Context) {
+	h.setProviderMode(c, domain.ProviderModeForcedClosed)
+}
+
+// DrainProvider stops new charges but still allows refunds and confirmations.
+func (h *AdminHandler) DrainProvider(c *gin.Context) {
+	h.setProviderMode(c, domain.ProviderModeDraining)
+}
+
+// ResetProvider hands the provider back to its breaker with zeroed counters.
+func (h *AdminHandler) ResetProvider(c *gin.Context) {
+	status, err := h.service.ResetProvider(middleware.AdminActor(c), c.Param("id"))
+	if err != nil {
+		h.providerError(c, "failed to reset provider: ", err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// ExportAudit streams the audit log as NDJSON, optionally filtered by
+// resourceType, resourceId, merchantId and since (RFC 3339).
+func (h *AdminHandler) ExportAudit(c *gin.Context) {
+	filter := domain.AuditFilter{
+		ResourceType: c.Query("resourceType"),
+		ResourceID:   c.Query("resourceId"),
+		MerchantID:   c.Query("merchantId"),
+	}
+	since, err := parseTimeQuery(c, "since")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filter: " + err.Error()})
+		return
+	}
+	filter.Since = since
+
+	events, err := h.service.AuditEvents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export audit log: " + err.Error()})
+		return
+	}
+	writeNDJSON(c, events)
+}
+
+func (h *AdminHandler) setProviderMode(c *gin.Context, mode domain.ProviderMode) {
+	status, err := h.service.SetProviderMode(middleware.AdminActor(c), c.Param("id"), mode)
+	if err != nil {
+		h.providerError(c, "failed to set provider mode: ", err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *AdminHandler) providerError(c *gin.Context, message string, err error) {
+	if errors.Is(err, domain.ErrProviderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message + err.Error()})
+}
+
+func writeNDJSON(c *gin.Context, events []domain.AuditEvent) {
+	c.Header("Content-Type", audit.NDJSONContentType)
+	c.Status(http.StatusOK)
+	if err := audit.WriteNDJSON(c.Writer, events); err != nil {
+		log.Printf("failed to write audit events: %v", err)
+	}
+}
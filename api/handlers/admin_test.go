@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"desafio-api/api/middleware"
+	"desafio-api/internal/domain"
+)
+
+type MockProviderAdminService struct {
+	mock.Mock
+}
+
+func (m *MockProviderAdminService) ProviderStatuses() []domain.ProviderStatus {
+	return m.Called().Get(0).([]domain.ProviderStatus)
+}
+
+func (m *MockProviderAdminService) SetProviderMode(actor domain.Actor, providerID string, mode domain.ProviderMode) (*domain.ProviderStatus, error) {
+	args := m.Called(actor, providerID, mode)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.ProviderStatus), args.Error(1)
+}
+
+func (m *MockProviderAdminService) ResetProvider(actor domain.Actor, providerID string) (*domain.ProviderStatus, error) {
+	args := m.Called(actor, providerID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.ProviderStatus), args.Error(1)
+}
+
+func (m *MockProviderAdminService) AuditEvents(filter domain.AuditFilter) ([]domain.AuditEvent, error) {
+	args := m.Called(filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]domain.AuditEvent), args.Error(1)
+}
+
+const testAdminID = "ops"
+
+var testAdmin = domain.Actor{Type: domain.ActorAdmin, ID: testAdminID}
+
+func setupAdminRouter(service *MockProviderAdminService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(middleware.AdminIDKey, testAdminID)
+	})
+	handler := NewAdminHandler(service)
+
+	router.GET("/admin/providers", handler.ListProviders)
+	router.POST("/admin/providers/:id/open", handler.OpenProvider)
+	router.POST("/admin/providers/:id/close", handler.CloseProvider)
+	router.POST("/admin/providers/:id/drain", handler.DrainProvider)
+	router.POST("/admin/providers/:id/reset", handler.ResetProvider)
+	router.GET("/admin/audit", handler.ExportAudit)
+	return router
+}
+
+func TestAdminHandler(t *testing.T) {
+	t.Run("provider statuses", func(t *testing.T) {
+		service := new(MockProviderAdminService)
+		service.On("ProviderStatuses").Return([]domain.ProviderStatus{
+			{ID: "stripe", Name: "Stripe", Mode: domain.ProviderModeAuto, Breaker: "open", ProviderStats: domain.ProviderStats{Samples: 4, SuccessRate: 0.25, LastError: "timeout"}},
+		})
+		router := setupAdminRouter(service)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/providers", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Providers []domain.ProviderStatus `json:"providers"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Len(t, response.Providers, 1)
+		assert.Equal(t, "open", response.Providers[0].Breaker)
+		assert.Equal(t, 0.25, response.Providers[0].SuccessRate)
+		assert.Equal(t, "timeout", response.Providers[0].LastError)
+	})
+
+	modes := map[string]domain.ProviderMode{
+		"open":  domain.ProviderModeForcedOpen,
+		"close": domain.ProviderModeForcedClosed,
+		"drain": domain.ProviderModeDraining,
+	}
+	for action, mode := range modes {
+		t.Run(action+" provider", func(t *testing.T) {
+			service := new(MockProviderAdminService)
+			service.On("SetProviderMode", testAdmin, "braintree", mode).Return(&domain.ProviderStatus{ID: "braintree", Mode: mode, Breaker: "closed"}, nil)
+			router := setupAdminRouter(service)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/providers/braintree/"+action, nil))
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var status domain.ProviderStatus
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+			assert.Equal(t, mode, status.Mode)
+			service.AssertExpectations(t)
+		})
+	}
+
+	t.Run("reset provider", func(t *testing.T) {
+		service := new(MockProviderAdminService)
+		service.On("ResetProvider", testAdmin, "braintree").Return(&domain.ProviderStatus{ID: "braintree", Mode: domain.ProviderModeAuto, Breaker: "closed"}, nil)
+		router := setupAdminRouter(service)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/providers/braintree/reset", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		service.AssertExpectations(t)
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		service := new(MockProviderAdminService)
+		service.On("SetProviderMode", testAdmin, "adyen", domain.ProviderModeForcedOpen).Return(nil, fmt.Errorf("%w: adyen", domain.ErrProviderNotFound))
+		router := setupAdminRouter(service)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/providers/adyen/open", nil))
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("export audit log", func(t *testing.T) {
+		service := new(MockProviderAdminService)
+		since := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		service.On("AuditEvents", domain.AuditFilter{ResourceType: domain.AuditResourceProvider, Since: &since}).Return([]domain.AuditEvent{
+			{ID: "event-1", Sequence: 1, Actor: testAdmin, Action: domain.AuditProviderModeSet, ResourceType: domain.AuditResourceProvider, ResourceID: "braintree"},
+		}, nil)
+		router := setupAdminRouter(service)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/audit?resourceType=provider&since=2025-01-01T00:00:00Z", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		var event domain.AuditEvent
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &event))
+		assert.Equal(t, domain.AuditProviderModeSet, event.Action)
+		assert.Equal(t, testAdminID, event.Actor.ID)
+	})
+
+	t.Run("export with invalid since", func(t *testing.T) {
+		router := setupAdminRouter(new(MockProviderAdminService))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/audit?since=yesterday", nil))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
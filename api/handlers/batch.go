@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"desafio-api/api/middleware"
+	"desafio-api/internal/batch"
+	"desafio-api/internal/domain"
+)
+
+type BatchProcessor interface {
+	Submit(actor domain.Actor, format domain.BatchFormat, r io.Reader) (*domain.Batch, error)
+	Get(merchantID string, batchID string) (*domain.Batch, error)
+}
+
+type BatchHandler struct {
+	processor    BatchProcessor
+	maxFileBytes int64
+}
+
+func NewBatchHandler(processor BatchProcessor, maxFileBytes int64) *BatchHandler {
+	return &BatchHandler{
+		processor:    processor,
+		maxFileBytes: maxFileBytes,
+	}
+}
+
+// SubmitBatch accepts a batch file as the "file" field of a multipart form
+// or as the request body. The format comes from ?format=, the file
+// extension or the Content-Type, in that order.
+func (h *BatchHandler) SubmitBatch(c *gin.Context) {
+	if h.maxFileBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxFileBytes)
+	}
+
+	var file io.Reader = c.Request.Body
+	format := domain.BatchFormat(c.Query("format"))
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		header, err := c.FormFile("file")
+		if err != nil {
+			h.submitError(c, err)
+			return
+		}
+		upload, err := header.Open()
+		if err != nil {
+			h.submitError(c, err)
+			return
+		}
+		defer upload.Close()
+		file = upload
+		if format == "" {
+			format = formatOf(header.Filename, header.Header.Get("Content-Type"))
+		}
+	} else if format == "" {
+		format = formatOf("", c.ContentType())
+	}
+	if format == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid request: format must be %q or %q", domain.BatchCSV, domain.BatchNDJSON)})
+		return
+	}
+
+	submitted, err := h.processor.Submit(middleware.MerchantActor(c), format, file)
+	if err != nil {
+		h.submitError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, submitted)
+}
+
+func (h *BatchHandler) submitError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("batch file is larger than %d bytes", tooLarge.Limit)})
+	case errors.Is(err, domain.ErrInvalidBatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, http.ErrMissingFile), errors.Is(err, http.ErrNotMultipart):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+	case errors.Is(err, batch.ErrStopped):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to submit batch: " + err.Error()})
+	}
+}
+
+func formatOf(filename string, contentType string) domain.BatchFormat {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return domain.BatchCSV
+	case ".ndjson", ".jsonl":
+		return domain.BatchNDJSON
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case batch.CSVContentType:
+		return domain.BatchCSV
+	case batch.NDJSONContentType:
+		return domain.BatchNDJSON
+	}
+	return ""
+}
+
+// GetBatch returns the progress of a batch.
+func (h *BatchHandler) GetBatch(c *gin.Context) {
+	submitted, err := h.processor.Get(middleware.MerchantID(c), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, domain.ErrBatchNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get batch: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, submitted)
+}
+
+// BatchResults downloads the result of every line of a completed batch, in
+// the format of the uploaded file or the one in ?format=.
+func (h *BatchHandler) BatchResults(c *gin.Context) {
+	submitted, err := h.processor.Get(middleware.MerchantID(c), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, domain.ErrBatchNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get batch: " + err.Error()})
+		return
+	}
+	if submitted.Status != domain.BatchCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "batch is still processing"})
+		return
+	}
+
+	format := submitted.Format
+	if value := c.Query("format"); value != "" {
+		format = domain.BatchFormat(value)
+	}
+	contentType := batch.CSVContentType
+	switch format {
+	case domain.BatchCSV:
+	case domain.BatchNDJSON:
+		contentType = batch.NDJSONContentType
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid request: format must be %q or %q", domain.BatchCSV, domain.BatchNDJSON)})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=batch-%s-results.%s", submitted.ID, format))
+	c.Status(http.StatusOK)
+	if err := batch.WriteResults(c.Writer, format, submitted.Results); err != nil {
+		log.Printf("failed to write results of batch %s: %v", submitted.ID, err)
+	}
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/api/middleware"
+	"desafio-api/internal/domain"
+)
+
+type MockBatchProcessor struct {
+	mock.Mock
+}
+
+func (m *MockBatchProcessor) Submit(actor domain.Actor, format domain.BatchFormat, r io.Reader) (*domain.Batch, error) {
+	content, _ := io.ReadAll(r)
+	args := m.Called(actor, format, string(content))
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Batch), args.Error(1)
+}
+
+func (m *MockBatchProcessor) Get(merchantID string, batchID string) (*domain.Batch, error) {
+	args := m.Called(merchantID, batchID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Batch), args.Error(1)
+}
+
+func setupBatchRouter(processor *MockBatchProcessor) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(middleware.MerchantIDKey, testMerchantID)
+		c.Set(middleware.APIKeyIDKey, testAPIKeyID)
+	})
+	handler := NewBatchHandler(processor, 1024)
+
+	router.POST("/batches", handler.SubmitBatch)
+	router.GET("/batches/:id", handler.GetBatch)
+	router.GET("/batches/:id/results", handler.BatchResults)
+
+	return router
+}
+
+func TestBatchHandler_SubmitBatch(t *testing.T) {
+	file := "operation,paymentId,amount\nrefund,pay-1,10\n"
+
+	t.Run("multipart upload", func(t *testing.T) {
+		processor := new(MockBatchProcessor)
+		router := setupBatchRouter(processor)
+		processor.On("Submit", testActor, domain.BatchCSV, file).Return(&domain.Batch{ID: "batch-1", Status: domain.BatchProcessing, Total: 1}, nil)
+
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("file", "refunds.csv")
+		require.NoError(t, err)
+		part.Write([]byte(file))
+		writer.Close()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/batches", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Contains(t, w.Body.String(), `"id":"batch-1"`)
+		processor.AssertExpectations(t)
+	})
+
+	t.Run("raw body", func(t *testing.T) {
+		processor := new(MockBatchProcessor)
+		router := setupBatchRouter(processor)
+		ndjson := `{"operation":"refund","paymentId":"pay-1","amount":10}` + "\n"
+		processor.On("Submit", testActor, domain.BatchNDJSON, ndjson).Return(&domain.Batch{ID: "batch-2"}, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/batches", strings.NewReader(ndjson))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		processor.AssertExpectations(t)
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		router := setupBatchRouter(new(MockBatchProcessor))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/batches", strings.NewReader(file))
+		req.Header.Set("Content-Type", "text/plain")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		processor := new(MockBatchProcessor)
+		router := setupBatchRouter(processor)
+		processor.On("Submit", testActor, domain.BatchCSV, "key\n").Return(nil, fmt.Errorf("%w: missing column \"operation\"", domain.ErrInvalidBatch))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/batches?format=csv", strings.NewReader("key\n"))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		processor.AssertExpectations(t)
+	})
+
+	t.Run("file too large", func(t *testing.T) {
+		processor := new(MockBatchProcessor)
+		router := setupBatchRouter(processor)
+		processor.On("Submit", testActor, domain.BatchCSV, mock.Anything).Return(nil, &http.MaxBytesError{Limit: 1024})
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/batches", strings.NewReader(strings.Repeat("x", 2048)))
+		req.Header.Set("Content-Type", "text/csv")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	})
+}
+
+func TestBatchHandler_GetBatch(t *testing.T) {
+	processor := new(MockBatchProcessor)
+	router := setupBatchRouter(processor)
+	completedAt := time.Now()
+	processor.On("Get", testMerchantID, "batch-1").Return(&domain.Batch{
+		ID:          "batch-1",
+		Format:      domain.BatchCSV,
+		Status:      domain.BatchCompleted,
+		Total:       1,
+		Processed:   1,
+		Succeeded:   1,
+		CompletedAt: &completedAt,
+		Results: []domain.BatchLineResult{
+			{Line: 2, Key: "r1", Operation: domain.BatchRefund, Status: domain.BatchLineSucceeded, PaymentID: "pay-1", PaymentStatus: domain.StatusRefunded},
+		},
+	}, nil)
+	processor.On("Get", testMerchantID, "batch-2").Return(&domain.Batch{ID: "batch-2", Status: domain.BatchProcessing}, nil)
+	processor.On("Get", testMerchantID, "missing").Return(nil, domain.ErrBatchNotFound)
+
+	t.Run("progress", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/batches/batch-1", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "completed", response["status"])
+		assert.Equal(t, float64(1), response["succeeded"])
+		assert.NotContains(t, response, "results")
+	})
+
+	t.Run("results download", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/batches/batch-1/results", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Header().Get("Content-Disposition"), "batch-batch-1-results.csv")
+		assert.Equal(t, "line,key,operation,status,paymentId,paymentStatus,error\n2,r1,refund,succeeded,pay-1,refunded,\n", w.Body.String())
+	})
+
+	t.Run("results as ndjson", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/batches/batch-1/results?format=ndjson", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"paymentId":"pay-1"`)
+	})
+
+	t.Run("results of a batch still processing", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/batches/batch-2/results", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("batch not found", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/batches/missing", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"desafio-api/api/middleware"
+	"desafio-api/internal/domain"
+	"desafio-api/internal/webhook"
+)
+
+// maxNotificationBytes bounds the body of provider webhooks.
+const maxNotificationBytes = 1 << 20
+
+// multipartOverhead is allowed on top of the evidence file for the rest of
+// the multipart body.
+const multipartOverhead = 1 << 16
+
+type DisputeService interface {
+	Create(actor domain.Actor, request domain.DisputeRequest) (*domain.Dispute, error)
+	Update(actor domain.Actor, disputeID string, update domain.DisputeUpdate) (*domain.Dispute, error)
+	HandleNotification(providerID string, body []byte, timestamp string, signature string) (*domain.Dispute, error)
+	AddEvidence(actor domain.Actor, disputeID string, filename string, description string, file io.Reader) (*domain.Evidence, error)
+	Dispute(disputeID string) (*domain.Dispute, error)
+	MerchantDispute(merchantID string, disputeID string) (*domain.Dispute, error)
+	Disputes(filter domain.DisputeFilter) ([]*domain.Dispute, error)
+}
+
+type DisputeHandler struct {
+	service          DisputeService
+	maxEvidenceBytes int64
+}
+
+func NewDisputeHandler(service DisputeService, maxEvidenceBytes int64) *DisputeHandler {
+	return &DisputeHandler{
+		service:          service,
+		maxEvidenceBytes: maxEvidenceBytes,
+	}
+}
+
+// ListDisputes lists the disputes of the merchant, optionally filtered by
+// status and paymentId.
+func (h *DisputeHandler) ListDisputes(c *gin.Context) {
+	h.listDisputes(c, middleware.MerchantID(c))
+}
+
+func (h *DisputeHandler) GetDispute(c *gin.Context) {
+	found, err := h.service.MerchantDispute(middleware.MerchantID(c), c.Param("id"))
+	if err != nil {
+		h.respondError(c, "failed to get dispute: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, found)
+}
+
+// UploadEvidence attaches the multipart "file" to a dispute of the
+// merchant, with the optional "description" field.
+func (h *DisputeHandler) UploadEvidence(c *gin.Context) {
+	if h.maxEvidenceBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxEvidenceBytes+multipartOverhead)
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		h.respondError(c, "failed to upload evidence: ", err)
+		return
+	}
+	upload, err := header.Open()
+	if err != nil {
+		h.respondError(c, "failed to upload evidence: ", err)
+		return
+	}
+	defer upload.Close()
+
+	evidence, err := h.service.AddEvidence(middleware.MerchantActor(c), c.Param("id"), header.Filename, c.PostForm("description"), upload)
+	if err != nil {
+		h.respondError(c, "failed to upload evidence: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, evidence)
+}
+
+// CreateDispute opens a dispute through the admin API, for providers that
+// don't send dispute webhooks.
+func (h *DisputeHandler) CreateDispute(c *gin.Context) {
+	var request domain.DisputeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	created, err := h.service.Create(middleware.AdminActor(c), request)
+	if err != nil {
+		h.respondError(c, "failed to create dispute: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// AdminListDisputes lists the disputes of every merchant, optionally
+// filtered by merchantId, status and paymentId.
+func (h *DisputeHandler) AdminListDisputes(c *gin.Context) {
+	h.listDisputes(c, c.Query("merchantId"))
+}
+
+func (h *DisputeHandler) AdminGetDispute(c *gin.Context) {
+	found, err := h.service.Dispute(c.Param("id"))
+	if err != nil {
+		h.respondError(c, "failed to get dispute: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, found)
+}
+
+// UpdateDispute changes the status of a dispute, closing it when it is won
+// or lost.
+func (h *DisputeHandler) UpdateDispute(c *gin.Context) {
+	var update domain.DisputeUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	updated, err := h.service.Update(middleware.AdminActor(c), c.Param("id"), update)
+	if err != nil {
+		h.respondError(c, "failed to update dispute: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// ProviderNotification receives the dispute webhooks of the provider in
+// the path, signed in the X-Webhook-Signature header along with the
+// X-Webhook-Timestamp header.
+func (h *DisputeHandler) ProviderNotification(c *gin.Context) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxNotificationBytes))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	notified, err := h.service.HandleNotification(c.Param("id"), body, c.GetHeader(webhook.TimestampHeader), c.GetHeader(webhook.SignatureHeader))
+	if err != nil {
+		h.respondError(c, "failed to process dispute notification: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, notified)
+}
+
+func (h *DisputeHandler) listDisputes(c *gin.Context, merchantID string) {
+	disputes, err := h.service.Disputes(domain.DisputeFilter{
+		MerchantID: merchantID,
+		PaymentID:  c.Query("paymentId"),
+		Status:     domain.DisputeStatus(c.Query("status")),
+	})
+	if err != nil {
+		h.respondError(c, "failed to list disputes: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": disputes})
+}
+
+func (h *DisputeHandler) respondError(c *gin.Context, message string, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("evidence file is larger than %d bytes", h.maxEvidenceBytes)})
+	case errors.Is(err, domain.ErrEvidenceTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrInvalidSignature):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrInvalidDispute), errors.Is(err, domain.ErrInvalidEvidence),
+		errors.Is(err, http.ErrMissingFile), errors.Is(err, http.ErrNotMultipart):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+	case errors.Is(err, domain.ErrDisputeNotFound), errors.Is(err, domain.ErrPaymentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrDisputeClosed), errors.Is(err, domain.ErrEvidenceNotAccepted), errors.Is(err, domain.ErrInvalidStatus):
+		c.JSON(http.StatusConflict, gin.H{"error": message + err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message + err.Error()})
+	}
+}
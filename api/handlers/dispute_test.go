@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/api/middleware"
+	"desafio-api/internal/domain"
+	"desafio-api/internal/webhook"
+)
+
+type MockDisputeService struct {
+	mock.Mock
+}
+
+func (m *MockDisputeService) Create(actor domain.Actor, request domain.DisputeRequest) (*domain.Dispute, error) {
+	return m.dispute(m.Called(actor, request))
+}
+
+func (m *MockDisputeService) Update(actor domain.Actor, disputeID string, update domain.DisputeUpdate) (*domain.Dispute, error) {
+	return m.dispute(m.Called(actor, disputeID, update))
+}
+
+func (m *MockDisputeService) HandleNotification(providerID string, body []byte, timestamp string, signature string) (*domain.Dispute, error) {
+	return m.dispute(m.Called(providerID, string(body), timestamp, signature))
+}
+
+func (m *MockDisputeService) AddEvidence(actor domain.Actor, disputeID string, filename string, description string, file io.Reader) (*domain.Evidence, error) {
+	content, _ := io.ReadAll(file)
+	args := m.Called(actor, disputeID, filename, description, string(content))
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Evidence), args.Error(1)
+}
+
+func (m *MockDisputeService) Dispute(disputeID string) (*domain.Dispute, error) {
+	return m.dispute(m.Called(disputeID))
+}
+
+func (m *MockDisputeService) MerchantDispute(merchantID string, disputeID string) (*domain.Dispute, error) {
+	return m.dispute(m.Called(merchantID, disputeID))
+}
+
+func (m *MockDisputeService) Disputes(filter domain.DisputeFilter) ([]*domain.Dispute, error) {
+	args := m.Called(filter)
+	return args.Get(0).([]*domain.Dispute), args.Error(1)
+}
+
+func (m *MockDisputeService) dispute(args mock.Arguments) (*domain.Dispute, error) {
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Dispute), args.Error(1)
+}
+
+func setupDisputeRouter(service *MockDisputeService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := NewDisputeHandler(service, 1024)
+
+	router.POST("/webhooks/providers/:id/disputes", handler.ProviderNotification)
+	admin := router.Group("/admin", func(c *gin.Context) {
+		c.Set(middleware.AdminIDKey, testAdminID)
+	})
+	admin.POST("/disputes", handler.CreateDispute)
+	admin.POST("/disputes/:id/status", handler.UpdateDispute)
+	merchant := router.Group("/", func(c *gin.Context) {
+		c.Set(middleware.MerchantIDKey, testMerchantID)
+		c.Set(middleware.APIKeyIDKey, testAPIKeyID)
+	})
+	merchant.GET("/disputes", handler.ListDisputes)
+	merchant.GET("/disputes/:id", handler.GetDispute)
+	merchant.POST("/disputes/:id/evidence", handler.UploadEvidence)
+
+	return router
+}
+
+func TestDisputeHandler_Admin(t *testing.T) {
+	service := new(MockDisputeService)
+	router := setupDisputeRouter(service)
+
+	t.Run("create", func(t *testing.T) {
+		request := domain.DisputeRequest{PaymentID: "pay-1", ReasonCode: "10.4"}
+		service.On("Create", testAdmin, request).Return(&domain.Dispute{ID: "dispute-1", PaymentID: "pay-1", Status: domain.DisputeNeedsResponse}, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/disputes", strings.NewReader(`{"paymentId": "pay-1", "reasonCode": "10.4"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"status":"needs_response"`)
+	})
+
+	t.Run("payment that can't be disputed", func(t *testing.T) {
+		request := domain.DisputeRequest{PaymentID: "pay-2", ReasonCode: "10.4"}
+		service.On("Create", testAdmin, request).Return(nil, fmt.Errorf("%w: payment cannot be disputed: status is failed", domain.ErrInvalidStatus))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/disputes", strings.NewReader(`{"paymentId": "pay-2", "reasonCode": "10.4"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("closed disputes don't change", func(t *testing.T) {
+		update := domain.DisputeUpdate{Status: domain.DisputeWon}
+		service.On("Update", testAdmin, "dispute-1", update).Return(nil, fmt.Errorf("%w: it was lost", domain.ErrDisputeClosed))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/admin/disputes/dispute-1/status", strings.NewReader(`{"status": "won"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	service.AssertExpectations(t)
+}
+
+func TestDisputeHandler_ProviderNotification(t *testing.T) {
+	service := new(MockDisputeService)
+	router := setupDisputeRouter(service)
+	body := `{"disputeId": "dp_1", "paymentId": "ch_1", "reasonCode": "fraudulent"}`
+	signature := webhook.SignTimestamped("secret", "1714564800", []byte(body))
+	service.On("HandleNotification", "stripe", body, "1714564800", signature).Return(&domain.Dispute{ID: "dispute-1", ProviderDisputeID: "dp_1"}, nil)
+	service.On("HandleNotification", "stripe", body, "", "").Return(nil, domain.ErrInvalidSignature)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/webhooks/providers/stripe/disputes", strings.NewReader(body))
+	req.Header.Set(webhook.TimestampHeader, "1714564800")
+	req.Header.Set(webhook.SignatureHeader, signature)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/webhooks/providers/stripe/disputes", strings.NewReader(body))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	service.AssertExpectations(t)
+}
+
+func TestDisputeHandler_UploadEvidence(t *testing.T) {
+	upload := func(router *gin.Engine, filename string, content string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		writer.WriteField("description", "delivery receipt")
+		part, err := writer.CreateFormFile("file", filename)
+		require.NoError(t, err)
+		part.Write([]byte(content))
+		writer.Close()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/disputes/dispute-1/evidence", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("uploaded", func(t *testing.T) {
+		service := new(MockDisputeService)
+		router := setupDisputeRouter(service)
+		service.On("AddEvidence", testActor, "dispute-1", "receipt.txt", "delivery receipt", "delivered").Return(&domain.Evidence{ID: "evidence-1", Filename: "receipt.txt", ContentType: "text/plain", Size: 9}, nil)
+
+		w := upload(router, "receipt.txt", "delivered")
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"id":"evidence-1"`)
+		service.AssertExpectations(t)
+	})
+
+	t.Run("dispute under review", func(t *testing.T) {
+		service := new(MockDisputeService)
+		router := setupDisputeRouter(service)
+		service.On("AddEvidence", testActor, "dispute-1", "receipt.txt", "delivery receipt", "delivered").Return(nil, fmt.Errorf("%w: status is under_review", domain.ErrEvidenceNotAccepted))
+
+		w := upload(router, "receipt.txt", "delivered")
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		service := new(MockDisputeService)
+		router := setupDisputeRouter(service)
+
+		w := upload(router, "receipt.txt", strings.Repeat("a", 1024+1<<16))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		service.AssertNotCalled(t, "AddEvidence", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type HealthService interface {
+	Ready() error
+}
+
+type HealthHandler struct {
+	service HealthService
+}
+
+func NewHealthHandler(service HealthService) *HealthHandler {
+	return &HealthHandler{
+		service: service,
+	}
+}
+
+// Healthz answers as long as the process is serving requests.
+func (h *HealthHandler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz fails while payments can't be processed, so the load balancer
+// stops sending traffic to this instance.
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	if err := h.service.Ready(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
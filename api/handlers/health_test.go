@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockHealthService struct {
+	mock.Mock
+}
+
+func (m *MockHealthService) Ready() error {
+	return m.Called().Error(0)
+}
+
+func setupHealthRouter(service *MockHealthService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := NewHealthHandler(service)
+
+	router.GET("/healthz", handler.Healthz)
+	router.GET("/readyz", handler.Readyz)
+	return router
+}
+
+func TestHealthHandler(t *testing.T) {
+	t.Run("liveness", func(t *testing.T) {
+		router := setupHealthRouter(new(MockHealthService))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		service := new(MockHealthService)
+		service.On("Ready").Return(nil)
+		router := setupHealthRouter(service)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("not ready", func(t *testing.T) {
+		service := new(MockHealthService)
+		service.On("Ready").Return(errors.New("no provider available for charges"))
+		router := setupHealthRouter(service)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), "no provider available for charges")
+	})
+
+}
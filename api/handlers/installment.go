@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"desafio-api/internal/domain"
+)
+
+type InstallmentSimulator interface {
+	Simulate(amount float64, currency string, start time.Time) ([]*domain.InstallmentPlan, error)
+}
+
+type InstallmentHandler struct {
+	simulator InstallmentSimulator
+}
+
+func NewInstallmentHandler(simulator InstallmentSimulator) *InstallmentHandler {
+	return &InstallmentHandler{
+		simulator: simulator,
+	}
+}
+
+// Simulate lists the installment plans available for an amount, for
+// checkout pages to offer.
+func (h *InstallmentHandler) Simulate(c *gin.Context) {
+	amount, err := parseFloatQuery(c, "amount")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+	currency := c.Query("currency")
+	if amount == nil || currency == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: amount and currency are required"})
+		return
+	}
+
+	plans, err := h.simulator.Simulate(*amount, currency, time.Now())
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInstallments) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to simulate installments: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": plans})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+	"desafio-api/internal/installments"
+)
+
+func setupInstallmentRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	calculator, err := installments.NewCalculator([]config.InstallmentConfig{{
+		Currency:            "BRL",
+		MinInstallments:     2,
+		MaxInstallments:     12,
+		MinInstallmentValue: 5,
+		InterestFree:        3,
+		Interest:            "merchant",
+		MonthlyRate:         0.0199,
+	}})
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.GET("/installments/simulate", NewInstallmentHandler(calculator).Simulate)
+	return router
+}
+
+func TestInstallmentHandler_Simulate(t *testing.T) {
+	router := setupInstallmentRouter(t)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/installments/simulate?amount=1000&currency=BRL", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Data []domain.InstallmentPlan `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Data, 12)
+	assert.Equal(t, 1000.0, response.Data[2].TotalAmount)
+	assert.Equal(t, 1134.0, response.Data[11].TotalAmount)
+	assert.Len(t, response.Data[11].Schedule, 12)
+
+	for _, query := range []string{"amount=abc&currency=BRL", "currency=BRL", "amount=100&currency=USD"} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/installments/simulate?"+query, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
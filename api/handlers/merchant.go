@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"desafio-api/api/middleware"
+	"desafio-api/internal/domain"
+)
+
+type MerchantService interface {
+	CreateAPIKey(merchantID string) (*domain.APIKey, string, error)
+	RotateAPIKey(merchantID, keyID string) (*domain.APIKey, string, error)
+	RevokeAPIKey(merchantID, keyID string) error
+	ListAPIKeys(merchantID string) ([]*domain.APIKey, error)
+}
+
+type MerchantHandler struct {
+	service MerchantService
+}
+
+// APIKeyResponse is the only place a plaintext key is ever returned.
+type APIKeyResponse struct {
+	*domain.APIKey
+	Key string `json:"key"`
+}
+
+func NewMerchantHandler(service MerchantService) *MerchantHandler {
+	return &MerchantHandler{
+		service: service,
+	}
+}
+
+func (h *MerchantHandler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.service.ListAPIKeys(middleware.MerchantID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list api keys: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": keys})
+}
+
+func (h *MerchantHandler) CreateAPIKey(c *gin.Context) {
+	key, plaintext, err := h.service.CreateAPIKey(middleware.MerchantID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create api key: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, APIKeyResponse{APIKey: key, Key: plaintext})
+}
+
+func (h *MerchantHandler) RotateAPIKey(c *gin.Context) {
+	key, plaintext, err := h.service.RotateAPIKey(middleware.MerchantID(c), c.Param("id"))
+	if err != nil {
+		h.respondKeyError(c, "failed to rotate api key: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, APIKeyResponse{APIKey: key, Key: plaintext})
+}
+
+func (h *MerchantHandler) RevokeAPIKey(c *gin.Context) {
+	if err := h.service.RevokeAPIKey(middleware.MerchantID(c), c.Param("id")); err != nil {
+		h.respondKeyError(c, "failed to revoke api key: ", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *MerchantHandler) respondKeyError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, domain.ErrAPIKeyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": message + err.Error()})
+	case errors.Is(err, domain.ErrInvalidAPIKey):
+		c.JSON(http.StatusConflict, gin.H{"error": message + err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message + err.Error()})
+	}
+}
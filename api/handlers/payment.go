@@ -1,17 +1,43 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"desafio-api/api/middleware"
 	"desafio-api/internal/domain"
+	"desafio-api/internal/webhook"
 )
 
 type PaymentService interface {
-	ProcessPayment(request domain.PaymentRequest) (*domain.Payment, error)
-	RefundPayment(paymentID string, request domain.RefundRequest) (*domain.Payment, error)
-	GetPayment(paymentID string) (*domain.Payment, error)
+	ProcessPayment(actor domain.Actor, request domain.PaymentRequest) (*domain.Payment, error)
+	SubmitPayment(actor domain.Actor, request domain.PaymentRequest, callbackURL string) (*domain.Payment, error)
+	RefundPayment(actor domain.Actor, paymentID string, request domain.RefundRequest) (*domain.Payment, error)
+	ConfirmPayment(actor domain.Actor, paymentID string, request domain.ConfirmRequest) (*domain.Payment, error)
+	GetPayment(merchantID string, paymentID string) (*domain.Payment, error)
+	ListPayments(merchantID string, filter domain.PaymentFilter) (*domain.PaymentPage, error)
+	PaymentEvents(merchantID string, paymentID string) ([]domain.AuditEvent, error)
+	PaymentAttempts(merchantID string, paymentID string) ([]domain.Attempt, error)
+	PaymentRefunds(merchantID string, paymentID string) ([]domain.Refund, error)
+}
+
+// expandedPayment is a payment with the provider attempts, returned for
+// ?expand=attempts.
+type expandedPayment struct {
+	*domain.Payment
+	Attempts []domain.Attempt `json:"attempts"`
+}
+
+// asyncPaymentRequest is the body of POST /payments?async=true: the charge
+// and where to post the payment once processed.
+type asyncPaymentRequest struct {
+	domain.PaymentRequest
+	CallbackURL string `json:"callbackUrl"`
 }
 
 type PaymentHandler struct {
@@ -25,21 +51,86 @@ func NewPaymentHandler(service PaymentService) *PaymentHandler {
 }
 
 func (h *PaymentHandler) ProcessPayment(c *gin.Context) {
+	if c.Query("async") == "true" {
+		h.submitPayment(c)
+		return
+	}
+
 	var request domain.PaymentRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
 		return
 	}
 
-	payment, err := h.service.ProcessPayment(request)
+	request.ClientIP = c.ClientIP()
+
+	payment, err := h.service.ProcessPayment(middleware.MerchantActor(c), request)
 	if err != nil {
+		var rejected *domain.RiskRejectedError
+		if errors.As(err, &rejected) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":     err.Error(),
+				"code":      "payment_rejected",
+				"paymentId": rejected.PaymentID,
+			})
+			return
+		}
+		if errors.Is(err, domain.ErrInvalidInstallments) || errors.Is(err, domain.ErrUnsupportedPayment) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+			return
+		}
+		if errors.Is(err, domain.ErrProviderUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to process payment: " + err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process payment: " + err.Error()})
 		return
 	}
 
+	// The charge may or may not have gone through; it is resolved in the background
+	if payment.Status == domain.StatusUnknown {
+		c.JSON(http.StatusAccepted, payment)
+		return
+	}
 	c.JSON(http.StatusOK, payment)
 }
 
+// submitPayment queues the charge and answers 202 with the pending payment,
+// to be polled with GET /payments/:id or received on the callback URL.
+func (h *PaymentHandler) submitPayment(c *gin.Context) {
+	var request asyncPaymentRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+	if request.CallbackURL != "" {
+		// The gateway posts to it, so it must not reach internal services
+		if err := webhook.CheckURL(c.Request.Context(), request.CallbackURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: callbackUrl " + err.Error()})
+			return
+		}
+	}
+
+	request.ClientIP = c.ClientIP()
+
+	payment, err := h.service.SubmitPayment(middleware.MerchantActor(c), request.PaymentRequest, request.CallbackURL)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidInstallments) || errors.Is(err, domain.ErrUnsupportedPayment) || errors.Is(err, domain.ErrInvalidCard) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+			return
+		}
+		if errors.Is(err, domain.ErrQueueFull) {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to submit payment: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to submit payment: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, payment)
+}
+
 func (h *PaymentHandler) RefundPayment(c *gin.Context) {
 	paymentID := c.Param("id")
 	if paymentID == "" {
@@ -53,12 +144,85 @@ func (h *PaymentHandler) RefundPayment(c *gin.Context) {
 		return
 	}
 
-	payment, err := h.service.RefundPayment(paymentID, request)
+	payment, err := h.service.RefundPayment(middleware.MerchantActor(c), paymentID, request)
 	if err != nil {
+		if errors.Is(err, domain.ErrPaymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, domain.ErrProviderUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to refund payment: " + err.Error()})
+			return
+		}
+		if errors.Is(err, domain.ErrOperationNotSupported) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "failed to refund payment: " + err.Error()})
+			return
+		}
+		if errors.Is(err, domain.ErrInvalidCard) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to refund payment: " + err.Error()})
 		return
 	}
 
+	// The provider is down, the refund is retried in the background
+	if payment.Status == domain.StatusRefundPending {
+		c.JSON(http.StatusAccepted, payment)
+		return
+	}
+	c.JSON(http.StatusOK, payment)
+}
+
+// PaymentRefunds returns the refund ledger of a payment: refunds made by
+// its provider, credits made by other providers and queued refunds.
+func (h *PaymentHandler) PaymentRefunds(c *gin.Context) {
+	paymentID := c.Param("id")
+	if paymentID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payment ID is required"})
+		return
+	}
+
+	refunds, err := h.service.PaymentRefunds(middleware.MerchantID(c), paymentID)
+	if err != nil {
+		if errors.Is(err, domain.ErrPaymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list payment refunds: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"refunds": refunds})
+}
+
+func (h *PaymentHandler) ConfirmPayment(c *gin.Context) {
+	paymentID := c.Param("id")
+	if paymentID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payment ID is required"})
+		return
+	}
+
+	var request domain.ConfirmRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	payment, err := h.service.ConfirmPayment(middleware.MerchantActor(c), paymentID, request)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrPaymentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, domain.ErrInvalidStatus):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, domain.ErrProviderUnavailable):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "failed to confirm payment: " + err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to confirm payment: " + err.Error()})
+		}
+		return
+	}
+
 	c.JSON(http.StatusOK, payment)
 }
 
@@ -69,11 +233,138 @@ func (h *PaymentHandler) GetPayment(c *gin.Context) {
 		return
 	}
 
-	payment, err := h.service.GetPayment(paymentID)
+	payment, err := h.service.GetPayment(middleware.MerchantID(c), paymentID)
 	if err != nil {
+		if errors.Is(err, domain.ErrPaymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get payment: " + err.Error()})
 		return
 	}
 
+	if c.Query("expand") == "attempts" {
+		attempts, err := h.service.PaymentAttempts(middleware.MerchantID(c), paymentID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get payment attempts: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, expandedPayment{Payment: payment, Attempts: attempts})
+		return
+	}
+
 	c.JSON(http.StatusOK, payment)
 }
+
+// PaymentEvents lists the audit events of a payment, as JSON or, with
+// ?format=ndjson, one event per line.
+func (h *PaymentHandler) PaymentEvents(c *gin.Context) {
+	paymentID := c.Param("id")
+	if paymentID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payment ID is required"})
+		return
+	}
+
+	events, err := h.service.PaymentEvents(middleware.MerchantID(c), paymentID)
+	if err != nil {
+		if errors.Is(err, domain.ErrPaymentNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list payment events: " + err.Error()})
+		return
+	}
+
+	if c.Query("format") == "ndjson" {
+		writeNDJSON(c, events)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+func (h *PaymentHandler) ListPayments(c *gin.Context) {
+	filter, err := parsePaymentFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filter: " + err.Error()})
+		return
+	}
+
+	page, err := h.service.ListPayments(middleware.MerchantID(c), filter)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid filter: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list payments: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+func parsePaymentFilter(c *gin.Context) (domain.PaymentFilter, error) {
+	filter := domain.PaymentFilter{
+		Status:      domain.PaymentStatus(c.Query("status")),
+		ProviderID:  c.Query("providerId"),
+		Currency:    c.Query("currency"),
+		CardLast4:   c.Query("cardLast4"),
+		Description: c.Query("description"),
+		Cursor:      c.Query("cursor"),
+		Order:       domain.SortAsc,
+	}
+
+	var err error
+	if filter.MinAmount, err = parseFloatQuery(c, "minAmount"); err != nil {
+		return filter, err
+	}
+	if filter.MaxAmount, err = parseFloatQuery(c, "maxAmount"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedFrom, err = parseTimeQuery(c, "createdFrom"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedTo, err = parseTimeQuery(c, "createdTo"); err != nil {
+		return filter, err
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("limit must be a positive integer")
+		}
+		filter.Limit = limit
+	}
+
+	switch order := domain.SortOrder(c.DefaultQuery("order", string(domain.SortAsc))); order {
+	case domain.SortAsc, domain.SortDesc:
+		filter.Order = order
+	default:
+		return filter, fmt.Errorf("order must be %q or %q", domain.SortAsc, domain.SortDesc)
+	}
+
+	return filter, nil
+}
+
+func parseFloatQuery(c *gin.Context, key string) (*float64, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a number", key)
+	}
+	return &parsed, nil
+}
+
+func parseTimeQuery(c *gin.Context, key string) (*time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 timestamp", key)
+	}
+	return &parsed, nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -14,6 +15,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
+	"desafio-api/api/middleware"
 	"desafio-api/internal/domain"
 )
 
@@ -21,38 +23,101 @@ type MockPaymentService struct {
 	mock.Mock
 }
 
-func (m *MockPaymentService) ProcessPayment(request domain.PaymentRequest) (*domain.Payment, error) {
-	args := m.Called(request)
+const (
+	testMerchantID = "merchant-test"
+	testAPIKeyID   = "key-test"
+)
+
+var testActor = domain.Actor{Type: domain.ActorMerchant, ID: testAPIKeyID, MerchantID: testMerchantID}
+
+func (m *MockPaymentService) ProcessPayment(actor domain.Actor, request domain.PaymentRequest) (*domain.Payment, error) {
+	args := m.Called(actor, request)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) SubmitPayment(actor domain.Actor, request domain.PaymentRequest, callbackURL string) (*domain.Payment, error) {
+	args := m.Called(actor, request, callbackURL)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Payment), args.Error(1)
 }
 
-func (m *MockPaymentService) RefundPayment(paymentID string, request domain.RefundRequest) (*domain.Payment, error) {
-	args := m.Called(paymentID, request)
+func (m *MockPaymentService) RefundPayment(actor domain.Actor, paymentID string, request domain.RefundRequest) (*domain.Payment, error) {
+	args := m.Called(actor, paymentID, request)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Payment), args.Error(1)
 }
 
-func (m *MockPaymentService) GetPayment(paymentID string) (*domain.Payment, error) {
-	args := m.Called(paymentID)
+func (m *MockPaymentService) ConfirmPayment(actor domain.Actor, paymentID string, request domain.ConfirmRequest) (*domain.Payment, error) {
+	args := m.Called(actor, paymentID, request)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Payment), args.Error(1)
 }
 
+func (m *MockPaymentService) GetPayment(merchantID string, paymentID string) (*domain.Payment, error) {
+	args := m.Called(merchantID, paymentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
+func (m *MockPaymentService) ListPayments(merchantID string, filter domain.PaymentFilter) (*domain.PaymentPage, error) {
+	args := m.Called(merchantID, filter)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.PaymentPage), args.Error(1)
+}
+
+func (m *MockPaymentService) PaymentEvents(merchantID string, paymentID string) ([]domain.AuditEvent, error) {
+	args := m.Called(merchantID, paymentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]domain.AuditEvent), args.Error(1)
+}
+
+func (m *MockPaymentService) PaymentAttempts(merchantID string, paymentID string) ([]domain.Attempt, error) {
+	args := m.Called(merchantID, paymentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]domain.Attempt), args.Error(1)
+}
+
+func (m *MockPaymentService) PaymentRefunds(merchantID string, paymentID string) ([]domain.Refund, error) {
+	args := m.Called(merchantID, paymentID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]domain.Refund), args.Error(1)
+}
+
 func setupRouter(service *MockPaymentService) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(middleware.MerchantIDKey, testMerchantID)
+		c.Set(middleware.APIKeyIDKey, testAPIKeyID)
+	})
 	handler := NewPaymentHandler(service)
 
 	router.POST("/payments", handler.ProcessPayment)
+	router.POST("/payments/:id/confirm", handler.ConfirmPayment)
 	router.POST("/refund/:id", handler.RefundPayment)
+	router.GET("/payments", handler.ListPayments)
 	router.GET("/payments/:id", handler.GetPayment)
+	router.GET("/payments/:id/events", handler.PaymentEvents)
+	router.GET("/payments/:id/refunds", handler.PaymentRefunds)
 
 	return router
 }
@@ -89,7 +154,7 @@ func TestPaymentHandler_ProcessPayment(t *testing.T) {
 			CardID:         gofakeit.UUID(),
 		}
 
-		service.On("ProcessPayment", request).Return(expectedPayment, nil)
+		service.On("ProcessPayment", testActor, request).Return(expectedPayment, nil)
 
 		jsonData, _ := json.Marshal(request)
 		w := httptest.NewRecorder()
@@ -127,7 +192,7 @@ func TestPaymentHandler_ProcessPayment(t *testing.T) {
 			Description: gofakeit.Sentence(3),
 		}
 
-		service.On("ProcessPayment", request).Return(nil, errors.New("service error"))
+		service.On("ProcessPayment", testActor, request).Return(nil, errors.New("service error"))
 
 		jsonData, _ := json.Marshal(request)
 		w := httptest.NewRecorder()
@@ -139,6 +204,153 @@ func TestPaymentHandler_ProcessPayment(t *testing.T) {
 
 		service.AssertExpectations(t)
 	})
+
+	t.Run("providers unavailable", func(t *testing.T) {
+		request := domain.PaymentRequest{
+			Amount:      gofakeit.Price(10, 1000),
+			Currency:    gofakeit.CurrencyShort(),
+			Description: gofakeit.Sentence(3),
+		}
+
+		service.On("ProcessPayment", testActor, request).Return(nil, fmt.Errorf("all providers failed, last error: %w: stripe bulkhead is full", domain.ErrProviderUnavailable))
+
+		jsonData, _ := json.Marshal(request)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/payments", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		service.AssertExpectations(t)
+	})
+
+	t.Run("installments out of the rules", func(t *testing.T) {
+		request := domain.PaymentRequest{
+			Amount:      20,
+			Currency:    "BRL",
+			Description: gofakeit.Sentence(3),
+			Card:        domain.Card{Number: "4111111111111111", Installments: 12},
+		}
+
+		service.On("ProcessPayment", testActor, request).Return(nil, fmt.Errorf("%w: installment of 1.89 is below the minimum of 5.00", domain.ErrInvalidInstallments))
+
+		jsonData, _ := json.Marshal(request)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/payments", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		service.AssertExpectations(t)
+	})
+
+	t.Run("rejected by risk assessment", func(t *testing.T) {
+		request := domain.PaymentRequest{
+			Amount:      gofakeit.Price(10, 1000),
+			Currency:    "BRL",
+			Description: gofakeit.Sentence(3),
+			Email:       gofakeit.Email(),
+		}
+
+		service.On("ProcessPayment", testActor, request).Return(nil, &domain.RiskRejectedError{PaymentID: "rejected-id"})
+
+		jsonData, _ := json.Marshal(request)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/payments", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.Contains(t, w.Body.String(), `"code":"payment_rejected"`)
+		assert.Contains(t, w.Body.String(), `"paymentId":"rejected-id"`)
+
+		service.AssertExpectations(t)
+	})
+	t.Run("unknown outcome is accepted", func(t *testing.T) {
+		request := domain.PaymentRequest{
+			Amount:      gofakeit.Price(10, 1000),
+			Currency:    "USD",
+			Description: gofakeit.Sentence(3),
+		}
+		payment := &domain.Payment{ID: gofakeit.UUID(), Status: domain.StatusUnknown, OriginalAmount: request.Amount}
+
+		service.On("ProcessPayment", testActor, request).Return(payment, nil)
+
+		jsonData, _ := json.Marshal(request)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/payments", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Contains(t, w.Body.String(), `"status":"unknown"`)
+
+		service.AssertExpectations(t)
+	})
+}
+
+func TestPaymentHandler_SubmitPayment(t *testing.T) {
+	service := new(MockPaymentService)
+	router := setupRouter(service)
+
+	t.Run("queued payment is accepted", func(t *testing.T) {
+		request := domain.PaymentRequest{
+			Amount:      gofakeit.Price(10, 1000),
+			Currency:    "BRL",
+			Description: gofakeit.Sentence(3),
+		}
+		payment := &domain.Payment{ID: gofakeit.UUID(), Status: domain.StatusPending, OriginalAmount: request.Amount}
+
+		service.On("SubmitPayment", testActor, request, "https://203.0.113.10/callback").Return(payment, nil)
+
+		jsonData, _ := json.Marshal(asyncPaymentRequest{PaymentRequest: request, CallbackURL: "https://203.0.113.10/callback"})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/payments?async=true", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Contains(t, w.Body.String(), `"status":"pending"`)
+
+		service.AssertExpectations(t)
+	})
+
+	t.Run("invalid callback URL", func(t *testing.T) {
+		for _, callbackURL := range []string{"file:///etc/passwd", "http://localhost:3001/__admin/scenario", "http://169.254.169.254/latest/meta-data", "http://10.0.0.5/hook"} {
+			jsonData := []byte(`{"amount": 10, "currency": "BRL", "callbackUrl": "` + callbackURL + `"}`)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/payments?async=true", bytes.NewBuffer(jsonData))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, callbackURL)
+			assert.Contains(t, w.Body.String(), "callbackUrl")
+		}
+	})
+
+	t.Run("queue full", func(t *testing.T) {
+		request := domain.PaymentRequest{
+			Amount:      gofakeit.Price(10, 1000),
+			Currency:    "USD",
+			Description: gofakeit.Sentence(3),
+		}
+
+		service.On("SubmitPayment", testActor, request, "").Return(nil, fmt.Errorf("failed to queue payment: %w", domain.ErrQueueFull))
+
+		jsonData, _ := json.Marshal(request)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/payments?async=true", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+		service.AssertExpectations(t)
+	})
 }
 
 func TestPaymentHandler_RefundPayment(t *testing.T) {
@@ -163,7 +375,7 @@ func TestPaymentHandler_RefundPayment(t *testing.T) {
 			CardID:         gofakeit.UUID(),
 		}
 
-		service.On("RefundPayment", paymentID, request).Return(expectedPayment, nil)
+		service.On("RefundPayment", testActor, paymentID, request).Return(expectedPayment, nil)
 
 		jsonData, _ := json.Marshal(request)
 		w := httptest.NewRecorder()
@@ -182,6 +394,124 @@ func TestPaymentHandler_RefundPayment(t *testing.T) {
 
 		service.AssertExpectations(t)
 	})
+
+	t.Run("partial refund not supported by the provider", func(t *testing.T) {
+		paymentID := gofakeit.UUID()
+		request := domain.RefundRequest{Amount: 10}
+
+		service.On("RefundPayment", testActor, paymentID, request).Return(nil, fmt.Errorf("%w: Stripe doesn't support partial refunds", domain.ErrOperationNotSupported))
+
+		jsonData, _ := json.Marshal(request)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/refund/"+paymentID, bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+		service.AssertExpectations(t)
+	})
+
+	t.Run("queued while the provider is down", func(t *testing.T) {
+		paymentID := gofakeit.UUID()
+		request := domain.RefundRequest{Amount: 10}
+
+		service.On("RefundPayment", testActor, paymentID, request).Return(&domain.Payment{ID: paymentID, Status: domain.StatusRefundPending}, nil)
+
+		jsonData, _ := json.Marshal(request)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/refund/"+paymentID, bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+
+		service.AssertExpectations(t)
+	})
+}
+
+func TestPaymentHandler_PaymentRefunds(t *testing.T) {
+	service := new(MockPaymentService)
+	router := setupRouter(service)
+
+	t.Run("refund ledger", func(t *testing.T) {
+		paymentID := gofakeit.UUID()
+		refunds := []domain.Refund{
+			{ID: gofakeit.UUID(), Method: domain.RefundMethodCredit, Status: domain.RefundSucceeded, Amount: 10, Currency: "BRL", ProviderID: "braintree"},
+		}
+		service.On("PaymentRefunds", testMerchantID, paymentID).Return(refunds, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/payments/"+paymentID+"/refunds", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Refunds []domain.Refund `json:"refunds"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, refunds, response.Refunds)
+	})
+
+	t.Run("payment not found", func(t *testing.T) {
+		service.On("PaymentRefunds", testMerchantID, "missing").Return(nil, fmt.Errorf("%w: missing", domain.ErrPaymentNotFound))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/payments/missing/refunds", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestPaymentHandler_ConfirmPayment(t *testing.T) {
+	service := new(MockPaymentService)
+	router := setupRouter(service)
+
+	t.Run("successful confirm", func(t *testing.T) {
+		paymentID := gofakeit.UUID()
+		request := domain.ConfirmRequest{AuthenticationResult: "ok"}
+		expectedPayment := &domain.Payment{
+			ID:             paymentID,
+			CreatedAt:      time.Now(),
+			Status:         domain.StatusAuthorized,
+			Authentication: "challenge",
+		}
+
+		service.On("ConfirmPayment", testActor, paymentID, request).Return(expectedPayment, nil)
+
+		jsonData, _ := json.Marshal(request)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/payments/"+paymentID+"/confirm", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response domain.Payment
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.StatusAuthorized, response.Status)
+
+		service.AssertExpectations(t)
+	})
+
+	t.Run("payment not awaiting confirmation", func(t *testing.T) {
+		paymentID := gofakeit.UUID()
+		request := domain.ConfirmRequest{AuthenticationResult: "ok"}
+
+		service.On("ConfirmPayment", testActor, paymentID, request).Return(nil, fmt.Errorf("%w: status is authorized", domain.ErrInvalidStatus))
+
+		jsonData, _ := json.Marshal(request)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/payments/"+paymentID+"/confirm", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		service.AssertExpectations(t)
+	})
 }
 
 func TestPaymentHandler_GetPayment(t *testing.T) {
@@ -202,7 +532,7 @@ func TestPaymentHandler_GetPayment(t *testing.T) {
 			CardID:         gofakeit.UUID(),
 		}
 
-		service.On("GetPayment", paymentID).Return(expectedPayment, nil)
+		service.On("GetPayment", testMerchantID, paymentID).Return(expectedPayment, nil)
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/payments/"+paymentID, nil)
@@ -220,9 +550,33 @@ func TestPaymentHandler_GetPayment(t *testing.T) {
 		service.AssertExpectations(t)
 	})
 
+	t.Run("expand attempts", func(t *testing.T) {
+		paymentID := gofakeit.UUID()
+		payment := &domain.Payment{ID: paymentID, CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 100, CurrentAmount: 100, Currency: "BRL"}
+		attempts := []domain.Attempt{
+			{ProviderID: "stripe", ProviderName: "Stripe", Operation: domain.OperationCharge, Number: 1, HTTPStatus: 503, ErrorClass: domain.ErrorClassServerError, Response: `{"error":"unavailable"}`},
+			{ProviderID: "braintree", ProviderName: "Braintree", Operation: domain.OperationCharge, Number: 1, HTTPStatus: 200, Status: domain.StatusAuthorized},
+		}
+		service.On("GetPayment", testMerchantID, paymentID).Return(payment, nil)
+		service.On("PaymentAttempts", testMerchantID, paymentID).Return(attempts, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/payments/"+paymentID+"?expand=attempts", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			domain.Payment
+			Attempts []domain.Attempt `json:"attempts"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, paymentID, response.ID)
+		assert.Equal(t, attempts, response.Attempts)
+	})
+
 	t.Run("payment not found", func(t *testing.T) {
 		paymentID := "non-existent"
-		service.On("GetPayment", paymentID).Return(nil, errors.New("payment not found"))
+		service.On("GetPayment", testMerchantID, paymentID).Return(nil, errors.New("payment not found"))
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/payments/"+paymentID, nil)
@@ -232,4 +586,129 @@ func TestPaymentHandler_GetPayment(t *testing.T) {
 
 		service.AssertExpectations(t)
 	})
+
+	t.Run("payment of another merchant", func(t *testing.T) {
+		paymentID := gofakeit.UUID()
+		service.On("GetPayment", testMerchantID, paymentID).Return(nil, fmt.Errorf("%w: %s", domain.ErrPaymentNotFound, paymentID))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/payments/"+paymentID, nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		service.AssertExpectations(t)
+	})
+}
+
+func TestPaymentHandler_ListPayments(t *testing.T) {
+	service := new(MockPaymentService)
+	router := setupRouter(service)
+
+	t.Run("successful list with filters", func(t *testing.T) {
+		minAmount := 10.0
+		createdFrom := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		expectedFilter := domain.PaymentFilter{
+			Status:      domain.StatusFailed,
+			ProviderID:  "braintree",
+			Currency:    "BRL",
+			MinAmount:   &minAmount,
+			CreatedFrom: &createdFrom,
+			Limit:       5,
+			Order:       domain.SortDesc,
+		}
+		expectedPage := &domain.PaymentPage{
+			Data: []*domain.Payment{{
+				ID:       gofakeit.UUID(),
+				Status:   domain.StatusFailed,
+				Currency: "BRL",
+			}},
+			NextCursor: "next",
+			HasMore:    true,
+		}
+
+		service.On("ListPayments", testMerchantID, expectedFilter).Return(expectedPage, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/payments?status=failed&providerId=braintree&currency=BRL&minAmount=10&createdFrom=2025-01-01T00:00:00Z&limit=5&order=desc", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response domain.PaymentPage
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Len(t, response.Data, 1)
+		assert.Equal(t, "next", response.NextCursor)
+		assert.True(t, response.HasMore)
+
+		service.AssertExpectations(t)
+	})
+
+	t.Run("invalid filter", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/payments?minAmount=abc", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		service.On("ListPayments", testMerchantID, domain.PaymentFilter{Cursor: "bad", Order: domain.SortAsc}).
+			Return(nil, domain.ErrInvalidCursor)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/payments?cursor=bad", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestPaymentHandler_PaymentEvents(t *testing.T) {
+	service := new(MockPaymentService)
+	router := setupRouter(service)
+
+	paymentID := gofakeit.UUID()
+	events := []domain.AuditEvent{
+		{ID: "event-1", Sequence: 1, Actor: testActor, Action: domain.AuditPaymentCreated, ResourceType: domain.AuditResourcePayment, ResourceID: paymentID, RequestID: "req-1", After: json.RawMessage(`{"payment":{"status":"authorized"}}`)},
+		{ID: "event-2", Sequence: 2, Actor: testActor, Action: domain.AuditPaymentRefunded, ResourceType: domain.AuditResourcePayment, ResourceID: paymentID, RequestID: "req-2", Before: json.RawMessage(`{"payment":{"status":"authorized"}}`), After: json.RawMessage(`{"payment":{"status":"refunded"}}`)},
+	}
+	service.On("PaymentEvents", testMerchantID, paymentID).Return(events, nil)
+
+	t.Run("json", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/payments/"+paymentID+"/events", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Events []domain.AuditEvent `json:"events"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Len(t, response.Events, 2)
+		assert.Equal(t, domain.AuditPaymentRefunded, response.Events[1].Action)
+		assert.JSONEq(t, `{"payment":{"status":"authorized"}}`, string(response.Events[1].Before))
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/payments/"+paymentID+"/events?format=ndjson", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		lines := bytes.Split(bytes.TrimSpace(w.Body.Bytes()), []byte("\n"))
+		assert.Len(t, lines, 2)
+	})
+
+	t.Run("payment not found", func(t *testing.T) {
+		service.On("PaymentEvents", testMerchantID, "missing").Return(nil, fmt.Errorf("%w: missing", domain.ErrPaymentNotFound))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/payments/missing/events", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
 }
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"desafio-api/api/middleware"
+	"desafio-api/internal/domain"
+)
+
+type CardVault interface {
+	Store(merchantID string, card domain.Card) (*domain.StoredCard, error)
+	Get(merchantID string, token string) (*domain.StoredCard, error)
+	Delete(merchantID string, token string) error
+}
+
+type SubscriptionService interface {
+	CreatePlan(merchantID string, request domain.PlanRequest) (*domain.Plan, error)
+	GetPlan(merchantID string, planID string) (*domain.Plan, error)
+	ListPlans(merchantID string) ([]*domain.Plan, error)
+	CreateSubscription(merchantID string, request domain.SubscriptionRequest) (*domain.Subscription, error)
+	GetSubscription(merchantID string, subscriptionID string) (*domain.Subscription, error)
+	ListSubscriptions(merchantID string) ([]*domain.Subscription, error)
+	Pause(merchantID string, subscriptionID string) (*domain.Subscription, error)
+	Resume(merchantID string, subscriptionID string) (*domain.Subscription, error)
+	Cancel(merchantID string, subscriptionID string) (*domain.Subscription, error)
+}
+
+type SubscriptionHandler struct {
+	vault   CardVault
+	service SubscriptionService
+}
+
+func NewSubscriptionHandler(vault CardVault, service SubscriptionService) *SubscriptionHandler {
+	return &SubscriptionHandler{
+		vault:   vault,
+		service: service,
+	}
+}
+
+// StoreCard keeps a card in the vault and returns its token, to be charged
+// later without sending the card data again.
+func (h *SubscriptionHandler) StoreCard(c *gin.Context) {
+	var card domain.Card
+	if err := c.ShouldBindJSON(&card); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	stored, err := h.vault.Store(middleware.MerchantID(c), card)
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidCard) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store card: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, stored)
+}
+
+func (h *SubscriptionHandler) GetCard(c *gin.Context) {
+	stored, err := h.vault.Get(middleware.MerchantID(c), c.Param("token"))
+	if err != nil {
+		h.respondError(c, "failed to get card: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, stored)
+}
+
+func (h *SubscriptionHandler) DeleteCard(c *gin.Context) {
+	if err := h.vault.Delete(middleware.MerchantID(c), c.Param("token")); err != nil {
+		h.respondError(c, "failed to delete card: ", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SubscriptionHandler) CreatePlan(c *gin.Context) {
+	var request domain.PlanRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	plan, err := h.service.CreatePlan(middleware.MerchantID(c), request)
+	if err != nil {
+		h.respondError(c, "failed to create plan: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, plan)
+}
+
+func (h *SubscriptionHandler) GetPlan(c *gin.Context) {
+	plan, err := h.service.GetPlan(middleware.MerchantID(c), c.Param("id"))
+	if err != nil {
+		h.respondError(c, "failed to get plan: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, plan)
+}
+
+func (h *SubscriptionHandler) ListPlans(c *gin.Context) {
+	plans, err := h.service.ListPlans(middleware.MerchantID(c))
+	if err != nil {
+		h.respondError(c, "failed to list plans: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": plans})
+}
+
+func (h *SubscriptionHandler) CreateSubscription(c *gin.Context) {
+	var request domain.SubscriptionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+
+	subscription, err := h.service.CreateSubscription(middleware.MerchantID(c), request)
+	if err != nil {
+		h.respondError(c, "failed to create subscription: ", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, subscription)
+}
+
+func (h *SubscriptionHandler) GetSubscription(c *gin.Context) {
+	subscription, err := h.service.GetSubscription(middleware.MerchantID(c), c.Param("id"))
+	if err != nil {
+		h.respondError(c, "failed to get subscription: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+func (h *SubscriptionHandler) ListSubscriptions(c *gin.Context) {
+	subscriptions, err := h.service.ListSubscriptions(middleware.MerchantID(c))
+	if err != nil {
+		h.respondError(c, "failed to list subscriptions: ", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": subscriptions})
+}
+
+func (h *SubscriptionHandler) PauseSubscription(c *gin.Context) {
+	h.changeSubscription(c, "failed to pause subscription: ", h.service.Pause)
+}
+
+func (h *SubscriptionHandler) ResumeSubscription(c *gin.Context) {
+	h.changeSubscription(c, "failed to resume subscription: ", h.service.Resume)
+}
+
+func (h *SubscriptionHandler) CancelSubscription(c *gin.Context) {
+	h.changeSubscription(c, "failed to cancel subscription: ", h.service.Cancel)
+}
+
+func (h *SubscriptionHandler) changeSubscription(c *gin.Context, message string, change func(merchantID string, subscriptionID string) (*domain.Subscription, error)) {
+	subscription, err := change(middleware.MerchantID(c), c.Param("id"))
+	if err != nil {
+		h.respondError(c, message, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, subscription)
+}
+
+func (h *SubscriptionHandler) respondError(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, domain.ErrInvalidSubscription):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+	case errors.Is(err, domain.ErrCardNotFound), errors.Is(err, domain.ErrPlanNotFound), errors.Is(err, domain.ErrSubscriptionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, domain.ErrSubscriptionCanceled):
+		c.JSON(http.StatusConflict, gin.H{"error": message + err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message + err.Error()})
+	}
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/api/middleware"
+	"desafio-api/internal/domain"
+	"desafio-api/internal/vault"
+)
+
+type MockSubscriptionService struct {
+	mock.Mock
+}
+
+func (m *MockSubscriptionService) CreatePlan(merchantID string, request domain.PlanRequest) (*domain.Plan, error) {
+	args := m.Called(merchantID, request)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Plan), args.Error(1)
+}
+
+func (m *MockSubscriptionService) GetPlan(merchantID string, planID string) (*domain.Plan, error) {
+	args := m.Called(merchantID, planID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Plan), args.Error(1)
+}
+
+func (m *MockSubscriptionService) ListPlans(merchantID string) ([]*domain.Plan, error) {
+	args := m.Called(merchantID)
+	return args.Get(0).([]*domain.Plan), args.Error(1)
+}
+
+func (m *MockSubscriptionService) CreateSubscription(merchantID string, request domain.SubscriptionRequest) (*domain.Subscription, error) {
+	args := m.Called(merchantID, request)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionService) GetSubscription(merchantID string, subscriptionID string) (*domain.Subscription, error) {
+	return m.subscription("GetSubscription", merchantID, subscriptionID)
+}
+
+func (m *MockSubscriptionService) ListSubscriptions(merchantID string) ([]*domain.Subscription, error) {
+	args := m.Called(merchantID)
+	return args.Get(0).([]*domain.Subscription), args.Error(1)
+}
+
+func (m *MockSubscriptionService) Pause(merchantID string, subscriptionID string) (*domain.Subscription, error) {
+	return m.subscription("Pause", merchantID, subscriptionID)
+}
+
+func (m *MockSubscriptionService) Resume(merchantID string, subscriptionID string) (*domain.Subscription, error) {
+	return m.subscription("Resume", merchantID, subscriptionID)
+}
+
+func (m *MockSubscriptionService) Cancel(merchantID string, subscriptionID string) (*domain.Subscription, error) {
+	return m.subscription("Cancel", merchantID, subscriptionID)
+}
+
+func (m *MockSubscriptionService) subscription(method string, merchantID string, subscriptionID string) (*domain.Subscription, error) {
+	args := m.MethodCalled(method, merchantID, subscriptionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Subscription), args.Error(1)
+}
+
+func setupSubscriptionRouter(service *MockSubscriptionService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(middleware.MerchantIDKey, testMerchantID)
+		c.Set(middleware.APIKeyIDKey, testAPIKeyID)
+	})
+	handler := NewSubscriptionHandler(vault.NewMemoryVault(), service)
+
+	router.POST("/cards", handler.StoreCard)
+	router.GET("/cards/:token", handler.GetCard)
+	router.POST("/plans", handler.CreatePlan)
+	router.POST("/subscriptions", handler.CreateSubscription)
+	router.POST("/subscriptions/:id/pause", handler.PauseSubscription)
+	router.POST("/subscriptions/:id/resume", handler.ResumeSubscription)
+
+	return router
+}
+
+func TestSubscriptionHandler_Cards(t *testing.T) {
+	router := setupSubscriptionRouter(new(MockSubscriptionService))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/cards", bytes.NewBufferString(`{"number": "4111111111111111", "holderName": "Ana", "cvv": "123", "expirationDate": "12/2030"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "4111111111111111")
+	assert.NotContains(t, w.Body.String(), "cvv")
+	var stored domain.StoredCard
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stored))
+	assert.Equal(t, "1111", stored.Last4)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/cards/"+stored.Token, nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/cards/card_missing", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSubscriptionHandler_Subscriptions(t *testing.T) {
+	service := new(MockSubscriptionService)
+	router := setupSubscriptionRouter(service)
+
+	t.Run("create plan", func(t *testing.T) {
+		request := domain.PlanRequest{Name: "Pro", Amount: 29.9, Currency: "BRL", Interval: domain.IntervalMonth}
+		service.On("CreatePlan", testMerchantID, request).Return(&domain.Plan{ID: "plan-1", Amount: 29.9, Interval: domain.IntervalMonth, IntervalCount: 1}, nil)
+
+		jsonData, _ := json.Marshal(request)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/plans", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Contains(t, w.Body.String(), `"id":"plan-1"`)
+	})
+
+	t.Run("subscription to an unknown plan", func(t *testing.T) {
+		request := domain.SubscriptionRequest{PlanID: "missing", CardToken: "card_1"}
+		service.On("CreateSubscription", testMerchantID, request).Return(nil, fmt.Errorf("%w: %w", domain.ErrInvalidSubscription, domain.ErrPlanNotFound))
+
+		jsonData, _ := json.Marshal(request)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/subscriptions", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("pause", func(t *testing.T) {
+		service.On("Pause", testMerchantID, "sub-1").Return(&domain.Subscription{ID: "sub-1", Status: domain.SubscriptionPaused}, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/subscriptions/sub-1/pause", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"status":"paused"`)
+	})
+
+	t.Run("resume canceled subscription", func(t *testing.T) {
+		service.On("Resume", testMerchantID, "sub-2").Return(nil, domain.ErrSubscriptionCanceled)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/subscriptions/sub-2/resume", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	service.AssertExpectations(t)
+}
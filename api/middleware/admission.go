@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdmissionLimiter interface {
+	Begin() bool
+	End(latency time.Duration, overloaded bool)
+}
+
+// Admission sheds requests with 503 and a Retry-After header once the
+// limiter's concurrency limit is reached. Responses with 503 or 504 tell
+// the limiter the providers are overloaded. A handler that panics ends its
+// request as a 500 and the panic goes on to the recovery middleware.
+func Admission(limiter AdmissionLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !limiter.Begin() {
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "server overloaded, try again later"})
+			return
+		}
+
+		started := time.Now()
+		defer func() {
+			status := c.Writer.Status()
+			recovered := recover()
+			if recovered != nil {
+				status = http.StatusInternalServerError
+			}
+			overloaded := status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+			limiter.End(time.Since(started), overloaded)
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+		c.Next()
+	}
+}
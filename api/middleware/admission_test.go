@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"desafio-api/internal/admission"
+)
+
+func TestAdmission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	limiter := admission.NewLimiter(admission.Settings{InitialLimit: 1, MinLimit: 1, MaxLimit: 1, TargetLatency: time.Second})
+	router := gin.New()
+	release := make(chan struct{})
+	router.POST("/payments", Admission(limiter), func(c *gin.Context) {
+		if c.Query("wait") != "" {
+			<-release
+		}
+		c.Status(http.StatusOK)
+	})
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/payments?wait=1", nil))
+		done <- w.Code
+	}()
+	assert.Eventually(t, func() bool { return limiter.Stats().InFlight == 1 }, time.Second, 5*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/payments", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, 0, limiter.Stats().InFlight)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/payments", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdmissionPanic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	limiter := admission.NewLimiter(admission.Settings{InitialLimit: 1, MinLimit: 1, MaxLimit: 1, TargetLatency: time.Second})
+	router := gin.New()
+	router.Use(gin.CustomRecovery(func(c *gin.Context, recovered any) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	router.POST("/payments", Admission(limiter), func(c *gin.Context) {
+		if c.Query("panic") != "" {
+			panic("boom")
+		}
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/payments?panic=1", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, 0, limiter.Stats().InFlight, "the slot is released")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/payments", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
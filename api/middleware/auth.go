@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"desafio-api/internal/domain"
+)
+
+const (
+	MerchantIDKey = "merchantID"
+	APIKeyIDKey   = "apiKeyID"
+	AdminIDKey    = "adminID"
+)
+
+type Authenticator interface {
+	Authenticate(key string) (*domain.APIKey, error)
+}
+
+type AdminAuthenticator interface {
+	Authenticate(key string) (string, error)
+}
+
+// MerchantAuth requires an "Authorization: Bearer <api key>" header and
+// stores the authenticated merchant and key IDs in the request context.
+func MerchantAuth(authenticator Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing api key"})
+			return
+		}
+
+		key, err := authenticator.Authenticate(token)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+			return
+		}
+
+		c.Set(MerchantIDKey, key.MerchantID)
+		c.Set(APIKeyIDKey, key.ID)
+		c.Next()
+	}
+}
+
+// AdminAuth requires an "Authorization: Bearer <admin key>" header and
+// stores the ID of the admin key in the request context.
+func AdminAuth(authenticator AdminAuthenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing admin key"})
+			return
+		}
+
+		adminID, err := authenticator.Authenticate(token)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin key"})
+			return
+		}
+
+		c.Set(AdminIDKey, adminID)
+		c.Next()
+	}
+}
+
+func AdminID(c *gin.Context) string {
+	return c.GetString(AdminIDKey)
+}
+
+// AdminActor identifies the admin making the request for the audit log.
+func AdminActor(c *gin.Context) domain.Actor {
+	return domain.Actor{Type: domain.ActorAdmin, ID: AdminID(c), RequestID: RequestIDFrom(c)}
+}
+
+// MerchantActor identifies the merchant API key making the request.
+func MerchantActor(c *gin.Context) domain.Actor {
+	return domain.Actor{
+		Type:       domain.ActorMerchant,
+		ID:         APIKeyID(c),
+		MerchantID: MerchantID(c),
+		RequestID:  RequestIDFrom(c),
+	}
+}
+
+func MerchantID(c *gin.Context) string {
+	return c.GetString(MerchantIDKey)
+}
+
+func APIKeyID(c *gin.Context) string {
+	return c.GetString(APIKeyIDKey)
+}
+
+func bearerToken(header string) (string, bool) {
+	scheme, token, found := strings.Cut(strings.TrimSpace(header), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
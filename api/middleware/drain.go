@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Drainer interface {
+	Begin() bool
+	End()
+}
+
+// Drain rejects new requests with 503 once the server started shutting
+// down, and lets the drainer know when the accepted ones finish.
+func Drain(drainer Drainer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !drainer.Begin() {
+			c.Header("Connection", "close")
+			c.Header("Retry-After", "5")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "server is shutting down"})
+			return
+		}
+		defer drainer.End()
+		c.Next()
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+	"desafio-api/internal/ratelimit"
+)
+
+// KeyFunc extracts the value a request is limited by. Returning false skips
+// the limit for that request.
+type KeyFunc func(c *gin.Context) (string, bool)
+
+// RateLimit rejects requests with 429 and a Retry-After header once the
+// bucket for the request's key is empty. Keys are namespaced by name so
+// several limits can share a backend.
+func RateLimit(backend ratelimit.Backend, name string, limit config.LimitConfig, key KeyFunc) gin.HandlerFunc {
+	bucketLimit := ratelimit.Limit{Rate: limit.RequestsPerSecond, Burst: limit.Burst}
+
+	return func(c *gin.Context) {
+		if !bucketLimit.Enabled() {
+			c.Next()
+			return
+		}
+
+		value, ok := key(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		allowed, retryAfter := backend.Allow(name+":"+value, bucketLimit)
+		if !allowed {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			if seconds < 1 {
+				seconds = 1
+			}
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded: " + name})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func ByClientIP(c *gin.Context) (string, bool) {
+	return c.ClientIP(), true
+}
+
+// ByAPIKey must run after MerchantAuth.
+func ByAPIKey(c *gin.Context) (string, bool) {
+	keyID := APIKeyID(c)
+	return keyID, keyID != ""
+}
+
+// maxPaymentBodyBytes bounds the payment request bodies read by
+// ByCardFingerprint.
+const maxPaymentBodyBytes = 1 << 20
+
+// ByCardFingerprint reads the card number from a payment request body and
+// restores the body so the handler can bind it again. Bodies larger than
+// maxPaymentBodyBytes are rejected with 413.
+func ByCardFingerprint(c *gin.Context) (string, bool) {
+	if c.Request.Body == nil {
+		return "", false
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxPaymentBodyBytes))
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("request body is larger than %d bytes", tooLarge.Limit)})
+		return "", false
+	}
+	if err != nil {
+		return "", false
+	}
+
+	var request struct {
+		Card domain.Card `json:"card"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return "", false
+	}
+	fingerprint := request.Card.Fingerprint()
+	return fingerprint, fingerprint != ""
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/ratelimit"
+)
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	t.Run("returns 429 with Retry-After", func(t *testing.T) {
+		router := gin.New()
+		limit := config.LimitConfig{RequestsPerSecond: 0.5, Burst: 1}
+		router.Use(RateLimit(ratelimit.NewMemoryBackend(), "client_ip", limit, ByClientIP))
+		router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("limits by card and keeps the body readable", func(t *testing.T) {
+		router := gin.New()
+		limit := config.LimitConfig{RequestsPerSecond: 1, Burst: 1}
+		router.POST("/payments", RateLimit(ratelimit.NewMemoryBackend(), "card", limit, ByCardFingerprint), func(c *gin.Context) {
+			body, _ := io.ReadAll(c.Request.Body)
+			c.String(http.StatusOK, string(body))
+		})
+
+		send := func(number string) *httptest.ResponseRecorder {
+			body := `{"card":{"number":"` + number + `"}}`
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("POST", "/payments", bytes.NewBufferString(body)))
+			return w
+		}
+
+		w := send("4111 1111 1111 1111")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "4111 1111 1111 1111")
+
+		assert.Equal(t, http.StatusTooManyRequests, send("4111111111111111").Code)
+		assert.Equal(t, http.StatusOK, send("5555555555554444").Code)
+	})
+
+	t.Run("rejects payment bodies that are too large", func(t *testing.T) {
+		router := gin.New()
+		limit := config.LimitConfig{RequestsPerSecond: 1, Burst: 1}
+		called := false
+		router.POST("/payments", RateLimit(ratelimit.NewMemoryBackend(), "card", limit, ByCardFingerprint), func(c *gin.Context) {
+			called = true
+			c.Status(http.StatusOK)
+		})
+
+		body := `{"card":{"number":"4111111111111111"},"description":"` + strings.Repeat("x", maxPaymentBodyBytes) + `"}`
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/payments", bytes.NewBufferString(body)))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+		assert.False(t, called)
+	})
+}
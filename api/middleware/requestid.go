@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	RequestIDKey    = "requestID"
+)
+
+// maxRequestIDLength bounds client supplied IDs, which end up in the logs
+// and the audit log.
+const maxRequestIDLength = 128
+
+// RequestID keeps the X-Request-ID sent by the client, or generates one, and
+// echoes it in the response so both sides can correlate the request.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.New().String()
+		}
+		c.Set(RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+func RequestIDFrom(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
@@ -1,18 +1,39 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"desafio-api/api/handlers"
+	"desafio-api/api/middleware"
+	"desafio-api/internal/admin"
+	"desafio-api/internal/admission"
+	"desafio-api/internal/audit"
+	"desafio-api/internal/batch"
 	"desafio-api/internal/config"
+	"desafio-api/internal/dispute"
 	"desafio-api/internal/domain"
+	"desafio-api/internal/fx"
+	"desafio-api/internal/health"
+	"desafio-api/internal/installments"
+	"desafio-api/internal/jobs"
+	"desafio-api/internal/merchant"
 	"desafio-api/internal/providers"
+	"desafio-api/internal/ratelimit"
+	"desafio-api/internal/risk"
 	"desafio-api/internal/service"
+	"desafio-api/internal/shutdown"
+	"desafio-api/internal/store"
+	"desafio-api/internal/subscription"
+	"desafio-api/internal/vault"
 	"desafio-api/mock"
 )
 
@@ -24,55 +45,260 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Start server to simulate the two payment providers
-	mockServer1 := mock.NewMockServer()
-	go func() {
-		if err := mockServer1.Run(":3001"); err != nil {
-			log.Fatalf("Failed to start mock server 1: %v", err)
+	var scenario *mock.Scenario
+	if cfg.Mock.ScenarioFile != "" {
+		loaded, err := mock.LoadScenario(cfg.Mock.ScenarioFile)
+		if err != nil {
+			log.Fatalf("Failed to load mock scenario: %v", err)
 		}
-	}()
+		scenario = &loaded
+	}
 
-	mockServer2 := mock.NewMockServer()
-	go func() {
-		if err := mockServer2.Run(":3002"); err != nil {
-			log.Fatalf("Failed to start mock server 2: %v", err)
+	// Configure payment providers, starting a mock server for each one
+	if len(cfg.Providers) == 0 {
+		log.Fatalf("No payment providers configured")
+	}
+	paymentProviders := make([]domain.PaymentProvider, 0, len(cfg.Providers))
+	probeTargets := make([]health.Target, 0, len(cfg.Providers))
+	for _, p := range cfg.Providers {
+		providerConfig, err := providers.ConfigForFormat(p.Format)
+		if err != nil {
+			log.Fatalf("Failed to configure provider %s: %v", p.ID, err)
 		}
-	}()
+		providerConfig.Name = p.Name
+		providerConfig.BaseURL = p.BaseURL
+		capabilities, err := providers.CapabilitiesFromConfig(p.Capabilities)
+		if err != nil {
+			log.Fatalf("Failed to configure capabilities of provider %s: %v", p.ID, err)
+		}
+		if capabilities.Credits() && providerConfig.CreditEndpoint == "" {
+			log.Fatalf("Provider %s declares credits, which the %s format doesn't support", p.ID, p.Format)
+		}
+		providerConfig.Capabilities = capabilities
+		provider := providers.NewProvider(p.ID, providerConfig, cfg)
+		paymentProviders = append(paymentProviders, provider)
+		probeTargets = append(probeTargets, provider)
+
+		if !cfg.Mock.Embedded {
+			continue
+		}
+		mockServer, err := mock.NewMockServerWithFormat(p.Format)
+		if err != nil {
+			log.Fatalf("Failed to create mock server for %s: %v", p.ID, err)
+		}
+		if scenario != nil {
+			if err := mockServer.SetScenario(*scenario); err != nil {
+				log.Fatalf("Failed to apply mock scenario: %v", err)
+			}
+		}
+		go func(id, addr string) {
+			if err := mockServer.Run(addr); err != nil {
+				log.Fatalf("Failed to start mock server for %s: %v", id, err)
+			}
+		}(p.ID, p.MockAddr)
+	}
 
-	// Configure payment providers
-	provider1 := providers.NewProvider("stripe", providers.ProviderConfig{
-		Name:                "Stripe",
-		BaseURL:             "http://localhost:3001",
-		ChargeEndpoint:      "/charges",
-		RefundEndpoint:      "/refund/{id}",
-		GetChargeEndpoint:   "/charges/{id}",
-		RequestTransformer:  providers.StandardRequestTransformer,
-		ResponseTransformer: providers.StandardResponseTransformer,
-	}, cfg)
-
-	provider2 := providers.NewProvider("braintree", providers.ProviderConfig{
-		Name:                "Braintree",
-		BaseURL:             "http://localhost:3002",
-		ChargeEndpoint:      "/charges",
-		RefundEndpoint:      "/refund/{id}",
-		GetChargeEndpoint:   "/charges/{id}",
-		RequestTransformer:  providers.StandardRequestTransformer,
-		ResponseTransformer: providers.StandardResponseTransformer,
-	}, cfg)
+	// Create merchant service for API key authentication
+	merchantService, err := merchant.NewService(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load merchants: %v", err)
+	}
+	merchantHandler := handlers.NewMerchantHandler(merchantService)
 
 	// Create payment service and handler
-	var paymentService handlers.PaymentService = service.NewPaymentService([]domain.PaymentProvider{provider1, provider2}, cfg)
+	monitor := health.NewMonitor(cfg.Health.Window)
+	// Async charges left pending by the last shutdown are processed again
+	jobQueue, err := jobs.Open(cfg.Async.JournalFile, cfg.Async.MaxPending)
+	if err != nil {
+		log.Fatalf("Failed to open job queue: %v", err)
+	}
+	defer jobQueue.Close()
+	if pending := jobQueue.Pending(); pending > 0 {
+		log.Printf("Resuming %d queued payments", pending)
+	}
+
+	auditLog := audit.NewMemoryLog()
+	cardVault := vault.NewMemoryVault()
+	serviceOptions := []service.Option{
+		service.WithHealthMonitor(monitor),
+		service.WithAuditLog(auditLog),
+		service.WithJobQueue(jobQueue, cardVault),
+	}
+	if cfg.Async.JournalKey != "" {
+		sealer, err := vault.NewSealer(cfg.Async.JournalKey)
+		if err != nil {
+			log.Fatalf("Failed to configure async.journal_key: %v", err)
+		}
+		serviceOptions = append(serviceOptions, service.WithCardSealer(sealer))
+	} else if cfg.Async.JournalFile != "" {
+		log.Printf("No async.journal_key configured, queued payments fail if the gateway restarts before charging them")
+	}
+	var rates domain.FXRateProvider = fx.DevelopmentRates()
+	if cfg.FX.RatesFile != "" {
+		fileRates, err := fx.OpenFile(cfg.FX.RatesFile)
+		if err != nil {
+			log.Fatalf("Failed to load exchange rates: %v", err)
+		}
+		rates = fileRates
+	} else {
+		log.Printf("No fx.rates_file configured, using development exchange rates")
+	}
+	serviceOptions = append(serviceOptions, service.WithFXRates(rates))
+	installmentCalculator, err := installments.NewCalculator(cfg.Installments)
+	if err != nil {
+		log.Fatalf("Failed to configure installments: %v", err)
+	}
+	serviceOptions = append(serviceOptions, service.WithInstallments(installmentCalculator))
+	if cfg.Risk.Enabled {
+		riskEngine, err := risk.NewEngine(cfg.Risk)
+		if err != nil {
+			log.Fatalf("Failed to configure risk engine: %v", err)
+		}
+		serviceOptions = append(serviceOptions, service.WithRiskEvaluator(riskEngine))
+	}
+	paymentService := service.NewPaymentService(paymentProviders, store.NewMemoryStore(), cfg, serviceOptions...)
 	paymentHandler := handlers.NewPaymentHandler(paymentService)
+	healthHandler := handlers.NewHealthHandler(paymentService)
+	adminHandler := handlers.NewAdminHandler(paymentService)
+	batchProcessor, err := batch.NewProcessor(paymentService, batch.Settings{
+		Concurrency: cfg.Batch.Concurrency,
+		MaxLines:    cfg.Batch.MaxLines,
+		JournalFile: cfg.Batch.JournalFile,
+	})
+	if err != nil {
+		log.Fatalf("Failed to open batch journal: %v", err)
+	}
+	batchHandler := handlers.NewBatchHandler(batchProcessor, cfg.Batch.MaxFileBytes)
+	subscriptionService := subscription.NewService(paymentService, cardVault, cfg.GetDunningSchedule())
+	subscriptionHandler := handlers.NewSubscriptionHandler(cardVault, subscriptionService)
+	installmentHandler := handlers.NewInstallmentHandler(installmentCalculator)
+
+	// Disputes, opened by provider webhooks or admins and sent to the
+	// merchants' webhooks
+	evidenceStore, err := dispute.NewEvidenceStore(cfg.Disputes.EvidenceDir, cfg.Disputes.MaxEvidenceBytes)
+	if err != nil {
+		log.Fatalf("Failed to open evidence store: %v", err)
+	}
+	webhookSecrets := make(map[string]string, len(cfg.Providers))
+	for _, p := range cfg.Providers {
+		webhookSecrets[p.ID] = p.WebhookSecret
+	}
+	merchantWebhooks := make(map[string]dispute.Endpoint, len(cfg.Merchants))
+	for _, m := range cfg.Merchants {
+		merchantWebhooks[m.ID] = dispute.Endpoint{URL: m.WebhookURL, Secret: m.WebhookSecret}
+	}
+	disputeService := dispute.NewService(paymentService, evidenceStore, auditLog,
+		dispute.NewWebhookNotifier(merchantWebhooks, cfg.GetDisputeWebhookTimeout(), cfg.Disputes.WebhookAttempts),
+		dispute.Settings{
+			ResponseWindow:     cfg.GetDisputeResponseWindow(),
+			ProviderSecrets:    webhookSecrets,
+			SignatureTolerance: cfg.GetDisputeSignatureTolerance(),
+		})
+	disputeHandler := handlers.NewDisputeHandler(disputeService, cfg.Disputes.MaxEvidenceBytes)
+
+	adminAuthenticator, err := admin.NewAuthenticator(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load admin keys: %v", err)
+	}
+
+	// Resolve charges left with unknown outcome by the last shutdown
+	outcomes, err := shutdown.LoadUnknownOutcomes(cfg.Shutdown.UnknownOutcomeFile)
+	if err != nil {
+		log.Fatalf("Failed to load unknown outcomes: %v", err)
+	}
+	if len(outcomes) > 0 {
+		log.Printf("Recovered %d of %d operations with unknown outcome", paymentService.RecoverUnknownOutcomes(outcomes), len(outcomes))
+		if err := os.Remove(cfg.Shutdown.UnknownOutcomeFile); err != nil {
+			log.Printf("Failed to remove unknown outcome file: %v", err)
+		}
+	}
+	sweeperCtx, stopSweeper := context.WithCancel(context.Background())
+	defer stopSweeper()
+	// The drain on shutdown also waits for the charges of the workers and
+	// the batch lines being processed
+	drainer := shutdown.NewDrainer()
+	go paymentService.RunSweeper(sweeperCtx, cfg.GetRecoverySweepInterval(), cfg.GetRecoveryMinAge())
+	go paymentService.RunRefundRetrier(sweeperCtx, cfg.GetRefundRetryInterval(), cfg.GetRefundMaxAge())
+	drainer.Go(func() { paymentService.RunWorkers(sweeperCtx, cfg.Async.Workers) })
+	drainer.Go(func() {
+		<-sweeperCtx.Done()
+		batchProcessor.Stop()
+	})
+	go subscriptionService.RunScheduler(sweeperCtx, cfg.GetSubscriptionSchedulerInterval())
+	go health.NewProber(probeTargets, monitor, cfg.GetHealthProbeInterval()).Run(sweeperCtx)
 
 	// Setup routes
+	limiter := ratelimit.NewMemoryBackend()
 	router := gin.Default()
-	router.POST("/payments", paymentHandler.ProcessPayment)
-	router.POST("/refund/:id", paymentHandler.RefundPayment)
-	router.GET("/payments/:id", paymentHandler.GetPayment)
+	router.Use(middleware.RequestID())
+	router.GET("/healthz", healthHandler.Healthz)
+	// Registered after the drain so a draining instance is not ready
+	router.Use(middleware.Drain(drainer))
+	router.GET("/readyz", healthHandler.Readyz)
+	adminRoutes := router.Group("/admin", middleware.AdminAuth(adminAuthenticator))
+	adminRoutes.GET("/providers", adminHandler.ListProviders)
+	adminRoutes.POST("/providers/:id/open", adminHandler.OpenProvider)
+	adminRoutes.POST("/providers/:id/close", adminHandler.CloseProvider)
+	adminRoutes.POST("/providers/:id/drain", adminHandler.DrainProvider)
+	adminRoutes.POST("/providers/:id/reset", adminHandler.ResetProvider)
+	adminRoutes.GET("/audit", adminHandler.ExportAudit)
+	adminRoutes.POST("/disputes", disputeHandler.CreateDispute)
+	adminRoutes.GET("/disputes", disputeHandler.AdminListDisputes)
+	adminRoutes.GET("/disputes/:id", disputeHandler.AdminGetDispute)
+	adminRoutes.POST("/disputes/:id/status", disputeHandler.UpdateDispute)
+	router.Use(middleware.RateLimit(limiter, "client_ip", cfg.RateLimit.ClientIP, middleware.ByClientIP))
+	// Signed by the provider, not authenticated with an API key
+	router.POST("/webhooks/providers/:id/disputes", disputeHandler.ProviderNotification)
+	authorized := router.Group("/",
+		middleware.MerchantAuth(merchantService),
+		middleware.RateLimit(limiter, "api_key", cfg.RateLimit.APIKey, middleware.ByAPIKey),
+	)
+	chargeHandlers := []gin.HandlerFunc{middleware.RateLimit(limiter, "card", cfg.RateLimit.Card, middleware.ByCardFingerprint)}
+	if cfg.Admission.Enabled {
+		// Only new charges are shed, refunds and reads are always admitted
+		chargeHandlers = append(chargeHandlers, middleware.Admission(admission.NewLimiter(admission.Settings{
+			InitialLimit:  cfg.Admission.InitialLimit,
+			MinLimit:      cfg.Admission.MinLimit,
+			MaxLimit:      cfg.Admission.MaxLimit,
+			TargetLatency: cfg.GetAdmissionTargetLatency(),
+			Backoff:       cfg.Admission.Backoff,
+		})))
+	}
+	authorized.POST("/payments", append(chargeHandlers, paymentHandler.ProcessPayment)...)
+	authorized.POST("/payments/:id/confirm", paymentHandler.ConfirmPayment)
+	authorized.POST("/refund/:id", paymentHandler.RefundPayment)
+	authorized.GET("/payments", paymentHandler.ListPayments)
+	authorized.GET("/payments/:id", paymentHandler.GetPayment)
+	authorized.GET("/payments/:id/events", paymentHandler.PaymentEvents)
+	authorized.GET("/payments/:id/refunds", paymentHandler.PaymentRefunds)
+	authorized.GET("/installments/simulate", installmentHandler.Simulate)
+	authorized.POST("/batches", batchHandler.SubmitBatch)
+	authorized.GET("/batches/:id", batchHandler.GetBatch)
+	authorized.GET("/batches/:id/results", batchHandler.BatchResults)
+	authorized.POST("/cards", subscriptionHandler.StoreCard)
+	authorized.GET("/cards/:token", subscriptionHandler.GetCard)
+	authorized.DELETE("/cards/:token", subscriptionHandler.DeleteCard)
+	authorized.POST("/plans", subscriptionHandler.CreatePlan)
+	authorized.GET("/plans", subscriptionHandler.ListPlans)
+	authorized.GET("/plans/:id", subscriptionHandler.GetPlan)
+	authorized.POST("/subscriptions", subscriptionHandler.CreateSubscription)
+	authorized.GET("/subscriptions", subscriptionHandler.ListSubscriptions)
+	authorized.GET("/subscriptions/:id", subscriptionHandler.GetSubscription)
+	authorized.POST("/subscriptions/:id/pause", subscriptionHandler.PauseSubscription)
+	authorized.POST("/subscriptions/:id/resume", subscriptionHandler.ResumeSubscription)
+	authorized.POST("/subscriptions/:id/cancel", subscriptionHandler.CancelSubscription)
+	authorized.GET("/disputes", disputeHandler.ListDisputes)
+	authorized.GET("/disputes/:id", disputeHandler.GetDispute)
+	authorized.POST("/disputes/:id/evidence", disputeHandler.UploadEvidence)
+	authorized.GET("/api-keys", merchantHandler.ListAPIKeys)
+	authorized.POST("/api-keys", merchantHandler.CreateAPIKey)
+	authorized.POST("/api-keys/:id/rotate", merchantHandler.RotateAPIKey)
+	authorized.DELETE("/api-keys/:id", merchantHandler.RevokeAPIKey)
 
 	// Start the server
+	server := &http.Server{Addr: ":8080", Handler: router}
 	go func() {
-		if err := router.Run(":8080"); err != nil {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("Failed to start the API server: %v", err)
 		}
 	}()
@@ -83,5 +309,23 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
+	// Stop accepting requests and let the running ones finish
+	stopSweeper()
+	log.Printf("Draining %d in-flight requests, workers and batches...", drainer.Active())
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.GetShutdownDrainTimeout())
+	defer cancelDrain()
+	if err := drainer.Drain(drainCtx); err != nil {
+		operations := paymentService.InFlight()
+		log.Printf("Drain timed out, recording %d payment operations with unknown outcome", len(operations))
+		if err := shutdown.RecordUnknownOutcomes(cfg.Shutdown.UnknownOutcomeFile, operations, "interrupted by shutdown"); err != nil {
+			log.Printf("Failed to record unknown outcomes: %v", err)
+		}
+	}
+
 	log.Println("Shutting down all servers...")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to shut down the API server: %v", err)
+	}
 }
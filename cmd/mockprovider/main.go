@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"desafio-api/internal/providers"
+	"desafio-api/mock"
+)
+
+// mockprovider runs a single mock payment provider, so the gateway can be
+// pointed at providers speaking different wire formats.
+func main() {
+	addr := flag.String("addr", ":3001", "address to listen on")
+	format := flag.String("format", providers.FormatStandard, "wire format: standard, stripe or braintree")
+	scenarioFile := flag.String("scenario", "", "YAML, TOML or JSON scenario file with fault injection rules")
+	flag.Parse()
+
+	server, err := mock.NewMockServerWithFormat(*format)
+	if err != nil {
+		log.Fatalf("Failed to create mock provider: %v", err)
+	}
+
+	if *scenarioFile != "" {
+		scenario, err := mock.LoadScenario(*scenarioFile)
+		if err != nil {
+			log.Fatalf("Failed to load scenario: %v", err)
+		}
+		if err := server.SetScenario(scenario); err != nil {
+			log.Fatalf("Failed to apply scenario: %v", err)
+		}
+	}
+
+	log.Printf("Mock provider speaking the %s format", *format)
+	if err := server.Run(*addr); err != nil {
+		log.Fatalf("Failed to start mock provider: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+// Authenticator checks the keys of the operators allowed to use the admin
+// API. Like merchant keys, only their SHA-256 hashes are configured.
+type Authenticator struct {
+	keys []config.APIKeyConfig
+}
+
+func NewAuthenticator(cfg *config.Config) (*Authenticator, error) {
+	keys := make([]config.APIKeyConfig, 0, len(cfg.Admin.APIKeys))
+	for _, k := range cfg.Admin.APIKeys {
+		if k.ID == "" || k.Hash == "" {
+			return nil, fmt.Errorf("admin api keys require an id and a hash")
+		}
+		keys = append(keys, config.APIKeyConfig{ID: k.ID, Hash: strings.ToLower(k.Hash)})
+	}
+	return &Authenticator{keys: keys}, nil
+}
+
+// Authenticate returns the ID of the admin key, which identifies the
+// operator in the audit log.
+func (a *Authenticator) Authenticate(plaintext string) (string, error) {
+	sum := sha256.Sum256([]byte(plaintext))
+	hash := []byte(hex.EncodeToString(sum[:]))
+
+	// Every key is compared so the time taken doesn't reveal which matched
+	matched := ""
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(hash, []byte(k.Hash)) == 1 {
+			matched = k.ID
+		}
+	}
+	if matched == "" {
+		return "", domain.ErrInvalidAPIKey
+	}
+	return matched, nil
+}
@@ -0,0 +1,36 @@
+package admin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+func TestAuthenticator(t *testing.T) {
+	cfg := &config.Config{Admin: config.AdminConfig{APIKeys: []config.APIKeyConfig{
+		// SHA-256 of "admin-secret" and "oncall-secret"
+		{ID: "ops", Hash: "16175223c8ddce5ace0493c948569c211b03c4c6bb3d3e484434999448cffe01"},
+		{ID: "oncall", Hash: "222EFD97D56A62254079523A3DDBEB341880D3D8467F075B2B6F74AAC1C140A0"},
+	}}}
+	authenticator, err := NewAuthenticator(cfg)
+	assert.NoError(t, err)
+
+	t.Run("valid key", func(t *testing.T) {
+		id, err := authenticator.Authenticate("oncall-secret")
+		assert.NoError(t, err)
+		assert.Equal(t, "oncall", id)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := authenticator.Authenticate("wrong")
+		assert.ErrorIs(t, err, domain.ErrInvalidAPIKey)
+	})
+
+	t.Run("key without hash", func(t *testing.T) {
+		_, err := NewAuthenticator(&config.Config{Admin: config.AdminConfig{APIKeys: []config.APIKeyConfig{{ID: "ops"}}}})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,106 @@
+package admission
+
+import (
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// Settings of an AIMD limiter. The limit of concurrent requests starts at
+// InitialLimit and stays between MinLimit and MaxLimit. Requests slower than
+// TargetLatency, or that failed because of overload, multiply the limit by
+// Backoff; the others grow it by one every limit requests.
+type Settings struct {
+	InitialLimit  int
+	MinLimit      int
+	MaxLimit      int
+	TargetLatency time.Duration
+	Backoff       float64
+}
+
+// Limiter admits requests while fewer than its limit are in flight, adapting
+// the limit to the latency observed. It sheds load early instead of queueing
+// requests behind slow providers.
+type Limiter struct {
+	mutex        sync.Mutex
+	settings     Settings
+	limit        float64
+	inFlight     int
+	rejected     int64
+	lastDecrease time.Time
+	now          func() time.Time
+}
+
+func NewLimiter(settings Settings) *Limiter {
+	if settings.MinLimit < 1 {
+		settings.MinLimit = 1
+	}
+	if settings.MaxLimit < settings.MinLimit {
+		settings.MaxLimit = settings.MinLimit
+	}
+	if settings.Backoff <= 0 || settings.Backoff >= 1 {
+		settings.Backoff = 0.9
+	}
+	limiter := &Limiter{settings: settings, now: time.Now}
+	limiter.limit = limiter.clamp(float64(settings.InitialLimit))
+	return limiter
+}
+
+// Begin admits a request, returning false when the limit is reached. An
+// admitted request must call End.
+func (l *Limiter) Begin() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.inFlight >= int(l.limit) {
+		l.rejected++
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+// End records the outcome of an admitted request. overloaded marks requests
+// that failed because the gateway or its providers couldn't keep up.
+func (l *Limiter) End(latency time.Duration, overloaded bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	utilized := l.inFlight >= int(l.limit)/2
+	l.inFlight--
+
+	if overloaded || (l.settings.TargetLatency > 0 && latency > l.settings.TargetLatency) {
+		// Requests that were in flight together report the same congestion,
+		// so the limit is lowered at most once per target latency
+		now := l.now()
+		if now.Sub(l.lastDecrease) < l.settings.TargetLatency {
+			return
+		}
+		l.lastDecrease = now
+		previous := int(l.limit)
+		l.limit = l.clamp(l.limit * l.settings.Backoff)
+		if int(l.limit) != previous {
+			log.Printf("admission limit lowered from %d to %d", previous, int(l.limit))
+		}
+		return
+	}
+	if utilized {
+		l.limit = l.clamp(l.limit + 1/l.limit)
+	}
+}
+
+// Stats is a snapshot of the limiter.
+type Stats struct {
+	Limit    int   `json:"limit"`
+	InFlight int   `json:"inFlight"`
+	Rejected int64 `json:"rejected"`
+}
+
+func (l *Limiter) Stats() Stats {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return Stats{Limit: int(l.limit), InFlight: l.inFlight, Rejected: l.rejected}
+}
+
+func (l *Limiter) clamp(limit float64) float64 {
+	return math.Max(float64(l.settings.MinLimit), math.Min(float64(l.settings.MaxLimit), limit))
+}
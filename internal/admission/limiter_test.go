@@ -0,0 +1,61 @@
+package admission
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	settings := Settings{InitialLimit: 4, MinLimit: 2, MaxLimit: 6, TargetLatency: 100 * time.Millisecond, Backoff: 0.5}
+
+	t.Run("rejects requests over the limit", func(t *testing.T) {
+		limiter := NewLimiter(settings)
+		for i := 0; i < 4; i++ {
+			assert.True(t, limiter.Begin())
+		}
+		assert.False(t, limiter.Begin())
+		assert.Equal(t, Stats{Limit: 4, InFlight: 4, Rejected: 1}, limiter.Stats())
+
+		limiter.End(10*time.Millisecond, false)
+		assert.True(t, limiter.Begin())
+	})
+
+	t.Run("slow requests lower the limit once per target latency", func(t *testing.T) {
+		limiter := NewLimiter(settings)
+		now := time.Now()
+		limiter.now = func() time.Time { return now }
+
+		for i := 0; i < 3; i++ {
+			limiter.Begin()
+		}
+		limiter.End(time.Second, false)
+		limiter.End(time.Second, false)
+		assert.Equal(t, 2, limiter.Stats().Limit)
+
+		now = now.Add(time.Second)
+		limiter.End(time.Second, true)
+		assert.Equal(t, 2, limiter.Stats().Limit, "never below the minimum")
+	})
+
+	t.Run("fast requests grow the limit up to the maximum", func(t *testing.T) {
+		limiter := NewLimiter(settings)
+		for i := 0; i < 100; i++ {
+			limiter.Begin()
+			limiter.Begin()
+			limiter.End(10*time.Millisecond, false)
+			limiter.End(10*time.Millisecond, false)
+		}
+		assert.Equal(t, 6, limiter.Stats().Limit)
+	})
+
+	t.Run("idle limiter doesn't grow", func(t *testing.T) {
+		limiter := NewLimiter(settings)
+		for i := 0; i < 100; i++ {
+			limiter.Begin()
+			limiter.End(10*time.Millisecond, false)
+		}
+		assert.Equal(t, 4, limiter.Stats().Limit)
+	})
+}
@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"desafio-api/internal/domain"
+)
+
+// MemoryLog is an append-only, in-memory audit log.
+type MemoryLog struct {
+	mutex  sync.RWMutex
+	events []domain.AuditEvent
+	now    func() time.Time
+}
+
+func NewMemoryLog() *MemoryLog {
+	return &MemoryLog{now: time.Now}
+}
+
+func (l *MemoryLog) Append(event *domain.AuditEvent) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	event.ID = uuid.New().String()
+	event.Sequence = int64(len(l.events)) + 1
+	if event.Timestamp.IsZero() {
+		event.Timestamp = l.now()
+	}
+	l.events = append(l.events, *event)
+	return nil
+}
+
+func (l *MemoryLog) List(filter domain.AuditFilter) ([]domain.AuditEvent, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	events := make([]domain.AuditEvent, 0)
+	for _, event := range l.events {
+		if matches(event, filter) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func matches(event domain.AuditEvent, filter domain.AuditFilter) bool {
+	if filter.ResourceType != "" && event.ResourceType != filter.ResourceType {
+		return false
+	}
+	if filter.ResourceID != "" && event.ResourceID != filter.ResourceID {
+		return false
+	}
+	if filter.MerchantID != "" && event.MerchantID != filter.MerchantID {
+		return false
+	}
+	if filter.Since != nil && event.Timestamp.Before(*filter.Since) {
+		return false
+	}
+	return true
+}
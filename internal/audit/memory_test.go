@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/internal/domain"
+)
+
+func TestMemoryLog(t *testing.T) {
+	log := NewMemoryLog()
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	log.now = func() time.Time { return start }
+
+	require.NoError(t, log.Append(&domain.AuditEvent{Action: domain.AuditPaymentCreated, ResourceType: domain.AuditResourcePayment, ResourceID: "pay-1", MerchantID: "m1"}))
+	require.NoError(t, log.Append(&domain.AuditEvent{Action: domain.AuditPaymentCreated, ResourceType: domain.AuditResourcePayment, ResourceID: "pay-2", MerchantID: "m2"}))
+	log.now = func() time.Time { return start.Add(time.Minute) }
+	require.NoError(t, log.Append(&domain.AuditEvent{Action: domain.AuditPaymentRefunded, ResourceType: domain.AuditResourcePayment, ResourceID: "pay-1", MerchantID: "m1"}))
+	require.NoError(t, log.Append(&domain.AuditEvent{Action: domain.AuditProviderReset, ResourceType: domain.AuditResourceProvider, ResourceID: "stripe"}))
+
+	t.Run("events of a resource in order", func(t *testing.T) {
+		events, err := log.List(domain.AuditFilter{ResourceType: domain.AuditResourcePayment, ResourceID: "pay-1"})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, domain.AuditPaymentCreated, events[0].Action)
+		assert.Equal(t, domain.AuditPaymentRefunded, events[1].Action)
+		assert.Less(t, events[0].Sequence, events[1].Sequence)
+		assert.NotEmpty(t, events[0].ID)
+	})
+
+	t.Run("filter by merchant and time", func(t *testing.T) {
+		since := start.Add(30 * time.Second)
+		events, err := log.List(domain.AuditFilter{MerchantID: "m1", Since: &since})
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, domain.AuditPaymentRefunded, events[0].Action)
+	})
+
+	t.Run("listed events can't change the log", func(t *testing.T) {
+		events, _ := log.List(domain.AuditFilter{})
+		events[0].Action = domain.AuditPaymentResolved
+
+		events, _ = log.List(domain.AuditFilter{})
+		assert.Equal(t, domain.AuditPaymentCreated, events[0].Action)
+		assert.Len(t, events, 4)
+	})
+
+	t.Run("ndjson export", func(t *testing.T) {
+		events, _ := log.List(domain.AuditFilter{})
+		var buf bytes.Buffer
+		require.NoError(t, WriteNDJSON(&buf, events))
+
+		scanner := bufio.NewScanner(&buf)
+		lines := 0
+		for scanner.Scan() {
+			var event domain.AuditEvent
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+			assert.Equal(t, events[lines].ID, event.ID)
+			lines++
+		}
+		assert.Equal(t, 4, lines)
+	})
+}
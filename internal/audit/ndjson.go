@@ -0,0 +1,22 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"desafio-api/internal/domain"
+)
+
+const NDJSONContentType = "application/x-ndjson"
+
+// WriteNDJSON writes one JSON encoded event per line.
+func WriteNDJSON(w io.Writer, events []domain.AuditEvent) error {
+	encoder := json.NewEncoder(w)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("error encoding audit event %s: %w", event.ID, err)
+		}
+	}
+	return nil
+}
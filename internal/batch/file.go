@@ -0,0 +1,260 @@
+package batch
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"desafio-api/internal/domain"
+)
+
+const (
+	CSVContentType    = "text/csv"
+	NDJSONContentType = "application/x-ndjson"
+)
+
+// Columns of a CSV batch file. The header names the columns used, in any
+// order; only operation is required.
+var csvColumns = []string{
+	"operation", "key", "paymentId", "amount", "currency", "description", "email",
+	"cardNumber", "holderName", "cvv", "expirationDate", "installments",
+}
+
+var resultColumns = []string{"line", "key", "operation", "status", "paymentId", "paymentStatus", "error"}
+
+// Line is an instruction read from a batch file. Err is set when the line
+// is invalid, so it is reported in the results instead of processed.
+type Line struct {
+	Number      int
+	Instruction domain.BatchInstruction
+	Err         error
+}
+
+// Parse reads the lines of a batch file, up to maxLines (0 means no limit).
+// Only a file that can't be read as a whole is an error; invalid lines are
+// returned with Err set.
+func Parse(format domain.BatchFormat, r io.Reader, maxLines int) ([]Line, error) {
+	var lines []Line
+	var err error
+	switch format {
+	case domain.BatchCSV:
+		lines, err = parseCSV(r, maxLines)
+	case domain.BatchNDJSON:
+		lines, err = parseNDJSON(r, maxLines)
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q", domain.ErrInvalidBatch, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: no instructions", domain.ErrInvalidBatch)
+	}
+	for i := range lines {
+		if lines[i].Err == nil {
+			lines[i].Err = validate(lines[i].Instruction)
+		}
+		if lines[i].Instruction.Key == "" {
+			lines[i].Instruction.Key = contentKey(lines[i].Instruction)
+		}
+	}
+	return lines, nil
+}
+
+func parseCSV(r io.Reader, maxLines int) ([]Line, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: no instructions", domain.ErrInvalidBatch)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidBatch, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		if !knownColumn(name) {
+			return nil, fmt.Errorf("%w: unknown column %q", domain.ErrInvalidBatch, name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["operation"]; !ok {
+		return nil, fmt.Errorf("%w: missing column \"operation\"", domain.ErrInvalidBatch)
+	}
+
+	var lines []Line
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("%w: %w", domain.ErrInvalidBatch, err)
+			}
+			lines = append(lines, Line{Number: parseErr.StartLine, Err: err})
+		} else {
+			number, _ := reader.FieldPos(0)
+			lines = append(lines, csvLine(number, columns, record))
+		}
+		if maxLines > 0 && len(lines) > maxLines {
+			return nil, fmt.Errorf("%w: more than %d instructions", domain.ErrInvalidBatch, maxLines)
+		}
+	}
+	return lines, nil
+}
+
+func knownColumn(name string) bool {
+	for _, column := range csvColumns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
+
+func csvLine(number int, columns map[string]int, record []string) Line {
+	field := func(name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	line := Line{Number: number}
+	line.Instruction = domain.BatchInstruction{
+		Operation: domain.BatchOperation(field("operation")),
+		Key:       field("key"),
+		PaymentID: field("paymentId"),
+		PaymentRequest: domain.PaymentRequest{
+			Currency:    field("currency"),
+			Description: field("description"),
+			Email:       field("email"),
+			Card: domain.Card{
+				Number:         field("cardNumber"),
+				HolderName:     field("holderName"),
+				CVV:            field("cvv"),
+				ExpirationDate: field("expirationDate"),
+			},
+		},
+	}
+	if value := field("amount"); value != "" {
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			line.Err = fmt.Errorf("amount must be a number")
+			return line
+		}
+		line.Instruction.Amount = amount
+	}
+	if value := field("installments"); value != "" {
+		installments, err := strconv.Atoi(value)
+		if err != nil {
+			line.Err = fmt.Errorf("installments must be an integer")
+			return line
+		}
+		line.Instruction.Card.Installments = installments
+	}
+	return line
+}
+
+func parseNDJSON(r io.Reader, maxLines int) ([]Line, error) {
+	var lines []Line
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for number := 1; scanner.Scan(); number++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		line := Line{Number: number}
+		if err := json.Unmarshal(scanner.Bytes(), &line.Instruction); err != nil {
+			line.Err = fmt.Errorf("invalid JSON: %v", err)
+		}
+		lines = append(lines, line)
+		if maxLines > 0 && len(lines) > maxLines {
+			return nil, fmt.Errorf("%w: more than %d instructions", domain.ErrInvalidBatch, maxLines)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidBatch, err)
+	}
+	return lines, nil
+}
+
+func validate(instruction domain.BatchInstruction) error {
+	switch instruction.Operation {
+	case domain.BatchPayment:
+		if instruction.Amount <= 0 {
+			return fmt.Errorf("amount must be positive")
+		}
+		if instruction.Currency == "" {
+			return fmt.Errorf("currency is required")
+		}
+	case domain.BatchRefund:
+		if instruction.PaymentID == "" {
+			return fmt.Errorf("paymentId is required")
+		}
+		if instruction.Amount <= 0 {
+			return fmt.Errorf("amount must be positive")
+		}
+	default:
+		return fmt.Errorf("operation must be %q or %q", domain.BatchPayment, domain.BatchRefund)
+	}
+	return nil
+}
+
+// contentKey identifies a line without a key by its content, so the same
+// line uploaded again is recognized. The card enters the key only by its
+// fingerprint and without the CVV.
+func contentKey(instruction domain.BatchInstruction) string {
+	instruction.Card.Number = instruction.Card.Fingerprint()
+	instruction.Card.CVV = ""
+	content, _ := json.Marshal(instruction)
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// WriteResults writes the line results as a CSV file with a header, or one
+// JSON result per line.
+func WriteResults(w io.Writer, format domain.BatchFormat, results []domain.BatchLineResult) error {
+	if format == domain.BatchNDJSON {
+		encoder := json.NewEncoder(w)
+		for _, result := range results {
+			if err := encoder.Encode(result); err != nil {
+				return fmt.Errorf("error encoding result of line %d: %w", result.Line, err)
+			}
+		}
+		return nil
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(resultColumns); err != nil {
+		return fmt.Errorf("error writing results: %w", err)
+	}
+	for _, result := range results {
+		record := []string{
+			strconv.Itoa(result.Line),
+			result.Key,
+			string(result.Operation),
+			string(result.Status),
+			result.PaymentID,
+			string(result.PaymentStatus),
+			result.Error,
+		}
+		if err := writer.Write(record); err != nil {
+			return fmt.Errorf("error writing result of line %d: %w", result.Line, err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
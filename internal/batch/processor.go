@@ -0,0 +1,387 @@
+package batch
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"desafio-api/internal/domain"
+)
+
+type PaymentService interface {
+	ProcessPayment(actor domain.Actor, request domain.PaymentRequest) (*domain.Payment, error)
+	RefundPayment(actor domain.Actor, paymentID string, request domain.RefundRequest) (*domain.Payment, error)
+}
+
+// Settings of a Processor. Concurrency is the number of lines of a batch
+// processed at the same time; files with more than MaxLines lines are
+// rejected (0 means no limit). The outcomes of the lines are appended to
+// JournalFile, if set, so they survive a restart.
+type Settings struct {
+	Concurrency int
+	MaxLines    int
+	JournalFile string
+}
+
+// ErrStopped is returned by Submit once the processor is stopped.
+var ErrStopped = errors.New("batch processor is stopped")
+
+// Processor runs the lines of uploaded batch files through the payment
+// service. Every processed line is remembered by merchant and key, so a
+// line uploaded again, in the same file or a later one, reports the earlier
+// result instead of charging or refunding twice. Charges are sent with a
+// reference derived from the key, so a provider that saw one already can
+// tell. Lines that failed are forgotten and processed again on the next
+// upload, except those whose result went through but couldn't be stored.
+type Processor struct {
+	mutex    sync.Mutex
+	service  PaymentService
+	settings Settings
+	batches  map[string]*domain.Batch
+	outcomes map[string]*domain.BatchLineResult
+	journal  *json.Encoder
+	file     *os.File
+	running  sync.WaitGroup
+	stopped  bool
+	now      func() time.Time
+}
+
+type journalEntry struct {
+	Key    string                  `json:"key"`
+	Result *domain.BatchLineResult `json:"result"`
+}
+
+// NewProcessor loads the outcomes journaled in settings.JournalFile, if
+// set, compacts the journal to the latest outcome of each key and keeps
+// journaling to it.
+func NewProcessor(service PaymentService, settings Settings) (*Processor, error) {
+	if settings.Concurrency < 1 {
+		settings.Concurrency = 1
+	}
+	p := &Processor{
+		service:  service,
+		settings: settings,
+		batches:  make(map[string]*domain.Batch),
+		outcomes: make(map[string]*domain.BatchLineResult),
+		now:      time.Now,
+	}
+	if settings.JournalFile == "" {
+		return p, nil
+	}
+
+	if err := p.loadJournal(settings.JournalFile); err != nil {
+		return nil, err
+	}
+	if err := writeJournal(settings.JournalFile, p.outcomes); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(settings.JournalFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening batch journal: %w", err)
+	}
+	p.file = file
+	p.journal = json.NewEncoder(file)
+	return p, nil
+}
+
+func (p *Processor) loadJournal(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening batch journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("error parsing batch journal on line %d: %w", line, err)
+		}
+		if entry.Key != "" && entry.Result != nil {
+			p.outcomes[entry.Key] = entry.Result
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading batch journal: %w", err)
+	}
+	return nil
+}
+
+func writeJournal(path string, outcomes map[string]*domain.BatchLineResult) error {
+	keys := make([]string, 0, len(outcomes))
+	for key := range outcomes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error compacting batch journal: %w", err)
+	}
+	encoder := json.NewEncoder(file)
+	for _, key := range keys {
+		if err := encoder.Encode(journalEntry{Key: key, Result: outcomes[key]}); err != nil {
+			file.Close()
+			return fmt.Errorf("error compacting batch journal: %w", err)
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("error compacting batch journal: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error compacting batch journal: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error compacting batch journal: %w", err)
+	}
+	return nil
+}
+
+// Submit parses a batch file and starts processing it in the background,
+// returning the batch right away. Files that can't be parsed return an
+// error wrapping ErrInvalidBatch.
+func (p *Processor) Submit(actor domain.Actor, format domain.BatchFormat, r io.Reader) (*domain.Batch, error) {
+	lines, err := Parse(format, r, p.settings.MaxLines)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &domain.Batch{
+		ID:         uuid.New().String(),
+		MerchantID: actor.MerchantID,
+		Format:     format,
+		Status:     domain.BatchProcessing,
+		Total:      len(lines),
+		CreatedAt:  p.now(),
+		Results:    make([]domain.BatchLineResult, len(lines)),
+	}
+	p.mutex.Lock()
+	if p.stopped {
+		p.mutex.Unlock()
+		return nil, ErrStopped
+	}
+	p.batches[batch.ID] = batch
+	snapshot := copyBatch(batch)
+	p.running.Add(1)
+	p.mutex.Unlock()
+
+	log.Printf("batch %s submitted with %d lines", batch.ID, len(lines))
+	go func() {
+		defer p.running.Done()
+		p.run(actor, batch, lines)
+	}()
+	return snapshot, nil
+}
+
+// Stop turns new batches away and waits for the lines being processed.
+// Lines not started yet are left out, to be processed on a re-upload.
+func (p *Processor) Stop() {
+	p.mutex.Lock()
+	p.stopped = true
+	p.mutex.Unlock()
+	p.running.Wait()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.file != nil {
+		if err := p.file.Close(); err != nil {
+			log.Printf("failed to close batch journal: %v", err)
+		}
+		p.file, p.journal = nil, nil
+	}
+}
+
+func (p *Processor) isStopped() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.stopped
+}
+
+// Get returns a copy of the batch, with the results of the lines processed
+// so far.
+func (p *Processor) Get(merchantID string, batchID string) (*domain.Batch, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	batch, ok := p.batches[batchID]
+	if !ok || batch.MerchantID != merchantID {
+		return nil, domain.ErrBatchNotFound
+	}
+	return copyBatch(batch), nil
+}
+
+func (p *Processor) run(actor domain.Actor, batch *domain.Batch, lines []Line) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < p.settings.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				result := p.process(actor, lines[index])
+				if result.Status == domain.BatchLineFailed {
+					log.Printf("batch %s line %d failed: %s", batch.ID, result.Line, result.Error)
+				}
+				p.record(batch, index, result)
+			}
+		}()
+	}
+	for index := range lines {
+		if p.isStopped() {
+			break
+		}
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	p.mutex.Lock()
+	completedAt := p.now()
+	batch.Status = domain.BatchCompleted
+	batch.CompletedAt = &completedAt
+	summary := *batch
+	p.mutex.Unlock()
+	if summary.Processed < summary.Total {
+		log.Printf("batch %s stopped after %d of %d lines", summary.ID, summary.Processed, summary.Total)
+	}
+	log.Printf("batch %s completed: %d succeeded, %d failed, %d duplicates", summary.ID, summary.Succeeded, summary.Failed, summary.Duplicates)
+}
+
+func (p *Processor) process(actor domain.Actor, line Line) domain.BatchLineResult {
+	instruction := line.Instruction
+	result := domain.BatchLineResult{
+		Line:      line.Number,
+		Key:       instruction.Key,
+		Operation: instruction.Operation,
+	}
+	if line.Err != nil {
+		result.Status = domain.BatchLineFailed
+		result.Error = line.Err.Error()
+		return result
+	}
+
+	key := actor.MerchantID + ":" + instruction.Key
+	if earlier, claimed := p.claim(key); claimed {
+		result.Status = domain.BatchLineDuplicate
+		if earlier == nil {
+			result.Error = "line is already being processed"
+			return result
+		}
+		result.PaymentID = earlier.PaymentID
+		result.PaymentStatus = earlier.PaymentStatus
+		result.Error = earlier.Error
+		return result
+	}
+
+	var payment *domain.Payment
+	var err error
+	switch instruction.Operation {
+	case domain.BatchPayment:
+		request := instruction.PaymentRequest
+		request.Reference = lineReference(key)
+		payment, err = p.service.ProcessPayment(actor, request)
+	case domain.BatchRefund:
+		payment, err = p.service.RefundPayment(actor, instruction.PaymentID, domain.RefundRequest{Amount: instruction.Amount})
+	}
+	if err != nil {
+		result.Status = domain.BatchLineFailed
+		result.Error = err.Error()
+		result.PaymentID = instruction.PaymentID
+		// It went through, processing it again could charge or refund twice
+		if errors.Is(err, domain.ErrNotStored) {
+			p.release(key, &result)
+		} else {
+			p.release(key, nil)
+		}
+		return result
+	}
+
+	result.Status = domain.BatchLineSucceeded
+	result.PaymentID = payment.ID
+	result.PaymentStatus = payment.Status
+	p.release(key, &result)
+	return result
+}
+
+// lineReference derives the reference of a charge from its line key, the
+// same on every upload.
+func lineReference(key string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("batch:"+key)).String()
+}
+
+// claim reserves a line key for processing. It returns false if the key is
+// free, or true with the earlier result (nil while still processing).
+func (p *Processor) claim(key string) (*domain.BatchLineResult, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if earlier, ok := p.outcomes[key]; ok {
+		if earlier.Status == "" {
+			return nil, true
+		}
+		result := *earlier
+		return &result, true
+	}
+	p.outcomes[key] = &domain.BatchLineResult{}
+	return nil, false
+}
+
+// release stores the result of a claimed key, journaling it, or frees the
+// key when result is nil so the line can be processed again.
+func (p *Processor) release(key string, result *domain.BatchLineResult) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if result == nil {
+		delete(p.outcomes, key)
+		return
+	}
+	stored := *result
+	p.outcomes[key] = &stored
+	if p.journal != nil {
+		if err := p.journal.Encode(journalEntry{Key: key, Result: &stored}); err != nil {
+			log.Printf("failed to journal outcome of batch line %s: %v", key, err)
+		}
+	}
+}
+
+func (p *Processor) record(batch *domain.Batch, index int, result domain.BatchLineResult) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	batch.Results[index] = result
+	batch.Processed++
+	switch result.Status {
+	case domain.BatchLineSucceeded:
+		batch.Succeeded++
+	case domain.BatchLineDuplicate:
+		batch.Duplicates++
+	default:
+		batch.Failed++
+	}
+}
+
+func copyBatch(batch *domain.Batch) *domain.Batch {
+	snapshot := *batch
+	snapshot.Results = make([]domain.BatchLineResult, 0, batch.Processed)
+	for _, result := range batch.Results {
+		if result.Status != "" {
+			snapshot.Results = append(snapshot.Results, result)
+		}
+	}
+	return &snapshot
+}
@@ -0,0 +1,245 @@
+package batch
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/internal/domain"
+)
+
+var testActor = domain.Actor{Type: domain.ActorMerchant, ID: "key-test", MerchantID: "merchant-test"}
+
+type stubService struct {
+	mutex      sync.Mutex
+	charges    int
+	references []string
+	refunds    map[string]float64
+	failures   map[string]error
+}
+
+func newStubService() *stubService {
+	return &stubService{refunds: make(map[string]float64), failures: make(map[string]error)}
+}
+
+func (s *stubService) ProcessPayment(actor domain.Actor, request domain.PaymentRequest) (*domain.Payment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.charges++
+	s.references = append(s.references, request.Reference)
+	if err := s.failures["pay-"+request.Description]; err != nil {
+		return nil, err
+	}
+	return &domain.Payment{ID: "pay-" + request.Description, Status: domain.StatusAuthorized, OriginalAmount: request.Amount}, nil
+}
+
+func (s *stubService) RefundPayment(actor domain.Actor, paymentID string, request domain.RefundRequest) (*domain.Payment, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.failures[paymentID]; err != nil {
+		return nil, err
+	}
+	s.refunds[paymentID] += request.Amount
+	return &domain.Payment{ID: paymentID, Status: domain.StatusRefunded}, nil
+}
+
+func waitCompleted(t *testing.T, processor *Processor, batchID string) *domain.Batch {
+	var batch *domain.Batch
+	require.Eventually(t, func() bool {
+		var err error
+		batch, err = processor.Get(testActor.MerchantID, batchID)
+		return err == nil && batch.Status == domain.BatchCompleted
+	}, time.Second, 5*time.Millisecond)
+	return batch
+}
+
+func TestParse(t *testing.T) {
+	t.Run("csv columns in any order", func(t *testing.T) {
+		file := "amount,operation,paymentId,key\n10.5,refund,pay-1,r1\n,refund,pay-2,\n"
+		lines, err := Parse(domain.BatchCSV, strings.NewReader(file), 0)
+		require.NoError(t, err)
+		require.Len(t, lines, 2)
+		assert.Equal(t, 2, lines[0].Number)
+		assert.Equal(t, domain.BatchRefund, lines[0].Instruction.Operation)
+		assert.Equal(t, 10.5, lines[0].Instruction.Amount)
+		assert.Equal(t, "r1", lines[0].Instruction.Key)
+		assert.NoError(t, lines[0].Err)
+		assert.EqualError(t, lines[1].Err, "amount must be positive")
+		assert.True(t, strings.HasPrefix(lines[1].Instruction.Key, "sha256:"))
+	})
+
+	t.Run("ndjson payment", func(t *testing.T) {
+		file := `{"operation":"payment","amount":20,"currency":"BRL","card":{"number":"4111111111111111","cvv":"123"}}` + "\n\n{bad json\n"
+		lines, err := Parse(domain.BatchNDJSON, strings.NewReader(file), 0)
+		require.NoError(t, err)
+		require.Len(t, lines, 2)
+		assert.Equal(t, "4111111111111111", lines[0].Instruction.Card.Number)
+		assert.NoError(t, lines[0].Err)
+		assert.NotContains(t, lines[0].Instruction.Key, "4111111111111111")
+		assert.Equal(t, 3, lines[1].Number)
+		assert.Error(t, lines[1].Err)
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		_, err := Parse(domain.BatchCSV, strings.NewReader("operation,unknown\nrefund,x\n"), 0)
+		assert.ErrorIs(t, err, domain.ErrInvalidBatch)
+		_, err = Parse(domain.BatchCSV, strings.NewReader("key\nk1\n"), 0)
+		assert.ErrorIs(t, err, domain.ErrInvalidBatch)
+		_, err = Parse(domain.BatchNDJSON, strings.NewReader(""), 0)
+		assert.ErrorIs(t, err, domain.ErrInvalidBatch)
+		_, err = Parse(domain.BatchCSV, strings.NewReader("operation\nrefund\nrefund\nrefund\n"), 2)
+		assert.ErrorIs(t, err, domain.ErrInvalidBatch)
+	})
+}
+
+func TestProcessor(t *testing.T) {
+	t.Run("lines are processed with per line results", func(t *testing.T) {
+		service := newStubService()
+		service.failures["pay-missing"] = domain.ErrPaymentNotFound
+		processor, err := NewProcessor(service, Settings{Concurrency: 2})
+		require.NoError(t, err)
+
+		file := "operation,key,paymentId,amount,currency,description,cardNumber\n" +
+			"payment,c1,,30,BRL,first,4111111111111111\n" +
+			"refund,r1,pay-1,10,,,\n" +
+			"refund,r2,pay-missing,10,,,\n" +
+			"refund,r3,,10,,,\n"
+		submitted, err := processor.Submit(testActor, domain.BatchCSV, strings.NewReader(file))
+		require.NoError(t, err)
+		assert.Equal(t, domain.BatchProcessing, submitted.Status)
+		assert.Equal(t, 4, submitted.Total)
+
+		batch := waitCompleted(t, processor, submitted.ID)
+		assert.Equal(t, 4, batch.Processed)
+		assert.Equal(t, 2, batch.Succeeded)
+		assert.Equal(t, 2, batch.Failed)
+		require.Len(t, batch.Results, 4)
+		assert.Equal(t, "pay-first", batch.Results[0].PaymentID)
+		assert.Equal(t, domain.StatusAuthorized, batch.Results[0].PaymentStatus)
+		assert.Equal(t, domain.BatchLineFailed, batch.Results[2].Status)
+		assert.Contains(t, batch.Results[2].Error, "payment not found")
+		assert.Equal(t, "paymentId is required", batch.Results[3].Error)
+		assert.NotNil(t, batch.CompletedAt)
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteResults(&buf, domain.BatchCSV, batch.Results))
+		assert.True(t, strings.HasPrefix(buf.String(), "line,key,operation,status,paymentId,paymentStatus,error\n2,c1,payment,succeeded,pay-first,authorized,\n"))
+	})
+
+	t.Run("re-upload doesn't process lines twice", func(t *testing.T) {
+		service := newStubService()
+		service.failures["pay-2"] = errors.New("provider unavailable")
+		processor, err := NewProcessor(service, Settings{Concurrency: 4})
+		require.NoError(t, err)
+
+		file := `{"operation":"refund","paymentId":"pay-1","amount":10}` + "\n" +
+			`{"operation":"refund","paymentId":"pay-2","amount":10}` + "\n" +
+			`{"operation":"refund","paymentId":"pay-1","amount":10}` + "\n"
+		submitted, err := processor.Submit(testActor, domain.BatchNDJSON, strings.NewReader(file))
+		require.NoError(t, err)
+		batch := waitCompleted(t, processor, submitted.ID)
+		assert.Equal(t, 1, batch.Succeeded)
+		assert.Equal(t, 1, batch.Duplicates)
+		assert.Equal(t, 1, batch.Failed)
+
+		// The failed line is retried, the others are reported as duplicates
+		delete(service.failures, "pay-2")
+		submitted, err = processor.Submit(testActor, domain.BatchNDJSON, strings.NewReader(file))
+		require.NoError(t, err)
+		batch = waitCompleted(t, processor, submitted.ID)
+		assert.Equal(t, 1, batch.Succeeded)
+		assert.Equal(t, 2, batch.Duplicates)
+		assert.Equal(t, "pay-1", batch.Results[0].PaymentID)
+		assert.Equal(t, domain.StatusRefunded, batch.Results[0].PaymentStatus)
+
+		assert.Equal(t, 10.0, service.refunds["pay-1"])
+		assert.Equal(t, 10.0, service.refunds["pay-2"])
+	})
+
+	t.Run("outcomes survive a restart", func(t *testing.T) {
+		journal := filepath.Join(t.TempDir(), "batch_outcomes.ndjson")
+		service := newStubService()
+		service.failures["pay-second"] = fmt.Errorf("%w: store unavailable", domain.ErrNotStored)
+		processor, err := NewProcessor(service, Settings{JournalFile: journal})
+		require.NoError(t, err)
+
+		file := "operation,key,amount,currency,description,cardNumber\n" +
+			"payment,c1,30,BRL,first,4111111111111111\n" +
+			"payment,c2,30,BRL,second,4111111111111111\n"
+		submitted, err := processor.Submit(testActor, domain.BatchCSV, strings.NewReader(file))
+		require.NoError(t, err)
+		batch := waitCompleted(t, processor, submitted.ID)
+		assert.Equal(t, 1, batch.Succeeded)
+		assert.Equal(t, 1, batch.Failed)
+		processor.Stop()
+
+		// Charges are sent with a reference that is the same on every upload
+		require.Len(t, service.references, 2)
+		assert.Equal(t, lineReference(testActor.MerchantID+":c1"), service.references[0])
+		assert.NotEqual(t, service.references[0], service.references[1])
+
+		delete(service.failures, "pay-second")
+		processor, err = NewProcessor(service, Settings{JournalFile: journal})
+		require.NoError(t, err)
+		submitted, err = processor.Submit(testActor, domain.BatchCSV, strings.NewReader(file))
+		require.NoError(t, err)
+		batch = waitCompleted(t, processor, submitted.ID)
+		assert.Equal(t, 2, batch.Duplicates)
+		assert.Equal(t, "pay-first", batch.Results[0].PaymentID)
+		assert.Contains(t, batch.Results[1].Error, "store unavailable", "a line that went through isn't processed again")
+		assert.Equal(t, 2, service.charges)
+	})
+
+	t.Run("journal is compacted to the latest outcome of each key", func(t *testing.T) {
+		journal := filepath.Join(t.TempDir(), "batch_outcomes.ndjson")
+		entries := `{"key":"m:c1","result":{"status":"failed","error":"store unavailable"}}
+{"key":"m:c2","result":{"status":"succeeded","paymentId":"pay-2"}}
+{"key":"m:c1","result":{"status":"succeeded","paymentId":"pay-1"}}
+`
+		require.NoError(t, os.WriteFile(journal, []byte(entries), 0o600))
+
+		processor, err := NewProcessor(newStubService(), Settings{JournalFile: journal})
+		require.NoError(t, err)
+		processor.Stop()
+
+		compacted, err := os.ReadFile(journal)
+		require.NoError(t, err)
+		lines := strings.Split(strings.TrimSpace(string(compacted)), "\n")
+		require.Len(t, lines, 2)
+		assert.Contains(t, lines[0], `"paymentId":"pay-1"`)
+		assert.Contains(t, lines[1], `"paymentId":"pay-2"`)
+
+		processor, err = NewProcessor(newStubService(), Settings{JournalFile: journal})
+		require.NoError(t, err)
+		defer processor.Stop()
+		assert.Equal(t, "pay-1", processor.outcomes["m:c1"].PaymentID)
+	})
+
+	t.Run("stopped processors take no batches", func(t *testing.T) {
+		processor, err := NewProcessor(newStubService(), Settings{})
+		require.NoError(t, err)
+		processor.Stop()
+
+		_, err = processor.Submit(testActor, domain.BatchCSV, strings.NewReader("operation,paymentId,amount\nrefund,pay-1,5\n"))
+		assert.ErrorIs(t, err, ErrStopped)
+	})
+
+	t.Run("batches of other merchants are not found", func(t *testing.T) {
+		processor, err := NewProcessor(newStubService(), Settings{})
+		require.NoError(t, err)
+		submitted, err := processor.Submit(testActor, domain.BatchCSV, strings.NewReader("operation,paymentId,amount\nrefund,pay-1,5\n"))
+		require.NoError(t, err)
+
+		_, err = processor.Get("other-merchant", submitted.ID)
+		assert.ErrorIs(t, err, domain.ErrBatchNotFound)
+	})
+}
@@ -11,15 +11,80 @@ type Config struct {
 	HTTP           HTTPConfig           `mapstructure:"http"`
 	Retry          RetryConfig          `mapstructure:"retry"`
 	CircuitBreaker CircuitBreakerConfig `mapstructure:"circuit_breaker"`
+	Auth           AuthConfig           `mapstructure:"auth"`
+	Merchants      []MerchantConfig     `mapstructure:"merchants"`
+	Providers      []ProviderConfig     `mapstructure:"providers"`
+	RateLimit      RateLimitConfig      `mapstructure:"rate_limit"`
+	Risk           RiskConfig           `mapstructure:"risk"`
+	Mock           MockConfig           `mapstructure:"mock"`
+	Shutdown       ShutdownConfig       `mapstructure:"shutdown"`
+	Recovery       RecoveryConfig       `mapstructure:"recovery"`
+	Health         HealthConfig         `mapstructure:"health"`
+	Admin          AdminConfig          `mapstructure:"admin"`
+	Hedging        HedgingConfig        `mapstructure:"hedging"`
+	Bulkhead       BulkheadConfig       `mapstructure:"bulkhead"`
+	Transport      TransportConfig      `mapstructure:"transport"`
+	Admission      AdmissionConfig      `mapstructure:"admission"`
+	Async          AsyncConfig          `mapstructure:"async"`
+	Batch          BatchConfig          `mapstructure:"batch"`
+	Subscriptions  SubscriptionsConfig  `mapstructure:"subscriptions"`
+	Installments   []InstallmentConfig  `mapstructure:"installments"`
+	FX             FXConfig             `mapstructure:"fx"`
+	Refunds        RefundsConfig        `mapstructure:"refunds"`
+	Disputes       DisputesConfig       `mapstructure:"disputes"`
 }
 
 type HTTPConfig struct {
 	TimeoutSeconds int `mapstructure:"timeout_seconds"`
 }
 
+const (
+	RetryFixed              = "fixed"
+	RetryExponential        = "exponential"
+	RetryDecorrelatedJitter = "decorrelated_jitter"
+)
+
+// RetryConfig is the default retry policy. MaxDelayMs caps the delay
+// between attempts and MaxElapsedMs the time spent on one operation, 0
+// meaning no limit. Policies override it per operation and
+// ProviderConfig.Retry per provider.
 type RetryConfig struct {
-	Attempts     int `mapstructure:"attempts"`
-	DelaySeconds int `mapstructure:"delay_seconds"`
+	Attempts     int                 `mapstructure:"attempts"`
+	DelaySeconds int                 `mapstructure:"delay_seconds"`
+	Strategy     string              `mapstructure:"strategy"`
+	MaxDelayMs   int                 `mapstructure:"max_delay_ms"`
+	MaxElapsedMs int                 `mapstructure:"max_elapsed_ms"`
+	Budget       RetryBudgetConfig   `mapstructure:"budget"`
+	Policies     []RetryPolicyConfig `mapstructure:"policies"`
+}
+
+// RetryPolicyConfig overrides the retry policy for Operation, or for every
+// operation when it is empty. Zero fields keep the inherited value.
+type RetryPolicyConfig struct {
+	Operation    string `mapstructure:"operation"`
+	Strategy     string `mapstructure:"strategy"`
+	Attempts     int    `mapstructure:"attempts"`
+	DelayMs      int    `mapstructure:"delay_ms"`
+	MaxDelayMs   int    `mapstructure:"max_delay_ms"`
+	MaxElapsedMs int    `mapstructure:"max_elapsed_ms"`
+}
+
+// RetryBudgetConfig limits the retries sent to a provider to Ratio of its
+// requests in the last WindowSeconds, plus MinPerSecond so providers with
+// little traffic can still be retried. A Ratio of 0 disables the budget.
+type RetryBudgetConfig struct {
+	Ratio         float64 `mapstructure:"ratio"`
+	MinPerSecond  float64 `mapstructure:"min_per_second"`
+	WindowSeconds int     `mapstructure:"window_seconds"`
+}
+
+// RetryPolicy is the retry policy resolved for a provider and operation.
+type RetryPolicy struct {
+	Strategy   string
+	Attempts   int
+	Delay      time.Duration
+	MaxDelay   time.Duration
+	MaxElapsed time.Duration
 }
 
 type CircuitBreakerConfig struct {
@@ -30,6 +95,307 @@ type CircuitBreakerConfig struct {
 	FailureRatio    float64 `mapstructure:"failure_ratio"`
 }
 
+type AuthConfig struct {
+	RotationGraceSeconds int `mapstructure:"rotation_grace_seconds"`
+}
+
+// MerchantConfig bootstraps a merchant. WebhookURL, when set, receives the
+// dispute events of the merchant, signed with WebhookSecret.
+type MerchantConfig struct {
+	ID            string         `mapstructure:"id"`
+	Name          string         `mapstructure:"name"`
+	APIKeys       []APIKeyConfig `mapstructure:"api_keys"`
+	WebhookURL    string         `mapstructure:"webhook_url"`
+	WebhookSecret string         `mapstructure:"webhook_secret"`
+}
+
+// APIKeyConfig bootstraps a merchant key. Hash is the hex encoded SHA-256
+// of the plaintext key, never the key itself.
+type APIKeyConfig struct {
+	ID   string `mapstructure:"id"`
+	Hash string `mapstructure:"hash"`
+}
+
+// HedgingConfig enables hedged charges: when a provider takes longer than
+// the threshold, the charge is also sent to the next provider and the
+// slower one is voided. A ThresholdMs of 0 uses the p95 latency observed for
+// the provider, bounded by MinThresholdMs and MaxThresholdMs.
+type HedgingConfig struct {
+	Enabled        bool `mapstructure:"enabled"`
+	ThresholdMs    int  `mapstructure:"threshold_ms"`
+	MinThresholdMs int  `mapstructure:"min_threshold_ms"`
+	MaxThresholdMs int  `mapstructure:"max_threshold_ms"`
+}
+
+// BulkheadConfig limits the calls in flight to each provider. Once
+// MaxConcurrent calls are running, up to MaxQueue more wait for a slot for
+// QueueTimeoutMs (0 waits until one frees up) and the rest are rejected.
+// A MaxConcurrent of 0 disables the bulkhead.
+type BulkheadConfig struct {
+	MaxConcurrent  int `mapstructure:"max_concurrent"`
+	MaxQueue       int `mapstructure:"max_queue"`
+	QueueTimeoutMs int `mapstructure:"queue_timeout_ms"`
+}
+
+// TransportConfig tunes the HTTP connection pool of each provider. Zero
+// fields keep the defaults of net/http.
+type TransportConfig struct {
+	MaxIdleConnsPerHost     int `mapstructure:"max_idle_conns_per_host"`
+	MaxConnsPerHost         int `mapstructure:"max_conns_per_host"`
+	IdleConnTimeoutSeconds  int `mapstructure:"idle_conn_timeout_seconds"`
+	DialTimeoutMs           int `mapstructure:"dial_timeout_ms"`
+	TLSHandshakeTimeoutMs   int `mapstructure:"tls_handshake_timeout_ms"`
+	ResponseHeaderTimeoutMs int `mapstructure:"response_header_timeout_ms"`
+}
+
+// AdmissionConfig controls the adaptive concurrency limit of new charges.
+// Charges slower than TargetLatencyMs or failing with overload multiply the
+// limit by Backoff; the others raise it, up to MaxLimit.
+type AdmissionConfig struct {
+	Enabled         bool    `mapstructure:"enabled"`
+	InitialLimit    int     `mapstructure:"initial_limit"`
+	MinLimit        int     `mapstructure:"min_limit"`
+	MaxLimit        int     `mapstructure:"max_limit"`
+	TargetLatencyMs int     `mapstructure:"target_latency_ms"`
+	Backoff         float64 `mapstructure:"backoff"`
+}
+
+// AsyncConfig controls the charges submitted with ?async=true. Pending jobs
+// are journaled to JournalFile (in memory only when empty) and processed by
+// Workers; beyond MaxPending jobs new submissions are rejected. JournalKey,
+// a hex encoded 32 byte key, encrypts the cards of the journaled jobs so
+// they can be charged after a restart; without it only card tokens are
+// journaled. Jobs that fail because the store is unavailable are retried
+// after RetryDelaySeconds.
+type AsyncConfig struct {
+	Workers                int    `mapstructure:"workers"`
+	MaxPending             int    `mapstructure:"max_pending"`
+	JournalFile            string `mapstructure:"journal_file"`
+	JournalKey             string `mapstructure:"journal_key"`
+	RetryDelaySeconds      int    `mapstructure:"retry_delay_seconds"`
+	CallbackTimeoutSeconds int    `mapstructure:"callback_timeout_seconds"`
+	CallbackAttempts       int    `mapstructure:"callback_attempts"`
+}
+
+// BatchConfig limits the files uploaded to POST /batches and how many of
+// their lines are processed at the same time. The outcome of every line is
+// journaled to JournalFile (in memory only when empty), so a re-upload
+// after a restart doesn't process it twice.
+type BatchConfig struct {
+	Concurrency  int    `mapstructure:"concurrency"`
+	MaxLines     int    `mapstructure:"max_lines"`
+	MaxFileBytes int64  `mapstructure:"max_file_bytes"`
+	JournalFile  string `mapstructure:"journal_file"`
+}
+
+// SubscriptionsConfig sets how often the scheduler looks for subscriptions
+// to charge and the dunning schedule: a failed charge is retried after each
+// of retry_hours, counted from the previous attempt.
+type SubscriptionsConfig struct {
+	SchedulerIntervalSeconds int   `mapstructure:"scheduler_interval_seconds"`
+	RetryHours               []int `mapstructure:"retry_hours"`
+}
+
+// InstallmentConfig holds the installment rules of a currency; payments in
+// other currencies can't be split. Plans of up to InterestFree
+// installments carry no interest. Longer ones carry MonthlyRate (0.0199 for
+// 1.99% a month), added to the charge when Interest is "merchant" or
+// charged by the issuer when it is "issuer".
+type InstallmentConfig struct {
+	Currency            string  `mapstructure:"currency"`
+	MinInstallments     int     `mapstructure:"min_installments"`
+	MaxInstallments     int     `mapstructure:"max_installments"`
+	MinInstallmentValue float64 `mapstructure:"min_installment_value"`
+	InterestFree        int     `mapstructure:"interest_free"`
+	Interest            string  `mapstructure:"interest"`
+	MonthlyRate         float64 `mapstructure:"monthly_rate"`
+}
+
+// FXConfig sets the exchange rates used to convert charges to the
+// settlement currency of a provider. RatesFile is a JSON file reloaded when
+// it changes; without it fixed development rates are used. Markup is the
+// fraction added to the market rate (0.02 for 2%).
+type FXConfig struct {
+	RatesFile string  `mapstructure:"rates_file"`
+	Markup    float64 `mapstructure:"markup"`
+}
+
+const (
+	RefundFallbackNone   = "none"
+	RefundFallbackQueue  = "queue"
+	RefundFallbackCredit = "credit"
+)
+
+// RefundsConfig decides what happens to a refund when the provider of the
+// payment is unavailable. With Fallback "queue" the refund is retried every
+// RetryIntervalSeconds until the provider recovers, and given up after
+// MaxAgeHours. "credit" credits the card through another provider declaring
+// the "credit" operation, and queues the refund when that isn't possible.
+type RefundsConfig struct {
+	Fallback             string `mapstructure:"fallback"`
+	RetryIntervalSeconds int    `mapstructure:"retry_interval_seconds"`
+	MaxAgeHours          int    `mapstructure:"max_age_hours"`
+}
+
+// DisputesConfig sets where the evidence uploaded for disputes is kept and
+// how large each file may be. Disputes opened without a due date must be
+// answered within ResponseDays. Merchant webhooks are given
+// WebhookTimeoutSeconds and tried WebhookAttempts times. Provider webhooks
+// signed more than SignatureToleranceSeconds away from now are refused.
+type DisputesConfig struct {
+	EvidenceDir               string `mapstructure:"evidence_dir"`
+	MaxEvidenceBytes          int64  `mapstructure:"max_evidence_bytes"`
+	ResponseDays              int    `mapstructure:"response_days"`
+	WebhookTimeoutSeconds     int    `mapstructure:"webhook_timeout_seconds"`
+	WebhookAttempts           int    `mapstructure:"webhook_attempts"`
+	SignatureToleranceSeconds int    `mapstructure:"signature_tolerance_seconds"`
+}
+
+// AdminConfig lists the keys of the operators allowed to use the admin API.
+type AdminConfig struct {
+	APIKeys []APIKeyConfig `mapstructure:"api_keys"`
+}
+
+type RateLimitConfig struct {
+	APIKey   LimitConfig `mapstructure:"api_key"`
+	ClientIP LimitConfig `mapstructure:"client_ip"`
+	Card     LimitConfig `mapstructure:"card"`
+}
+
+// LimitConfig is a token bucket: requests_per_second refill rate and burst
+// capacity. A zero rate disables the limit.
+type LimitConfig struct {
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int     `mapstructure:"burst"`
+}
+
+type RiskConfig struct {
+	Enabled          bool                    `mapstructure:"enabled"`
+	ReviewScore      int                     `mapstructure:"review_score"`
+	RejectScore      int                     `mapstructure:"reject_score"`
+	Velocity         []VelocityRuleConfig    `mapstructure:"velocity"`
+	Declines         DeclineRuleConfig       `mapstructure:"declines"`
+	AmountThresholds []AmountThresholdConfig `mapstructure:"amount_thresholds"`
+	Geo              GeoRuleConfig           `mapstructure:"geo"`
+	Blocklist        BlocklistConfig         `mapstructure:"blocklist"`
+}
+
+// VelocityRuleConfig scores a payment when more than Max attempts were made
+// for the same key (card, ip or merchant) within the window.
+type VelocityRuleConfig struct {
+	Key           string `mapstructure:"key"`
+	Max           int    `mapstructure:"max"`
+	WindowSeconds int    `mapstructure:"window_seconds"`
+	Score         int    `mapstructure:"score"`
+}
+
+type DeclineRuleConfig struct {
+	Max           int `mapstructure:"max"`
+	WindowSeconds int `mapstructure:"window_seconds"`
+	Score         int `mapstructure:"score"`
+}
+
+// AmountThresholdConfig scores payments at or above Amount. An empty
+// currency applies to every currency.
+type AmountThresholdConfig struct {
+	Currency string  `mapstructure:"currency"`
+	Amount   float64 `mapstructure:"amount"`
+	Score    int     `mapstructure:"score"`
+}
+
+// GeoRuleConfig maps card BIN prefixes and client IP ranges to ISO country
+// codes, scoring payments where both are known and differ.
+type GeoRuleConfig struct {
+	MismatchScore int                `mapstructure:"mismatch_score"`
+	BINs          []BINCountryConfig `mapstructure:"bins"`
+	IPRanges      []IPCountryConfig  `mapstructure:"ip_ranges"`
+}
+
+type BINCountryConfig struct {
+	Prefix  string `mapstructure:"prefix"`
+	Country string `mapstructure:"country"`
+}
+
+type IPCountryConfig struct {
+	CIDR    string `mapstructure:"cidr"`
+	Country string `mapstructure:"country"`
+}
+
+// BlocklistConfig lists card fingerprints (see domain.Card.Fingerprint) and
+// emails that are always rejected.
+type BlocklistConfig struct {
+	CardFingerprints []string `mapstructure:"card_fingerprints"`
+	Emails           []string `mapstructure:"emails"`
+	Score            int      `mapstructure:"score"`
+}
+
+// ProviderConfig points the gateway at a provider API. Format selects the
+// transformers ("standard", "stripe" or "braintree"); MockAddr is where the
+// embedded mock for this provider listens when mock.embedded is set.
+// Idempotent providers dedupe charges by reference and can look them up,
+// which hedging requires to void the losing charge. Retry, Bulkhead and
+// Transport override the global settings for this provider. Capabilities
+// declares what the provider supports. Dispute webhooks of the provider
+// must be signed with WebhookSecret; without one they are refused.
+type ProviderConfig struct {
+	ID            string              `mapstructure:"id"`
+	Name          string              `mapstructure:"name"`
+	BaseURL       string              `mapstructure:"base_url"`
+	Format        string              `mapstructure:"format"`
+	MockAddr      string              `mapstructure:"mock_addr"`
+	Idempotent    bool                `mapstructure:"idempotent"`
+	Retry         []RetryPolicyConfig `mapstructure:"retry"`
+	Bulkhead      *BulkheadConfig     `mapstructure:"bulkhead"`
+	Transport     *TransportConfig    `mapstructure:"transport"`
+	Capabilities  CapabilitiesConfig  `mapstructure:"capabilities"`
+	WebhookSecret string              `mapstructure:"webhook_secret"`
+}
+
+// CapabilitiesConfig lists what a provider supports; empty lists and zero
+// limits mean no restriction. Operations are "charge", "refund",
+// "partial_refund", "confirm" and "credit", the only one never assumed.
+// Charges in currencies other than Currencies are converted to
+// SettlementCurrency, or skip the provider when it has none. Brands are the
+// ones of domain.Card.Brand.
+type CapabilitiesConfig struct {
+	Operations         []string `mapstructure:"operations"`
+	Currencies         []string `mapstructure:"currencies"`
+	SettlementCurrency string   `mapstructure:"settlement_currency"`
+	Brands             []string `mapstructure:"brands"`
+	MaxInstallments    int      `mapstructure:"max_installments"`
+	MinAmount          float64  `mapstructure:"min_amount"`
+	MaxAmount          float64  `mapstructure:"max_amount"`
+}
+
+// MockConfig controls the embedded mock providers. ScenarioFile is a YAML,
+// TOML or JSON file with the fault injection rules loaded at startup.
+type MockConfig struct {
+	Embedded     bool   `mapstructure:"embedded"`
+	ScenarioFile string `mapstructure:"scenario_file"`
+}
+
+// ShutdownConfig controls the drain on SIGINT/SIGTERM. Provider calls still
+// running after the drain timeout are appended to UnknownOutcomeFile.
+type ShutdownConfig struct {
+	DrainTimeoutSeconds int    `mapstructure:"drain_timeout_seconds"`
+	UnknownOutcomeFile  string `mapstructure:"unknown_outcome_file"`
+}
+
+// RecoveryConfig controls the sweeper that resolves payments in unknown
+// status. Payments younger than MinAgeSeconds are left alone, since the
+// provider may still be processing them.
+type RecoveryConfig struct {
+	SweepIntervalSeconds int `mapstructure:"sweep_interval_seconds"`
+	MinAgeSeconds        int `mapstructure:"min_age_seconds"`
+}
+
+// HealthConfig controls the provider stats. Window is the number of recent
+// calls per provider used for success rate and latency percentiles.
+type HealthConfig struct {
+	ProbeIntervalSeconds int `mapstructure:"probe_interval_seconds"`
+	Window               int `mapstructure:"window"`
+}
+
 func Load() (*Config, error) {
 	viper.SetConfigName("config")
 	viper.SetConfigType("toml")
@@ -39,11 +405,72 @@ func Load() (*Config, error) {
 	viper.SetDefault("http.timeout_seconds", 10)
 	viper.SetDefault("retry.attempts", 3)
 	viper.SetDefault("retry.delay_seconds", 1)
+	viper.SetDefault("retry.strategy", RetryFixed)
+	viper.SetDefault("retry.max_delay_ms", 10000)
+	viper.SetDefault("retry.budget.ratio", 0.2)
+	viper.SetDefault("retry.budget.min_per_second", 1)
+	viper.SetDefault("retry.budget.window_seconds", 10)
 	viper.SetDefault("circuit_breaker.max_requests", 3)
 	viper.SetDefault("circuit_breaker.interval_seconds", 10)
 	viper.SetDefault("circuit_breaker.timeout_seconds", 30)
 	viper.SetDefault("circuit_breaker.min_requests", 3)
 	viper.SetDefault("circuit_breaker.failure_ratio", 0.6)
+	viper.SetDefault("auth.rotation_grace_seconds", 86400)
+	viper.SetDefault("rate_limit.api_key.requests_per_second", 20)
+	viper.SetDefault("rate_limit.api_key.burst", 40)
+	viper.SetDefault("rate_limit.client_ip.requests_per_second", 10)
+	viper.SetDefault("rate_limit.client_ip.burst", 20)
+	viper.SetDefault("rate_limit.card.requests_per_second", 0.05)
+	viper.SetDefault("rate_limit.card.burst", 3)
+	viper.SetDefault("risk.review_score", 50)
+	viper.SetDefault("risk.reject_score", 80)
+	viper.SetDefault("risk.blocklist.score", 100)
+	viper.SetDefault("mock.embedded", true)
+	viper.SetDefault("shutdown.drain_timeout_seconds", 30)
+	viper.SetDefault("shutdown.unknown_outcome_file", "unknown_outcomes.ndjson")
+	viper.SetDefault("recovery.sweep_interval_seconds", 30)
+	viper.SetDefault("recovery.min_age_seconds", 30)
+	viper.SetDefault("health.probe_interval_seconds", 10)
+	viper.SetDefault("health.window", 100)
+	viper.SetDefault("hedging.enabled", false)
+	viper.SetDefault("hedging.min_threshold_ms", 200)
+	viper.SetDefault("hedging.max_threshold_ms", 3000)
+	viper.SetDefault("bulkhead.max_concurrent", 50)
+	viper.SetDefault("bulkhead.max_queue", 100)
+	viper.SetDefault("bulkhead.queue_timeout_ms", 1000)
+	viper.SetDefault("admission.enabled", true)
+	viper.SetDefault("admission.initial_limit", 20)
+	viper.SetDefault("admission.min_limit", 5)
+	viper.SetDefault("admission.max_limit", 40)
+	viper.SetDefault("admission.target_latency_ms", 2000)
+	viper.SetDefault("admission.backoff", 0.9)
+	viper.SetDefault("fx.markup", 0.02)
+	viper.SetDefault("refunds.fallback", RefundFallbackNone)
+	viper.SetDefault("refunds.retry_interval_seconds", 60)
+	viper.SetDefault("refunds.max_age_hours", 72)
+	viper.SetDefault("disputes.evidence_dir", "dispute_evidence")
+	viper.SetDefault("disputes.max_evidence_bytes", 5<<20)
+	viper.SetDefault("disputes.response_days", 7)
+	viper.SetDefault("disputes.webhook_timeout_seconds", 5)
+	viper.SetDefault("disputes.webhook_attempts", 3)
+	viper.SetDefault("disputes.signature_tolerance_seconds", 300)
+	viper.SetDefault("subscriptions.scheduler_interval_seconds", 60)
+	viper.SetDefault("subscriptions.retry_hours", []int{24, 72, 168})
+	viper.SetDefault("batch.concurrency", 4)
+	viper.SetDefault("batch.max_lines", 10000)
+	viper.SetDefault("batch.max_file_bytes", 10<<20)
+	viper.SetDefault("batch.journal_file", "batch_outcomes.ndjson")
+	viper.SetDefault("async.workers", 4)
+	viper.SetDefault("async.max_pending", 1000)
+	viper.SetDefault("async.journal_file", "payment_jobs.ndjson")
+	viper.SetDefault("async.retry_delay_seconds", 5)
+	viper.SetDefault("async.callback_timeout_seconds", 5)
+	viper.SetDefault("async.callback_attempts", 3)
+	viper.SetDefault("transport.max_idle_conns_per_host", 32)
+	viper.SetDefault("transport.max_conns_per_host", 64)
+	viper.SetDefault("transport.idle_conn_timeout_seconds", 90)
+	viper.SetDefault("transport.dial_timeout_ms", 2000)
+	viper.SetDefault("transport.tls_handshake_timeout_ms", 5000)
 
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, fmt.Errorf("error reading config file: %w", err)
@@ -53,6 +480,17 @@ func Load() (*Config, error) {
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
+	if err := config.validateRetry(); err != nil {
+		return nil, err
+	}
+	if err := config.validateAdmission(); err != nil {
+		return nil, err
+	}
+	switch config.Refunds.Fallback {
+	case "", RefundFallbackNone, RefundFallbackQueue, RefundFallbackCredit:
+	default:
+		return nil, fmt.Errorf("invalid refund fallback %q", config.Refunds.Fallback)
+	}
 
 	return &config, nil
 }
@@ -65,6 +503,163 @@ func (c *Config) GetRetryDelay() time.Duration {
 	return time.Duration(c.Retry.DelaySeconds) * time.Second
 }
 
+// GetRetryPolicy resolves the retry policy of an operation at a provider:
+// the [retry] defaults, then the policies for the operation, then the
+// provider's policies for every operation and for the operation.
+func (c *Config) GetRetryPolicy(providerID, operation string) RetryPolicy {
+	policy := RetryPolicy{
+		Strategy:   c.Retry.Strategy,
+		Attempts:   c.Retry.Attempts,
+		Delay:      c.GetRetryDelay(),
+		MaxDelay:   time.Duration(c.Retry.MaxDelayMs) * time.Millisecond,
+		MaxElapsed: time.Duration(c.Retry.MaxElapsedMs) * time.Millisecond,
+	}
+	if policy.Strategy == "" {
+		policy.Strategy = RetryFixed
+	}
+
+	for _, override := range c.Retry.Policies {
+		if override.Operation == operation {
+			policy = override.apply(policy)
+		}
+	}
+	for _, p := range c.Providers {
+		if p.ID != providerID {
+			continue
+		}
+		for _, override := range p.Retry {
+			if override.Operation == "" {
+				policy = override.apply(policy)
+			}
+		}
+		for _, override := range p.Retry {
+			if override.Operation != "" && override.Operation == operation {
+				policy = override.apply(policy)
+			}
+		}
+	}
+	return policy
+}
+
+func (o RetryPolicyConfig) apply(policy RetryPolicy) RetryPolicy {
+	if o.Strategy != "" {
+		policy.Strategy = o.Strategy
+	}
+	if o.Attempts > 0 {
+		policy.Attempts = o.Attempts
+	}
+	if o.DelayMs > 0 {
+		policy.Delay = time.Duration(o.DelayMs) * time.Millisecond
+	}
+	if o.MaxDelayMs > 0 {
+		policy.MaxDelay = time.Duration(o.MaxDelayMs) * time.Millisecond
+	}
+	if o.MaxElapsedMs > 0 {
+		policy.MaxElapsed = time.Duration(o.MaxElapsedMs) * time.Millisecond
+	}
+	return policy
+}
+
+func (c *Config) validateRetry() error {
+	policies := append([]RetryPolicyConfig{{Strategy: c.Retry.Strategy}}, c.Retry.Policies...)
+	for _, p := range c.Providers {
+		policies = append(policies, p.Retry...)
+	}
+	for _, policy := range policies {
+		switch policy.Strategy {
+		case "", RetryFixed, RetryExponential, RetryDecorrelatedJitter:
+		default:
+			return fmt.Errorf("invalid retry strategy %q", policy.Strategy)
+		}
+	}
+	return nil
+}
+
+// validateAdmission keeps the admission limit below every provider's
+// bulkhead, so charges admitted up to the limit leave slots for refunds.
+func (c *Config) validateAdmission() error {
+	if !c.Admission.Enabled {
+		return nil
+	}
+	for _, p := range c.Providers {
+		bulkhead := c.GetBulkhead(p.ID)
+		if bulkhead.MaxConcurrent > 0 && c.Admission.MaxLimit >= bulkhead.MaxConcurrent {
+			return fmt.Errorf("admission max_limit %d must be below the bulkhead max_concurrent %d of provider %s", c.Admission.MaxLimit, bulkhead.MaxConcurrent, p.ID)
+		}
+	}
+	return nil
+}
+
+func (c *Config) GetRetryBudgetWindow() time.Duration {
+	return time.Duration(c.Retry.Budget.WindowSeconds) * time.Second
+}
+
+func (c *Config) GetAdmissionTargetLatency() time.Duration {
+	return time.Duration(c.Admission.TargetLatencyMs) * time.Millisecond
+}
+
+func (c *Config) GetSubscriptionSchedulerInterval() time.Duration {
+	return time.Duration(c.Subscriptions.SchedulerIntervalSeconds) * time.Second
+}
+
+func (c *Config) GetDunningSchedule() []time.Duration {
+	schedule := make([]time.Duration, len(c.Subscriptions.RetryHours))
+	for i, hours := range c.Subscriptions.RetryHours {
+		schedule[i] = time.Duration(hours) * time.Hour
+	}
+	return schedule
+}
+
+func (c *Config) GetRefundRetryInterval() time.Duration {
+	return time.Duration(c.Refunds.RetryIntervalSeconds) * time.Second
+}
+
+func (c *Config) GetRefundMaxAge() time.Duration {
+	return time.Duration(c.Refunds.MaxAgeHours) * time.Hour
+}
+
+func (c *Config) GetDisputeResponseWindow() time.Duration {
+	return time.Duration(c.Disputes.ResponseDays) * 24 * time.Hour
+}
+
+func (c *Config) GetDisputeWebhookTimeout() time.Duration {
+	return time.Duration(c.Disputes.WebhookTimeoutSeconds) * time.Second
+}
+
+func (c *Config) GetDisputeSignatureTolerance() time.Duration {
+	return time.Duration(c.Disputes.SignatureToleranceSeconds) * time.Second
+}
+
+func (c *Config) GetAsyncRetryDelay() time.Duration {
+	return time.Duration(c.Async.RetryDelaySeconds) * time.Second
+}
+
+func (c *Config) GetAsyncCallbackTimeout() time.Duration {
+	return time.Duration(c.Async.CallbackTimeoutSeconds) * time.Second
+}
+
+// GetBulkhead returns the bulkhead of a provider, which replaces the
+// [bulkhead] section when the provider sets one.
+func (c *Config) GetBulkhead(providerID string) BulkheadConfig {
+	for _, p := range c.Providers {
+		if p.ID == providerID && p.Bulkhead != nil {
+			return *p.Bulkhead
+		}
+	}
+	return c.Bulkhead
+}
+
+// GetTransport returns the HTTP transport settings of a provider, which
+// replace the [transport] section when the provider sets them.
+func (c *Config) GetTransport(providerID string) TransportConfig {
+	for _, p := range c.Providers {
+		if p.ID == providerID && p.Transport != nil {
+			return *p.Transport
+		}
+	}
+	return c.Transport
+}
+
 func (c *Config) GetCircuitBreakerInterval() time.Duration {
 	return time.Duration(c.CircuitBreaker.IntervalSeconds) * time.Second
 }
@@ -72,3 +667,35 @@ func (c *Config) GetCircuitBreakerInterval() time.Duration {
 func (c *Config) GetCircuitBreakerTimeout() time.Duration {
 	return time.Duration(c.CircuitBreaker.TimeoutSeconds) * time.Second
 }
+
+func (c *Config) GetAPIKeyRotationGrace() time.Duration {
+	return time.Duration(c.Auth.RotationGraceSeconds) * time.Second
+}
+
+func (c *Config) GetShutdownDrainTimeout() time.Duration {
+	return time.Duration(c.Shutdown.DrainTimeoutSeconds) * time.Second
+}
+
+func (c *Config) GetRecoverySweepInterval() time.Duration {
+	return time.Duration(c.Recovery.SweepIntervalSeconds) * time.Second
+}
+
+func (c *Config) GetRecoveryMinAge() time.Duration {
+	return time.Duration(c.Recovery.MinAgeSeconds) * time.Second
+}
+
+func (c *Config) GetHealthProbeInterval() time.Duration {
+	return time.Duration(c.Health.ProbeIntervalSeconds) * time.Second
+}
+
+func (c *Config) GetHedgingThreshold() time.Duration {
+	return time.Duration(c.Hedging.ThresholdMs) * time.Millisecond
+}
+
+func (c *Config) GetHedgingMinThreshold() time.Duration {
+	return time.Duration(c.Hedging.MinThresholdMs) * time.Millisecond
+}
+
+func (c *Config) GetHedgingMaxThreshold() time.Duration {
+	return time.Duration(c.Hedging.MaxThresholdMs) * time.Millisecond
+}
@@ -0,0 +1,93 @@
+package dispute
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"desafio-api/internal/domain"
+)
+
+// evidenceTypes are the accepted evidence content types, with the
+// extension their files are stored with.
+var evidenceTypes = map[string]string{
+	"application/pdf": ".pdf",
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"text/plain":      ".txt",
+}
+
+// EvidenceStore keeps the evidence files on the local disk, in a directory
+// per dispute. Files are named after their evidence ID, the name they were
+// uploaded with is only kept as metadata.
+type EvidenceStore struct {
+	dir      string
+	maxBytes int64
+}
+
+// NewEvidenceStore stores the evidence under dir, rejecting files larger
+// than maxBytes (0 means no limit).
+func NewEvidenceStore(dir string, maxBytes int64) (*EvidenceStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("error creating evidence directory: %w", err)
+	}
+	return &EvidenceStore{dir: dir, maxBytes: maxBytes}, nil
+}
+
+// Save writes file as evidence of the dispute and returns its path, size
+// and content type. The content type is sniffed from the file rather than
+// trusted from the upload, and must be PDF, PNG, JPEG or plain text.
+func (e *EvidenceStore) Save(disputeID string, evidenceID string, file io.Reader) (path string, size int64, contentType string, err error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", 0, "", fmt.Errorf("error reading evidence: %w", err)
+	}
+	if n == 0 {
+		return "", 0, "", fmt.Errorf("%w: file is empty", domain.ErrInvalidEvidence)
+	}
+	contentType, _, _ = mime.ParseMediaType(http.DetectContentType(head[:n]))
+	extension, ok := evidenceTypes[contentType]
+	if !ok {
+		return "", 0, "", fmt.Errorf("%w: files of type %s are not accepted", domain.ErrInvalidEvidence, contentType)
+	}
+
+	dir := filepath.Join(e.dir, disputeID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", 0, "", fmt.Errorf("error creating evidence directory: %w", err)
+	}
+	path = filepath.Join(dir, evidenceID+extension)
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return "", 0, "", fmt.Errorf("error creating evidence file: %w", err)
+	}
+	content := io.MultiReader(bytes.NewReader(head[:n]), file)
+	if e.maxBytes > 0 {
+		content = io.LimitReader(content, e.maxBytes+1)
+	}
+	size, err = io.Copy(out, content)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	switch {
+	case err != nil:
+		err = fmt.Errorf("error writing evidence file: %w", err)
+	case e.maxBytes > 0 && size > e.maxBytes:
+		err = fmt.Errorf("%w: the limit is %d bytes", domain.ErrEvidenceTooLarge, e.maxBytes)
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", 0, "", err
+	}
+	return path, size, contentType, nil
+}
+
+// Remove deletes an evidence file that was saved but couldn't be attached.
+func (e *EvidenceStore) Remove(path string) error {
+	return os.Remove(path)
+}
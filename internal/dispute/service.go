@@ -0,0 +1,424 @@
+package dispute
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"desafio-api/internal/domain"
+	"desafio-api/internal/webhook"
+)
+
+type PaymentService interface {
+	ProviderTransaction(providerID string, chargeID string) (*domain.Transaction, error)
+	DisputePayment(actor domain.Actor, paymentID string, amount float64) (*domain.Transaction, error)
+	CloseDispute(actor domain.Actor, paymentID string, won bool, amount float64) (*domain.Payment, error)
+}
+
+// Notifier delivers dispute events to the merchant of the dispute.
+type Notifier interface {
+	Notify(merchantID string, event domain.DisputeEvent)
+}
+
+// Settings of a Service. Disputes opened without a due date must be
+// answered within ResponseWindow. ProviderSecrets maps the ID of each
+// provider to the secret its webhooks are signed with; webhooks of
+// providers without one are refused, as are webhooks signed more than
+// SignatureTolerance away from now.
+type Settings struct {
+	ResponseWindow     time.Duration
+	ProviderSecrets    map[string]string
+	SignatureTolerance time.Duration
+}
+
+// Service keeps the disputes opened against payments, by providers through
+// their webhooks or by admins, and the evidence merchants upload for them.
+// A payment is disputed while its dispute is open; every change of a
+// dispute is recorded in the audit log and sent to the merchant.
+type Service struct {
+	mutex    sync.Mutex
+	payments PaymentService
+	evidence *EvidenceStore
+	audit    domain.AuditLog
+	notifier Notifier
+	settings Settings
+	disputes map[string]*domain.Dispute
+	// Signatures of the webhooks handled within the tolerance, by the
+	// time they were signed at
+	handled map[string]time.Time
+	now     func() time.Time
+}
+
+// NewService creates the dispute service. auditLog and notifier may be nil.
+func NewService(payments PaymentService, evidence *EvidenceStore, auditLog domain.AuditLog, notifier Notifier, settings Settings) *Service {
+	return &Service{
+		payments: payments,
+		evidence: evidence,
+		audit:    auditLog,
+		notifier: notifier,
+		settings: settings,
+		disputes: make(map[string]*domain.Dispute),
+		handled:  make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+// Create opens a dispute against a payment, which becomes disputed. A
+// payment has at most one open dispute.
+func (s *Service) Create(actor domain.Actor, request domain.DisputeRequest) (*domain.Dispute, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	dispute, err := s.create(actor, request)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot(dispute), nil
+}
+
+func (s *Service) create(actor domain.Actor, request domain.DisputeRequest) (*domain.Dispute, error) {
+	if request.PaymentID == "" {
+		return nil, fmt.Errorf("%w: paymentId is required", domain.ErrInvalidDispute)
+	}
+	if request.ReasonCode == "" {
+		return nil, fmt.Errorf("%w: reasonCode is required", domain.ErrInvalidDispute)
+	}
+	if request.Amount < 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", domain.ErrInvalidDispute)
+	}
+	switch request.Status {
+	case "":
+		request.Status = domain.DisputeNeedsResponse
+	case domain.DisputeNeedsResponse, domain.DisputeUnderReview:
+	default:
+		return nil, fmt.Errorf("%w: disputes are opened with status %s or %s", domain.ErrInvalidDispute, domain.DisputeNeedsResponse, domain.DisputeUnderReview)
+	}
+	for _, open := range s.disputes {
+		if open.PaymentID == request.PaymentID && !open.Status.Closed() {
+			return nil, fmt.Errorf("%w: payment already has the open dispute %s", domain.ErrInvalidDispute, open.ID)
+		}
+	}
+
+	transaction, err := s.payments.DisputePayment(actor, request.PaymentID, request.Amount)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	dispute := &domain.Dispute{
+		ID:                uuid.New().String(),
+		PaymentID:         transaction.Payment.ID,
+		MerchantID:        transaction.MerchantID,
+		ProviderID:        transaction.ProviderID,
+		ProviderDisputeID: request.ProviderDisputeID,
+		ReasonCode:        request.ReasonCode,
+		Amount:            request.Amount,
+		Currency:          transaction.Payment.Currency,
+		Status:            request.Status,
+		DueDate:           now.Add(s.settings.ResponseWindow),
+		Evidence:          []domain.Evidence{},
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	if dispute.Amount == 0 {
+		dispute.Amount = transaction.Payment.CurrentAmount
+	}
+	if request.DueDate != nil {
+		dispute.DueDate = *request.DueDate
+	}
+	s.disputes[dispute.ID] = dispute
+	log.Printf("dispute %s opened against payment %s: %s", dispute.ID, dispute.PaymentID, dispute.ReasonCode)
+	s.record(actor, domain.AuditDisputeCreated, nil, dispute)
+	return dispute, nil
+}
+
+// Update changes the status of a dispute. Closing it as won or lost takes
+// its payment out of disputed status, charging the amount back when lost.
+// Closed disputes can't change; updates repeating their outcome are
+// ignored, so a provider sending a webhook twice gets no error.
+func (s *Service) Update(actor domain.Actor, disputeID string, update domain.DisputeUpdate) (*domain.Dispute, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	dispute, ok := s.disputes[disputeID]
+	if !ok {
+		return nil, domain.ErrDisputeNotFound
+	}
+	if err := s.update(actor, dispute, update); err != nil {
+		return nil, err
+	}
+	return snapshot(dispute), nil
+}
+
+func (s *Service) update(actor domain.Actor, dispute *domain.Dispute, update domain.DisputeUpdate) error {
+	switch update.Status {
+	case "", domain.DisputeNeedsResponse, domain.DisputeUnderReview, domain.DisputeWon, domain.DisputeLost:
+	default:
+		return fmt.Errorf("%w: unknown status %q", domain.ErrInvalidDispute, update.Status)
+	}
+	if update.Status == "" {
+		update.Status = dispute.Status
+	}
+	if dispute.Status.Closed() {
+		if update.Status == dispute.Status {
+			return nil
+		}
+		return fmt.Errorf("%w: it was %s", domain.ErrDisputeClosed, dispute.Status)
+	}
+	if update.Status == dispute.Status && (update.DueDate == nil || update.DueDate.Equal(dispute.DueDate)) {
+		return nil
+	}
+
+	before := snapshot(dispute)
+	now := s.now()
+	action := domain.AuditDisputeUpdated
+	if update.Status.Closed() {
+		won := update.Status == domain.DisputeWon
+		if _, err := s.payments.CloseDispute(actor, dispute.PaymentID, won, dispute.Amount); err != nil {
+			return err
+		}
+		action = domain.AuditDisputeLost
+		if won {
+			action = domain.AuditDisputeWon
+		}
+		dispute.ClosedAt = &now
+	}
+	dispute.Status = update.Status
+	if update.DueDate != nil {
+		dispute.DueDate = *update.DueDate
+	}
+	dispute.UpdatedAt = now
+	log.Printf("dispute %s of payment %s is %s", dispute.ID, dispute.PaymentID, dispute.Status)
+	s.record(actor, action, before, dispute)
+	return nil
+}
+
+// HandleNotification processes a dispute webhook of a provider, which must
+// be signed with the provider's secret along with timestamp, in Unix
+// seconds. Webhooks signed outside the tolerance and webhooks already
+// handled are refused, so a captured one can't be replayed. The first
+// notification of a dispute opens it, the next ones update it.
+func (s *Service) HandleNotification(providerID string, body []byte, timestamp string, signature string) (*domain.Dispute, error) {
+	secret := s.settings.ProviderSecrets[providerID]
+	if secret == "" || !hmac.Equal([]byte(webhook.SignTimestamped(secret, timestamp, body)), []byte(signature)) {
+		return nil, domain.ErrInvalidSignature
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid timestamp", domain.ErrInvalidSignature)
+	}
+	signedAt := time.Unix(seconds, 0)
+	if age := s.now().Sub(signedAt); age > s.settings.SignatureTolerance || age < -s.settings.SignatureTolerance {
+		return nil, fmt.Errorf("%w: signed at %s, outside the tolerance of %v", domain.ErrInvalidSignature, signedAt.UTC().Format(time.RFC3339), s.settings.SignatureTolerance)
+	}
+	var notification domain.DisputeNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidDispute, err)
+	}
+	if notification.DisputeID == "" || notification.PaymentID == "" {
+		return nil, fmt.Errorf("%w: disputeId and paymentId are required", domain.ErrInvalidDispute)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for handled, at := range s.handled {
+		if s.now().Sub(at) > s.settings.SignatureTolerance {
+			delete(s.handled, handled)
+		}
+	}
+	if _, replayed := s.handled[signature]; replayed {
+		return nil, fmt.Errorf("%w: webhook already handled", domain.ErrInvalidSignature)
+	}
+	dispute, err := s.notify(providerID, notification)
+	if err != nil {
+		return nil, err
+	}
+	// Only handled ones are kept: the provider may send a failed one again
+	s.handled[signature] = signedAt
+	return snapshot(dispute), nil
+}
+
+// notify opens or updates the dispute of a notification. The caller must
+// hold the lock.
+func (s *Service) notify(providerID string, notification domain.DisputeNotification) (*domain.Dispute, error) {
+	actor := domain.ProviderActor(providerID)
+	for _, dispute := range s.disputes {
+		if dispute.ProviderID == providerID && dispute.ProviderDisputeID == notification.DisputeID {
+			if err := s.update(actor, dispute, domain.DisputeUpdate{Status: notification.Status, DueDate: notification.DueDate}); err != nil {
+				return nil, err
+			}
+			return dispute, nil
+		}
+	}
+
+	transaction, err := s.payments.ProviderTransaction(providerID, notification.PaymentID)
+	if err != nil {
+		return nil, err
+	}
+	request := domain.DisputeRequest{
+		PaymentID:         transaction.Payment.ID,
+		ProviderDisputeID: notification.DisputeID,
+		ReasonCode:        notification.ReasonCode,
+		Amount:            notification.Amount,
+		Status:            notification.Status,
+		DueDate:           notification.DueDate,
+	}
+	// Disputes the provider decided before telling the gateway are opened
+	// and closed right away
+	if request.Status.Closed() {
+		request.Status = ""
+	}
+	dispute, err := s.create(actor, request)
+	if err != nil {
+		return nil, err
+	}
+	if notification.Status.Closed() {
+		if err := s.update(actor, dispute, domain.DisputeUpdate{Status: notification.Status}); err != nil {
+			return nil, err
+		}
+	}
+	return dispute, nil
+}
+
+// AddEvidence stores a file uploaded by the merchant for a dispute that
+// needs a response and isn't past its due date.
+func (s *Service) AddEvidence(actor domain.Actor, disputeID string, filename string, description string, file io.Reader) (*domain.Evidence, error) {
+	// The file is written without holding the lock, then the dispute is
+	// checked again
+	s.mutex.Lock()
+	dispute, err := s.acceptingEvidence(actor.MerchantID, disputeID)
+	s.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	evidence := domain.Evidence{
+		ID:          uuid.New().String(),
+		Filename:    filepath.Base(filename),
+		Description: description,
+	}
+	evidence.Path, evidence.Size, evidence.ContentType, err = s.evidence.Save(dispute.ID, evidence.ID, file)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	dispute, err = s.acceptingEvidence(actor.MerchantID, disputeID)
+	if err != nil {
+		if err := s.evidence.Remove(evidence.Path); err != nil {
+			log.Printf("failed to remove evidence %s of dispute %s: %v", evidence.ID, disputeID, err)
+		}
+		return nil, err
+	}
+	before := snapshot(dispute)
+	evidence.UploadedAt = s.now()
+	dispute.Evidence = append(dispute.Evidence, evidence)
+	dispute.UpdatedAt = evidence.UploadedAt
+	log.Printf("evidence %s added to dispute %s", evidence.ID, dispute.ID)
+	s.record(actor, domain.AuditDisputeEvidence, before, dispute)
+	return &evidence, nil
+}
+
+func (s *Service) acceptingEvidence(merchantID string, disputeID string) (*domain.Dispute, error) {
+	dispute, ok := s.disputes[disputeID]
+	if !ok || dispute.MerchantID != merchantID {
+		return nil, domain.ErrDisputeNotFound
+	}
+	if dispute.Status != domain.DisputeNeedsResponse {
+		return nil, fmt.Errorf("%w: status is %s", domain.ErrEvidenceNotAccepted, dispute.Status)
+	}
+	if s.now().After(dispute.DueDate) {
+		return nil, fmt.Errorf("%w: it was due on %s", domain.ErrEvidenceNotAccepted, dispute.DueDate.Format(time.RFC3339))
+	}
+	return dispute, nil
+}
+
+// Dispute returns any dispute, for admins.
+func (s *Service) Dispute(disputeID string) (*domain.Dispute, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	dispute, ok := s.disputes[disputeID]
+	if !ok {
+		return nil, domain.ErrDisputeNotFound
+	}
+	return snapshot(dispute), nil
+}
+
+func (s *Service) MerchantDispute(merchantID string, disputeID string) (*domain.Dispute, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	dispute, ok := s.disputes[disputeID]
+	if !ok || dispute.MerchantID != merchantID {
+		return nil, domain.ErrDisputeNotFound
+	}
+	return snapshot(dispute), nil
+}
+
+// Disputes lists the matching disputes, oldest first.
+func (s *Service) Disputes(filter domain.DisputeFilter) ([]*domain.Dispute, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	disputes := make([]*domain.Dispute, 0)
+	for _, dispute := range s.disputes {
+		if (filter.MerchantID == "" || dispute.MerchantID == filter.MerchantID) &&
+			(filter.PaymentID == "" || dispute.PaymentID == filter.PaymentID) &&
+			(filter.Status == "" || dispute.Status == filter.Status) {
+			disputes = append(disputes, snapshot(dispute))
+		}
+	}
+	sort.Slice(disputes, func(i, j int) bool { return disputes[i].CreatedAt.Before(disputes[j].CreatedAt) })
+	return disputes, nil
+}
+
+// record appends the change to the audit log and sends it to the merchant.
+// before is nil when the dispute is new.
+func (s *Service) record(actor domain.Actor, action domain.AuditAction, before *domain.Dispute, dispute *domain.Dispute) {
+	after := snapshot(dispute)
+	if s.audit != nil {
+		event := &domain.AuditEvent{
+			Actor:        actor,
+			Action:       action,
+			ResourceType: domain.AuditResourceDispute,
+			ResourceID:   dispute.ID,
+			MerchantID:   dispute.MerchantID,
+			RequestID:    actor.RequestID,
+			After:        encode(after),
+		}
+		if before != nil {
+			event.Before = encode(before)
+		}
+		if err := s.audit.Append(event); err != nil {
+			log.Printf("failed to record audit event %s of dispute %s: %v", action, dispute.ID, err)
+		}
+	}
+	if s.notifier != nil {
+		s.notifier.Notify(dispute.MerchantID, domain.DisputeEvent{
+			ID:        uuid.New().String(),
+			Type:      action,
+			CreatedAt: after.UpdatedAt,
+			Dispute:   *after,
+		})
+	}
+}
+
+func snapshot(dispute *domain.Dispute) *domain.Dispute {
+	result := *dispute
+	result.Evidence = append([]domain.Evidence{}, dispute.Evidence...)
+	return &result
+}
+
+func encode(dispute *domain.Dispute) json.RawMessage {
+	data, err := json.Marshal(dispute)
+	if err != nil {
+		log.Printf("failed to encode dispute %s for the audit log: %v", dispute.ID, err)
+		return nil
+	}
+	return data
+}
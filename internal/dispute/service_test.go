@@ -0,0 +1,296 @@
+package dispute
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/internal/audit"
+	"desafio-api/internal/domain"
+	"desafio-api/internal/webhook"
+)
+
+const (
+	testMerchantID = "merchant-test"
+	testSecret     = "whsec_test"
+)
+
+// stubPayments holds authorized payments of 100 BRL at "stripe", whose ID
+// at the provider is "ch_" followed by the payment ID.
+type stubPayments struct {
+	mutex    sync.Mutex
+	payments map[string]*domain.Transaction
+	closed   map[string]bool
+}
+
+func newStubPayments(ids ...string) *stubPayments {
+	p := &stubPayments{payments: make(map[string]*domain.Transaction), closed: make(map[string]bool)}
+	for _, id := range ids {
+		p.payments[id] = &domain.Transaction{
+			Payment:           &domain.Payment{ID: id, Status: domain.StatusAuthorized, CurrentAmount: 100, Currency: "BRL"},
+			MerchantID:        testMerchantID,
+			ProviderID:        "stripe",
+			ProviderPaymentID: "ch_" + id,
+		}
+	}
+	return p
+}
+
+func (p *stubPayments) ProviderTransaction(providerID string, chargeID string) (*domain.Transaction, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, transaction := range p.payments {
+		if transaction.ProviderID == providerID && transaction.ProviderPaymentID == chargeID {
+			return transaction, nil
+		}
+	}
+	return nil, domain.ErrPaymentNotFound
+}
+
+func (p *stubPayments) DisputePayment(actor domain.Actor, paymentID string, amount float64) (*domain.Transaction, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	transaction, ok := p.payments[paymentID]
+	if !ok {
+		return nil, domain.ErrPaymentNotFound
+	}
+	if transaction.Payment.Status != domain.StatusAuthorized {
+		return nil, domain.ErrInvalidStatus
+	}
+	transaction.Payment.Status = domain.StatusDisputed
+	return transaction, nil
+}
+
+func (p *stubPayments) CloseDispute(actor domain.Actor, paymentID string, won bool, amount float64) (*domain.Payment, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	transaction := p.payments[paymentID]
+	transaction.Payment.Status = domain.StatusAuthorized
+	p.closed[paymentID] = won
+	return transaction.Payment, nil
+}
+
+type recordingNotifier struct {
+	events []domain.DisputeEvent
+}
+
+func (n *recordingNotifier) Notify(merchantID string, event domain.DisputeEvent) {
+	n.events = append(n.events, event)
+}
+
+func newTestService(t *testing.T, payments *stubPayments, maxEvidenceBytes int64) (*Service, *recordingNotifier, *time.Time) {
+	evidence, err := NewEvidenceStore(t.TempDir(), maxEvidenceBytes)
+	require.NoError(t, err)
+	notifier := &recordingNotifier{}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	service := NewService(payments, evidence, audit.NewMemoryLog(), notifier, Settings{
+		ResponseWindow:     7 * 24 * time.Hour,
+		ProviderSecrets:    map[string]string{"stripe": testSecret},
+		SignatureTolerance: 5 * time.Minute,
+	})
+	service.now = func() time.Time { return now }
+	return service, notifier, &now
+}
+
+func TestServiceLifecycle(t *testing.T) {
+	payments := newStubPayments("pay-1")
+	service, notifier, now := newTestService(t, payments, 0)
+	admin := domain.Actor{Type: domain.ActorAdmin, ID: "ops"}
+
+	created, err := service.Create(admin, domain.DisputeRequest{PaymentID: "pay-1", ReasonCode: "10.4"})
+	require.NoError(t, err)
+	assert.Equal(t, domain.DisputeNeedsResponse, created.Status)
+	assert.Equal(t, 100.0, created.Amount)
+	assert.Equal(t, "BRL", created.Currency)
+	assert.Equal(t, now.Add(7*24*time.Hour), created.DueDate)
+	assert.Equal(t, domain.StatusDisputed, payments.payments["pay-1"].Payment.Status)
+
+	_, err = service.Create(admin, domain.DisputeRequest{PaymentID: "pay-1", ReasonCode: "10.4"})
+	assert.ErrorIs(t, err, domain.ErrInvalidDispute, "a payment has one open dispute")
+
+	_, err = service.Update(admin, created.ID, domain.DisputeUpdate{Status: domain.DisputeUnderReview})
+	require.NoError(t, err)
+	lost, err := service.Update(admin, created.ID, domain.DisputeUpdate{Status: domain.DisputeLost})
+	require.NoError(t, err)
+	assert.NotNil(t, lost.ClosedAt)
+	assert.Equal(t, false, payments.closed["pay-1"])
+
+	_, err = service.Update(admin, created.ID, domain.DisputeUpdate{Status: domain.DisputeLost})
+	assert.NoError(t, err, "repeating the outcome is ignored")
+	_, err = service.Update(admin, created.ID, domain.DisputeUpdate{Status: domain.DisputeWon})
+	assert.ErrorIs(t, err, domain.ErrDisputeClosed)
+
+	var types []domain.AuditAction
+	for _, event := range notifier.events {
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []domain.AuditAction{domain.AuditDisputeCreated, domain.AuditDisputeUpdated, domain.AuditDisputeLost}, types)
+	events, err := service.audit.List(domain.AuditFilter{ResourceType: domain.AuditResourceDispute, ResourceID: created.ID})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Empty(t, events[0].Before)
+	assert.Contains(t, string(events[2].After), `"status":"lost"`)
+}
+
+func TestServiceProviderNotifications(t *testing.T) {
+	payments := newStubPayments("pay-1", "pay-2")
+	service, _, now := newTestService(t, payments, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	notify := func(notification string, secret string) (*domain.Dispute, error) {
+		body := []byte(notification)
+		return service.HandleNotification("stripe", body, timestamp, webhook.SignTimestamped(secret, timestamp, body))
+	}
+
+	_, err := notify(`{"disputeId": "dp_1", "paymentId": "ch_pay-1", "reasonCode": "fraudulent"}`, "wrong")
+	assert.ErrorIs(t, err, domain.ErrInvalidSignature)
+	_, err = service.HandleNotification("braintree", []byte(`{}`), timestamp, webhook.SignTimestamped(testSecret, timestamp, []byte(`{}`)))
+	assert.ErrorIs(t, err, domain.ErrInvalidSignature, "providers without a secret are refused")
+	body := []byte(`{"disputeId": "dp_1", "paymentId": "ch_pay-1", "reasonCode": "fraudulent"}`)
+	_, err = service.HandleNotification("stripe", body, timestamp, webhook.Sign(testSecret, body))
+	assert.ErrorIs(t, err, domain.ErrInvalidSignature, "the timestamp must be signed")
+
+	opened, err := notify(`{"disputeId": "dp_1", "paymentId": "ch_pay-1", "reasonCode": "fraudulent", "amount": 40}`, testSecret)
+	require.NoError(t, err)
+	assert.Equal(t, "pay-1", opened.PaymentID)
+	assert.Equal(t, "dp_1", opened.ProviderDisputeID)
+	assert.Equal(t, 40.0, opened.Amount)
+	assert.Equal(t, domain.ActorProvider, lastEvent(t, service, opened.ID).Actor.Type)
+
+	won, err := notify(`{"disputeId": "dp_1", "paymentId": "ch_pay-1", "status": "won"}`, testSecret)
+	require.NoError(t, err)
+	assert.Equal(t, opened.ID, won.ID)
+	assert.Equal(t, domain.DisputeWon, won.Status)
+	assert.Equal(t, true, payments.closed["pay-1"])
+
+	// Decided before the provider told the gateway
+	lost, err := notify(`{"disputeId": "dp_2", "paymentId": "ch_pay-2", "reasonCode": "duplicate", "status": "lost"}`, testSecret)
+	require.NoError(t, err)
+	assert.Equal(t, domain.DisputeLost, lost.Status)
+	assert.Equal(t, false, payments.closed["pay-2"])
+
+	_, err = notify(`{"disputeId": "dp_3", "paymentId": "ch_missing", "reasonCode": "fraudulent"}`, testSecret)
+	assert.ErrorIs(t, err, domain.ErrPaymentNotFound)
+}
+
+func TestServiceNotificationReplays(t *testing.T) {
+	payments := newStubPayments("pay-1")
+	service, _, now := newTestService(t, payments, 0)
+	body := []byte(`{"disputeId": "dp_1", "paymentId": "ch_pay-1", "reasonCode": "fraudulent"}`)
+	send := func(signedAt time.Time) (*domain.Dispute, error) {
+		timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+		return service.HandleNotification("stripe", body, timestamp, webhook.SignTimestamped(testSecret, timestamp, body))
+	}
+
+	t.Run("stale timestamps are refused", func(t *testing.T) {
+		_, err := send(now.Add(-6 * time.Minute))
+		assert.ErrorIs(t, err, domain.ErrInvalidSignature)
+		_, err = send(now.Add(6 * time.Minute))
+		assert.ErrorIs(t, err, domain.ErrInvalidSignature)
+		_, err = service.HandleNotification("stripe", body, "yesterday", webhook.SignTimestamped(testSecret, "yesterday", body))
+		assert.ErrorIs(t, err, domain.ErrInvalidSignature)
+	})
+
+	t.Run("a handled webhook can't be replayed", func(t *testing.T) {
+		signedAt := *now
+		_, err := send(signedAt)
+		require.NoError(t, err)
+
+		*now = now.Add(time.Minute)
+		_, err = send(signedAt)
+		assert.ErrorIs(t, err, domain.ErrInvalidSignature)
+
+		// Sent again by the provider, signed anew
+		_, err = send(*now)
+		assert.NoError(t, err)
+	})
+}
+
+func TestServiceEvidence(t *testing.T) {
+	payments := newStubPayments("pay-1")
+	service, notifier, now := newTestService(t, payments, 64)
+	merchant := domain.Actor{Type: domain.ActorMerchant, ID: "key-1", MerchantID: testMerchantID}
+	created, err := service.Create(domain.Actor{Type: domain.ActorAdmin, ID: "ops"}, domain.DisputeRequest{PaymentID: "pay-1", ReasonCode: "13.1"})
+	require.NoError(t, err)
+
+	evidence, err := service.AddEvidence(merchant, created.ID, "../receipt.txt", "delivery receipt", strings.NewReader("delivered on 2024-04-28"))
+	require.NoError(t, err)
+	assert.Equal(t, "receipt.txt", evidence.Filename)
+	assert.Equal(t, "text/plain", evidence.ContentType)
+	assert.Equal(t, int64(23), evidence.Size)
+	stored, err := os.ReadFile(evidence.Path)
+	require.NoError(t, err)
+	assert.Equal(t, "delivered on 2024-04-28", string(stored))
+	assert.Equal(t, domain.AuditDisputeEvidence, notifier.events[len(notifier.events)-1].Type)
+
+	_, err = service.AddEvidence(merchant, created.ID, "tool.exe", "", bytes.NewReader([]byte{0x4d, 0x5a, 0x90, 0x00, 0x03, 0x00, 0x00, 0x00}))
+	assert.ErrorIs(t, err, domain.ErrInvalidEvidence)
+	_, err = service.AddEvidence(merchant, created.ID, "long.txt", "", strings.NewReader(strings.Repeat("a", 65)))
+	assert.ErrorIs(t, err, domain.ErrEvidenceTooLarge)
+	other := domain.Actor{Type: domain.ActorMerchant, ID: "key-2", MerchantID: "merchant-other"}
+	_, err = service.AddEvidence(other, created.ID, "receipt.txt", "", strings.NewReader("receipt"))
+	assert.ErrorIs(t, err, domain.ErrDisputeNotFound)
+
+	*now = created.DueDate.Add(time.Minute)
+	_, err = service.AddEvidence(merchant, created.ID, "late.txt", "", strings.NewReader("too late"))
+	assert.ErrorIs(t, err, domain.ErrEvidenceNotAccepted)
+
+	found, err := service.MerchantDispute(testMerchantID, created.ID)
+	require.NoError(t, err)
+	require.Len(t, found.Evidence, 1, "rejected files are not attached")
+	files, err := os.ReadDir(filepath.Join(service.evidence.dir, created.ID))
+	require.NoError(t, err)
+	assert.Len(t, files, 1, "rejected files are not kept")
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	notifier := NewWebhookNotifier(map[string]Endpoint{testMerchantID: {URL: server.URL, Secret: "merchant-secret"}}, time.Second, 2)
+	notifier.Notify("merchant-without-webhook", domain.DisputeEvent{ID: "evt-0"})
+	notifier.Notify(testMerchantID, domain.DisputeEvent{ID: "evt-1", Type: domain.AuditDisputeCreated, Dispute: domain.Dispute{ID: "dispute-1"}})
+
+	select {
+	case r := <-received:
+		body := <-bodies
+		assert.Equal(t, string(domain.AuditDisputeCreated), r.Header.Get(EventHeader))
+		assert.Equal(t, webhook.Sign("merchant-secret", body), r.Header.Get(webhook.SignatureHeader))
+		var event domain.DisputeEvent
+		require.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, "evt-1", event.ID)
+		assert.Equal(t, "dispute-1", event.Dispute.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+}
+
+func lastEvent(t *testing.T, service *Service, disputeID string) domain.AuditEvent {
+	events, err := service.audit.List(domain.AuditFilter{ResourceID: disputeID})
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	return events[len(events)-1]
+}
@@ -0,0 +1,93 @@
+package dispute
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+
+	"desafio-api/internal/domain"
+	"desafio-api/internal/webhook"
+)
+
+const EventHeader = "X-Webhook-Event"
+
+// Endpoint is where a merchant receives its dispute events. Events are
+// signed when Secret is set.
+type Endpoint struct {
+	URL    string
+	Secret string
+}
+
+// WebhookNotifier posts the dispute events to the webhook of their
+// merchant. Like the callbacks of async payments, delivery is best effort:
+// failed attempts are retried a few times and then only logged. Events are
+// sent concurrently and may arrive out of order, the dispute they carry
+// has the time it was updated.
+type WebhookNotifier struct {
+	endpoints map[string]Endpoint
+	client    *http.Client
+	attempts  int
+}
+
+// NewWebhookNotifier sends the events of each merchant to its endpoint in
+// endpoints, keyed by merchant ID. Merchants without one get no events.
+func NewWebhookNotifier(endpoints map[string]Endpoint, timeout time.Duration, attempts int) *WebhookNotifier {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return &WebhookNotifier{
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: timeout},
+		attempts:  attempts,
+	}
+}
+
+func (n *WebhookNotifier) Notify(merchantID string, event domain.DisputeEvent) {
+	endpoint, ok := n.endpoints[merchantID]
+	if !ok || endpoint.URL == "" {
+		return
+	}
+	go n.send(endpoint, event)
+}
+
+func (n *WebhookNotifier) send(endpoint Endpoint, event domain.DisputeEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("failed to encode event %s of dispute %s: %v", event.Type, event.Dispute.ID, err)
+		return
+	}
+
+	err = retry.Do(
+		func() error {
+			req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(body))
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(EventHeader, string(event.Type))
+			if endpoint.Secret != "" {
+				req.Header.Set(webhook.SignatureHeader, webhook.Sign(endpoint.Secret, body))
+			}
+			resp, err := n.client.Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			}
+			return nil
+		},
+		retry.Attempts(uint(n.attempts)),
+		retry.Delay(time.Second),
+		retry.LastErrorOnly(true),
+	)
+	if err != nil {
+		log.Printf("failed to deliver event %s of dispute %s: %v", event.Type, event.Dispute.ID, err)
+	}
+}
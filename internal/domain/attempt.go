@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+type ErrorClass string
+
+const (
+	ErrorClassTimeout         ErrorClass = "timeout"
+	ErrorClassNetwork         ErrorClass = "network"
+	ErrorClassNotFound        ErrorClass = "not_found"
+	ErrorClassClientError     ErrorClass = "client_error"
+	ErrorClassServerError     ErrorClass = "server_error"
+	ErrorClassInvalidResponse ErrorClass = "invalid_response"
+	ErrorClassInternal        ErrorClass = "internal"
+)
+
+// Attempt is one call made to a provider on behalf of a payment. Number
+// counts the retries of the operation at that provider, starting at 1.
+// HTTPStatus and Response are only known for calls that reached the
+// provider; Response is a truncated snippet with card data redacted.
+type Attempt struct {
+	ProviderID   string        `json:"providerId"`
+	ProviderName string        `json:"providerName"`
+	Operation    OperationType `json:"operation"`
+	Number       int           `json:"number"`
+	StartedAt    time.Time     `json:"startedAt"`
+	EndedAt      time.Time     `json:"endedAt"`
+	HTTPStatus   int           `json:"httpStatus,omitempty"`
+	Status       PaymentStatus `json:"status,omitempty"`
+	ErrorClass   ErrorClass    `json:"errorClass,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	Response     string        `json:"response,omitempty"`
+}
+
+// ProviderError is returned by providers for failed calls, with what is
+// known about the provider's answer. Its message is the one of Err.
+// RetryAfter is the wait the provider asked for with a Retry-After header.
+type ProviderError struct {
+	Class      ErrorClass
+	HTTPStatus int
+	Response   string
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyError returns the class of an error returned by a provider.
+func ClassifyError(err error) ErrorClass {
+	var providerErr *ProviderError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &providerErr):
+		return providerErr.Class
+	case errors.Is(err, ErrOutcomeUnknown):
+		return ErrorClassTimeout
+	case errors.Is(err, ErrPaymentNotFound):
+		return ErrorClassNotFound
+	default:
+		return ErrorClassInternal
+	}
+}
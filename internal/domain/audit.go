@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type ActorType string
+
+const (
+	ActorMerchant ActorType = "merchant"
+	ActorAdmin    ActorType = "admin"
+	ActorSystem   ActorType = "system"
+	// ActorProvider is a provider calling one of the gateway's webhooks.
+	ActorProvider ActorType = "provider"
+)
+
+// Actor is who asked for an operation: a merchant API key, an admin key, a
+// provider webhook or a background job of the gateway. MerchantID is only
+// set for merchants.
+type Actor struct {
+	Type       ActorType `json:"type"`
+	ID         string    `json:"id"`
+	MerchantID string    `json:"merchantId,omitempty"`
+	RequestID  string    `json:"-"`
+}
+
+// SystemActor is the actor of the gateway's own jobs, like the sweeper.
+func SystemActor(job string) Actor {
+	return Actor{Type: ActorSystem, ID: job}
+}
+
+// ProviderActor is the actor of the webhooks sent by a provider.
+func ProviderActor(providerID string) Actor {
+	return Actor{Type: ActorProvider, ID: providerID}
+}
+
+type AuditAction string
+
+const (
+	AuditPaymentCreated      AuditAction = "payment.created"
+	AuditPaymentRefunded     AuditAction = "payment.refunded"
+	AuditPaymentRefundFailed AuditAction = "payment.refund_failed"
+	AuditPaymentRefundQueued AuditAction = "payment.refund_queued"
+	AuditPaymentCredited     AuditAction = "payment.credited"
+	AuditPaymentConfirmed    AuditAction = "payment.confirmed"
+	AuditPaymentResolved     AuditAction = "payment.resolved"
+	AuditPaymentRecovered    AuditAction = "payment.recovered"
+	AuditPaymentProcessed    AuditAction = "payment.processed"
+	AuditPaymentDisputed     AuditAction = "payment.disputed"
+	AuditPaymentReinstated   AuditAction = "payment.reinstated"
+	AuditPaymentChargedBack  AuditAction = "payment.charged_back"
+	AuditDisputeCreated      AuditAction = "dispute.created"
+	AuditDisputeUpdated      AuditAction = "dispute.updated"
+	AuditDisputeEvidence     AuditAction = "dispute.evidence_added"
+	AuditDisputeWon          AuditAction = "dispute.won"
+	AuditDisputeLost         AuditAction = "dispute.lost"
+	AuditProviderModeSet     AuditAction = "provider.mode_set"
+	AuditProviderReset       AuditAction = "provider.reset"
+)
+
+const (
+	AuditResourcePayment  = "payment"
+	AuditResourceProvider = "provider"
+	AuditResourceDispute  = "dispute"
+)
+
+// AuditEvent is an entry of the append-only audit log. Before and After are
+// JSON snapshots of the resource taken when the event was recorded; Before
+// is empty for creations.
+type AuditEvent struct {
+	ID           string          `json:"id"`
+	Sequence     int64           `json:"sequence"`
+	Timestamp    time.Time       `json:"timestamp"`
+	Actor        Actor           `json:"actor"`
+	Action       AuditAction     `json:"action"`
+	ResourceType string          `json:"resourceType"`
+	ResourceID   string          `json:"resourceId"`
+	MerchantID   string          `json:"merchantId,omitempty"`
+	RequestID    string          `json:"requestId,omitempty"`
+	Before       json.RawMessage `json:"before,omitempty"`
+	After        json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditFilter selects events; empty fields match everything.
+type AuditFilter struct {
+	ResourceType string
+	ResourceID   string
+	MerchantID   string
+	Since        *time.Time
+}
+
+// AuditLog only ever appends: recorded events are never changed or removed.
+type AuditLog interface {
+	// Append assigns the ID, sequence and, if unset, the timestamp.
+	Append(event *AuditEvent) error
+	// List returns the matching events in the order they were appended.
+	List(filter AuditFilter) ([]AuditEvent, error)
+}
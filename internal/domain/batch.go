@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+type BatchStatus string
+
+const (
+	BatchProcessing BatchStatus = "processing"
+	BatchCompleted  BatchStatus = "completed"
+)
+
+type BatchOperation string
+
+const (
+	BatchPayment BatchOperation = "payment"
+	BatchRefund  BatchOperation = "refund"
+)
+
+type BatchLineStatus string
+
+const (
+	BatchLineSucceeded BatchLineStatus = "succeeded"
+	BatchLineFailed    BatchLineStatus = "failed"
+	// BatchLineDuplicate is a line whose key was already processed by an
+	// earlier batch of the merchant, reported with the earlier result.
+	BatchLineDuplicate BatchLineStatus = "duplicate"
+)
+
+type BatchFormat string
+
+const (
+	BatchCSV    BatchFormat = "csv"
+	BatchNDJSON BatchFormat = "ndjson"
+)
+
+var (
+	ErrBatchNotFound = errors.New("batch not found")
+	ErrInvalidBatch  = errors.New("invalid batch file")
+)
+
+// BatchInstruction is one line of a batch file: a charge with the fields of
+// a PaymentRequest, or a refund of Amount on PaymentID. Key identifies the
+// line across uploads; without it the line's content is used.
+type BatchInstruction struct {
+	Operation BatchOperation `json:"operation"`
+	Key       string         `json:"key,omitempty"`
+	PaymentID string         `json:"paymentId,omitempty"`
+	PaymentRequest
+}
+
+type BatchLineResult struct {
+	Line          int             `json:"line"`
+	Key           string          `json:"key"`
+	Operation     BatchOperation  `json:"operation"`
+	Status        BatchLineStatus `json:"status"`
+	PaymentID     string          `json:"paymentId,omitempty"`
+	PaymentStatus PaymentStatus   `json:"paymentStatus,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+type Batch struct {
+	ID          string            `json:"id"`
+	MerchantID  string            `json:"-"`
+	Format      BatchFormat       `json:"format"`
+	Status      BatchStatus       `json:"status"`
+	Total       int               `json:"total"`
+	Processed   int               `json:"processed"`
+	Succeeded   int               `json:"succeeded"`
+	Failed      int               `json:"failed"`
+	Duplicates  int               `json:"duplicates"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	CompletedAt *time.Time        `json:"completedAt,omitempty"`
+	Results     []BatchLineResult `json:"-"`
+}
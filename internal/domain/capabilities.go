@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// OperationPartialRefund is only used in capabilities: providers listing
+// OperationRefund without it can only refund the whole amount.
+const OperationPartialRefund OperationType = "partial_refund"
+
+var (
+	// ErrUnsupportedPayment is returned when no provider can make a charge.
+	ErrUnsupportedPayment = errors.New("payment not supported by any provider")
+	// ErrOperationNotSupported is returned when the provider of a payment
+	// can't perform an operation on it.
+	ErrOperationNotSupported = errors.New("operation not supported by the provider")
+)
+
+// Capabilities describes what a provider can handle. Empty lists and zero
+// limits mean no restriction, so a provider declaring nothing is assumed to
+// handle everything. Charges in currencies not listed are converted to
+// SettlementCurrency when it is set; amount limits apply to the amount sent
+// to the provider, in its currency.
+type Capabilities struct {
+	Operations         []OperationType `json:"operations,omitempty"`
+	Currencies         []string        `json:"currencies,omitempty"`
+	SettlementCurrency string          `json:"settlementCurrency,omitempty"`
+	Brands             []string        `json:"brands,omitempty"`
+	MaxInstallments    int             `json:"maxInstallments,omitempty"`
+	MinAmount          float64         `json:"minAmount,omitempty"`
+	MaxAmount          float64         `json:"maxAmount,omitempty"`
+}
+
+func (c Capabilities) Supports(operation OperationType) bool {
+	return len(c.Operations) == 0 || slices.Contains(c.Operations, operation)
+}
+
+// Credits reports whether the provider makes stand-alone credits, which
+// unlike the other operations must be listed explicitly.
+func (c Capabilities) Credits() bool {
+	return slices.Contains(c.Operations, OperationCredit)
+}
+
+// ChargesCurrency reports whether currency can be charged without
+// conversion.
+func (c Capabilities) ChargesCurrency(currency string) bool {
+	if len(c.Currencies) == 0 || strings.EqualFold(c.SettlementCurrency, currency) {
+		return true
+	}
+	return slices.ContainsFunc(c.Currencies, func(supported string) bool {
+		return strings.EqualFold(supported, currency)
+	})
+}
+
+// CheckCharge returns why the provider can't make the charge, apart from
+// its currency. Cards of unrecognized brand are not filtered.
+func (c Capabilities) CheckCharge(request PaymentRequest) error {
+	if !c.Supports(OperationCharge) {
+		return errors.New("charges are not supported")
+	}
+	if brand := request.Card.Brand(); brand != "" && len(c.Brands) > 0 && !slices.Contains(c.Brands, brand) {
+		return fmt.Errorf("card brand %s is not supported", brand)
+	}
+	if c.MaxInstallments > 0 && request.Card.Installments > c.MaxInstallments {
+		return fmt.Errorf("at most %d installments are supported", c.MaxInstallments)
+	}
+	if c.MinAmount > 0 && request.Amount < c.MinAmount {
+		return fmt.Errorf("amount below the minimum of %.2f %s", c.MinAmount, request.Currency)
+	}
+	if c.MaxAmount > 0 && request.Amount > c.MaxAmount {
+		return fmt.Errorf("amount above the maximum of %.2f %s", c.MaxAmount, request.Currency)
+	}
+	return nil
+}
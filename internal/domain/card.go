@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrCardNotFound = errors.New("card not found")
+	ErrInvalidCard  = errors.New("invalid card")
+)
+
+// StoredCard is a card kept in the vault for later charges, identified by
+// its token. The card data itself is never returned; the CVV isn't stored.
+type StoredCard struct {
+	Token          string    `json:"token"`
+	MerchantID     string    `json:"-"`
+	Card           Card      `json:"-"`
+	Last4          string    `json:"last4"`
+	HolderName     string    `json:"holderName"`
+	ExpirationDate string    `json:"expirationDate"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
@@ -0,0 +1,121 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+type DisputeStatus string
+
+const (
+	// DisputeNeedsResponse means the merchant must upload evidence before
+	// DueDate.
+	DisputeNeedsResponse DisputeStatus = "needs_response"
+	// DisputeUnderReview means the evidence was sent and the issuer is
+	// deciding.
+	DisputeUnderReview DisputeStatus = "under_review"
+	DisputeWon         DisputeStatus = "won"
+	DisputeLost        DisputeStatus = "lost"
+)
+
+// Closed reports whether the dispute was decided. Closed disputes never
+// change again.
+func (s DisputeStatus) Closed() bool {
+	return s == DisputeWon || s == DisputeLost
+}
+
+var (
+	ErrDisputeNotFound = errors.New("dispute not found")
+	ErrInvalidDispute  = errors.New("invalid dispute")
+	ErrDisputeClosed   = errors.New("dispute is closed")
+	// ErrEvidenceNotAccepted is returned for evidence uploaded to a dispute
+	// that doesn't need a response, or after its due date.
+	ErrEvidenceNotAccepted = errors.New("dispute doesn't accept evidence")
+	// ErrInvalidEvidence is returned for evidence files of a type that is
+	// not accepted.
+	ErrInvalidEvidence  = errors.New("invalid evidence")
+	ErrEvidenceTooLarge = errors.New("evidence file is too large")
+	// ErrInvalidSignature is returned for provider webhooks not signed with
+	// the provider's secret.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// Dispute is a chargeback opened by the cardholder against a payment.
+// ProviderDisputeID is the ID of the dispute at the provider of the
+// payment, empty for disputes created through the admin API. Amount is in
+// the currency of the payment and at most its current amount.
+type Dispute struct {
+	ID                string        `json:"id"`
+	PaymentID         string        `json:"paymentId"`
+	MerchantID        string        `json:"merchantId"`
+	ProviderID        string        `json:"providerId"`
+	ProviderDisputeID string        `json:"providerDisputeId,omitempty"`
+	ReasonCode        string        `json:"reasonCode"`
+	Amount            float64       `json:"amount"`
+	Currency          string        `json:"currency"`
+	Status            DisputeStatus `json:"status"`
+	DueDate           time.Time     `json:"dueDate"`
+	Evidence          []Evidence    `json:"evidence"`
+	CreatedAt         time.Time     `json:"createdAt"`
+	UpdatedAt         time.Time     `json:"updatedAt"`
+	ClosedAt          *time.Time    `json:"closedAt,omitempty"`
+}
+
+// Evidence is a file uploaded by the merchant to contest a dispute, kept on
+// the local disk at Path.
+type Evidence struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	Description string    `json:"description,omitempty"`
+	Path        string    `json:"-"`
+	UploadedAt  time.Time `json:"uploadedAt"`
+}
+
+// DisputeRequest opens a dispute through the admin API. A zero Amount
+// disputes the current amount of the payment, an empty Status is
+// needs_response and a nil DueDate uses the configured response window.
+type DisputeRequest struct {
+	PaymentID         string        `json:"paymentId"`
+	ProviderDisputeID string        `json:"providerDisputeId"`
+	ReasonCode        string        `json:"reasonCode"`
+	Amount            float64       `json:"amount"`
+	Status            DisputeStatus `json:"status"`
+	DueDate           *time.Time    `json:"dueDate,omitempty"`
+}
+
+// DisputeUpdate changes the status of a dispute, and its due date when set.
+type DisputeUpdate struct {
+	Status  DisputeStatus `json:"status"`
+	DueDate *time.Time    `json:"dueDate,omitempty"`
+}
+
+// DisputeNotification is the body of the dispute webhooks sent by
+// providers. DisputeID is the ID of the dispute at the provider and
+// PaymentID the ID of the charge there; the first notification of a
+// dispute opens it and the next ones update it.
+type DisputeNotification struct {
+	DisputeID  string        `json:"disputeId"`
+	PaymentID  string        `json:"paymentId"`
+	ReasonCode string        `json:"reasonCode"`
+	Amount     float64       `json:"amount"`
+	Status     DisputeStatus `json:"status"`
+	DueDate    *time.Time    `json:"dueDate,omitempty"`
+}
+
+// DisputeFilter selects disputes; empty fields match everything.
+type DisputeFilter struct {
+	MerchantID string
+	PaymentID  string
+	Status     DisputeStatus
+}
+
+// DisputeEvent is posted to the webhook of the merchant of a dispute
+// whenever it changes. Type is the action recorded in the audit log.
+type DisputeEvent struct {
+	ID        string      `json:"id"`
+	Type      AuditAction `json:"type"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Dispute   Dispute     `json:"dispute"`
+}
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrUnsupportedCurrency = errors.New("unsupported currency")
+	ErrRateNotFound        = errors.New("exchange rate not found")
+)
+
+// FXRate is how many units of To one unit of From buys.
+type FXRate struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	Rate float64   `json:"rate"`
+	AsOf time.Time `json:"asOf"`
+}
+
+type FXRateProvider interface {
+	Rate(from string, to string) (*FXRate, error)
+}
+
+// FXConversion records a charge converted to the settlement currency of the
+// provider that made it. Rate is the market rate and Markup the fraction
+// added on top, so ToAmount is FromAmount * Rate * (1 + Markup).
+type FXConversion struct {
+	FromCurrency string    `json:"fromCurrency"`
+	FromAmount   float64   `json:"fromAmount"`
+	ToCurrency   string    `json:"toCurrency"`
+	ToAmount     float64   `json:"toAmount"`
+	Rate         float64   `json:"rate"`
+	Markup       float64   `json:"markup"`
+	RateAsOf     time.Time `json:"rateAsOf"`
+}
@@ -0,0 +1,60 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+// ProviderMode is set by an admin to override the circuit breaker of a
+// provider. In auto mode the breaker decides; forced_open takes the provider
+// out of rotation, forced_closed ignores the breaker and draining stops new
+// charges while still allowing refunds and confirmations.
+type ProviderMode string
+
+const (
+	ProviderModeAuto         ProviderMode = "auto"
+	ProviderModeForcedOpen   ProviderMode = "forced_open"
+	ProviderModeForcedClosed ProviderMode = "forced_closed"
+	ProviderModeDraining     ProviderMode = "draining"
+)
+
+var (
+	ErrProviderNotFound    = errors.New("provider not found")
+	ErrProviderUnavailable = errors.New("provider unavailable")
+)
+
+// ProviderStats summarizes the recent calls and health probes of a provider.
+type ProviderStats struct {
+	Samples       int        `json:"samples"`
+	SuccessRate   float64    `json:"successRate"`
+	LatencyP50Ms  float64    `json:"latencyP50Ms"`
+	LatencyP95Ms  float64    `json:"latencyP95Ms"`
+	LatencyP99Ms  float64    `json:"latencyP99Ms"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorAt   *time.Time `json:"lastErrorAt,omitempty"`
+	LastSuccessAt *time.Time `json:"lastSuccessAt,omitempty"`
+	LastProbeAt   *time.Time `json:"lastProbeAt,omitempty"`
+}
+
+// ProviderStatus is what the gateway knows about a provider: the admin mode,
+// the state of its circuit breaker ("closed", "half-open" or "open"), its
+// declared capabilities and its stats.
+type ProviderStatus struct {
+	ID           string         `json:"id"`
+	Name         string         `json:"name"`
+	Mode         ProviderMode   `json:"mode"`
+	Breaker      string         `json:"breaker"`
+	Bulkhead     *BulkheadStats `json:"bulkhead,omitempty"`
+	Capabilities Capabilities   `json:"capabilities"`
+	ProviderStats
+}
+
+// BulkheadStats shows how full the bulkhead of a provider is. Rejected
+// counts the calls shed since startup.
+type BulkheadStats struct {
+	InFlight      int   `json:"inFlight"`
+	Queued        int   `json:"queued"`
+	MaxConcurrent int   `json:"maxConcurrent"`
+	MaxQueue      int   `json:"maxQueue"`
+	Rejected      int64 `json:"rejected"`
+}
@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+// InstallmentInterest says who charges interest on an installment plan.
+type InstallmentInterest string
+
+const (
+	// InterestNone splits the amount without interest.
+	InterestNone InstallmentInterest = "none"
+	// InterestMerchant adds the merchant's interest to the amount charged.
+	InterestMerchant InstallmentInterest = "merchant"
+	// InterestIssuer charges the amount as is; the issuer adds its own
+	// interest to the customer's bill.
+	InterestIssuer InstallmentInterest = "issuer"
+)
+
+var ErrInvalidInstallments = errors.New("invalid installments")
+
+// InstallmentPlan splits Amount into Count monthly installments. MonthlyRate
+// is a fraction, 0.0199 meaning 1.99% a month. With issuer interest the
+// gateway charges Amount and TotalAmount is an estimate of what the
+// customer pays the issuer.
+type InstallmentPlan struct {
+	Count             int                 `json:"count"`
+	Interest          InstallmentInterest `json:"interest"`
+	MonthlyRate       float64             `json:"monthlyRate"`
+	Amount            float64             `json:"amount"`
+	InstallmentAmount float64             `json:"installmentAmount"`
+	TotalAmount       float64             `json:"totalAmount"`
+	Schedule          []Installment       `json:"schedule"`
+}
+
+type Installment struct {
+	Number  int       `json:"number"`
+	Amount  float64   `json:"amount"`
+	DueDate time.Time `json:"dueDate"`
+}
+
+// ChargeAmount is the amount sent to the provider.
+func (p InstallmentPlan) ChargeAmount() float64 {
+	if p.Interest == InterestMerchant {
+		return p.TotalAmount
+	}
+	return p.Amount
+}
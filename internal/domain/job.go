@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// PaymentJob is a charge submitted with ?async=true, processed by the
+// workers in the background. PaymentID is the ID of the pending payment
+// returned to the client, also used as the charge reference. Request keeps
+// ClientIP separately since it isn't part of the request JSON.
+//
+// Request carries no card data, only card.installments: the card is kept
+// in the vault under CardToken and, when a journal key is configured,
+// encrypted in SealedCard so the job can still be charged after a restart.
+// The CVV is never journaled, it is only kept in memory until the charge.
+// Resumed marks the jobs that may have been charged already: those loaded
+// from the journal, which the last run may have charged before it stopped,
+// and those retried after a failure.
+type PaymentJob struct {
+	ID          string         `json:"id"`
+	PaymentID   string         `json:"paymentId"`
+	Actor       Actor          `json:"actor"`
+	Request     PaymentRequest `json:"request"`
+	CardToken   string         `json:"cardToken"`
+	SealedCard  string         `json:"sealedCard,omitempty"`
+	CardLast4   string         `json:"cardLast4,omitempty"`
+	CVV         string         `json:"-"`
+	ClientIP    string         `json:"clientIp,omitempty"`
+	CallbackURL string         `json:"callbackUrl,omitempty"`
+	EnqueuedAt  time.Time      `json:"enqueuedAt"`
+	Resumed     bool           `json:"-"`
+}
+
+var ErrQueueFull = errors.New("job queue is full")
+
+type JobQueue interface {
+	// Enqueue stores the job, returning ErrQueueFull when too many are pending.
+	Enqueue(job *PaymentJob) error
+	// Next blocks until a job is available or ctx is done.
+	Next(ctx context.Context) (*PaymentJob, error)
+	// Done removes a job once it was processed.
+	Done(jobID string) error
+	// Retry puts a job taken with Next back in the queue after delay.
+	Retry(jobID string, delay time.Duration)
+	Pending() int
+}
@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrMerchantNotFound = errors.New("merchant not found")
+	ErrAPIKeyNotFound   = errors.New("api key not found")
+	ErrInvalidAPIKey    = errors.New("invalid api key")
+)
+
+type Merchant struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// APIKey never holds the plaintext key, only its SHA-256 hash. The ID is
+// embedded in the plaintext key so it can be looked up without scanning.
+type APIKey struct {
+	ID         string     `json:"id"`
+	MerchantID string     `json:"merchantId"`
+	Hash       string     `json:"-"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+func (k *APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
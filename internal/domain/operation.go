@@ -0,0 +1,36 @@
+package domain
+
+import "time"
+
+type OperationType string
+
+const (
+	OperationCharge  OperationType = "charge"
+	OperationRefund  OperationType = "refund"
+	OperationConfirm OperationType = "confirm"
+	OperationLookup  OperationType = "lookup"
+	OperationVoid    OperationType = "void"
+)
+
+// InFlightOperation is a provider call that was started but hasn't returned
+// yet. PaymentID is empty for charges, whose ID is assigned by the provider.
+type InFlightOperation struct {
+	ID         string        `json:"id"`
+	Type       OperationType `json:"type"`
+	MerchantID string        `json:"merchantId"`
+	PaymentID  string        `json:"paymentId,omitempty"`
+	Reference  string        `json:"reference,omitempty"`
+	ProviderID string        `json:"providerId,omitempty"`
+	Amount     float64       `json:"amount"`
+	Currency   string        `json:"currency,omitempty"`
+	CardLast4  string        `json:"cardLast4,omitempty"`
+	StartedAt  time.Time     `json:"startedAt"`
+}
+
+// UnknownOutcome marks an operation that was interrupted before its result
+// was known, so it can be reconciled with the provider later.
+type UnknownOutcome struct {
+	InFlightOperation
+	Reason     string    `json:"reason"`
+	RecordedAt time.Time `json:"recordedAt"`
+}
@@ -1,6 +1,11 @@
 package domain
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
 
 type PaymentStatus string
 
@@ -8,6 +13,22 @@ const (
 	StatusAuthorized PaymentStatus = "authorized"
 	StatusFailed     PaymentStatus = "failed"
 	StatusRefunded   PaymentStatus = "refunded"
+	StatusRejected   PaymentStatus = "rejected"
+	// StatusRequiresAction means the customer must complete a step-up
+	// authentication (3-D Secure) before the payment can be confirmed.
+	StatusRequiresAction PaymentStatus = "requires_action"
+	// StatusUnknown means the provider call timed out and the charge could
+	// not be looked up yet. The sweeper resolves it in the background.
+	StatusUnknown PaymentStatus = "unknown"
+	// StatusPending means the payment was submitted with ?async=true and is
+	// waiting for a worker to charge it.
+	StatusPending PaymentStatus = "pending"
+	// StatusRefundPending means a refund is queued until the provider of
+	// the payment recovers, or its outcome is unknown and being looked up.
+	StatusRefundPending PaymentStatus = "refund_pending"
+	// StatusDisputed means the cardholder opened a dispute that is not
+	// closed yet. The payment can't be refunded meanwhile.
+	StatusDisputed PaymentStatus = "disputed"
 )
 
 type Card struct {
@@ -18,39 +39,257 @@ type Card struct {
 	Installments   int    `json:"installments"`
 }
 
+// Last4 returns the last four digits of the card number, ignoring any
+// spaces or dashes used for formatting.
+func (c Card) Last4() string {
+	digits := c.digits()
+	if len(digits) <= 4 {
+		return digits
+	}
+	return digits[len(digits)-4:]
+}
+
+// Fingerprint identifies a card number without exposing it, so it can be
+// used as a key for limits and risk rules. It is empty when there is no number.
+func (c Card) Fingerprint() string {
+	digits := c.digits()
+	if digits == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(digits))
+	return hex.EncodeToString(sum[:])
+}
+
+// Card brands recognized by Brand.
+const (
+	BrandVisa       = "visa"
+	BrandMastercard = "mastercard"
+	BrandAmex       = "amex"
+	BrandElo        = "elo"
+	BrandHipercard  = "hipercard"
+	BrandDiners     = "diners"
+	BrandDiscover   = "discover"
+	BrandJCB        = "jcb"
+)
+
+// brandRanges maps ranges of card number prefixes to brands. Brands whose
+// ranges overlap others (Elo and Hipercard) come first.
+var brandRanges = []struct {
+	brand    string
+	from, to string
+}{
+	{BrandElo, "401178", "401179"},
+	{BrandElo, "431274", "431274"},
+	{BrandElo, "438935", "438935"},
+	{BrandElo, "451416", "451416"},
+	{BrandElo, "457393", "457393"},
+	{BrandElo, "457631", "457632"},
+	{BrandElo, "504175", "504175"},
+	{BrandElo, "506699", "506778"},
+	{BrandElo, "509000", "509999"},
+	{BrandElo, "627780", "627780"},
+	{BrandElo, "636297", "636297"},
+	{BrandElo, "636368", "636368"},
+	{BrandElo, "650031", "650051"},
+	{BrandElo, "650405", "650439"},
+	{BrandElo, "650485", "650538"},
+	{BrandElo, "650541", "650598"},
+	{BrandElo, "650700", "650727"},
+	{BrandElo, "650901", "650920"},
+	{BrandElo, "651652", "651679"},
+	{BrandElo, "655000", "655058"},
+	{BrandHipercard, "606282", "606282"},
+	{BrandHipercard, "3841", "3841"},
+	{BrandAmex, "34", "34"},
+	{BrandAmex, "37", "37"},
+	{BrandJCB, "3528", "3589"},
+	{BrandDiners, "300", "305"},
+	{BrandDiners, "36", "36"},
+	{BrandDiners, "38", "39"},
+	{BrandDiscover, "6011", "6011"},
+	{BrandDiscover, "644", "649"},
+	{BrandDiscover, "65", "65"},
+	{BrandMastercard, "51", "55"},
+	{BrandMastercard, "2221", "2720"},
+	{BrandVisa, "4", "4"},
+}
+
+// Brand identifies the card brand by the prefix of the number, returning
+// an empty string when it isn't recognized.
+func (c Card) Brand() string {
+	digits := c.digits()
+	for _, r := range brandRanges {
+		if len(digits) < len(r.from) {
+			continue
+		}
+		prefix := digits[:len(r.from)]
+		if prefix >= r.from && prefix <= r.to {
+			return r.brand
+		}
+	}
+	return ""
+}
+
+func (c Card) digits() string {
+	digits := make([]byte, 0, len(c.Number))
+	for i := 0; i < len(c.Number); i++ {
+		if c.Number[i] >= '0' && c.Number[i] <= '9' {
+			digits = append(digits, c.Number[i])
+		}
+	}
+	return string(digits)
+}
+
 type PaymentRequest struct {
 	Amount      float64 `json:"amount"`
 	Currency    string  `json:"currency"`
 	Description string  `json:"description"`
+	Email       string  `json:"email,omitempty"`
 	Card        Card    `json:"card"`
+	ClientIP    string  `json:"-"`
+	// Reference is generated by the gateway for every charge and sent to
+	// the providers, so a charge with an unknown outcome can be looked up.
+	Reference string `json:"-"`
+	// InstallmentPlan is computed by the gateway from Card.Installments.
+	InstallmentPlan *InstallmentPlan `json:"-"`
+	// FX is set when the charge was converted for the provider.
+	FX *FXConversion `json:"-"`
 }
 
 type Payment struct {
-	ID             string        `json:"id"`
-	CreatedAt      time.Time     `json:"createdAt"`
-	Status         PaymentStatus `json:"status"`
-	OriginalAmount float64       `json:"originalAmount"`
-	CurrentAmount  float64       `json:"currentAmount"`
-	Currency       string        `json:"currency"`
-	Description    string        `json:"description"`
-	PaymentMethod  string        `json:"paymentMethod"`
-	CardID         string        `json:"cardId"`
+	ID             string           `json:"id"`
+	CreatedAt      time.Time        `json:"createdAt"`
+	Status         PaymentStatus    `json:"status"`
+	OriginalAmount float64          `json:"originalAmount"`
+	CurrentAmount  float64          `json:"currentAmount"`
+	Currency       string           `json:"currency"`
+	Description    string           `json:"description"`
+	PaymentMethod  string           `json:"paymentMethod"`
+	CardID         string           `json:"cardId"`
+	CardLast4      string           `json:"cardLast4,omitempty"`
+	Authentication string           `json:"authentication,omitempty"`
+	NextAction     *NextAction      `json:"nextAction,omitempty"`
+	Installments   *InstallmentPlan `json:"installments,omitempty"`
+	FX             *FXConversion    `json:"fx,omitempty"`
+}
+
+// NextAction tells the client how to complete a payment in
+// requires_action status: redirect the customer to RedirectURL, or run the
+// challenge described by Data, then call the confirm endpoint.
+type NextAction struct {
+	Type        string            `json:"type"`
+	RedirectURL string            `json:"redirectUrl,omitempty"`
+	Data        map[string]string `json:"data,omitempty"`
 }
 
+const (
+	NextActionRedirect  = "redirect"
+	NextActionChallenge = "challenge"
+)
+
+// ConfirmRequest carries the result of the customer's authentication back
+// to the provider.
+type ConfirmRequest struct {
+	AuthenticationResult string `json:"authenticationResult"`
+}
+
+// Transaction is a payment as stored by the gateway. ProviderPaymentID is
+// the ID of the charge at the provider, which differs from Payment.ID when
+// the payment was created with an unknown outcome and resolved later.
+// Attempts lists every provider call made for the payment, including the
+// ones to providers that failed before the fallback. FX records the
+// conversion to the provider's settlement currency, if there was one.
+// DisputedFrom is the status the payment goes back to when its dispute is
+// closed.
 type Transaction struct {
-	Payment      *Payment `json:"payment"`
-	ProviderID   string   `json:"providerId"`
-	ProviderName string   `json:"providerName"`
+	Payment           *Payment        `json:"payment"`
+	MerchantID        string          `json:"merchantId"`
+	ProviderID        string          `json:"providerId"`
+	ProviderName      string          `json:"providerName"`
+	ProviderPaymentID string          `json:"providerPaymentId,omitempty"`
+	Reference         string          `json:"reference,omitempty"`
+	Risk              *RiskAssessment `json:"risk,omitempty"`
+	Attempts          []Attempt       `json:"attempts,omitempty"`
+	FX                *FXConversion   `json:"fx,omitempty"`
+	Refunds           []Refund        `json:"refunds,omitempty"`
+	DisputedFrom      PaymentStatus   `json:"disputedFrom,omitempty"`
+	// CardFingerprint identifies the card charged, so a credit can only be
+	// sent to that card. It is never exposed.
+	CardFingerprint string `json:"-"`
 }
 
+// RefundRequest carries the card of the payment only for the credit
+// fallback, since the gateway doesn't keep card numbers. It must match the
+// fingerprint of the card charged and is never sent to the provider of the
+// payment.
 type RefundRequest struct {
 	Amount float64 `json:"amount"`
+	Card   *Card   `json:"card,omitempty"`
+}
+
+type SortOrder string
+
+const (
+	SortAsc  SortOrder = "asc"
+	SortDesc SortOrder = "desc"
+)
+
+var (
+	ErrPaymentNotFound = errors.New("payment not found")
+	ErrInvalidCursor   = errors.New("invalid cursor")
+	ErrInvalidStatus   = errors.New("invalid payment status")
+	// ErrOutcomeUnknown is returned by providers when a request may have
+	// reached the provider but no response came back, e.g. on timeouts.
+	ErrOutcomeUnknown = errors.New("provider outcome unknown")
+	// ErrNotStored wraps failures to store the result of a provider call,
+	// which went through even though an error is returned.
+	ErrNotStored = errors.New("failed to store transaction")
+)
+
+// PaymentFilter describes a search over stored transactions. Zero values
+// are ignored, so an empty filter matches every transaction.
+type PaymentFilter struct {
+	MerchantID  string
+	Status      PaymentStatus
+	ProviderID  string
+	Currency    string
+	MinAmount   *float64
+	MaxAmount   *float64
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	CardLast4   string
+	Description string
+	Cursor      string
+	Limit       int
+	Order       SortOrder
+}
+
+type PaymentPage struct {
+	Data       []*Payment `json:"data"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+	HasMore    bool       `json:"hasMore"`
+}
+
+type TransactionStore interface {
+	Save(transaction *Transaction) error
+	Get(paymentID string) (*Transaction, error)
+	List(filter PaymentFilter) ([]*Transaction, string, error)
+	// Ping reports whether the store is reachable.
+	Ping() error
 }
 
 type PaymentProvider interface {
 	ProcessPayment(request PaymentRequest) (*Payment, error)
 	RefundPayment(paymentID string, request RefundRequest) (*Payment, error)
+	ConfirmPayment(paymentID string, request ConfirmRequest) (*Payment, error)
 	GetPayment(paymentID string) (*Payment, error)
+	// FindPaymentByReference looks up a charge by the Reference of its
+	// request, returning ErrPaymentNotFound if the provider never saw it.
+	FindPaymentByReference(reference string) (*Payment, error)
+	// Credit sends a stand-alone credit to a card, for providers whose
+	// capabilities list OperationCredit.
+	Credit(request CreditRequest) (*Payment, error)
 	GetID() string
 	GetName() string
+	Capabilities() Capabilities
 }
@@ -0,0 +1,62 @@
+package domain
+
+import "time"
+
+// OperationCredit sends money to a card without a charge to refund, used
+// when the provider of a payment can't refund it. Providers only make
+// credits when their capabilities list it explicitly.
+const OperationCredit OperationType = "credit"
+
+type RefundMethod string
+
+const (
+	// RefundMethodRefund refunds the charge at the provider of the payment.
+	RefundMethodRefund RefundMethod = "refund"
+	// RefundMethodCredit credits the card through another provider.
+	RefundMethodCredit RefundMethod = "credit"
+)
+
+type RefundStatus string
+
+const (
+	RefundSucceeded RefundStatus = "succeeded"
+	// RefundQueued is retried in the background until the provider of the
+	// payment recovers.
+	RefundQueued RefundStatus = "queued"
+	RefundFailed RefundStatus = "failed"
+	// RefundUnknown may or may not have gone through. It is never sent
+	// again, only looked up in the background until its outcome is known;
+	// the payment can't be refunded again meanwhile.
+	RefundUnknown RefundStatus = "unknown"
+)
+
+// Refund is an entry of the refund ledger of a payment. ProviderID is the
+// provider that made, or is to make, the refund; for credits it differs
+// from the provider of the payment and ProviderRefundID is the ID of the
+// credit there. Reason tells why a fallback was used, Error the last
+// failure of a queued refund and Retries how many times it was retried.
+type Refund struct {
+	ID               string       `json:"id"`
+	Method           RefundMethod `json:"method"`
+	Status           RefundStatus `json:"status"`
+	Amount           float64      `json:"amount"`
+	Currency         string       `json:"currency"`
+	ProviderID       string       `json:"providerId"`
+	ProviderName     string       `json:"providerName"`
+	ProviderRefundID string       `json:"providerRefundId,omitempty"`
+	Reason           string       `json:"reason,omitempty"`
+	Error            string       `json:"error,omitempty"`
+	Retries          int          `json:"retries,omitempty"`
+	CreatedAt        time.Time    `json:"createdAt"`
+	UpdatedAt        time.Time    `json:"updatedAt"`
+}
+
+// CreditRequest credits Amount to Card. Reference is the ID of the refund,
+// so providers dedupe credits sent twice.
+type CreditRequest struct {
+	Amount      float64
+	Currency    string
+	Description string
+	Reference   string
+	Card        Card
+}
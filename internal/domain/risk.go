@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+type RiskDecision string
+
+const (
+	RiskApprove RiskDecision = "approve"
+	RiskReview  RiskDecision = "review"
+	RiskReject  RiskDecision = "reject"
+)
+
+var ErrPaymentRejected = errors.New("payment rejected by risk assessment")
+
+type RiskAssessment struct {
+	Score       int          `json:"score"`
+	Decision    RiskDecision `json:"decision"`
+	Reasons     []string     `json:"reasons,omitempty"`
+	EvaluatedAt time.Time    `json:"evaluatedAt"`
+}
+
+// RiskRejectedError is returned when a payment is rejected before reaching
+// any provider. It matches ErrPaymentRejected with errors.Is.
+type RiskRejectedError struct {
+	PaymentID  string
+	Assessment RiskAssessment
+}
+
+func (e *RiskRejectedError) Error() string {
+	return ErrPaymentRejected.Error()
+}
+
+func (e *RiskRejectedError) Unwrap() error {
+	return ErrPaymentRejected
+}
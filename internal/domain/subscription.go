@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+type PlanInterval string
+
+const (
+	IntervalDay   PlanInterval = "day"
+	IntervalWeek  PlanInterval = "week"
+	IntervalMonth PlanInterval = "month"
+	IntervalYear  PlanInterval = "year"
+)
+
+type SubscriptionStatus string
+
+const (
+	SubscriptionActive SubscriptionStatus = "active"
+	// SubscriptionPastDue means the last charge failed and is retried on
+	// the dunning schedule.
+	SubscriptionPastDue SubscriptionStatus = "past_due"
+	// SubscriptionUnpaid means every dunning retry failed. It isn't charged
+	// again until resumed.
+	SubscriptionUnpaid   SubscriptionStatus = "unpaid"
+	SubscriptionPaused   SubscriptionStatus = "paused"
+	SubscriptionCanceled SubscriptionStatus = "canceled"
+)
+
+var (
+	ErrPlanNotFound         = errors.New("plan not found")
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrInvalidSubscription  = errors.New("invalid subscription")
+	ErrSubscriptionCanceled = errors.New("subscription is canceled")
+)
+
+// Plan is what a subscription is charged: Amount every IntervalCount
+// intervals.
+type Plan struct {
+	ID            string       `json:"id"`
+	MerchantID    string       `json:"-"`
+	Name          string       `json:"name"`
+	Amount        float64      `json:"amount"`
+	Currency      string       `json:"currency"`
+	Interval      PlanInterval `json:"interval"`
+	IntervalCount int          `json:"intervalCount"`
+	CreatedAt     time.Time    `json:"createdAt"`
+}
+
+type PlanRequest struct {
+	Name          string       `json:"name"`
+	Amount        float64      `json:"amount"`
+	Currency      string       `json:"currency"`
+	Interval      PlanInterval `json:"interval"`
+	IntervalCount int          `json:"intervalCount"`
+}
+
+// Subscription charges its plan to a stored card. Billing dates are counted
+// from BillingAnchor, so monthly charges stay on the same day of the month.
+type Subscription struct {
+	ID             string               `json:"id"`
+	MerchantID     string               `json:"-"`
+	PlanID         string               `json:"planId"`
+	CardToken      string               `json:"cardToken"`
+	Status         SubscriptionStatus   `json:"status"`
+	BillingAnchor  time.Time            `json:"billingAnchor"`
+	NextBillingAt  time.Time            `json:"nextBillingAt"`
+	FailedAttempts int                  `json:"failedAttempts"`
+	NextRetryAt    *time.Time           `json:"nextRetryAt,omitempty"`
+	Charges        []SubscriptionCharge `json:"charges"`
+	CreatedAt      time.Time            `json:"createdAt"`
+	PausedAt       *time.Time           `json:"pausedAt,omitempty"`
+	CanceledAt     *time.Time           `json:"canceledAt,omitempty"`
+}
+
+// SubscriptionCharge is one attempt to charge a period of a subscription.
+type SubscriptionCharge struct {
+	PeriodStart time.Time     `json:"periodStart"`
+	Attempt     int           `json:"attempt"`
+	PaymentID   string        `json:"paymentId,omitempty"`
+	Status      PaymentStatus `json:"status,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	ChargedAt   time.Time     `json:"chargedAt"`
+}
+
+type SubscriptionRequest struct {
+	PlanID    string `json:"planId"`
+	CardToken string `json:"cardToken"`
+	// StartAt is when the first period is charged, now if empty.
+	StartAt *time.Time `json:"startAt,omitempty"`
+}
@@ -0,0 +1,145 @@
+package fx
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"desafio-api/internal/domain"
+)
+
+// table holds the rates against Base: one unit of Base buys Rates[c] units
+// of c. Rates between two other currencies are crossed through Base.
+type table struct {
+	Base  string             `json:"base"`
+	AsOf  time.Time          `json:"asOf"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+func (t *table) rate(from string, to string) (*domain.FXRate, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+	fromRate, ok := t.units(from)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s to %s", domain.ErrRateNotFound, from, to)
+	}
+	toRate, ok := t.units(to)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s to %s", domain.ErrRateNotFound, from, to)
+	}
+	return &domain.FXRate{From: from, To: to, Rate: toRate / fromRate, AsOf: t.AsOf}, nil
+}
+
+func (t *table) units(currency string) (float64, bool) {
+	if currency == t.Base {
+		return 1, true
+	}
+	rate, ok := t.Rates[currency]
+	return rate, ok && rate > 0
+}
+
+func (t *table) normalize() error {
+	if t.Base == "" {
+		return fmt.Errorf("rates without base currency")
+	}
+	t.Base = strings.ToUpper(t.Base)
+	rates := make(map[string]float64, len(t.Rates))
+	for currency, rate := range t.Rates {
+		if rate <= 0 {
+			return fmt.Errorf("invalid rate %v for %s", rate, currency)
+		}
+		rates[strings.ToUpper(currency)] = rate
+	}
+	t.Rates = rates
+	return nil
+}
+
+// StaticRates is a fixed table of rates, the local stand-in for a rates
+// feed in development and tests.
+type StaticRates struct {
+	table table
+}
+
+func NewStaticRates(base string, rates map[string]float64) (*StaticRates, error) {
+	t := table{Base: base, AsOf: time.Now(), Rates: rates}
+	if err := t.normalize(); err != nil {
+		return nil, err
+	}
+	return &StaticRates{table: t}, nil
+}
+
+// DevelopmentRates returns approximate rates of the usual currencies
+// against USD, not meant for real charges.
+func DevelopmentRates() *StaticRates {
+	rates, _ := NewStaticRates("USD", map[string]float64{
+		"BRL": 5.0,
+		"EUR": 0.92,
+		"GBP": 0.79,
+		"ARS": 870.0,
+		"MXN": 17.0,
+	})
+	return rates
+}
+
+func (r *StaticRates) Rate(from string, to string) (*domain.FXRate, error) {
+	return r.table.rate(from, to)
+}
+
+// FileRates reads the rates from a JSON file with the base currency, the
+// time of the rates and the rates themselves:
+//
+//	{"base": "USD", "asOf": "2024-05-01T12:00:00Z", "rates": {"BRL": 5.05}}
+//
+// The file is read again whenever its modification time changes, so an
+// external job can keep it up to date. If the new content is invalid the
+// previous rates are kept.
+type FileRates struct {
+	path    string
+	mutex   sync.Mutex
+	table   table
+	modTime time.Time
+}
+
+func OpenFile(path string) (*FileRates, error) {
+	r := &FileRates{path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading rates file: %w", err)
+	}
+	if err := r.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *FileRates) Rate(from string, to string) (*domain.FXRate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if info, err := os.Stat(r.path); err == nil && !info.ModTime().Equal(r.modTime) {
+		if err := r.load(info.ModTime()); err != nil {
+			log.Printf("keeping previous exchange rates: %v", err)
+		}
+	}
+	return r.table.rate(from, to)
+}
+
+// load reads the file, which is only read again once modified again.
+func (r *FileRates) load(modTime time.Time) error {
+	r.modTime = modTime
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("error reading rates file: %w", err)
+	}
+	var t table
+	if err := json.Unmarshal(data, &t); err != nil {
+		return fmt.Errorf("error parsing rates file: %w", err)
+	}
+	if err := t.normalize(); err != nil {
+		return fmt.Errorf("error parsing rates file: %w", err)
+	}
+	r.table = t
+	return nil
+}
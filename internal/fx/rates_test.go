@@ -0,0 +1,67 @@
+package fx
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/internal/domain"
+)
+
+func TestStaticRates(t *testing.T) {
+	rates, err := NewStaticRates("usd", map[string]float64{"BRL": 5, "eur": 0.8})
+	require.NoError(t, err)
+
+	rate, err := rates.Rate("USD", "BRL")
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, rate.Rate)
+
+	rate, err = rates.Rate("brl", "USD")
+	require.NoError(t, err)
+	assert.Equal(t, 0.2, rate.Rate)
+
+	// Crossed through the base currency
+	rate, err = rates.Rate("EUR", "BRL")
+	require.NoError(t, err)
+	assert.Equal(t, "EUR", rate.From)
+	assert.InDelta(t, 6.25, rate.Rate, 1e-9)
+
+	_, err = rates.Rate("USD", "JPY")
+	assert.ErrorIs(t, err, domain.ErrRateNotFound)
+
+	_, err = NewStaticRates("USD", map[string]float64{"BRL": 0})
+	assert.Error(t, err)
+}
+
+func TestFileRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"base": "USD", "asOf": "2024-05-01T12:00:00Z", "rates": {"BRL": 5.05}}`), 0o644))
+
+	rates, err := OpenFile(path)
+	require.NoError(t, err)
+	rate, err := rates.Rate("USD", "BRL")
+	require.NoError(t, err)
+	assert.Equal(t, 5.05, rate.Rate)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), rate.AsOf)
+
+	// Reloaded once modified
+	require.NoError(t, os.WriteFile(path, []byte(`{"base": "USD", "rates": {"BRL": 5.2}}`), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	rate, err = rates.Rate("USD", "BRL")
+	require.NoError(t, err)
+	assert.Equal(t, 5.2, rate.Rate)
+
+	// Invalid content keeps the previous rates
+	require.NoError(t, os.WriteFile(path, []byte(`{"rates": `), 0o644))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+	rate, err = rates.Rate("USD", "BRL")
+	require.NoError(t, err)
+	assert.Equal(t, 5.2, rate.Rate)
+
+	_, err = OpenFile(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
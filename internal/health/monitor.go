@@ -0,0 +1,141 @@
+package health
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"desafio-api/internal/domain"
+)
+
+const DefaultWindow = 100
+
+type sample struct {
+	latency time.Duration
+	ok      bool
+}
+
+type providerHealth struct {
+	samples       []sample
+	next          int
+	lastError     string
+	lastErrorAt   *time.Time
+	lastSuccessAt *time.Time
+	lastProbeAt   *time.Time
+}
+
+// Monitor keeps the outcome and latency of the last window calls made to
+// each provider, whether by payments or by the prober.
+type Monitor struct {
+	mutex     sync.Mutex
+	window    int
+	providers map[string]*providerHealth
+	now       func() time.Time
+}
+
+func NewMonitor(window int) *Monitor {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Monitor{
+		window:    window,
+		providers: make(map[string]*providerHealth),
+		now:       time.Now,
+	}
+}
+
+func (m *Monitor) Record(providerID string, latency time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.record(providerID, latency, err)
+}
+
+// RecordProbe records the result of an active health check.
+func (m *Monitor) RecordProbe(providerID string, latency time.Duration, err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	h := m.record(providerID, latency, err)
+	now := m.now()
+	h.lastProbeAt = &now
+}
+
+func (m *Monitor) record(providerID string, latency time.Duration, err error) *providerHealth {
+	h, exists := m.providers[providerID]
+	if !exists {
+		h = &providerHealth{samples: make([]sample, 0, m.window)}
+		m.providers[providerID] = h
+	}
+
+	s := sample{latency: latency, ok: err == nil}
+	if len(h.samples) < m.window {
+		h.samples = append(h.samples, s)
+	} else {
+		h.samples[h.next] = s
+	}
+	h.next = (h.next + 1) % m.window
+
+	now := m.now()
+	if err != nil {
+		h.lastError = err.Error()
+		h.lastErrorAt = &now
+	} else {
+		h.lastSuccessAt = &now
+	}
+	return h
+}
+
+func (m *Monitor) Stats(providerID string) domain.ProviderStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	h, exists := m.providers[providerID]
+	if !exists || len(h.samples) == 0 {
+		return domain.ProviderStats{}
+	}
+
+	latencies := make([]time.Duration, len(h.samples))
+	succeeded := 0
+	for i, s := range h.samples {
+		latencies[i] = s.latency
+		if s.ok {
+			succeeded++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return domain.ProviderStats{
+		Samples:       len(h.samples),
+		SuccessRate:   float64(succeeded) / float64(len(h.samples)),
+		LatencyP50Ms:  milliseconds(percentile(latencies, 0.50)),
+		LatencyP95Ms:  milliseconds(percentile(latencies, 0.95)),
+		LatencyP99Ms:  milliseconds(percentile(latencies, 0.99)),
+		LastError:     h.lastError,
+		LastErrorAt:   copyTime(h.lastErrorAt),
+		LastSuccessAt: copyTime(h.lastSuccessAt),
+		LastProbeAt:   copyTime(h.lastProbeAt),
+	}
+}
+
+// percentile uses the nearest-rank method on sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	copied := *t
+	return &copied
+}
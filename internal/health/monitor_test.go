@@ -0,0 +1,57 @@
+package health
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitor(t *testing.T) {
+	t.Run("success rate and percentiles over the window", func(t *testing.T) {
+		m := NewMonitor(10)
+		for i := 1; i <= 20; i++ {
+			var err error
+			if i%5 == 0 {
+				err = errors.New("boom")
+			}
+			m.Record("stripe", time.Duration(i)*time.Millisecond, err)
+		}
+
+		stats := m.Stats("stripe")
+		assert.Equal(t, 10, stats.Samples)
+		assert.InDelta(t, 0.8, stats.SuccessRate, 0.001)
+		assert.Equal(t, 15.0, stats.LatencyP50Ms)
+		assert.Equal(t, 20.0, stats.LatencyP99Ms)
+		assert.Equal(t, "boom", stats.LastError)
+		assert.NotNil(t, stats.LastErrorAt)
+		assert.NotNil(t, stats.LastSuccessAt)
+		assert.Nil(t, stats.LastProbeAt)
+	})
+
+	t.Run("probes feed the same stats", func(t *testing.T) {
+		m := NewMonitor(10)
+		prober := NewProber([]Target{&stubTarget{id: "braintree", err: errors.New("connection refused")}}, m, time.Minute)
+		prober.ProbeAll()
+
+		stats := m.Stats("braintree")
+		assert.Equal(t, 1, stats.Samples)
+		assert.Equal(t, 0.0, stats.SuccessRate)
+		assert.Equal(t, "connection refused", stats.LastError)
+		assert.NotNil(t, stats.LastProbeAt)
+	})
+
+	t.Run("unknown provider has empty stats", func(t *testing.T) {
+		assert.Equal(t, 0, NewMonitor(10).Stats("none").Samples)
+	})
+}
+
+type stubTarget struct {
+	id  string
+	err error
+}
+
+func (s *stubTarget) GetID() string      { return s.id }
+func (s *stubTarget) GetName() string    { return s.id }
+func (s *stubTarget) HealthCheck() error { return s.err }
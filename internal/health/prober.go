@@ -0,0 +1,51 @@
+package health
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Target is a provider that can be health checked.
+type Target interface {
+	GetID() string
+	GetName() string
+	HealthCheck() error
+}
+
+// Prober checks every target on an interval and feeds the results to the
+// monitor, so providers without traffic still have fresh stats.
+type Prober struct {
+	targets  []Target
+	monitor  *Monitor
+	interval time.Duration
+}
+
+func NewProber(targets []Target, monitor *Monitor, interval time.Duration) *Prober {
+	return &Prober{targets: targets, monitor: monitor, interval: interval}
+}
+
+// Run probes immediately and then every interval until ctx is done.
+func (p *Prober) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.ProbeAll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Prober) ProbeAll() {
+	for _, target := range p.targets {
+		start := time.Now()
+		err := target.HealthCheck()
+		p.monitor.RecordProbe(target.GetID(), time.Since(start), err)
+		if err != nil {
+			log.Printf("[provider: %s] health check failed: %v", target.GetName(), err)
+		}
+	}
+}
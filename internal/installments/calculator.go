@@ -0,0 +1,170 @@
+package installments
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+type rule struct {
+	minInstallments     int
+	maxInstallments     int
+	minInstallmentValue float64
+	interestFree        int
+	interest            domain.InstallmentInterest
+	monthlyRate         float64
+}
+
+// Calculator computes installment plans from the rules of each currency.
+type Calculator struct {
+	rules map[string]rule
+}
+
+func NewCalculator(cfg []config.InstallmentConfig) (*Calculator, error) {
+	calculator := &Calculator{rules: make(map[string]rule)}
+	for _, c := range cfg {
+		currency := strings.ToUpper(c.Currency)
+		if currency == "" {
+			return nil, fmt.Errorf("installment rule without currency")
+		}
+		if _, ok := calculator.rules[currency]; ok {
+			return nil, fmt.Errorf("duplicate installment rule for %s", currency)
+		}
+		if c.MaxInstallments < 1 || c.MinInstallments > c.MaxInstallments {
+			return nil, fmt.Errorf("invalid installment range for %s: %d to %d", currency, c.MinInstallments, c.MaxInstallments)
+		}
+		if c.MonthlyRate < 0 {
+			return nil, fmt.Errorf("negative monthly rate for %s", currency)
+		}
+
+		r := rule{
+			minInstallments:     max(c.MinInstallments, 2),
+			maxInstallments:     c.MaxInstallments,
+			minInstallmentValue: c.MinInstallmentValue,
+			interestFree:        c.InterestFree,
+			interest:            domain.InstallmentInterest(c.Interest),
+			monthlyRate:         c.MonthlyRate,
+		}
+		switch r.interest {
+		case domain.InterestMerchant, domain.InterestIssuer:
+		case "", domain.InterestNone:
+			r.interest = domain.InterestNone
+			r.interestFree = r.maxInstallments
+		default:
+			return nil, fmt.Errorf("unknown installment interest %q for %s", c.Interest, currency)
+		}
+		calculator.rules[currency] = r
+	}
+	return calculator, nil
+}
+
+// Plan splits amount into count installments, the first one due a month
+// after start. It fails with ErrInvalidInstallments when the currency has
+// no rules or count or the resulting installment are out of its limits.
+func (c *Calculator) Plan(amount float64, currency string, count int, start time.Time) (*domain.InstallmentPlan, error) {
+	r, err := c.rule(amount, currency)
+	if err != nil {
+		return nil, err
+	}
+	if count != 1 && (count < r.minInstallments || count > r.maxInstallments) {
+		return nil, fmt.Errorf("%w: installments must be between %d and %d for %s", domain.ErrInvalidInstallments, r.minInstallments, r.maxInstallments, strings.ToUpper(currency))
+	}
+
+	plan := r.plan(amount, count, start)
+	if count > 1 && plan.InstallmentAmount < r.minInstallmentValue {
+		return nil, fmt.Errorf("%w: installment of %.2f is below the minimum of %.2f", domain.ErrInvalidInstallments, plan.InstallmentAmount, r.minInstallmentValue)
+	}
+	return plan, nil
+}
+
+// Simulate returns every plan available for amount, from a single payment
+// up to the largest number of installments allowed.
+func (c *Calculator) Simulate(amount float64, currency string, start time.Time) ([]*domain.InstallmentPlan, error) {
+	r, err := c.rule(amount, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	plans := []*domain.InstallmentPlan{r.plan(amount, 1, start)}
+	for count := r.minInstallments; count <= r.maxInstallments; count++ {
+		plan := r.plan(amount, count, start)
+		// Installments only get smaller from here on
+		if plan.InstallmentAmount < r.minInstallmentValue {
+			break
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+func (c *Calculator) rule(amount float64, currency string) (rule, error) {
+	if amount <= 0 {
+		return rule{}, fmt.Errorf("%w: amount must be positive", domain.ErrInvalidInstallments)
+	}
+	r, ok := c.rules[strings.ToUpper(currency)]
+	if !ok {
+		return rule{}, fmt.Errorf("%w: installments are not available for %s", domain.ErrInvalidInstallments, currency)
+	}
+	return r, nil
+}
+
+// plan computes the installments in cents. Interest-free plans give the
+// rounding difference to the first installment; plans with interest use the
+// Price table (fixed installments) at the monthly rate.
+func (r rule) plan(amount float64, count int, start time.Time) *domain.InstallmentPlan {
+	amountCents := toCents(amount)
+	plan := &domain.InstallmentPlan{
+		Count:    count,
+		Interest: domain.InterestNone,
+		Amount:   fromCents(amountCents),
+		Schedule: make([]domain.Installment, count),
+	}
+
+	installments := make([]int64, count)
+	if count <= r.interestFree || count == 1 || r.monthlyRate == 0 {
+		for i := range installments {
+			installments[i] = amountCents / int64(count)
+		}
+		installments[0] += amountCents % int64(count)
+	} else {
+		plan.Interest = r.interest
+		plan.MonthlyRate = r.monthlyRate
+		payment := float64(amountCents) * r.monthlyRate / (1 - math.Pow(1+r.monthlyRate, -float64(count)))
+		for i := range installments {
+			installments[i] = int64(math.Round(payment))
+		}
+	}
+
+	var total int64
+	for i, cents := range installments {
+		total += cents
+		plan.Schedule[i] = domain.Installment{
+			Number:  i + 1,
+			Amount:  fromCents(cents),
+			DueDate: addMonths(start, i+1),
+		}
+	}
+	plan.InstallmentAmount = fromCents(installments[count-1])
+	plan.TotalAmount = fromCents(total)
+	return plan
+}
+
+// addMonths keeps the day of start, or the last day of shorter months.
+func addMonths(start time.Time, months int) time.Time {
+	year, month, day := start.Date()
+	first := time.Date(year, month+time.Month(months), 1, start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(day, last)-1)
+}
+
+func toCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func fromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
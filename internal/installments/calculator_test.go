@@ -0,0 +1,92 @@
+package installments
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+func newTestCalculator(t *testing.T, interest string) *Calculator {
+	calculator, err := NewCalculator([]config.InstallmentConfig{{
+		Currency:            "BRL",
+		MinInstallments:     2,
+		MaxInstallments:     12,
+		MinInstallmentValue: 5,
+		InterestFree:        3,
+		Interest:            interest,
+		MonthlyRate:         0.0199,
+	}})
+	require.NoError(t, err)
+	return calculator
+}
+
+func TestCalculator_Plan(t *testing.T) {
+	start := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+
+	t.Run("interest free", func(t *testing.T) {
+		plan, err := newTestCalculator(t, "merchant").Plan(100, "brl", 3, start)
+		require.NoError(t, err)
+		assert.Equal(t, domain.InterestNone, plan.Interest)
+		assert.Equal(t, 100.0, plan.TotalAmount)
+		assert.Equal(t, 100.0, plan.ChargeAmount())
+		assert.Equal(t, 33.33, plan.InstallmentAmount)
+		require.Len(t, plan.Schedule, 3)
+		assert.Equal(t, 33.34, plan.Schedule[0].Amount)
+		assert.Equal(t, time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC), plan.Schedule[0].DueDate)
+		assert.Equal(t, time.Date(2024, 4, 30, 12, 0, 0, 0, time.UTC), plan.Schedule[2].DueDate)
+	})
+
+	t.Run("merchant interest", func(t *testing.T) {
+		plan, err := newTestCalculator(t, "merchant").Plan(1000, "BRL", 12, start)
+		require.NoError(t, err)
+		assert.Equal(t, domain.InterestMerchant, plan.Interest)
+		assert.Equal(t, 94.5, plan.InstallmentAmount)
+		assert.Equal(t, 1134.0, plan.TotalAmount)
+		assert.Equal(t, 1134.0, plan.ChargeAmount())
+	})
+
+	t.Run("issuer interest", func(t *testing.T) {
+		plan, err := newTestCalculator(t, "issuer").Plan(1000, "BRL", 4, start)
+		require.NoError(t, err)
+		assert.Equal(t, domain.InterestIssuer, plan.Interest)
+		assert.Equal(t, 262.56, plan.InstallmentAmount)
+		assert.Equal(t, 1050.24, plan.TotalAmount)
+		assert.Equal(t, 1000.0, plan.ChargeAmount())
+	})
+
+	t.Run("out of the rules", func(t *testing.T) {
+		calculator := newTestCalculator(t, "merchant")
+		_, err := calculator.Plan(100, "BRL", 13, start)
+		assert.ErrorIs(t, err, domain.ErrInvalidInstallments)
+		_, err = calculator.Plan(20, "BRL", 6, start)
+		assert.ErrorIs(t, err, domain.ErrInvalidInstallments)
+		_, err = calculator.Plan(100, "USD", 2, start)
+		assert.ErrorIs(t, err, domain.ErrInvalidInstallments)
+	})
+}
+
+func TestCalculator_Simulate(t *testing.T) {
+	plans, err := newTestCalculator(t, "merchant").Simulate(20, "BRL", time.Now())
+	require.NoError(t, err)
+	// 1x, then 2x to 4x, the last one with an installment of at least 5.00
+	require.Len(t, plans, 4)
+	assert.Equal(t, 1, plans[0].Count)
+	assert.Equal(t, 4, plans[3].Count)
+	assert.Equal(t, domain.InterestMerchant, plans[3].Interest)
+	assert.GreaterOrEqual(t, plans[3].InstallmentAmount, 5.0)
+
+	_, err = newTestCalculator(t, "merchant").Simulate(0, "BRL", time.Now())
+	assert.ErrorIs(t, err, domain.ErrInvalidInstallments)
+}
+
+func TestNewCalculator_InvalidRules(t *testing.T) {
+	_, err := NewCalculator([]config.InstallmentConfig{{Currency: "BRL", MaxInstallments: 12, Interest: "customer"}})
+	assert.Error(t, err)
+	_, err = NewCalculator([]config.InstallmentConfig{{Currency: "BRL", MinInstallments: 6, MaxInstallments: 3}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,228 @@
+package jobs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"desafio-api/internal/domain"
+)
+
+// Queue is a FIFO of payment jobs. With a path, every job enqueued and done
+// is appended to a journal file, so the jobs still pending after a restart
+// are processed again. The journal holds the card data of pending jobs and
+// is only readable by its owner.
+type Queue struct {
+	mutex      sync.Mutex
+	file       *os.File
+	encoder    *json.Encoder
+	waiting    []*domain.PaymentJob
+	active     map[string]*domain.PaymentJob
+	maxPending int
+	notify     chan struct{}
+}
+
+type journalEntry struct {
+	Job  *domain.PaymentJob `json:"job,omitempty"`
+	Done string             `json:"done,omitempty"`
+}
+
+// Open loads the jobs left pending in the journal at path, compacting it,
+// and keeps journaling to it. An empty path keeps the queue in memory only.
+// A maxPending of 0 means no limit.
+func Open(path string, maxPending int) (*Queue, error) {
+	q := &Queue{
+		active:     make(map[string]*domain.PaymentJob),
+		maxPending: maxPending,
+		notify:     make(chan struct{}, 1),
+	}
+	if path == "" {
+		return q, nil
+	}
+
+	pending, err := loadJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeJournal(path, pending); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening job journal: %w", err)
+	}
+	q.file = file
+	q.encoder = json.NewEncoder(file)
+	q.waiting = pending
+	if len(pending) > 0 {
+		q.notify <- struct{}{}
+	}
+	return q, nil
+}
+
+func loadJournal(path string) ([]*domain.PaymentJob, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening job journal: %w", err)
+	}
+	defer file.Close()
+
+	var jobs []*domain.PaymentJob
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error parsing job journal on line %d: %w", line, err)
+		}
+		switch {
+		case entry.Job != nil:
+			entry.Job.Resumed = true
+			jobs = append(jobs, entry.Job)
+		case entry.Done != "":
+			for i, job := range jobs {
+				if job.ID == entry.Done {
+					jobs = append(jobs[:i], jobs[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading job journal: %w", err)
+	}
+	return jobs, nil
+}
+
+// writeJournal replaces the journal with one holding only the given jobs.
+func writeJournal(path string, jobs []*domain.PaymentJob) error {
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error compacting job journal: %w", err)
+	}
+	encoder := json.NewEncoder(file)
+	for _, job := range jobs {
+		if err := encoder.Encode(journalEntry{Job: job}); err != nil {
+			file.Close()
+			return fmt.Errorf("error compacting job journal: %w", err)
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("error compacting job journal: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error compacting job journal: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error compacting job journal: %w", err)
+	}
+	return nil
+}
+
+func (q *Queue) Enqueue(job *domain.PaymentJob) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.maxPending > 0 && len(q.waiting)+len(q.active) >= q.maxPending {
+		return domain.ErrQueueFull
+	}
+	if err := q.journal(journalEntry{Job: job}); err != nil {
+		return err
+	}
+	q.waiting = append(q.waiting, job)
+	q.signal()
+	return nil
+}
+
+func (q *Queue) Next(ctx context.Context) (*domain.PaymentJob, error) {
+	for {
+		q.mutex.Lock()
+		if len(q.waiting) > 0 {
+			job := q.waiting[0]
+			q.waiting = q.waiting[1:]
+			q.active[job.ID] = job
+			// Wake up another worker for the jobs left
+			if len(q.waiting) > 0 {
+				q.signal()
+			}
+			q.mutex.Unlock()
+			return job, nil
+		}
+		q.mutex.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (q *Queue) Done(jobID string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.active, jobID)
+	return q.journal(journalEntry{Done: jobID})
+}
+
+// Retry puts a job that couldn't be processed back in the queue after
+// delay, marked as resumed. It stays in the journal meanwhile.
+func (q *Queue) Retry(jobID string, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		job, ok := q.active[jobID]
+		if !ok {
+			return
+		}
+		delete(q.active, jobID)
+		job.Resumed = true
+		q.waiting = append(q.waiting, job)
+		q.signal()
+	})
+}
+
+func (q *Queue) Pending() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.waiting) + len(q.active)
+}
+
+func (q *Queue) Close() error {
+	if q.file == nil {
+		return nil
+	}
+	return q.file.Close()
+}
+
+func (q *Queue) journal(entry journalEntry) error {
+	if q.file == nil {
+		return nil
+	}
+	if err := q.encoder.Encode(entry); err != nil {
+		return fmt.Errorf("error writing job journal: %w", err)
+	}
+	if err := q.file.Sync(); err != nil {
+		return fmt.Errorf("error writing job journal: %w", err)
+	}
+	return nil
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
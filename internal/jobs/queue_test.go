@@ -0,0 +1,105 @@
+package jobs
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/internal/domain"
+)
+
+func newJob(id string) *domain.PaymentJob {
+	return &domain.PaymentJob{
+		ID:        id,
+		PaymentID: "pay-" + id,
+		Actor:     domain.Actor{Type: domain.ActorMerchant, ID: "key", MerchantID: "merchant"},
+		Request:   domain.PaymentRequest{Amount: 10, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}},
+		ClientIP:  "127.0.0.1",
+	}
+}
+
+func TestQueue(t *testing.T) {
+	t.Run("jobs left pending survive a restart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.ndjson")
+		queue, err := Open(path, 0)
+		require.NoError(t, err)
+		require.NoError(t, queue.Enqueue(newJob("1")))
+		require.NoError(t, queue.Enqueue(newJob("2")))
+		require.NoError(t, queue.Enqueue(newJob("3")))
+
+		job, err := queue.Next(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "1", job.ID)
+		assert.False(t, job.Resumed)
+		require.NoError(t, queue.Done(job.ID))
+		// Taken but not done, as when the process dies while charging
+		job, err = queue.Next(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "2", job.ID)
+		require.NoError(t, queue.Close())
+
+		queue, err = Open(path, 0)
+		require.NoError(t, err)
+		defer queue.Close()
+		assert.Equal(t, 2, queue.Pending())
+		job, err = queue.Next(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "2", job.ID)
+		assert.Equal(t, "127.0.0.1", job.ClientIP)
+		assert.True(t, job.Resumed)
+		assert.Equal(t, "4111111111111111", job.Request.Card.Number)
+		job, err = queue.Next(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "3", job.ID)
+	})
+
+	t.Run("rejects jobs when full", func(t *testing.T) {
+		queue, err := Open("", 1)
+		require.NoError(t, err)
+		require.NoError(t, queue.Enqueue(newJob("1")))
+		assert.ErrorIs(t, queue.Enqueue(newJob("2")), domain.ErrQueueFull)
+
+		job, err := queue.Next(context.Background())
+		require.NoError(t, err)
+		assert.ErrorIs(t, queue.Enqueue(newJob("2")), domain.ErrQueueFull)
+		require.NoError(t, queue.Done(job.ID))
+		assert.NoError(t, queue.Enqueue(newJob("2")))
+	})
+
+	t.Run("retried job comes back resumed", func(t *testing.T) {
+		queue, err := Open("", 0)
+		require.NoError(t, err)
+		require.NoError(t, queue.Enqueue(newJob("1")))
+		job, err := queue.Next(context.Background())
+		require.NoError(t, err)
+
+		queue.Retry(job.ID, 10*time.Millisecond)
+		assert.Equal(t, 1, queue.Pending())
+		job, err = queue.Next(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "1", job.ID)
+		assert.True(t, job.Resumed)
+	})
+
+	t.Run("next waits for a job", func(t *testing.T) {
+		queue, err := Open("", 0)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err = queue.Next(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			queue.Enqueue(newJob("1"))
+		}()
+		job, err := queue.Next(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "1", job.ID)
+	})
+}
@@ -0,0 +1,230 @@
+package merchant
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+// Plaintext keys have the form "gw_<key id>_<secret>".
+const keyPrefix = "gw_"
+
+type Service struct {
+	mutex       sync.RWMutex
+	merchants   map[string]*domain.Merchant
+	keys        map[string]*domain.APIKey
+	rotateGrace time.Duration
+	now         func() time.Time
+}
+
+func NewService(cfg *config.Config) (*Service, error) {
+	s := &Service{
+		merchants:   make(map[string]*domain.Merchant),
+		keys:        make(map[string]*domain.APIKey),
+		rotateGrace: cfg.GetAPIKeyRotationGrace(),
+		now:         time.Now,
+	}
+
+	for _, m := range cfg.Merchants {
+		merchant := &domain.Merchant{ID: m.ID, Name: m.Name, CreatedAt: s.now()}
+		s.merchants[merchant.ID] = merchant
+		for _, k := range m.APIKeys {
+			if k.ID == "" || k.Hash == "" {
+				return nil, fmt.Errorf("merchant %s: api keys require an id and a hash", m.ID)
+			}
+			// Keys are found by ID alone, one could authenticate as another merchant
+			if existing, exists := s.keys[k.ID]; exists {
+				return nil, fmt.Errorf("merchant %s: api key id %s is already used by merchant %s", m.ID, k.ID, existing.MerchantID)
+			}
+			s.keys[k.ID] = &domain.APIKey{
+				ID:         k.ID,
+				MerchantID: merchant.ID,
+				Hash:       strings.ToLower(k.Hash),
+				CreatedAt:  merchant.CreatedAt,
+			}
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Service) CreateMerchant(id, name string) (*domain.Merchant, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.merchants[id]; exists {
+		return nil, fmt.Errorf("merchant already exists: %s", id)
+	}
+	merchant := &domain.Merchant{ID: id, Name: name, CreatedAt: s.now()}
+	s.merchants[id] = merchant
+	return merchant, nil
+}
+
+func (s *Service) GetMerchant(id string) (*domain.Merchant, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	merchant, exists := s.merchants[id]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", domain.ErrMerchantNotFound, id)
+	}
+	return merchant, nil
+}
+
+// CreateAPIKey issues a new key for the merchant. The plaintext key is only
+// returned here; afterwards only its hash is kept.
+func (s *Service) CreateAPIKey(merchantID string) (*domain.APIKey, string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.merchants[merchantID]; !exists {
+		return nil, "", fmt.Errorf("%w: %s", domain.ErrMerchantNotFound, merchantID)
+	}
+	return s.createKey(merchantID)
+}
+
+// RotateAPIKey issues a replacement key and lets the old one keep working
+// for the configured grace period so clients can roll over.
+func (s *Service) RotateAPIKey(merchantID, keyID string) (*domain.APIKey, string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old, err := s.merchantKey(merchantID, keyID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !old.Active(s.now()) {
+		return nil, "", fmt.Errorf("%w: key %s is no longer active", domain.ErrInvalidAPIKey, keyID)
+	}
+
+	key, plaintext, err := s.createKey(merchantID)
+	if err != nil {
+		return nil, "", err
+	}
+	expiresAt := s.now().Add(s.rotateGrace)
+	if old.ExpiresAt == nil || expiresAt.Before(*old.ExpiresAt) {
+		old.ExpiresAt = &expiresAt
+	}
+	return key, plaintext, nil
+}
+
+func (s *Service) RevokeAPIKey(merchantID, keyID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, err := s.merchantKey(merchantID, keyID)
+	if err != nil {
+		return err
+	}
+	if key.RevokedAt == nil {
+		now := s.now()
+		key.RevokedAt = &now
+	}
+	return nil
+}
+
+func (s *Service) ListAPIKeys(merchantID string) ([]*domain.APIKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, exists := s.merchants[merchantID]; !exists {
+		return nil, fmt.Errorf("%w: %s", domain.ErrMerchantNotFound, merchantID)
+	}
+	keys := make([]*domain.APIKey, 0)
+	for _, key := range s.keys {
+		if key.MerchantID == merchantID {
+			copied := *key
+			keys = append(keys, &copied)
+		}
+	}
+	return keys, nil
+}
+
+// Authenticate resolves a plaintext key to its active API key record.
+func (s *Service) Authenticate(plaintext string) (*domain.APIKey, error) {
+	keyID, ok := parseKeyID(plaintext)
+	if !ok {
+		return nil, domain.ErrInvalidAPIKey
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	key, exists := s.keys[keyID]
+	if !exists {
+		return nil, domain.ErrInvalidAPIKey
+	}
+	if subtle.ConstantTimeCompare([]byte(HashKey(plaintext)), []byte(key.Hash)) != 1 {
+		return nil, domain.ErrInvalidAPIKey
+	}
+	if !key.Active(s.now()) {
+		return nil, domain.ErrInvalidAPIKey
+	}
+	copied := *key
+	return &copied, nil
+}
+
+// HashKey returns the hex encoded SHA-256 of a plaintext key, which is the
+// value stored in config.toml for bootstrapped keys.
+func HashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Service) createKey(merchantID string) (*domain.APIKey, string, error) {
+	keyID, err := randomHex(8)
+	if err != nil {
+		return nil, "", fmt.Errorf("error generating key id: %w", err)
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, "", fmt.Errorf("error generating key secret: %w", err)
+	}
+
+	plaintext := keyPrefix + keyID + "_" + secret
+	key := &domain.APIKey{
+		ID:         keyID,
+		MerchantID: merchantID,
+		Hash:       HashKey(plaintext),
+		CreatedAt:  s.now(),
+	}
+	s.keys[keyID] = key
+	copied := *key
+	return &copied, plaintext, nil
+}
+
+func (s *Service) merchantKey(merchantID, keyID string) (*domain.APIKey, error) {
+	key, exists := s.keys[keyID]
+	if !exists || key.MerchantID != merchantID {
+		return nil, fmt.Errorf("%w: %s", domain.ErrAPIKeyNotFound, keyID)
+	}
+	return key, nil
+}
+
+func parseKeyID(plaintext string) (string, bool) {
+	rest, found := strings.CutPrefix(plaintext, keyPrefix)
+	if !found {
+		return "", false
+	}
+	keyID, secret, found := strings.Cut(rest, "_")
+	if !found || keyID == "" || secret == "" {
+		return "", false
+	}
+	return keyID, true
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
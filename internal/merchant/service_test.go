@@ -0,0 +1,97 @@
+package merchant
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+func getTestConfig() *config.Config {
+	return &config.Config{
+		Auth: config.AuthConfig{RotationGraceSeconds: 60},
+		Merchants: []config.MerchantConfig{{
+			ID:   "merchant-demo",
+			Name: "Demo Merchant",
+			APIKeys: []config.APIKeyConfig{{
+				ID:   "demo",
+				Hash: HashKey("gw_demo_secret"),
+			}},
+		}},
+	}
+}
+
+func TestMerchantService(t *testing.T) {
+	t.Run("authenticate bootstrapped key", func(t *testing.T) {
+		service, err := NewService(getTestConfig())
+		assert.NoError(t, err)
+
+		key, err := service.Authenticate("gw_demo_secret")
+		assert.NoError(t, err)
+		assert.Equal(t, "merchant-demo", key.MerchantID)
+
+		_, err = service.Authenticate("gw_demo_wrong")
+		assert.ErrorIs(t, err, domain.ErrInvalidAPIKey)
+
+		_, err = service.Authenticate("not-a-key")
+		assert.ErrorIs(t, err, domain.ErrInvalidAPIKey)
+	})
+
+	t.Run("duplicate key ids are rejected", func(t *testing.T) {
+		cfg := getTestConfig()
+		cfg.Merchants = append(cfg.Merchants, config.MerchantConfig{
+			ID:      "merchant-other",
+			APIKeys: []config.APIKeyConfig{{ID: "demo", Hash: HashKey("gw_demo_other")}},
+		})
+
+		_, err := NewService(cfg)
+		assert.ErrorContains(t, err, "demo")
+	})
+
+	t.Run("rotate keeps old key during grace period", func(t *testing.T) {
+		service, _ := NewService(getTestConfig())
+		now := time.Now()
+		service.now = func() time.Time { return now }
+
+		newKey, plaintext, err := service.RotateAPIKey("merchant-demo", "demo")
+		assert.NoError(t, err)
+		assert.NotEqual(t, "demo", newKey.ID)
+		assert.NotContains(t, newKey.Hash, plaintext)
+
+		_, err = service.Authenticate("gw_demo_secret")
+		assert.NoError(t, err)
+		_, err = service.Authenticate(plaintext)
+		assert.NoError(t, err)
+
+		now = now.Add(2 * time.Minute)
+		_, err = service.Authenticate("gw_demo_secret")
+		assert.ErrorIs(t, err, domain.ErrInvalidAPIKey)
+		_, err = service.Authenticate(plaintext)
+		assert.NoError(t, err)
+	})
+
+	t.Run("revoke key", func(t *testing.T) {
+		service, _ := NewService(getTestConfig())
+
+		err := service.RevokeAPIKey("merchant-demo", "demo")
+		assert.NoError(t, err)
+
+		_, err = service.Authenticate("gw_demo_secret")
+		assert.ErrorIs(t, err, domain.ErrInvalidAPIKey)
+	})
+
+	t.Run("cannot manage keys of another merchant", func(t *testing.T) {
+		service, _ := NewService(getTestConfig())
+		_, err := service.CreateMerchant("merchant-other", "Other")
+		assert.NoError(t, err)
+
+		err = service.RevokeAPIKey("merchant-other", "demo")
+		assert.ErrorIs(t, err, domain.ErrAPIKeyNotFound)
+
+		_, _, err = service.RotateAPIKey("merchant-other", "demo")
+		assert.ErrorIs(t, err, domain.ErrAPIKeyNotFound)
+	})
+}
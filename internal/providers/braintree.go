@@ -0,0 +1,213 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"desafio-api/internal/domain"
+)
+
+// BraintreeEnvelope wraps every request and response of a Braintree-like
+// API in a "transaction" object. Amounts are decimal strings.
+type BraintreeEnvelope struct {
+	Transaction BraintreeTransaction `json:"transaction"`
+}
+
+type BraintreeTransaction struct {
+	ID               string                     `json:"id,omitempty"`
+	Type             string                     `json:"type,omitempty"`
+	Status           string                     `json:"status,omitempty"`
+	Amount           string                     `json:"amount,omitempty"`
+	RefundedAmount   string                     `json:"refundedAmount,omitempty"`
+	CurrencyIsoCode  string                     `json:"currencyIsoCode,omitempty"`
+	OrderID          string                     `json:"orderId,omitempty"`
+	CreatedAt        string                     `json:"createdAt,omitempty"`
+	CustomFields     map[string]string          `json:"customFields,omitempty"`
+	CreditCard       *BraintreeCreditCard       `json:"creditCard,omitempty"`
+	Installments     *BraintreeInstallments     `json:"installments,omitempty"`
+	ThreeDSecureInfo *BraintreeThreeDSecureInfo `json:"threeDSecureInfo,omitempty"`
+}
+
+// BraintreeSearchResult is the response of the transaction search.
+type BraintreeSearchResult struct {
+	Transactions []BraintreeTransaction `json:"transactions"`
+}
+
+type BraintreeCreditCard struct {
+	Number         string `json:"number,omitempty"`
+	CardholderName string `json:"cardholderName,omitempty"`
+	CVV            string `json:"cvv,omitempty"`
+	ExpirationDate string `json:"expirationDate,omitempty"`
+	Token          string `json:"token,omitempty"`
+}
+
+type BraintreeInstallments struct {
+	Count int `json:"count"`
+}
+
+type BraintreeThreeDSecureInfo struct {
+	Status string            `json:"status,omitempty"`
+	AcsURL string            `json:"acsUrl,omitempty"`
+	Lookup map[string]string `json:"lookup,omitempty"`
+	Result string            `json:"result,omitempty"`
+}
+
+// Braintree-like transaction statuses
+const (
+	BraintreeAuthorized             = "authorized"
+	BraintreeProcessorDeclined      = "processor_declined"
+	BraintreeFailed                 = "failed"
+	BraintreeAuthenticationRequired = "authentication_required"
+	BraintreeVoided                 = "voided"
+)
+
+func formatDecimal(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+func BraintreeRequestTransformer(request domain.PaymentRequest) (interface{}, error) {
+	transaction := BraintreeTransaction{
+		Type:            "sale",
+		Amount:          formatDecimal(request.Amount),
+		CurrencyIsoCode: request.Currency,
+		OrderID:         request.Reference,
+		CreditCard: &BraintreeCreditCard{
+			Number:         request.Card.Number,
+			CardholderName: request.Card.HolderName,
+			CVV:            request.Card.CVV,
+			ExpirationDate: request.Card.ExpirationDate,
+		},
+	}
+	if request.Description != "" {
+		transaction.CustomFields = map[string]string{"description": request.Description}
+	}
+	if request.Card.Installments > 0 {
+		transaction.Installments = &BraintreeInstallments{Count: request.Card.Installments}
+	}
+	return BraintreeEnvelope{Transaction: transaction}, nil
+}
+
+// BraintreeCreditTransformer creates a transaction of type "credit", which
+// Braintree-like APIs use to send money to a card.
+func BraintreeCreditTransformer(request domain.CreditRequest) (interface{}, error) {
+	transaction := BraintreeTransaction{
+		Type:            "credit",
+		Amount:          formatDecimal(request.Amount),
+		CurrencyIsoCode: request.Currency,
+		OrderID:         request.Reference,
+		CreditCard: &BraintreeCreditCard{
+			Number:         request.Card.Number,
+			CardholderName: request.Card.HolderName,
+			ExpirationDate: request.Card.ExpirationDate,
+		},
+	}
+	if request.Description != "" {
+		transaction.CustomFields = map[string]string{"description": request.Description}
+	}
+	return BraintreeEnvelope{Transaction: transaction}, nil
+}
+
+// BraintreeRefundTransformer leaves the amount out of full refunds, which
+// Braintree refunds in whole.
+func BraintreeRefundTransformer(request domain.RefundRequest) (interface{}, error) {
+	var amount string
+	if request.Amount > 0 {
+		amount = formatDecimal(request.Amount)
+	}
+	return BraintreeEnvelope{Transaction: BraintreeTransaction{Amount: amount}}, nil
+}
+
+func BraintreeConfirmTransformer(request domain.ConfirmRequest) (interface{}, error) {
+	return BraintreeEnvelope{Transaction: BraintreeTransaction{
+		ThreeDSecureInfo: &BraintreeThreeDSecureInfo{Result: request.AuthenticationResult},
+	}}, nil
+}
+
+func BraintreeResponseTransformer(provider *Provider) func([]byte) (*domain.Payment, error) {
+	return func(data []byte) (*domain.Payment, error) {
+		var envelope BraintreeEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return nil, fmt.Errorf("error unmarshaling response: %w", err)
+		}
+		return braintreePayment(envelope.Transaction)
+	}
+}
+
+// BraintreeLookupTransformer takes the first transaction of a search result.
+func BraintreeLookupTransformer(provider *Provider) func([]byte) (*domain.Payment, error) {
+	return func(data []byte) (*domain.Payment, error) {
+		var result BraintreeSearchResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("error unmarshaling response: %w", err)
+		}
+		if len(result.Transactions) == 0 {
+			return nil, domain.ErrPaymentNotFound
+		}
+		return braintreePayment(result.Transactions[0])
+	}
+}
+
+func braintreePayment(transaction BraintreeTransaction) (*domain.Payment, error) {
+	if transaction.ID == "" {
+		return nil, fmt.Errorf("response has no transaction id")
+	}
+
+	amount, err := strconv.ParseFloat(transaction.Amount, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount %q: %w", transaction.Amount, err)
+	}
+	refunded := 0.0
+	if transaction.RefundedAmount != "" {
+		if refunded, err = strconv.ParseFloat(transaction.RefundedAmount, 64); err != nil {
+			return nil, fmt.Errorf("invalid refunded amount %q: %w", transaction.RefundedAmount, err)
+		}
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, transaction.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid createdAt %q: %w", transaction.CreatedAt, err)
+	}
+
+	var status domain.PaymentStatus
+	switch transaction.Status {
+	case BraintreeAuthorized, "submitted_for_settlement", "settled":
+		status = domain.StatusAuthorized
+	case BraintreeAuthenticationRequired:
+		status = domain.StatusRequiresAction
+	case BraintreeVoided:
+		status = domain.StatusRefunded
+	default:
+		status = domain.StatusFailed
+	}
+
+	payment := &domain.Payment{
+		ID:             transaction.ID,
+		CreatedAt:      createdAt,
+		Status:         status,
+		OriginalAmount: amount,
+		CurrentAmount:  amount - refunded,
+		Currency:       strings.ToUpper(transaction.CurrencyIsoCode),
+		Description:    transaction.CustomFields["description"],
+		PaymentMethod:  "card",
+	}
+	if transaction.CreditCard != nil {
+		payment.CardID = transaction.CreditCard.Token
+	}
+	if info := transaction.ThreeDSecureInfo; info != nil {
+		switch info.Status {
+		case "challenge_required":
+			payment.NextAction = &domain.NextAction{
+				Type:        domain.NextActionRedirect,
+				RedirectURL: info.AcsURL,
+				Data:        info.Lookup,
+			}
+		case "authenticate_frictionless_successful":
+			payment.Authentication = "frictionless"
+		case "authenticate_successful":
+			payment.Authentication = "challenge"
+		}
+	}
+	return payment, nil
+}
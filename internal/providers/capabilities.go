@@ -0,0 +1,53 @@
+package providers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+var knownBrands = []string{
+	domain.BrandVisa,
+	domain.BrandMastercard,
+	domain.BrandAmex,
+	domain.BrandElo,
+	domain.BrandHipercard,
+	domain.BrandDiners,
+	domain.BrandDiscover,
+	domain.BrandJCB,
+}
+
+// CapabilitiesFromConfig validates the capabilities declared for a provider.
+func CapabilitiesFromConfig(cfg config.CapabilitiesConfig) (domain.Capabilities, error) {
+	capabilities := domain.Capabilities{
+		SettlementCurrency: strings.ToUpper(cfg.SettlementCurrency),
+		MaxInstallments:    cfg.MaxInstallments,
+		MinAmount:          cfg.MinAmount,
+		MaxAmount:          cfg.MaxAmount,
+	}
+	for _, operation := range cfg.Operations {
+		switch op := domain.OperationType(operation); op {
+		case domain.OperationCharge, domain.OperationRefund, domain.OperationPartialRefund, domain.OperationConfirm, domain.OperationCredit:
+			capabilities.Operations = append(capabilities.Operations, op)
+		default:
+			return domain.Capabilities{}, fmt.Errorf("unknown operation %q", operation)
+		}
+	}
+	for _, currency := range cfg.Currencies {
+		capabilities.Currencies = append(capabilities.Currencies, strings.ToUpper(currency))
+	}
+	for _, brand := range cfg.Brands {
+		brand = strings.ToLower(brand)
+		if !slices.Contains(knownBrands, brand) {
+			return domain.Capabilities{}, fmt.Errorf("unknown card brand %q", brand)
+		}
+		capabilities.Brands = append(capabilities.Brands, brand)
+	}
+	if cfg.MaxAmount > 0 && cfg.MinAmount > cfg.MaxAmount {
+		return domain.Capabilities{}, fmt.Errorf("min_amount %.2f is above max_amount %.2f", cfg.MinAmount, cfg.MaxAmount)
+	}
+	return capabilities, nil
+}
@@ -0,0 +1,59 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+func TestCapabilitiesFromConfig(t *testing.T) {
+	capabilities, err := CapabilitiesFromConfig(config.CapabilitiesConfig{
+		Operations:         []string{"charge", "refund"},
+		Currencies:         []string{"brl"},
+		SettlementCurrency: "brl",
+		Brands:             []string{"Visa", "elo"},
+		MaxInstallments:    6,
+		MaxAmount:          1000,
+	})
+	require.NoError(t, err)
+	assert.True(t, capabilities.Supports(domain.OperationRefund))
+	assert.False(t, capabilities.Supports(domain.OperationPartialRefund))
+	assert.True(t, capabilities.ChargesCurrency("BRL"))
+	assert.False(t, capabilities.ChargesCurrency("USD"))
+
+	card := domain.Card{Number: "4111 1111 1111 1111"}
+	assert.NoError(t, capabilities.CheckCharge(domain.PaymentRequest{Amount: 100, Currency: "BRL", Card: card}))
+	card.Installments = 12
+	assert.Error(t, capabilities.CheckCharge(domain.PaymentRequest{Amount: 100, Currency: "BRL", Card: card}))
+	assert.Error(t, capabilities.CheckCharge(domain.PaymentRequest{Amount: 2000, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}}))
+	assert.Error(t, capabilities.CheckCharge(domain.PaymentRequest{Amount: 100, Currency: "BRL", Card: domain.Card{Number: "5555555555554444"}}))
+
+	_, err = CapabilitiesFromConfig(config.CapabilitiesConfig{Operations: []string{"payout"}})
+	assert.Error(t, err)
+	_, err = CapabilitiesFromConfig(config.CapabilitiesConfig{Brands: []string{"maestro"}})
+	assert.Error(t, err)
+}
+
+func TestCardBrand(t *testing.T) {
+	brands := map[string]string{
+		"4111111111111111": domain.BrandVisa,
+		"5555555555554444": domain.BrandMastercard,
+		"2223000048400011": domain.BrandMastercard,
+		"378282246310005":  domain.BrandAmex,
+		"6362970000457013": domain.BrandElo,
+		"4514160123456789": domain.BrandElo,
+		"6062825624254001": domain.BrandHipercard,
+		"3841001111222233": domain.BrandHipercard,
+		"36227206271667":   domain.BrandDiners,
+		"6011111111111117": domain.BrandDiscover,
+		"3530111333300000": domain.BrandJCB,
+		"123456789":        "",
+	}
+	for number, brand := range brands {
+		assert.Equal(t, brand, domain.Card{Number: number}.Brand(), number)
+	}
+}
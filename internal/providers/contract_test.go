@@ -0,0 +1,260 @@
+package providers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+	"desafio-api/internal/providers"
+	"desafio-api/mock"
+)
+
+// The contract tests run every transformer pair against the mock speaking
+// the same wire format, so both sides of the format stay in sync.
+func TestProviderContract(t *testing.T) {
+	cfg := &config.Config{HTTP: config.HTTPConfig{TimeoutSeconds: 5}}
+
+	for _, format := range []string{providers.FormatStandard, providers.FormatStripe, providers.FormatBraintree} {
+		t.Run(format, func(t *testing.T) {
+			server, err := mock.NewMockServerWithFormat(format)
+			require.NoError(t, err)
+			httpServer := httptest.NewServer(server.Handler())
+			defer httpServer.Close()
+
+			providerConfig, err := providers.ConfigForFormat(format)
+			require.NoError(t, err)
+			providerConfig.Name = format
+			providerConfig.BaseURL = httpServer.URL
+			provider := providers.NewProvider(format, providerConfig, cfg)
+
+			newRequest := func(cardNumber string) domain.PaymentRequest {
+				return domain.PaymentRequest{
+					Amount:      123.45,
+					Currency:    "BRL",
+					Description: gofakeit.Sentence(3),
+					Card: domain.Card{
+						Number:         cardNumber,
+						HolderName:     gofakeit.Name(),
+						CVV:            "123",
+						ExpirationDate: "12/2030",
+						Installments:   1,
+					},
+				}
+			}
+
+			t.Run("charge, get and refund", func(t *testing.T) {
+				request := newRequest("4111111111111111")
+				payment, err := provider.ProcessPayment(request)
+				require.NoError(t, err)
+				assert.NotEmpty(t, payment.ID)
+				assert.Equal(t, domain.StatusAuthorized, payment.Status)
+				assert.Equal(t, 123.45, payment.OriginalAmount)
+				assert.Equal(t, 123.45, payment.CurrentAmount)
+				assert.Equal(t, "BRL", payment.Currency)
+				assert.Equal(t, request.Description, payment.Description)
+				assert.False(t, payment.CreatedAt.IsZero())
+
+				fetched, err := provider.GetPayment(payment.ID)
+				require.NoError(t, err)
+				assert.Equal(t, payment.ID, fetched.ID)
+				assert.Equal(t, domain.StatusAuthorized, fetched.Status)
+
+				refunded, err := provider.RefundPayment(payment.ID, domain.RefundRequest{Amount: 23.45})
+				require.NoError(t, err)
+				assert.Equal(t, domain.StatusRefunded, refunded.Status)
+				assert.InDelta(t, 100.0, refunded.CurrentAmount, 0.001)
+			})
+
+			t.Run("refund without an amount", func(t *testing.T) {
+				payment, err := provider.ProcessPayment(newRequest("4111111111111111"))
+				require.NoError(t, err)
+
+				refunded, err := provider.RefundPayment(payment.ID, domain.RefundRequest{})
+				require.NoError(t, err)
+				assert.Equal(t, domain.StatusRefunded, refunded.Status)
+				assert.InDelta(t, 0.0, refunded.CurrentAmount, 0.001)
+			})
+
+			t.Run("credit", func(t *testing.T) {
+				request := newRequest("4111111111111111")
+				credit := domain.CreditRequest{Amount: 50, Currency: "BRL", Reference: gofakeit.UUID(), Card: request.Card}
+				if !provider.SupportsCredits() {
+					_, err := provider.Credit(credit)
+					assert.Error(t, err)
+					return
+				}
+
+				payment, err := provider.Credit(credit)
+				require.NoError(t, err)
+				assert.NotEmpty(t, payment.ID)
+				assert.Equal(t, domain.StatusAuthorized, payment.Status)
+				assert.Equal(t, 50.0, payment.OriginalAmount)
+
+				again, err := provider.Credit(credit)
+				require.NoError(t, err)
+				assert.Equal(t, payment.ID, again.ID)
+
+				credit.Reference = gofakeit.UUID()
+				credit.Card.Number = mock.CardDeclined
+				declined, err := provider.Credit(credit)
+				require.NoError(t, err)
+				assert.Equal(t, domain.StatusFailed, declined.Status)
+			})
+
+			t.Run("declined card", func(t *testing.T) {
+				payment, err := provider.ProcessPayment(newRequest(mock.CardDeclined))
+				require.NoError(t, err)
+				assert.Equal(t, domain.StatusFailed, payment.Status)
+			})
+
+			t.Run("3-D Secure challenge and confirmation", func(t *testing.T) {
+				payment, err := provider.ProcessPayment(newRequest(mock.CardThreeDSChallenge))
+				require.NoError(t, err)
+				assert.Equal(t, domain.StatusRequiresAction, payment.Status)
+				require.NotNil(t, payment.NextAction)
+				assert.Equal(t, domain.NextActionRedirect, payment.NextAction.Type)
+				assert.Contains(t, payment.NextAction.RedirectURL, "/3ds/"+payment.ID)
+
+				confirmed, err := provider.ConfirmPayment(payment.ID, domain.ConfirmRequest{AuthenticationResult: "ok"})
+				require.NoError(t, err)
+				assert.Equal(t, domain.StatusAuthorized, confirmed.Status)
+				assert.Equal(t, "challenge", confirmed.Authentication)
+				assert.Nil(t, confirmed.NextAction)
+			})
+
+			t.Run("frictionless 3-D Secure", func(t *testing.T) {
+				payment, err := provider.ProcessPayment(newRequest(mock.CardThreeDSFrictionless))
+				require.NoError(t, err)
+				assert.Equal(t, domain.StatusAuthorized, payment.Status)
+				assert.Equal(t, "frictionless", payment.Authentication)
+			})
+
+			t.Run("find charge by reference", func(t *testing.T) {
+				request := newRequest("4111111111111111")
+				request.Reference = gofakeit.UUID()
+				payment, err := provider.ProcessPayment(request)
+				require.NoError(t, err)
+
+				found, err := provider.FindPaymentByReference(request.Reference)
+				require.NoError(t, err)
+				assert.Equal(t, payment.ID, found.ID)
+				assert.Equal(t, domain.StatusAuthorized, found.Status)
+
+				_, err = provider.FindPaymentByReference(gofakeit.UUID())
+				assert.ErrorIs(t, err, domain.ErrPaymentNotFound)
+			})
+
+			t.Run("lost response has an unknown outcome", func(t *testing.T) {
+				require.NoError(t, server.AddRule(mock.Rule{Name: "lost", Endpoint: "/charges", Method: http.MethodPost, LoseResponse: true, FailNext: 1}))
+				slowCfg := &config.Config{HTTP: config.HTTPConfig{TimeoutSeconds: 1}}
+				impatient := providers.NewProvider(format, providerConfig, slowCfg)
+
+				request := newRequest("4111111111111111")
+				request.Reference = gofakeit.UUID()
+				_, err := impatient.ProcessPayment(request)
+				assert.ErrorIs(t, err, domain.ErrOutcomeUnknown)
+
+				found, err := provider.FindPaymentByReference(request.Reference)
+				require.NoError(t, err)
+				assert.Equal(t, domain.StatusAuthorized, found.Status)
+
+				again, err := provider.ProcessPayment(request)
+				require.NoError(t, err)
+				assert.Equal(t, found.ID, again.ID)
+			})
+
+			t.Run("unparseable answer to a charge has an unknown outcome", func(t *testing.T) {
+				require.NoError(t, server.AddRule(mock.Rule{Name: "garbled", Endpoint: "/charges", Method: http.MethodPost, MalformedJSON: true, FailNext: 1}))
+
+				_, err := provider.ProcessPayment(newRequest("4111111111111111"))
+				assert.ErrorIs(t, err, domain.ErrOutcomeUnknown)
+
+				var providerErr *domain.ProviderError
+				require.ErrorAs(t, err, &providerErr)
+				assert.Equal(t, domain.ErrorClassInvalidResponse, providerErr.Class)
+			})
+
+			t.Run("health check", func(t *testing.T) {
+				assert.NoError(t, provider.HealthCheck())
+
+				require.NoError(t, server.AddRule(mock.Rule{Name: "down", Endpoint: "/health", StatusCode: http.StatusServiceUnavailable}))
+				assert.Error(t, provider.HealthCheck())
+				server.ResetScenario()
+			})
+
+			t.Run("server error", func(t *testing.T) {
+				require.NoError(t, server.AddRule(mock.Rule{Name: "unavailable", Endpoint: "/charges", Method: http.MethodPost, StatusCode: http.StatusServiceUnavailable, RetryAfterSeconds: 2, FailNext: 1}))
+
+				_, err := provider.ProcessPayment(newRequest("4111111111111111"))
+
+				var providerErr *domain.ProviderError
+				require.ErrorAs(t, err, &providerErr)
+				assert.Equal(t, http.StatusServiceUnavailable, providerErr.HTTPStatus)
+				assert.Equal(t, domain.ErrorClassServerError, providerErr.Class)
+				assert.NotEmpty(t, providerErr.Response)
+				assert.Equal(t, 2*time.Second, providerErr.RetryAfter)
+			})
+
+			t.Run("unknown payment", func(t *testing.T) {
+				_, err := provider.GetPayment("non-existent")
+				assert.ErrorIs(t, err, domain.ErrPaymentNotFound)
+
+				var providerErr *domain.ProviderError
+				require.ErrorAs(t, err, &providerErr)
+				assert.Equal(t, http.StatusNotFound, providerErr.HTTPStatus)
+				assert.Equal(t, domain.ErrorClassNotFound, providerErr.Class)
+			})
+		})
+	}
+}
+
+func TestProviderLostConnection(t *testing.T) {
+	cfg := &config.Config{HTTP: config.HTTPConfig{TimeoutSeconds: 5}}
+	providerConfig, err := providers.ConfigForFormat(providers.FormatStandard)
+	require.NoError(t, err)
+	providerConfig.Name = providers.FormatStandard
+	request := domain.PaymentRequest{
+		Amount:   10,
+		Currency: "BRL",
+		Card:     domain.Card{Number: "4111111111111111", HolderName: "Ana", CVV: "123", ExpirationDate: "12/2030", Installments: 1},
+	}
+
+	t.Run("connection dropped after the charge was sent", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			require.NoError(t, err)
+			conn.Close()
+		}))
+		defer server.Close()
+		providerConfig.BaseURL = server.URL
+		provider := providers.NewProvider("standard", providerConfig, cfg)
+
+		_, err := provider.ProcessPayment(request)
+		assert.ErrorIs(t, err, domain.ErrOutcomeUnknown)
+
+		_, err = provider.GetPayment("any")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, domain.ErrOutcomeUnknown)
+	})
+
+	t.Run("connection refused before the charge was sent", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		providerConfig.BaseURL = server.URL
+		server.Close()
+		provider := providers.NewProvider("standard", providerConfig, cfg)
+
+		_, err := provider.ProcessPayment(request)
+		assert.NotErrorIs(t, err, domain.ErrOutcomeUnknown)
+
+		var providerErr *domain.ProviderError
+		require.ErrorAs(t, err, &providerErr)
+		assert.Equal(t, domain.ErrorClassNetwork, providerErr.Class)
+	})
+}
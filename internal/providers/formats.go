@@ -0,0 +1,74 @@
+package providers
+
+import (
+	"fmt"
+	"math"
+)
+
+// Wire formats understood by the transformers and by the mock providers.
+const (
+	FormatStandard  = "standard"
+	FormatStripe    = "stripe"
+	FormatBraintree = "braintree"
+)
+
+// ConfigForFormat returns the endpoints and transformers of a wire format.
+// Name and BaseURL are left for the caller to fill in.
+func ConfigForFormat(format string) (ProviderConfig, error) {
+	switch format {
+	case FormatStandard, "":
+		return ProviderConfig{
+			ChargeEndpoint:      "/charges",
+			RefundEndpoint:      "/refund/{id}",
+			ConfirmEndpoint:     "/charges/{id}/confirm",
+			GetChargeEndpoint:   "/charges/{id}",
+			FindChargeEndpoint:  "/charges?reference={reference}",
+			HealthEndpoint:      "/health",
+			CreditEndpoint:      "/credits",
+			RequestTransformer:  StandardRequestTransformer,
+			CreditTransformer:   StandardCreditTransformer,
+			ResponseTransformer: StandardResponseTransformer,
+		}, nil
+	case FormatStripe:
+		return ProviderConfig{
+			ChargeEndpoint:      "/v1/charges",
+			RefundEndpoint:      "/v1/charges/{id}/refund",
+			ConfirmEndpoint:     "/v1/charges/{id}/confirm",
+			GetChargeEndpoint:   "/v1/charges/{id}",
+			FindChargeEndpoint:  "/v1/charges?reference={reference}",
+			HealthEndpoint:      "/health",
+			RequestTransformer:  StripeRequestTransformer,
+			RefundTransformer:   StripeRefundTransformer,
+			ConfirmTransformer:  StripeConfirmTransformer,
+			ResponseTransformer: StripeResponseTransformer,
+			LookupTransformer:   StripeLookupTransformer,
+		}, nil
+	case FormatBraintree:
+		return ProviderConfig{
+			ChargeEndpoint:      "/transactions",
+			RefundEndpoint:      "/transactions/{id}/refund",
+			ConfirmEndpoint:     "/transactions/{id}/confirm",
+			GetChargeEndpoint:   "/transactions/{id}",
+			FindChargeEndpoint:  "/transactions?orderId={reference}",
+			HealthEndpoint:      "/health",
+			CreditEndpoint:      "/transactions",
+			RequestTransformer:  BraintreeRequestTransformer,
+			RefundTransformer:   BraintreeRefundTransformer,
+			ConfirmTransformer:  BraintreeConfirmTransformer,
+			CreditTransformer:   BraintreeCreditTransformer,
+			ResponseTransformer: BraintreeResponseTransformer,
+			LookupTransformer:   BraintreeLookupTransformer,
+		}, nil
+	default:
+		return ProviderConfig{}, fmt.Errorf("unknown provider format: %s", format)
+	}
+}
+
+// ToMinorUnits converts an amount to cents, as used by Stripe-like APIs.
+func ToMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func FromMinorUnits(amount int64) float64 {
+	return float64(amount) / 100
+}
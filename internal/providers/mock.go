@@ -12,19 +12,23 @@ type MockPaymentRequest struct {
 	Amount      float64     `json:"amount"`
 	Currency    string      `json:"currency"`
 	Description string      `json:"description"`
+	Reference   string      `json:"reference,omitempty"`
 	Card        domain.Card `json:"card"`
 }
 
 type MockPaymentResponse struct {
-	ID             string    `json:"id"`
-	CreatedAt      time.Time `json:"createdAt"`
-	Status         string    `json:"status"`
-	OriginalAmount float64   `json:"originalAmount"`
-	CurrentAmount  float64   `json:"currentAmount"`
-	Currency       string    `json:"currency"`
-	Description    string    `json:"description"`
-	PaymentMethod  string    `json:"paymentMethod"`
-	CardID         string    `json:"cardId"`
+	ID             string             `json:"id"`
+	CreatedAt      time.Time          `json:"createdAt"`
+	Status         string             `json:"status"`
+	OriginalAmount float64            `json:"originalAmount"`
+	CurrentAmount  float64            `json:"currentAmount"`
+	Currency       string             `json:"currency"`
+	Description    string             `json:"description"`
+	Reference      string             `json:"reference,omitempty"`
+	PaymentMethod  string             `json:"paymentMethod"`
+	CardID         string             `json:"cardId"`
+	Authentication string             `json:"authentication,omitempty"`
+	NextAction     *domain.NextAction `json:"nextAction,omitempty"`
 }
 
 func StandardRequestTransformer(request domain.PaymentRequest) (interface{}, error) {
@@ -32,6 +36,18 @@ func StandardRequestTransformer(request domain.PaymentRequest) (interface{}, err
 		Amount:      request.Amount,
 		Currency:    request.Currency,
 		Description: request.Description,
+		Reference:   request.Reference,
+		Card:        request.Card,
+	}, nil
+}
+
+// StandardCreditTransformer sends credits in the shape of a charge.
+func StandardCreditTransformer(request domain.CreditRequest) (interface{}, error) {
+	return MockPaymentRequest{
+		Amount:      request.Amount,
+		Currency:    request.Currency,
+		Description: request.Description,
+		Reference:   request.Reference,
 		Card:        request.Card,
 	}, nil
 }
@@ -47,6 +63,8 @@ func StandardResponseTransformer(provider *Provider) func([]byte) (*domain.Payme
 		switch resp.Status {
 		case "authorized", "paid":
 			status = domain.StatusAuthorized
+		case "requires_action":
+			status = domain.StatusRequiresAction
 		case "refunded", "voided":
 			status = domain.StatusRefunded
 		default:
@@ -63,6 +81,8 @@ func StandardResponseTransformer(provider *Provider) func([]byte) (*domain.Payme
 			Description:    resp.Description,
 			PaymentMethod:  resp.PaymentMethod,
 			CardID:         resp.CardID,
+			Authentication: resp.Authentication,
+			NextAction:     resp.NextAction,
 		}
 		return payment, nil
 	}
@@ -3,22 +3,46 @@ package providers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"desafio-api/internal/config"
 	"desafio-api/internal/domain"
 )
 
+// ProviderConfig describes the API of a provider. RefundTransformer and
+// ConfirmTransformer are optional; without them the gateway request is sent
+// as is. Payloads of type url.Values are form encoded, anything else as JSON.
+// LookupTransformer parses the response of FindChargeEndpoint and defaults
+// to ResponseTransformer. Credits are only supported with a CreditEndpoint
+// and CreditTransformer; their responses go through ResponseTransformer.
+// Capabilities is set from the gateway config.
 type ProviderConfig struct {
 	Name                string
 	BaseURL             string
 	ChargeEndpoint      string
 	RefundEndpoint      string
+	ConfirmEndpoint     string
 	GetChargeEndpoint   string
+	FindChargeEndpoint  string
+	HealthEndpoint      string
+	CreditEndpoint      string
 	RequestTransformer  func(domain.PaymentRequest) (interface{}, error)
+	RefundTransformer   func(domain.RefundRequest) (interface{}, error)
+	ConfirmTransformer  func(domain.ConfirmRequest) (interface{}, error)
+	CreditTransformer   func(domain.CreditRequest) (interface{}, error)
 	ResponseTransformer func(*Provider) func([]byte) (*domain.Payment, error)
+	LookupTransformer   func(*Provider) func([]byte) (*domain.Payment, error)
+	Capabilities        domain.Capabilities
 }
 
 type Provider struct {
@@ -33,118 +57,255 @@ func NewProvider(id string, providerConfig ProviderConfig, cfg *config.Config) *
 		ID:         id,
 		Name:       providerConfig.Name,
 		config:     providerConfig,
-		httpClient: &http.Client{Timeout: cfg.GetHTTPTimeout()},
+		httpClient: &http.Client{Timeout: cfg.GetHTTPTimeout(), Transport: newTransport(cfg.GetTransport(id))},
 	}
 }
 
+// newTransport gives every provider its own connection pool, so a slow
+// provider can't hold the connections of the others.
+func newTransport(cfg config.TransportConfig) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	if cfg.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+	}
+	if cfg.IdleConnTimeoutSeconds > 0 {
+		transport.IdleConnTimeout = time.Duration(cfg.IdleConnTimeoutSeconds) * time.Second
+	}
+	if cfg.DialTimeoutMs > 0 {
+		dialer := &net.Dialer{Timeout: time.Duration(cfg.DialTimeoutMs) * time.Millisecond, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+	}
+	if cfg.TLSHandshakeTimeoutMs > 0 {
+		transport.TLSHandshakeTimeout = time.Duration(cfg.TLSHandshakeTimeoutMs) * time.Millisecond
+	}
+	if cfg.ResponseHeaderTimeoutMs > 0 {
+		transport.ResponseHeaderTimeout = time.Duration(cfg.ResponseHeaderTimeoutMs) * time.Millisecond
+	}
+	return transport
+}
+
 func (p *Provider) ProcessPayment(request domain.PaymentRequest) (*domain.Payment, error) {
 	payload, err := p.config.RequestTransformer(request)
 	if err != nil {
 		return nil, fmt.Errorf("[provider: %s] error transforming request: %w", p.Name, err)
 	}
+	return p.do(http.MethodPost, p.config.ChargeEndpoint, payload, true, p.config.ResponseTransformer)
+}
 
-	jsonData, err := json.Marshal(payload)
-	if err != nil {
-		return nil, fmt.Errorf("[provider: %s] error marshaling request: %w", p.Name, err)
+func (p *Provider) RefundPayment(paymentID string, request domain.RefundRequest) (*domain.Payment, error) {
+	var payload interface{} = request
+	if p.config.RefundTransformer != nil {
+		var err error
+		if payload, err = p.config.RefundTransformer(request); err != nil {
+			return nil, fmt.Errorf("[provider: %s] error transforming request: %w", p.Name, err)
+		}
 	}
+	return p.do(http.MethodPost, strings.ReplaceAll(p.config.RefundEndpoint, "{id}", paymentID), payload, true, p.config.ResponseTransformer)
+}
 
-	resp, err := p.httpClient.Post(
-		fmt.Sprintf("%s%s", p.config.BaseURL, p.config.ChargeEndpoint),
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("[provider: %s] error making request: %w", p.Name, err)
+func (p *Provider) ConfirmPayment(paymentID string, request domain.ConfirmRequest) (*domain.Payment, error) {
+	var payload interface{} = request
+	if p.config.ConfirmTransformer != nil {
+		var err error
+		if payload, err = p.config.ConfirmTransformer(request); err != nil {
+			return nil, fmt.Errorf("[provider: %s] error transforming request: %w", p.Name, err)
+		}
 	}
-	defer resp.Body.Close()
+	return p.do(http.MethodPost, strings.ReplaceAll(p.config.ConfirmEndpoint, "{id}", paymentID), payload, false, p.config.ResponseTransformer)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("[provider: %s] unexpected status code: %d", p.Name, resp.StatusCode)
-	}
+func (p *Provider) GetPayment(paymentID string) (*domain.Payment, error) {
+	return p.do(http.MethodGet, strings.ReplaceAll(p.config.GetChargeEndpoint, "{id}", paymentID), nil, false, p.config.ResponseTransformer)
+}
 
-	respBody, err := readBody(resp)
-	if err != nil {
-		return nil, fmt.Errorf("[provider: %s] error reading response body: %w", p.Name, err)
+func (p *Provider) FindPaymentByReference(reference string) (*domain.Payment, error) {
+	if p.config.FindChargeEndpoint == "" {
+		return nil, fmt.Errorf("[provider: %s] lookup by reference is not supported", p.Name)
+	}
+	transformer := p.config.LookupTransformer
+	if transformer == nil {
+		transformer = p.config.ResponseTransformer
 	}
+	endpoint := strings.ReplaceAll(p.config.FindChargeEndpoint, "{reference}", url.QueryEscape(reference))
+	return p.do(http.MethodGet, endpoint, nil, false, transformer)
+}
 
-	transformer := p.config.ResponseTransformer(p)
-	payment, err := transformer(respBody)
+func (p *Provider) Credit(request domain.CreditRequest) (*domain.Payment, error) {
+	if !p.SupportsCredits() {
+		return nil, fmt.Errorf("[provider: %s] credits are not supported", p.Name)
+	}
+	payload, err := p.config.CreditTransformer(request)
 	if err != nil {
-		return nil, fmt.Errorf("[provider: %s] error transforming response: %w", p.Name, err)
+		return nil, fmt.Errorf("[provider: %s] error transforming request: %w", p.Name, err)
 	}
+	return p.do(http.MethodPost, p.config.CreditEndpoint, payload, true, p.config.ResponseTransformer)
+}
 
-	return payment, nil
+// SupportsCredits reports whether the wire format of the provider has
+// credits, which its capabilities can only declare if so.
+func (p *Provider) SupportsCredits() bool {
+	return p.config.CreditEndpoint != "" && p.config.CreditTransformer != nil
 }
 
-func (p *Provider) RefundPayment(paymentID string, request domain.RefundRequest) (*domain.Payment, error) {
-	jsonData, err := json.Marshal(request)
-	if err != nil {
-		return nil, fmt.Errorf("[provider: %s] error marshaling request: %w", p.Name, err)
+// HealthCheck calls the provider's health endpoint, which must answer 200.
+func (p *Provider) HealthCheck() error {
+	if p.config.HealthEndpoint == "" {
+		return fmt.Errorf("[provider: %s] health endpoint not configured", p.Name)
 	}
-
-	endpoint := strings.ReplaceAll(p.config.RefundEndpoint, "{id}", paymentID)
-	resp, err := p.httpClient.Post(
-		fmt.Sprintf("%s%s", p.config.BaseURL, endpoint),
-		"application/json",
-		bytes.NewBuffer(jsonData),
-	)
+	resp, err := p.httpClient.Get(fmt.Sprintf("%s%s", p.config.BaseURL, p.config.HealthEndpoint))
 	if err != nil {
-		return nil, fmt.Errorf("[provider: %s] error making request: %w", p.Name, err)
+		return fmt.Errorf("[provider: %s] error making request: %w", p.Name, err)
 	}
 	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("[provider: %s] unexpected status code: %d", p.Name, resp.StatusCode)
+		return fmt.Errorf("[provider: %s] unexpected status code: %d", p.Name, resp.StatusCode)
 	}
+	return nil
+}
 
-	respBody, err := readBody(resp)
-	if err != nil {
-		return nil, fmt.Errorf("[provider: %s] error reading response body: %w", p.Name, err)
-	}
+func (p *Provider) GetID() string {
+	return p.ID
+}
 
-	transformer := p.config.ResponseTransformer(p)
-	payment, err := transformer(respBody)
-	if err != nil {
-		return nil, fmt.Errorf("[provider: %s] error transforming response: %w", p.Name, err)
-	}
+func (p *Provider) GetName() string {
+	return p.Name
+}
 
-	return payment, nil
+func (p *Provider) Capabilities() domain.Capabilities {
+	return p.config.Capabilities
 }
 
-func (p *Provider) GetPayment(paymentID string) (*domain.Payment, error) {
-	endpoint := strings.ReplaceAll(p.config.GetChargeEndpoint, "{id}", paymentID)
-	resp, err := p.httpClient.Get(fmt.Sprintf("%s%s", p.config.BaseURL, endpoint))
+// do sends payload to the endpoint and transforms the response into a payment.
+// Timeouts are reported as domain.ErrOutcomeUnknown, since the provider may
+// have processed the request, and 404s as domain.ErrPaymentNotFound. Errors
+// after the request was sent are *domain.ProviderError. For requests that
+// move money (charges, refunds and credits) any failure once the request was
+// written, such as a reset connection, a broken body or an unparseable 200,
+// is an unknown outcome too: only failures before it was sent are retryable.
+func (p *Provider) do(method, endpoint string, payload interface{}, movesMoney bool, responseTransformer func(*Provider) func([]byte) (*domain.Payment, error)) (*domain.Payment, error) {
+	var body io.Reader
+	contentType := ""
+	switch payload := payload.(type) {
+	case nil:
+	case url.Values:
+		body = strings.NewReader(payload.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("[provider: %s] error marshaling request: %w", p.Name, err)
+		}
+		body = bytes.NewBuffer(jsonData)
+		contentType = "application/json"
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", p.config.BaseURL, endpoint), body)
 	if err != nil {
-		return nil, fmt.Errorf("[provider: %s] error making request: %w", p.Name, err)
+		return nil, fmt.Errorf("[provider: %s] error creating request: %w", p.Name, err)
 	}
-	defer resp.Body.Close()
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	var sent atomic.Bool
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil {
+				sent.Store(true)
+			}
+		},
+	}))
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("[provider: %s] unexpected status code: %d", p.Name, resp.StatusCode)
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, &domain.ProviderError{
+				Class: domain.ErrorClassTimeout,
+				Err:   fmt.Errorf("[provider: %s] %w: %v", p.Name, domain.ErrOutcomeUnknown, err),
+			}
+		}
+		if movesMoney && sent.Load() {
+			return nil, &domain.ProviderError{
+				Class: domain.ErrorClassNetwork,
+				Err:   fmt.Errorf("[provider: %s] %w: connection lost after the request was sent: %v", p.Name, domain.ErrOutcomeUnknown, err),
+			}
+		}
+		return nil, &domain.ProviderError{
+			Class: domain.ErrorClassNetwork,
+			Err:   fmt.Errorf("[provider: %s] error making request: %w", p.Name, err),
+		}
 	}
+	defer resp.Body.Close()
 
 	respBody, err := readBody(resp)
 	if err != nil {
-		return nil, fmt.Errorf("[provider: %s] error reading response body: %w", p.Name, err)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, &domain.ProviderError{
+				Class:      domain.ErrorClassTimeout,
+				HTTPStatus: resp.StatusCode,
+				Err:        fmt.Errorf("[provider: %s] %w: %v", p.Name, domain.ErrOutcomeUnknown, err),
+			}
+		}
+		if movesMoney && resp.StatusCode == http.StatusOK {
+			return nil, &domain.ProviderError{
+				Class:      domain.ErrorClassNetwork,
+				HTTPStatus: resp.StatusCode,
+				Err:        fmt.Errorf("[provider: %s] %w: error reading response body: %v", p.Name, domain.ErrOutcomeUnknown, err),
+			}
+		}
+		return nil, &domain.ProviderError{
+			Class:      domain.ErrorClassNetwork,
+			HTTPStatus: resp.StatusCode,
+			Err:        fmt.Errorf("[provider: %s] error reading response body: %w", p.Name, err),
+		}
 	}
 
-	transformer := p.config.ResponseTransformer(p)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, &domain.ProviderError{
+			Class:      domain.ErrorClassNotFound,
+			HTTPStatus: resp.StatusCode,
+			Response:   redactResponse(respBody),
+			Err:        fmt.Errorf("[provider: %s] %w", p.Name, domain.ErrPaymentNotFound),
+		}
+	case resp.StatusCode != http.StatusOK:
+		class := domain.ErrorClassServerError
+		if resp.StatusCode < http.StatusInternalServerError {
+			class = domain.ErrorClassClientError
+		}
+		return nil, &domain.ProviderError{
+			Class:      class,
+			HTTPStatus: resp.StatusCode,
+			Response:   redactResponse(respBody),
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+			Err:        fmt.Errorf("[provider: %s] unexpected status code: %d", p.Name, resp.StatusCode),
+		}
+	}
+
+	transformer := responseTransformer(p)
 	payment, err := transformer(respBody)
 	if err != nil {
-		return nil, fmt.Errorf("[provider: %s] error transforming response: %w", p.Name, err)
+		err = fmt.Errorf("[provider: %s] error transforming response: %w", p.Name, err)
+		if movesMoney {
+			err = fmt.Errorf("%w: %w", domain.ErrOutcomeUnknown, err)
+		}
+		return nil, &domain.ProviderError{
+			Class:      domain.ErrorClassInvalidResponse,
+			HTTPStatus: resp.StatusCode,
+			Response:   redactResponse(respBody),
+			Err:        err,
+		}
 	}
 
 	return payment, nil
 }
 
-func (p *Provider) GetID() string {
-	return p.ID
-}
-
-func (p *Provider) GetName() string {
-	return p.Name
-}
-
 func readBody(resp *http.Response) ([]byte, error) {
 	var buf bytes.Buffer
 	_, err := buf.ReadFrom(resp.Body)
@@ -153,3 +314,23 @@ func readBody(resp *http.Response) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// retryAfter parses a Retry-After header, given in seconds or as an HTTP
+// date. It returns 0 when the header is missing or invalid.
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
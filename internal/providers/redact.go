@@ -0,0 +1,22 @@
+package providers
+
+import "regexp"
+
+// maxResponseSnippet bounds the provider response kept on failed attempts.
+const maxResponseSnippet = 512
+
+var (
+	cardNumberPattern      = regexp.MustCompile(`\b\d{8,15}(\d{4})\b`)
+	sensitiveFieldsPattern = regexp.MustCompile(`(?i)("(?:cvv|cvc|cvv2|securityCode|security_code)"\s*:\s*)"[^"]*"`)
+)
+
+// redactResponse masks card numbers and security codes in a provider
+// response and truncates it, so it can be stored and shown to support.
+func redactResponse(body []byte) string {
+	redacted := cardNumberPattern.ReplaceAll(body, []byte("************$1"))
+	redacted = sensitiveFieldsPattern.ReplaceAll(redacted, []byte(`$1"[REDACTED]"`))
+	if len(redacted) > maxResponseSnippet {
+		return string(redacted[:maxResponseSnippet]) + "..."
+	}
+	return string(redacted)
+}
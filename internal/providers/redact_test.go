@@ -0,0 +1,30 @@
+package providers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactResponse(t *testing.T) {
+	t.Run("card numbers keep the last 4 digits", func(t *testing.T) {
+		redacted := redactResponse([]byte(`{"error":"card 4111111111111111 declined"}`))
+		assert.Equal(t, `{"error":"card ************1111 declined"}`, redacted)
+	})
+
+	t.Run("security codes", func(t *testing.T) {
+		redacted := redactResponse([]byte(`{"cvv": "123", "CVC":"9876", "amount": 100}`))
+		assert.Equal(t, `{"cvv": "[REDACTED]", "CVC":"[REDACTED]", "amount": 100}`, redacted)
+	})
+
+	t.Run("short numbers are kept", func(t *testing.T) {
+		redacted := redactResponse([]byte(`{"amount":10050,"code":"2001"}`))
+		assert.Equal(t, `{"amount":10050,"code":"2001"}`, redacted)
+	})
+
+	t.Run("long responses are truncated", func(t *testing.T) {
+		redacted := redactResponse([]byte(strings.Repeat("a", 2*maxResponseSnippet)))
+		assert.Len(t, redacted, maxResponseSnippet+len("..."))
+	})
+}
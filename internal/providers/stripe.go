@@ -0,0 +1,156 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"desafio-api/internal/domain"
+)
+
+// StripeCharge is the charge object of a Stripe-like API: amounts in cents,
+// lowercase currencies and unix timestamps. Requests are form encoded.
+type StripeCharge struct {
+	ID             string              `json:"id"`
+	Object         string              `json:"object"`
+	Amount         int64               `json:"amount"`
+	AmountRefunded int64               `json:"amount_refunded"`
+	Currency       string              `json:"currency"`
+	Description    string              `json:"description"`
+	Status         string              `json:"status"`
+	Refunded       bool                `json:"refunded"`
+	Created        int64               `json:"created"`
+	PaymentMethod  string              `json:"payment_method"`
+	FailureCode    string              `json:"failure_code,omitempty"`
+	Metadata       map[string]string   `json:"metadata,omitempty"`
+	ThreeDSecure   *StripeThreeDSecure `json:"three_d_secure,omitempty"`
+	NextAction     *StripeNextAction   `json:"next_action,omitempty"`
+}
+
+// StripeList is the response of list and search endpoints.
+type StripeList struct {
+	Object string         `json:"object"`
+	Data   []StripeCharge `json:"data"`
+}
+
+type StripeThreeDSecure struct {
+	AuthenticationFlow string `json:"authentication_flow"`
+}
+
+type StripeNextAction struct {
+	Type          string            `json:"type"`
+	RedirectToURL *StripeRedirect   `json:"redirect_to_url,omitempty"`
+	UseStripeSDK  map[string]string `json:"use_stripe_sdk,omitempty"`
+}
+
+type StripeRedirect struct {
+	URL string `json:"url"`
+}
+
+func StripeRequestTransformer(request domain.PaymentRequest) (interface{}, error) {
+	form := url.Values{}
+	form.Set("amount", strconv.FormatInt(ToMinorUnits(request.Amount), 10))
+	form.Set("currency", strings.ToLower(request.Currency))
+	form.Set("description", request.Description)
+	if request.Reference != "" {
+		form.Set("metadata[reference]", request.Reference)
+	}
+	form.Set("source[number]", request.Card.Number)
+	form.Set("source[name]", request.Card.HolderName)
+	form.Set("source[cvc]", request.Card.CVV)
+	if month, year, found := strings.Cut(request.Card.ExpirationDate, "/"); found {
+		form.Set("source[exp_month]", month)
+		form.Set("source[exp_year]", year)
+	}
+	if request.Card.Installments > 0 {
+		form.Set("installments", strconv.Itoa(request.Card.Installments))
+	}
+	return form, nil
+}
+
+// StripeRefundTransformer leaves the amount out of full refunds, which
+// Stripe refunds in whole.
+func StripeRefundTransformer(request domain.RefundRequest) (interface{}, error) {
+	form := url.Values{}
+	if request.Amount > 0 {
+		form.Set("amount", strconv.FormatInt(ToMinorUnits(request.Amount), 10))
+	}
+	return form, nil
+}
+
+func StripeConfirmTransformer(request domain.ConfirmRequest) (interface{}, error) {
+	form := url.Values{}
+	form.Set("authentication_result", request.AuthenticationResult)
+	return form, nil
+}
+
+func StripeResponseTransformer(provider *Provider) func([]byte) (*domain.Payment, error) {
+	return func(data []byte) (*domain.Payment, error) {
+		var charge StripeCharge
+		if err := json.Unmarshal(data, &charge); err != nil {
+			return nil, fmt.Errorf("error unmarshaling response: %w", err)
+		}
+		return stripePayment(charge)
+	}
+}
+
+// StripeLookupTransformer takes the first charge of a list response.
+func StripeLookupTransformer(provider *Provider) func([]byte) (*domain.Payment, error) {
+	return func(data []byte) (*domain.Payment, error) {
+		var list StripeList
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, fmt.Errorf("error unmarshaling response: %w", err)
+		}
+		if list.Object != "list" {
+			return nil, fmt.Errorf("unexpected object in response: %q", list.Object)
+		}
+		if len(list.Data) == 0 {
+			return nil, domain.ErrPaymentNotFound
+		}
+		return stripePayment(list.Data[0])
+	}
+}
+
+func stripePayment(charge StripeCharge) (*domain.Payment, error) {
+	if charge.Object != "charge" {
+		return nil, fmt.Errorf("unexpected object in response: %q", charge.Object)
+	}
+
+	var status domain.PaymentStatus
+	switch {
+	case charge.Refunded || charge.AmountRefunded > 0:
+		status = domain.StatusRefunded
+	case charge.Status == "succeeded":
+		status = domain.StatusAuthorized
+	case charge.Status == "requires_action":
+		status = domain.StatusRequiresAction
+	default:
+		status = domain.StatusFailed
+	}
+
+	payment := &domain.Payment{
+		ID:             charge.ID,
+		CreatedAt:      time.Unix(charge.Created, 0),
+		Status:         status,
+		OriginalAmount: FromMinorUnits(charge.Amount),
+		CurrentAmount:  FromMinorUnits(charge.Amount - charge.AmountRefunded),
+		Currency:       strings.ToUpper(charge.Currency),
+		Description:    charge.Description,
+		PaymentMethod:  "card",
+		CardID:         charge.PaymentMethod,
+	}
+	if charge.ThreeDSecure != nil {
+		payment.Authentication = charge.ThreeDSecure.AuthenticationFlow
+	}
+	if charge.NextAction != nil && charge.NextAction.RedirectToURL != nil {
+		payment.NextAction = &domain.NextAction{
+			Type:        domain.NextActionRedirect,
+			RedirectURL: charge.NextAction.RedirectToURL.URL,
+			Data:        charge.NextAction.UseStripeSDK,
+		}
+	}
+	return payment, nil
+}
@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second that holds at
+// most Burst tokens. A zero Rate disables limiting.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Backend stores the buckets. MemoryBackend keeps them in process; a shared
+// backend (e.g. Redis) can implement the same interface to limit across
+// instances.
+type Backend interface {
+	// Allow takes one token from the bucket identified by key. When the
+	// bucket is empty it returns false and how long until a token is available.
+	Allow(key string, limit Limit) (bool, time.Duration)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+type MemoryBackend struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// Idle buckets are dropped once they would have refilled completely, as a
+// fresh bucket behaves the same way.
+const sweepInterval = time.Minute
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (b *MemoryBackend) Allow(key string, limit Limit) (bool, time.Duration) {
+	if !limit.Enabled() {
+		return true, 0
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := b.now()
+	b.sweep(now)
+
+	current, exists := b.buckets[key]
+	if !exists {
+		current = &bucket{tokens: float64(limit.Burst), updated: now}
+		b.buckets[key] = current
+	}
+
+	elapsed := now.Sub(current.updated).Seconds()
+	current.tokens = math.Min(float64(limit.Burst), current.tokens+elapsed*limit.Rate)
+	current.updated = now
+
+	if current.tokens >= 1 {
+		current.tokens--
+		missing := float64(limit.Burst) - current.tokens
+		current.full = now.Add(time.Duration(missing / limit.Rate * float64(time.Second)))
+		return true, 0
+	}
+
+	wait := time.Duration((1 - current.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+func (b *MemoryBackend) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < sweepInterval {
+		return
+	}
+	b.lastSweep = now
+
+	for key, current := range b.buckets {
+		if now.After(current.full) {
+			delete(b.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	t.Run("allows burst then refills", func(t *testing.T) {
+		backend := NewMemoryBackend()
+		now := time.Now()
+		backend.now = func() time.Time { return now }
+		limit := Limit{Rate: 1, Burst: 2}
+
+		allowed, _ := backend.Allow("key", limit)
+		assert.True(t, allowed)
+		allowed, _ = backend.Allow("key", limit)
+		assert.True(t, allowed)
+
+		allowed, retryAfter := backend.Allow("key", limit)
+		assert.False(t, allowed)
+		assert.Equal(t, time.Second, retryAfter)
+
+		now = now.Add(time.Second)
+		allowed, _ = backend.Allow("key", limit)
+		assert.True(t, allowed)
+	})
+
+	t.Run("buckets are independent per key", func(t *testing.T) {
+		backend := NewMemoryBackend()
+		limit := Limit{Rate: 1, Burst: 1}
+
+		allowed, _ := backend.Allow("a", limit)
+		assert.True(t, allowed)
+		allowed, _ = backend.Allow("b", limit)
+		assert.True(t, allowed)
+		allowed, _ = backend.Allow("a", limit)
+		assert.False(t, allowed)
+	})
+
+	t.Run("disabled limit always allows", func(t *testing.T) {
+		backend := NewMemoryBackend()
+		for i := 0; i < 10; i++ {
+			allowed, _ := backend.Allow("key", Limit{})
+			assert.True(t, allowed)
+		}
+	})
+
+	t.Run("sweeps refilled buckets", func(t *testing.T) {
+		backend := NewMemoryBackend()
+		now := time.Now()
+		backend.now = func() time.Time { return now }
+
+		backend.Allow("fast", Limit{Rate: 10, Burst: 1})
+		backend.Allow("slow", Limit{Rate: 0.001, Burst: 1})
+
+		now = now.Add(2 * sweepInterval)
+		backend.Allow("other", Limit{Rate: 10, Burst: 1})
+
+		assert.NotContains(t, backend.buckets, "fast")
+		assert.Contains(t, backend.buckets, "slow")
+	})
+}
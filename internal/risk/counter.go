@@ -0,0 +1,48 @@
+package risk
+
+import (
+	"sync"
+	"time"
+)
+
+// counter keeps event timestamps per key for sliding window counts.
+type counter struct {
+	mutex  sync.Mutex
+	events map[string][]time.Time
+	window time.Duration
+}
+
+func newCounter(window time.Duration) *counter {
+	return &counter{
+		events: make(map[string][]time.Time),
+		window: window,
+	}
+}
+
+func (c *counter) add(key string, at time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.events[key] = append(c.trim(key, at), at)
+}
+
+func (c *counter) count(key string, at time.Time) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	events := c.trim(key, at)
+	if len(events) == 0 {
+		delete(c.events, key)
+	} else {
+		c.events[key] = events
+	}
+	return len(events)
+}
+
+func (c *counter) trim(key string, at time.Time) []time.Time {
+	events := c.events[key]
+	cutoff := at.Add(-c.window)
+	i := 0
+	for i < len(events) && !events[i].After(cutoff) {
+		i++
+	}
+	return events[i:]
+}
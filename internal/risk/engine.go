@@ -0,0 +1,108 @@
+package risk
+
+import (
+	"fmt"
+	"time"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+// Engine scores payments before they are sent to a provider. Each matching
+// rule adds its score, and the total is compared against the review and
+// reject thresholds.
+type Engine struct {
+	rules       []Rule
+	velocity    []*velocityRule
+	declines    *counter
+	reviewScore int
+	rejectScore int
+	now         func() time.Time
+}
+
+func NewEngine(cfg config.RiskConfig) (*Engine, error) {
+	engine := &Engine{
+		reviewScore: cfg.ReviewScore,
+		rejectScore: cfg.RejectScore,
+		now:         time.Now,
+	}
+
+	for _, v := range cfg.Velocity {
+		switch v.Key {
+		case "card", "ip", "merchant":
+		default:
+			return nil, fmt.Errorf("unknown velocity key %q", v.Key)
+		}
+		rule := &velocityRule{
+			key:     v.Key,
+			max:     v.Max,
+			score:   v.Score,
+			counter: newCounter(time.Duration(v.WindowSeconds) * time.Second),
+		}
+		engine.velocity = append(engine.velocity, rule)
+		engine.rules = append(engine.rules, rule)
+	}
+
+	engine.declines = newCounter(time.Duration(cfg.Declines.WindowSeconds) * time.Second)
+	engine.rules = append(engine.rules, &declineRule{
+		max:     cfg.Declines.Max,
+		score:   cfg.Declines.Score,
+		counter: engine.declines,
+	})
+
+	for _, threshold := range cfg.AmountThresholds {
+		engine.rules = append(engine.rules, &amountRule{
+			currency: threshold.Currency,
+			amount:   threshold.Amount,
+			score:    threshold.Score,
+		})
+	}
+
+	geo, err := newGeoRule(cfg.Geo)
+	if err != nil {
+		return nil, err
+	}
+	engine.rules = append(engine.rules, geo, newBlocklistRule(cfg.Blocklist))
+
+	return engine, nil
+}
+
+// Evaluate scores the payment and records the attempt for velocity rules.
+func (e *Engine) Evaluate(merchantID string, request domain.PaymentRequest) domain.RiskAssessment {
+	input := Input{MerchantID: merchantID, Request: request, At: e.now()}
+
+	assessment := domain.RiskAssessment{
+		Decision:    domain.RiskApprove,
+		EvaluatedAt: input.At,
+	}
+	for _, rule := range e.rules {
+		score, reason := rule.Evaluate(input)
+		if score <= 0 {
+			continue
+		}
+		assessment.Score += score
+		assessment.Reasons = append(assessment.Reasons, reason)
+	}
+
+	for _, rule := range e.velocity {
+		rule.record(input)
+	}
+
+	switch {
+	case assessment.Score >= e.rejectScore:
+		assessment.Decision = domain.RiskReject
+	case assessment.Score >= e.reviewScore:
+		assessment.Decision = domain.RiskReview
+	}
+	return assessment
+}
+
+// RecordOutcome feeds provider declines back into the repeated declines rule.
+func (e *Engine) RecordOutcome(merchantID string, request domain.PaymentRequest, approved bool) {
+	if approved {
+		return
+	}
+	if fingerprint := request.Card.Fingerprint(); fingerprint != "" {
+		e.declines.add(fingerprint, e.now())
+	}
+}
@@ -0,0 +1,108 @@
+package risk
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+func getTestConfig() config.RiskConfig {
+	return config.RiskConfig{
+		Enabled:     true,
+		ReviewScore: 50,
+		RejectScore: 80,
+		Velocity: []config.VelocityRuleConfig{
+			{Key: "card", Max: 2, WindowSeconds: 60, Score: 50},
+		},
+		Declines:         config.DeclineRuleConfig{Max: 2, WindowSeconds: 60, Score: 80},
+		AmountThresholds: []config.AmountThresholdConfig{{Currency: "BRL", Amount: 1000, Score: 30}},
+		Geo: config.GeoRuleConfig{
+			MismatchScore: 30,
+			BINs:          []config.BINCountryConfig{{Prefix: "411111", Country: "US"}},
+			IPRanges:      []config.IPCountryConfig{{CIDR: "200.0.0.0/8", Country: "BR"}},
+		},
+		Blocklist: config.BlocklistConfig{
+			Emails: []string{"fraud@example.com"},
+			Score:  100,
+		},
+	}
+}
+
+func paymentRequest(number string, amount float64) domain.PaymentRequest {
+	return domain.PaymentRequest{
+		Amount:   amount,
+		Currency: "BRL",
+		Card:     domain.Card{Number: number},
+		ClientIP: "10.0.0.1",
+	}
+}
+
+func TestEngine(t *testing.T) {
+	t.Run("approves low risk payment", func(t *testing.T) {
+		engine, err := NewEngine(getTestConfig())
+		assert.NoError(t, err)
+
+		assessment := engine.Evaluate("merchant", paymentRequest("5555555555554444", 10))
+		assert.Equal(t, domain.RiskApprove, assessment.Decision)
+		assert.Zero(t, assessment.Score)
+	})
+
+	t.Run("adds up amount and geo scores for review", func(t *testing.T) {
+		engine, _ := NewEngine(getTestConfig())
+		request := paymentRequest("4111111111111111", 1500)
+		request.ClientIP = "200.1.2.3"
+
+		assessment := engine.Evaluate("merchant", request)
+		assert.Equal(t, 60, assessment.Score)
+		assert.Equal(t, domain.RiskReview, assessment.Decision)
+		assert.Len(t, assessment.Reasons, 2)
+	})
+
+	t.Run("rejects blocklisted email", func(t *testing.T) {
+		engine, _ := NewEngine(getTestConfig())
+		request := paymentRequest("5555555555554444", 10)
+		request.Email = "Fraud@Example.com"
+
+		assessment := engine.Evaluate("merchant", request)
+		assert.Equal(t, domain.RiskReject, assessment.Decision)
+	})
+
+	t.Run("velocity per card within window", func(t *testing.T) {
+		engine, _ := NewEngine(getTestConfig())
+		now := time.Now()
+		engine.now = func() time.Time { return now }
+		request := paymentRequest("5555555555554444", 10)
+
+		engine.Evaluate("merchant", request)
+		engine.Evaluate("merchant", request)
+		assessment := engine.Evaluate("merchant", request)
+		assert.Equal(t, domain.RiskReview, assessment.Decision)
+
+		now = now.Add(2 * time.Minute)
+		assessment = engine.Evaluate("merchant", request)
+		assert.Equal(t, domain.RiskApprove, assessment.Decision)
+	})
+
+	t.Run("repeated declines reject the card", func(t *testing.T) {
+		engine, _ := NewEngine(getTestConfig())
+		request := paymentRequest("5555555555554444", 10)
+
+		engine.RecordOutcome("merchant", request, false)
+		engine.RecordOutcome("merchant", request, true)
+		engine.RecordOutcome("merchant", request, false)
+
+		assessment := engine.Evaluate("merchant", request)
+		assert.Equal(t, domain.RiskReject, assessment.Decision)
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		cfg := getTestConfig()
+		cfg.Geo.IPRanges = []config.IPCountryConfig{{CIDR: "not-a-cidr", Country: "BR"}}
+		_, err := NewEngine(cfg)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,182 @@
+package risk
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+type Input struct {
+	MerchantID string
+	Request    domain.PaymentRequest
+	At         time.Time
+}
+
+// Rule adds Score to the assessment when it matches, with a reason that
+// explains why.
+type Rule interface {
+	Evaluate(input Input) (score int, reason string)
+}
+
+type velocityRule struct {
+	key     string
+	max     int
+	score   int
+	counter *counter
+}
+
+func (r *velocityRule) Evaluate(input Input) (int, string) {
+	key, ok := velocityKey(r.key, input)
+	if !ok {
+		return 0, ""
+	}
+	if r.counter.count(key, input.At) >= r.max {
+		return r.score, fmt.Sprintf("velocity: more than %d attempts per %s", r.max, r.key)
+	}
+	return 0, ""
+}
+
+func (r *velocityRule) record(input Input) {
+	if key, ok := velocityKey(r.key, input); ok {
+		r.counter.add(key, input.At)
+	}
+}
+
+func velocityKey(key string, input Input) (string, bool) {
+	var value string
+	switch key {
+	case "card":
+		value = input.Request.Card.Fingerprint()
+	case "ip":
+		value = input.Request.ClientIP
+	case "merchant":
+		value = input.MerchantID
+	}
+	return value, value != ""
+}
+
+type declineRule struct {
+	max     int
+	score   int
+	counter *counter
+}
+
+func (r *declineRule) Evaluate(input Input) (int, string) {
+	fingerprint := input.Request.Card.Fingerprint()
+	if fingerprint == "" || r.max <= 0 {
+		return 0, ""
+	}
+	if r.counter.count(fingerprint, input.At) >= r.max {
+		return r.score, fmt.Sprintf("card declined %d or more times recently", r.max)
+	}
+	return 0, ""
+}
+
+type amountRule struct {
+	currency string
+	amount   float64
+	score    int
+}
+
+func (r *amountRule) Evaluate(input Input) (int, string) {
+	if r.currency != "" && !strings.EqualFold(r.currency, input.Request.Currency) {
+		return 0, ""
+	}
+	if input.Request.Amount >= r.amount {
+		return r.score, fmt.Sprintf("amount at or above %.2f", r.amount)
+	}
+	return 0, ""
+}
+
+type geoRule struct {
+	score    int
+	bins     []config.BINCountryConfig
+	ipRanges []ipRange
+}
+
+type ipRange struct {
+	network *net.IPNet
+	country string
+}
+
+func newGeoRule(cfg config.GeoRuleConfig) (*geoRule, error) {
+	rule := &geoRule{score: cfg.MismatchScore, bins: cfg.BINs}
+	for _, r := range cfg.IPRanges {
+		_, network, err := net.ParseCIDR(r.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip range %q: %w", r.CIDR, err)
+		}
+		rule.ipRanges = append(rule.ipRanges, ipRange{network: network, country: strings.ToUpper(r.Country)})
+	}
+	return rule, nil
+}
+
+func (r *geoRule) Evaluate(input Input) (int, string) {
+	binCountry := r.binCountry(input.Request.Card.Number)
+	ipCountry := r.ipCountry(input.Request.ClientIP)
+	if binCountry == "" || ipCountry == "" || binCountry == ipCountry {
+		return 0, ""
+	}
+	return r.score, fmt.Sprintf("card country %s differs from ip country %s", binCountry, ipCountry)
+}
+
+// binCountry uses the longest matching prefix.
+func (r *geoRule) binCountry(number string) string {
+	number = strings.NewReplacer(" ", "", "-", "").Replace(number)
+	country, longest := "", 0
+	for _, bin := range r.bins {
+		if len(bin.Prefix) > longest && strings.HasPrefix(number, bin.Prefix) {
+			country, longest = strings.ToUpper(bin.Country), len(bin.Prefix)
+		}
+	}
+	return country
+}
+
+func (r *geoRule) ipCountry(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+	for _, candidate := range r.ipRanges {
+		if candidate.network.Contains(ip) {
+			return candidate.country
+		}
+	}
+	return ""
+}
+
+type blocklistRule struct {
+	score  int
+	cards  map[string]struct{}
+	emails map[string]struct{}
+}
+
+func newBlocklistRule(cfg config.BlocklistConfig) *blocklistRule {
+	rule := &blocklistRule{
+		score:  cfg.Score,
+		cards:  make(map[string]struct{}),
+		emails: make(map[string]struct{}),
+	}
+	for _, fingerprint := range cfg.CardFingerprints {
+		rule.cards[strings.ToLower(fingerprint)] = struct{}{}
+	}
+	for _, email := range cfg.Emails {
+		rule.emails[strings.ToLower(strings.TrimSpace(email))] = struct{}{}
+	}
+	return rule
+}
+
+func (r *blocklistRule) Evaluate(input Input) (int, string) {
+	if _, blocked := r.cards[input.Request.Card.Fingerprint()]; blocked {
+		return r.score, "card is blocklisted"
+	}
+	email := strings.ToLower(strings.TrimSpace(input.Request.Email))
+	if _, blocked := r.emails[email]; blocked && email != "" {
+		return r.score, "email is blocklisted"
+	}
+	return 0, ""
+}
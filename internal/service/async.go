@@ -0,0 +1,386 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/google/uuid"
+
+	"desafio-api/internal/domain"
+	"desafio-api/internal/webhook"
+)
+
+type CardVault interface {
+	Store(merchantID string, card domain.Card) (*domain.StoredCard, error)
+	Get(merchantID string, token string) (*domain.StoredCard, error)
+	Delete(merchantID string, token string) error
+}
+
+// CardSealer encrypts cards that must be written outside the vault.
+type CardSealer interface {
+	Seal(card domain.Card) (string, error)
+	Open(sealed string) (domain.Card, error)
+}
+
+// WithJobQueue enables async payments. Their cards wait in vault until
+// charged, so only the card token is written to the queue.
+func WithJobQueue(queue domain.JobQueue, vault CardVault) Option {
+	return func(s *PaymentService) {
+		s.jobs = queue
+		s.vault = vault
+	}
+}
+
+// WithCardSealer also writes the cards of queued payments to the queue,
+// encrypted, so they can be charged after a restart empties the vault.
+func WithCardSealer(sealer CardSealer) Option {
+	return func(s *PaymentService) {
+		s.sealer = sealer
+	}
+}
+
+// SubmitPayment stores the charge as a pending payment and queues it for
+// the workers, returning without waiting for the providers. Once the charge
+// is processed the payment keeps its ID, and callbackURL, if set, receives
+// it.
+func (s *PaymentService) SubmitPayment(actor domain.Actor, request domain.PaymentRequest, callbackURL string) (*domain.Payment, error) {
+	if s.jobs == nil || s.vault == nil {
+		return nil, errors.New("async payments are not enabled")
+	}
+	// The plan is computed again when the job is processed
+	planned, err := s.planInstallments(request)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.routes(planned); err != nil {
+		return nil, err
+	}
+	if request.Reference == "" {
+		request.Reference = uuid.New().String()
+	}
+
+	stored, err := s.vault.Store(actor.MerchantID, request.Card)
+	if err != nil {
+		return nil, err
+	}
+	job := &domain.PaymentJob{
+		ID:          uuid.New().String(),
+		PaymentID:   request.Reference,
+		Actor:       actor,
+		Request:     request,
+		CardToken:   stored.Token,
+		CardLast4:   stored.Last4,
+		CVV:         request.Card.CVV,
+		ClientIP:    request.ClientIP,
+		CallbackURL: callbackURL,
+		EnqueuedAt:  time.Now(),
+	}
+	job.Request.Card = domain.Card{Installments: request.Card.Installments}
+	if s.sealer != nil {
+		if job.SealedCard, err = s.sealer.Seal(request.Card); err != nil {
+			s.deleteJobCard(job)
+			return nil, err
+		}
+	}
+	transaction := pendingTransaction(job)
+	transaction.Payment.OriginalAmount = planned.Amount
+	transaction.Payment.CurrentAmount = planned.Amount
+	transaction.Payment.Installments = planned.InstallmentPlan
+	transaction.CardFingerprint = request.Card.Fingerprint()
+	if err := s.saveTransaction(actor, domain.AuditPaymentCreated, nil, transaction); err != nil {
+		s.deleteJobCard(job)
+		return nil, fmt.Errorf("failed to store transaction: %w", err)
+	}
+
+	if err := s.jobs.Enqueue(job); err != nil {
+		s.deleteJobCard(job)
+		// The pending payment can't be taken back, so it is failed instead
+		before := snapshot(transaction)
+		transaction.Payment.Status = domain.StatusFailed
+		if err := s.saveTransaction(domain.SystemActor("worker"), domain.AuditPaymentProcessed, before, transaction); err != nil {
+			log.Printf("failed to store payment %s that couldn't be queued: %v", transaction.Payment.ID, err)
+		}
+		return nil, fmt.Errorf("failed to queue payment: %w", err)
+	}
+	log.Printf("payment %s queued for processing", transaction.Payment.ID)
+	return transaction.Payment, nil
+}
+
+func pendingTransaction(job *domain.PaymentJob) *domain.Transaction {
+	return &domain.Transaction{
+		Payment: &domain.Payment{
+			ID:             job.PaymentID,
+			CreatedAt:      job.EnqueuedAt,
+			Status:         domain.StatusPending,
+			OriginalAmount: job.Request.Amount,
+			CurrentAmount:  job.Request.Amount,
+			Currency:       job.Request.Currency,
+			Description:    job.Request.Description,
+			PaymentMethod:  "card",
+			CardLast4:      job.CardLast4,
+		},
+		MerchantID: job.Actor.MerchantID,
+		Reference:  job.PaymentID,
+	}
+}
+
+// storeCharge saves the result of a charge. The result of an async charge
+// replaces its pending payment, keeping the ID the client already has.
+func (s *PaymentService) storeCharge(actor domain.Actor, pending *domain.Transaction, transaction *domain.Transaction) error {
+	if pending == nil {
+		return s.saveTransaction(actor, domain.AuditPaymentCreated, nil, transaction)
+	}
+	before := snapshot(pending)
+	transaction.Payment.ID = pending.Payment.ID
+	transaction.Payment.CreatedAt = pending.Payment.CreatedAt
+	return s.saveTransaction(actor, domain.AuditPaymentProcessed, before, transaction)
+}
+
+// RunWorkers processes the queued payments with n workers until ctx is
+// done, then waits for the jobs being processed. Jobs interrupted by a
+// shutdown stay in the queue and are looked up before being charged again.
+func (s *PaymentService) RunWorkers(ctx context.Context, n int) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := s.jobs.Next(ctx)
+				if err != nil {
+					return
+				}
+				s.processJob(job)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// processJob charges a queued payment and finishes its job. When the
+// store fails the job is retried later, as resumed, since it may have been
+// charged already.
+func (s *PaymentService) processJob(job *domain.PaymentJob) {
+	transaction, err := s.chargeJob(job)
+	if err != nil {
+		log.Printf("queued payment %s will be retried: %v", job.PaymentID, err)
+		s.jobs.Retry(job.ID, s.config.GetAsyncRetryDelay())
+		return
+	}
+
+	if err := s.jobs.Done(job.ID); err != nil {
+		log.Printf("failed to remove job %s from the queue: %v", job.ID, err)
+	}
+	s.deleteJobCard(job)
+	log.Printf("queued payment %s processed with status %s", job.PaymentID, transaction.Payment.Status)
+	if job.CallbackURL != "" {
+		go s.sendCallback(job.Actor.MerchantID, job.CallbackURL, transaction.Payment)
+	}
+}
+
+// chargeJob charges the payment of a job unless it was already processed,
+// returning it as stored. Errors are failures of the store, including a
+// charge made whose result couldn't be stored.
+func (s *PaymentService) chargeJob(job *domain.PaymentJob) (*domain.Transaction, error) {
+	pending, err := s.transactions.Get(job.PaymentID)
+	switch {
+	case errors.Is(err, domain.ErrPaymentNotFound):
+		// Queued before a restart, the pending payment was only in memory
+		pending = pendingTransaction(job)
+		if err := s.saveTransaction(domain.SystemActor("recovery"), domain.AuditPaymentRecovered, nil, pending); err != nil {
+			return nil, fmt.Errorf("failed to store recovered payment: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to load payment: %w", err)
+	}
+
+	// The last run may have charged it before it stopped
+	if pending.Payment.Status == domain.StatusPending && job.Resumed && s.findResumedCharge(job, pending) {
+		pending, err = s.transactions.Get(job.PaymentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load resumed payment: %w", err)
+		}
+	}
+
+	if pending.Payment.Status == domain.StatusPending {
+		request := job.Request
+		request.Reference = job.PaymentID
+		request.ClientIP = job.ClientIP
+		request.Card, err = s.jobCard(job)
+		if err != nil {
+			log.Printf("card of queued payment %s is not available: %v", job.PaymentID, err)
+		} else if _, err := s.processPayment(job.Actor, request, pending); errors.Is(err, domain.ErrNotStored) {
+			// Charged but still pending in the store: retried as resumed,
+			// the charge is then found by its reference
+			return nil, err
+		} else if err != nil {
+			log.Printf("queued payment %s failed: %v", job.PaymentID, err)
+		}
+	}
+
+	transaction, err := s.transactions.Get(job.PaymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load processed payment: %w", err)
+	}
+	// Every provider failed before charging
+	if transaction.Payment.Status == domain.StatusPending {
+		before := snapshot(transaction)
+		transaction.Payment.Status = domain.StatusFailed
+		if err := s.saveTransaction(job.Actor, domain.AuditPaymentProcessed, before, transaction); err != nil {
+			return nil, fmt.Errorf("failed to store failed payment: %w", err)
+		}
+	}
+	return transaction, nil
+}
+
+// findResumedCharge looks up the charge of a job resumed after a restart
+// at every provider, by its reference. A charge found replaces the pending
+// payment. When a lookup fails the payment is left unknown, for the sweeper
+// to resolve, rather than charged again. It returns false when no provider
+// saw the charge, which is then safe to send.
+func (s *PaymentService) findResumedCharge(job *domain.PaymentJob, pending *domain.Transaction) bool {
+	tracker := &attempts{}
+	var unresolved domain.PaymentProvider
+	// Every provider is asked: lookups aren't a declared capability, and one
+	// that can't look charges up leaves the payment unknown
+	for _, provider := range s.providers {
+		payment, err := s.call(provider, domain.OperationLookup, tracker, func() (*domain.Payment, error) {
+			return provider.FindPaymentByReference(job.PaymentID)
+		})
+		switch {
+		case err == nil:
+			log.Printf("[provider: %s] resumed payment %s was already charged with status %s", provider.GetName(), job.PaymentID, payment.Status)
+			payment.CardLast4 = pending.Payment.CardLast4
+			payment.Installments = pending.Payment.Installments
+			transaction := &domain.Transaction{
+				Payment:           payment,
+				MerchantID:        pending.MerchantID,
+				ProviderID:        provider.GetID(),
+				ProviderName:      provider.GetName(),
+				ProviderPaymentID: payment.ID,
+				Reference:         job.PaymentID,
+				Attempts:          tracker.snapshot(),
+				CardFingerprint:   pending.CardFingerprint,
+			}
+			if err := s.storeCharge(domain.SystemActor("recovery"), pending, transaction); err != nil {
+				log.Printf("failed to store resumed payment %s: %v", job.PaymentID, err)
+			}
+			return true
+		case errors.Is(err, domain.ErrPaymentNotFound):
+		default:
+			log.Printf("[provider: %s] lookup of resumed payment %s failed: %v", provider.GetName(), job.PaymentID, err)
+			if unresolved == nil {
+				unresolved = provider
+			}
+		}
+	}
+	if unresolved == nil {
+		return false
+	}
+
+	before := snapshot(pending)
+	pending.Payment.Status = domain.StatusUnknown
+	pending.ProviderID = unresolved.GetID()
+	pending.ProviderName = unresolved.GetName()
+	pending.Attempts = append(pending.Attempts, tracker.list...)
+	if err := s.saveTransaction(domain.SystemActor("recovery"), domain.AuditPaymentProcessed, before, pending); err != nil {
+		log.Printf("failed to store resumed payment %s: %v", job.PaymentID, err)
+	}
+	return true
+}
+
+// jobCard returns the card to charge for a job: from the vault or, after a
+// restart emptied it, from the sealed copy in the job. The CVV is only
+// there when the job was queued by this process.
+func (s *PaymentService) jobCard(job *domain.PaymentJob) (domain.Card, error) {
+	var card domain.Card
+	stored, err := s.vault.Get(job.Actor.MerchantID, job.CardToken)
+	switch {
+	case err == nil:
+		card = stored.Card
+	case errors.Is(err, domain.ErrCardNotFound) && job.SealedCard != "" && s.sealer != nil:
+		if card, err = s.sealer.Open(job.SealedCard); err != nil {
+			return domain.Card{}, err
+		}
+	default:
+		return domain.Card{}, err
+	}
+	card.CVV = job.CVV
+	card.Installments = job.Request.Card.Installments
+	return card, nil
+}
+
+// deleteJobCard removes the card of a job from the vault, where it was only
+// kept for the charge.
+func (s *PaymentService) deleteJobCard(job *domain.PaymentJob) {
+	err := s.vault.Delete(job.Actor.MerchantID, job.CardToken)
+	if err != nil && !errors.Is(err, domain.ErrCardNotFound) {
+		log.Printf("failed to delete card of job %s: %v", job.ID, err)
+	}
+}
+
+// sendCallback posts the processed payment to the callback URL of the job,
+// signed with the webhook secret of the merchant when it has one. The URL
+// was supplied by the merchant, so internal addresses and redirects are
+// refused. Delivery is best effort: failed attempts are retried a few
+// times and then only logged, the payment can still be polled.
+func (s *PaymentService) sendCallback(merchantID string, callbackURL string, payment *domain.Payment) {
+	body, err := json.Marshal(payment)
+	if err != nil {
+		log.Printf("failed to encode callback of payment %s: %v", payment.ID, err)
+		return
+	}
+
+	attempts := s.config.Async.CallbackAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	secret := s.webhookSecret(merchantID)
+	err = retry.Do(
+		func() error {
+			req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(body))
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			if secret != "" {
+				req.Header.Set(webhook.SignatureHeader, webhook.Sign(secret, body))
+			}
+			resp, err := s.callbacks.Do(req)
+			if err != nil {
+				if errors.Is(err, webhook.ErrForbiddenAddress) {
+					return retry.Unrecoverable(err)
+				}
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			}
+			return nil
+		},
+		retry.Attempts(uint(attempts)),
+		retry.Delay(time.Second),
+		retry.LastErrorOnly(true),
+	)
+	if err != nil {
+		log.Printf("failed to deliver callback of payment %s: %v", payment.ID, err)
+	}
+}
+
+func (s *PaymentService) webhookSecret(merchantID string) string {
+	for _, m := range s.config.Merchants {
+		if m.ID == merchantID {
+			return m.WebhookSecret
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"desafio-api/internal/domain"
+)
+
+// attempts collects the provider calls made by one payment operation, so
+// they can be stored on the transaction.
+type attempts struct {
+	list []domain.Attempt
+}
+
+func (a *attempts) add(provider domain.PaymentProvider, operation domain.OperationType, startedAt time.Time, payment *domain.Payment, err error) {
+	number := 1
+	for _, attempt := range a.list {
+		if attempt.ProviderID == provider.GetID() && attempt.Operation == operation {
+			number++
+		}
+	}
+
+	attempt := domain.Attempt{
+		ProviderID:   provider.GetID(),
+		ProviderName: provider.GetName(),
+		Operation:    operation,
+		Number:       number,
+		StartedAt:    startedAt,
+		EndedAt:      time.Now(),
+	}
+	if payment != nil {
+		attempt.Status = payment.Status
+	}
+	if err == nil {
+		// Providers only return a payment for 200 responses
+		attempt.HTTPStatus = http.StatusOK
+	} else {
+		attempt.ErrorClass = domain.ClassifyError(err)
+		attempt.Error = err.Error()
+		var providerErr *domain.ProviderError
+		if errors.As(err, &providerErr) {
+			attempt.HTTPStatus = providerErr.HTTPStatus
+			attempt.Response = providerErr.Response
+		}
+	}
+	a.list = append(a.list, attempt)
+}
+
+// merge appends the attempts collected by another tracker.
+func (a *attempts) merge(other *attempts) {
+	a.list = append(a.list, other.list...)
+}
+
+// snapshot copies the attempts made so far, since a charge stores them on
+// the transaction of every provider that declined before the fallback.
+func (a *attempts) snapshot() []domain.Attempt {
+	if len(a.list) == 0 {
+		return nil
+	}
+	return append([]domain.Attempt(nil), a.list...)
+}
+
+// saveAttempts appends the attempts of an operation that didn't change the
+// payment, like a refund that never reached the provider's answer.
+func (s *PaymentService) saveAttempts(transaction *domain.Transaction, tracker *attempts) {
+	if len(tracker.list) == 0 {
+		return
+	}
+	transaction.Attempts = append(transaction.Attempts, tracker.list...)
+	if err := s.transactions.Save(transaction); err != nil {
+		log.Printf("failed to store attempts of payment %s: %v", transaction.Payment.ID, err)
+	}
+}
+
+// PaymentAttempts lists the provider calls made for a payment of the
+// merchant, oldest first.
+func (s *PaymentService) PaymentAttempts(merchantID string, paymentID string) ([]domain.Attempt, error) {
+	transaction, err := s.merchantTransaction(merchantID, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if transaction.Attempts == nil {
+		return []domain.Attempt{}, nil
+	}
+	return transaction.Attempts, nil
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"desafio-api/internal/domain"
+)
+
+// WithAuditLog records every payment and provider change, with who made it,
+// in an append-only log.
+func WithAuditLog(auditLog domain.AuditLog) Option {
+	return func(s *PaymentService) {
+		s.audit = auditLog
+	}
+}
+
+// saveTransaction stores the transaction and records the change in the
+// audit log. before is the snapshot taken before the change, nil when the
+// transaction is new.
+func (s *PaymentService) saveTransaction(actor domain.Actor, action domain.AuditAction, before json.RawMessage, transaction *domain.Transaction) error {
+	if err := s.transactions.Save(transaction); err != nil {
+		return err
+	}
+	s.record(actor, &domain.AuditEvent{
+		Action:       action,
+		ResourceType: domain.AuditResourcePayment,
+		ResourceID:   transaction.Payment.ID,
+		MerchantID:   transaction.MerchantID,
+		Before:       before,
+		After:        snapshot(transaction),
+	})
+	return nil
+}
+
+func (s *PaymentService) record(actor domain.Actor, event *domain.AuditEvent) {
+	if s.audit == nil {
+		return
+	}
+	event.Actor = actor
+	event.RequestID = actor.RequestID
+	if err := s.audit.Append(event); err != nil {
+		log.Printf("failed to record audit event %s of %s %s: %v", event.Action, event.ResourceType, event.ResourceID, err)
+	}
+}
+
+// PaymentEvents lists the audit events of a payment of the merchant, oldest
+// first.
+func (s *PaymentService) PaymentEvents(merchantID string, paymentID string) ([]domain.AuditEvent, error) {
+	if _, err := s.merchantTransaction(merchantID, paymentID); err != nil {
+		return nil, err
+	}
+	return s.AuditEvents(domain.AuditFilter{
+		ResourceType: domain.AuditResourcePayment,
+		ResourceID:   paymentID,
+		MerchantID:   merchantID,
+	})
+}
+
+func (s *PaymentService) AuditEvents(filter domain.AuditFilter) ([]domain.AuditEvent, error) {
+	if s.audit == nil {
+		return []domain.AuditEvent{}, nil
+	}
+	events, err := s.audit.List(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	return events, nil
+}
+
+// snapshot serializes the state of a resource so later changes to it don't
+// leak into recorded events.
+func snapshot(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("failed to snapshot %T for the audit log: %v", v, err)
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/sony/gobreaker"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+// breakers holds one circuit breaker per provider, so a failing provider
+// doesn't stop traffic to the healthy ones, along with the mode set by an
+// admin to override it.
+type breakers struct {
+	mutex    sync.Mutex
+	settings gobreaker.Settings
+	byID     map[string]*gobreaker.CircuitBreaker
+	modes    map[string]domain.ProviderMode
+}
+
+func newBreakers(cfg *config.Config) *breakers {
+	settings := gobreaker.Settings{
+		MaxRequests: cfg.CircuitBreaker.MaxRequests,
+		Interval:    cfg.GetCircuitBreakerInterval(),
+		Timeout:     cfg.GetCircuitBreakerTimeout(),
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
+			return counts.Requests >= cfg.CircuitBreaker.MinRequests && failureRatio >= cfg.CircuitBreaker.FailureRatio
+		},
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			log.Printf("[provider: %s] circuit breaker state changed from %v to %v", name, from, to)
+		},
+	}
+	return &breakers{
+		settings: settings,
+		byID:     make(map[string]*gobreaker.CircuitBreaker),
+		modes:    make(map[string]domain.ProviderMode),
+	}
+}
+
+func (b *breakers) get(providerID string) *gobreaker.CircuitBreaker {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.breaker(providerID)
+}
+
+func (b *breakers) breaker(providerID string) *gobreaker.CircuitBreaker {
+	breaker, exists := b.byID[providerID]
+	if !exists {
+		settings := b.settings
+		settings.Name = providerID
+		breaker = gobreaker.NewCircuitBreaker(settings)
+		b.byID[providerID] = breaker
+	}
+	return breaker
+}
+
+func (b *breakers) mode(providerID string) domain.ProviderMode {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if mode, exists := b.modes[providerID]; exists {
+		return mode
+	}
+	return domain.ProviderModeAuto
+}
+
+// setMode overrides the breaker of a provider and returns the previous mode.
+func (b *breakers) setMode(providerID string, mode domain.ProviderMode) domain.ProviderMode {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	previous, exists := b.modes[providerID]
+	if !exists {
+		previous = domain.ProviderModeAuto
+	}
+	if mode == domain.ProviderModeAuto {
+		delete(b.modes, providerID)
+	} else {
+		b.modes[providerID] = mode
+	}
+	return previous
+}
+
+// reset hands the provider back to a fresh, closed breaker with zeroed
+// counters and returns the previous mode.
+func (b *breakers) reset(providerID string) domain.ProviderMode {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	previous, exists := b.modes[providerID]
+	if !exists {
+		previous = domain.ProviderModeAuto
+	}
+	delete(b.modes, providerID)
+	delete(b.byID, providerID)
+	return previous
+}
+
+// available reports whether the provider takes new charges.
+func (b *breakers) available(providerID string) bool {
+	switch b.mode(providerID) {
+	case domain.ProviderModeForcedClosed:
+		return true
+	case domain.ProviderModeAuto:
+		return b.get(providerID).State() == gobreaker.StateClosed
+	default:
+		return false
+	}
+}
+
+// execute runs fn through the breaker of the provider, honoring the admin
+// mode. Draining providers only reject charges.
+func (b *breakers) execute(providerID string, operation domain.OperationType, fn func() (interface{}, error)) (interface{}, error) {
+	switch b.mode(providerID) {
+	case domain.ProviderModeForcedOpen:
+		return nil, fmt.Errorf("%w: %s is forced open", domain.ErrProviderUnavailable, providerID)
+	case domain.ProviderModeDraining:
+		if operation == domain.OperationCharge {
+			return nil, fmt.Errorf("%w: %s is draining", domain.ErrProviderUnavailable, providerID)
+		}
+	case domain.ProviderModeForcedClosed:
+		return fn()
+	}
+	return b.get(providerID).Execute(fn)
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+// bulkheads holds one bulkhead per provider, so a slow provider can only
+// tie up its own share of the gateway's goroutines and connections.
+type bulkheads struct {
+	mutex  sync.Mutex
+	config *config.Config
+	byID   map[string]*bulkhead
+}
+
+func newBulkheads(cfg *config.Config) *bulkheads {
+	return &bulkheads{config: cfg, byID: make(map[string]*bulkhead)}
+}
+
+// get returns the bulkhead of a provider, or nil if it has none.
+func (b *bulkheads) get(providerID string) *bulkhead {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if bulkhead, ok := b.byID[providerID]; ok {
+		return bulkhead
+	}
+	cfg := b.config.GetBulkhead(providerID)
+	var bulkhead *bulkhead
+	if cfg.MaxConcurrent > 0 {
+		bulkhead = newBulkhead(cfg)
+	}
+	b.byID[providerID] = bulkhead
+	return bulkhead
+}
+
+// execute runs fn once the provider has a free slot, or returns
+// ErrProviderUnavailable if its bulkhead and queue are full.
+func (b *bulkheads) execute(providerID string, fn func() (interface{}, error)) (interface{}, error) {
+	bulkhead := b.get(providerID)
+	if bulkhead == nil {
+		return fn()
+	}
+	if !bulkhead.acquire() {
+		log.Printf("[provider: %s] bulkhead full, call rejected", providerID)
+		return nil, fmt.Errorf("%w: %s bulkhead is full", domain.ErrProviderUnavailable, providerID)
+	}
+	defer bulkhead.release()
+	return fn()
+}
+
+func (b *bulkheads) stats(providerID string) *domain.BulkheadStats {
+	bulkhead := b.get(providerID)
+	if bulkhead == nil {
+		return nil
+	}
+	return bulkhead.stats()
+}
+
+type bulkhead struct {
+	mutex    sync.Mutex
+	slots    chan struct{}
+	queued   int
+	rejected int64
+	maxQueue int
+	timeout  time.Duration
+}
+
+func newBulkhead(cfg config.BulkheadConfig) *bulkhead {
+	return &bulkhead{
+		slots:    make(chan struct{}, cfg.MaxConcurrent),
+		maxQueue: cfg.MaxQueue,
+		timeout:  time.Duration(cfg.QueueTimeoutMs) * time.Millisecond,
+	}
+}
+
+// acquire takes a slot, waiting in the queue while there is room in it.
+func (b *bulkhead) acquire() bool {
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	b.mutex.Lock()
+	if b.queued >= b.maxQueue {
+		b.rejected++
+		b.mutex.Unlock()
+		return false
+	}
+	b.queued++
+	b.mutex.Unlock()
+	defer func() {
+		b.mutex.Lock()
+		b.queued--
+		b.mutex.Unlock()
+	}()
+
+	var expired <-chan time.Time
+	if b.timeout > 0 {
+		timer := time.NewTimer(b.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return true
+	case <-expired:
+		b.mutex.Lock()
+		b.rejected++
+		b.mutex.Unlock()
+		return false
+	}
+}
+
+func (b *bulkhead) release() {
+	<-b.slots
+}
+
+func (b *bulkhead) stats() *domain.BulkheadStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return &domain.BulkheadStats{
+		InFlight:      len(b.slots),
+		Queued:        b.queued,
+		MaxConcurrent: cap(b.slots),
+		MaxQueue:      b.maxQueue,
+		Rejected:      b.rejected,
+	}
+}
+
+// execute runs an operation at a provider through its bulkhead and then its
+// circuit breaker, so calls shed by the bulkhead don't trip the breaker.
+func (s *PaymentService) execute(providerID string, operation domain.OperationType, fn func() (interface{}, error)) (interface{}, error) {
+	return s.bulkheads.execute(providerID, func() (interface{}, error) {
+		return s.breakers.execute(providerID, operation, fn)
+	})
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"desafio-api/internal/domain"
+)
+
+// ProviderTransaction returns the transaction of the charge chargeID made
+// at the provider, as the provider refers to it in its webhooks.
+func (s *PaymentService) ProviderTransaction(providerID string, chargeID string) (*domain.Transaction, error) {
+	transaction, err := s.transactions.Get(chargeID)
+	if err == nil && transaction.ProviderID == providerID && providerPaymentID(transaction) == chargeID {
+		return transaction, nil
+	}
+	if err != nil && !errors.Is(err, domain.ErrPaymentNotFound) {
+		return nil, err
+	}
+
+	// Payments resolved after an unknown outcome have another ID at the
+	// provider
+	cursor := ""
+	for {
+		transactions, next, err := s.transactions.List(domain.PaymentFilter{ProviderID: providerID, Cursor: cursor})
+		if err != nil {
+			return nil, err
+		}
+		for _, transaction := range transactions {
+			if transaction.ProviderPaymentID == chargeID {
+				return transaction, nil
+			}
+		}
+		if next == "" {
+			return nil, fmt.Errorf("%w: %s", domain.ErrPaymentNotFound, chargeID)
+		}
+		cursor = next
+	}
+}
+
+// DisputePayment puts a payment in disputed status until CloseDispute is
+// called. Only captured money can be disputed: the payment must be
+// authorized, or refunded with part of its amount left, and amount at most
+// its current amount.
+func (s *PaymentService) DisputePayment(actor domain.Actor, paymentID string, amount float64) (*domain.Transaction, error) {
+	transaction, err := s.transactions.Get(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	status := transaction.Payment.Status
+	if status != domain.StatusAuthorized && (status != domain.StatusRefunded || transaction.Payment.CurrentAmount <= 0) {
+		return nil, fmt.Errorf("%w: payment cannot be disputed: status is %s", domain.ErrInvalidStatus, status)
+	}
+	if amount > transaction.Payment.CurrentAmount {
+		return nil, fmt.Errorf("%w: amount above the %.2f left in the payment", domain.ErrInvalidDispute, transaction.Payment.CurrentAmount)
+	}
+
+	before := snapshot(transaction)
+	payment := *transaction.Payment
+	payment.Status = domain.StatusDisputed
+	transaction.Payment = &payment
+	transaction.DisputedFrom = status
+	if err := s.saveTransaction(actor, domain.AuditPaymentDisputed, before, transaction); err != nil {
+		return nil, fmt.Errorf("failed to store transaction: %w", err)
+	}
+	return transaction, nil
+}
+
+// CloseDispute takes a payment out of disputed status, back to the status
+// it had. A lost dispute charges amount back, and the payment is refunded
+// once nothing is left of it.
+func (s *PaymentService) CloseDispute(actor domain.Actor, paymentID string, won bool, amount float64) (*domain.Payment, error) {
+	transaction, err := s.transactions.Get(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if transaction.Payment.Status != domain.StatusDisputed {
+		return nil, fmt.Errorf("%w: payment is not disputed: status is %s", domain.ErrInvalidStatus, transaction.Payment.Status)
+	}
+
+	before := snapshot(transaction)
+	payment := *transaction.Payment
+	payment.Status = transaction.DisputedFrom
+	if payment.Status == "" {
+		payment.Status = domain.StatusAuthorized
+	}
+	action := domain.AuditPaymentReinstated
+	if !won {
+		payment.CurrentAmount = math.Max(math.Round((payment.CurrentAmount-amount)*100)/100, 0)
+		if payment.CurrentAmount == 0 {
+			payment.Status = domain.StatusRefunded
+		}
+		action = domain.AuditPaymentChargedBack
+	}
+	transaction.Payment = &payment
+	transaction.DisputedFrom = ""
+	if err := s.saveTransaction(actor, action, before, transaction); err != nil {
+		return nil, fmt.Errorf("failed to store transaction: %w", err)
+	}
+	return &payment, nil
+}
@@ -0,0 +1,237 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+
+	"desafio-api/internal/domain"
+)
+
+// minHedgeSamples is how many calls a provider needs before its observed
+// p95 latency is trusted as the hedging threshold.
+const minHedgeSamples = 20
+
+// hedgePartner returns the provider that hedges the charge sent to the
+// route at index i, or nil when hedging doesn't apply. Both providers must
+// be idempotent, so the losing charge can be found, accept refunds, which
+// is how it is voided, and charge the same amount in the same currency.
+func (s *PaymentService) hedgePartner(routes []route, i int) domain.PaymentProvider {
+	if !s.config.Hedging.Enabled || i+1 >= len(routes) {
+		return nil
+	}
+	if routes[i].request.Currency != routes[i+1].request.Currency || routes[i].request.Amount != routes[i+1].request.Amount {
+		return nil
+	}
+	primary, secondary := routes[i].provider, routes[i+1].provider
+	if !s.idempotent(primary.GetID()) || !s.idempotent(secondary.GetID()) {
+		return nil
+	}
+	if !primary.Capabilities().Supports(domain.OperationRefund) || !secondary.Capabilities().Supports(domain.OperationRefund) {
+		return nil
+	}
+	if !s.breakers.available(secondary.GetID()) {
+		return nil
+	}
+	return secondary
+}
+
+func (s *PaymentService) idempotent(providerID string) bool {
+	for _, p := range s.config.Providers {
+		if p.ID == providerID {
+			return p.Idempotent
+		}
+	}
+	return false
+}
+
+// hedgeThreshold is how long the charge waits for provider before it is
+// also sent to the secondary.
+func (s *PaymentService) hedgeThreshold(provider domain.PaymentProvider) time.Duration {
+	if threshold := s.config.GetHedgingThreshold(); threshold > 0 {
+		return threshold
+	}
+
+	threshold := s.config.GetHedgingMaxThreshold()
+	if s.health != nil {
+		if stats := s.health.Stats(provider.GetID()); stats.Samples >= minHedgeSamples {
+			threshold = time.Duration(stats.LatencyP95Ms * float64(time.Millisecond))
+		}
+	}
+	if min := s.config.GetHedgingMinThreshold(); threshold < min {
+		threshold = min
+	}
+	if max := s.config.GetHedgingMaxThreshold(); max > 0 && threshold > max {
+		threshold = max
+	}
+	return threshold
+}
+
+// hedgedCharge sends the charge to primary and, if it hasn't answered
+// within the threshold, to secondary too. The first provider to authorize
+// it, or ask for authentication, wins and comes first in the results; a
+// decline doesn't win, since the other provider may still authorize. The
+// other one is voided in the background once it answers, so only one
+// authorization survives. Its attempts are added to the winning payment
+// once its ID is sent on stored. Without a winner, unknown outcomes come
+// before declines and errors. hedged reports whether the secondary was tried.
+func (s *PaymentService) hedgedCharge(operation domain.InFlightOperation, primary, secondary domain.PaymentProvider, request domain.PaymentRequest, tracker *attempts, stored <-chan string) (results []chargeResult, hedged bool) {
+	done := make(chan hedgeParticipant, 2)
+	start := func(provider domain.PaymentProvider) {
+		own := &attempts{}
+		go func() {
+			done <- hedgeParticipant{result: s.chargeProvider(provider, request, own), tracker: own}
+		}()
+	}
+
+	start(primary)
+	timer := time.NewTimer(s.hedgeThreshold(primary))
+	defer timer.Stop()
+	select {
+	case finished := <-done:
+		tracker.merge(finished.tracker)
+		return []chargeResult{finished.result}, false
+	case <-timer.C:
+	}
+
+	log.Printf("[provider: %s] no answer within the hedging threshold, also charging %s", primary.GetName(), secondary.GetName())
+	operation.ProviderID = secondary.GetID()
+	hedgeID := s.inFlight.start(operation)
+	defer s.inFlight.finish(hedgeID)
+	start(secondary)
+
+	var failures []hedgeParticipant
+	for pending := 2; pending > 0; pending-- {
+		finished := <-done
+		if !hedgeWinner(finished.result) {
+			failures = append(failures, finished)
+			continue
+		}
+
+		// The winner's attempts are stored with it, the loser's are added later
+		tracker.merge(finished.tracker)
+		losers := make(chan hedgeParticipant, 2)
+		for _, failed := range failures {
+			losers <- failed
+		}
+		if pending == 2 {
+			loser := primary
+			if finished.result.provider == primary {
+				loser = secondary
+			}
+			operation.ProviderID = loser.GetID()
+			loserID := s.inFlight.start(operation)
+			go func() {
+				defer s.inFlight.finish(loserID)
+				losers <- <-done
+				close(losers)
+			}()
+		} else {
+			close(losers)
+		}
+		go s.settleHedgeLosers(losers, request, stored)
+		return []chargeResult{finished.result}, true
+	}
+
+	// Only the first unknown outcome is kept as a payment, the others are voided
+	sort.SliceStable(failures, func(i, j int) bool {
+		return errors.Is(failures[i].result.err, errOutcomeUnresolved) && !errors.Is(failures[j].result.err, errOutcomeUnresolved)
+	})
+	for i, failed := range failures {
+		tracker.merge(failed.tracker)
+		results = append(results, failed.result)
+		if i > 0 && errors.Is(failed.result.err, errOutcomeUnresolved) && errors.Is(failures[0].result.err, errOutcomeUnresolved) {
+			go s.voidHedgeLoser(failed.result, request, &attempts{})
+		}
+	}
+	return results, true
+}
+
+type hedgeParticipant struct {
+	result  chargeResult
+	tracker *attempts
+}
+
+func hedgeWinner(result chargeResult) bool {
+	return result.err == nil && (result.payment.Status == domain.StatusAuthorized || result.payment.Status == domain.StatusRequiresAction)
+}
+
+// settleHedgeLosers voids the charges of the providers that lost a hedge
+// and adds their attempts to the winning payment.
+func (s *PaymentService) settleHedgeLosers(losers <-chan hedgeParticipant, request domain.PaymentRequest, stored <-chan string) {
+	late := &attempts{}
+	for lost := range losers {
+		s.voidHedgeLoser(lost.result, request, lost.tracker)
+		late.merge(lost.tracker)
+	}
+
+	paymentID, ok := <-stored
+	if !ok {
+		return
+	}
+	transaction, err := s.transactions.Get(paymentID)
+	if err != nil {
+		log.Printf("failed to load payment %s to store the attempts of the hedge: %v", paymentID, err)
+		return
+	}
+	s.saveAttempts(transaction, late)
+}
+
+// voidHedgeLoser voids the charge of the provider that lost a hedged
+// charge. A charge whose outcome is unknown is looked up again first.
+// Providers have no void of their own: charges are captured when
+// authorized, so the void is a refund of the whole amount.
+func (s *PaymentService) voidHedgeLoser(result chargeResult, request domain.PaymentRequest, tracker *attempts) {
+	provider, payment := result.provider, result.payment
+	switch {
+	case result.err == nil:
+	case errors.Is(result.err, errOutcomeUnresolved):
+		err := s.retry(provider, domain.OperationLookup, func() error {
+			var err error
+			payment, err = s.call(provider, domain.OperationLookup, tracker, func() (*domain.Payment, error) {
+				return provider.FindPaymentByReference(request.Reference)
+			})
+			if errors.Is(err, domain.ErrPaymentNotFound) {
+				return retry.Unrecoverable(err)
+			}
+			return err
+		})
+		if errors.Is(err, domain.ErrPaymentNotFound) {
+			return
+		}
+		if err != nil {
+			log.Printf("[provider: %s] hedged charge %s may still be authorized, lookup failed: %v", provider.GetName(), request.Reference, err)
+			return
+		}
+	default:
+		// The provider didn't charge
+		return
+	}
+
+	if payment.Status != domain.StatusAuthorized && payment.Status != domain.StatusRequiresAction {
+		return
+	}
+
+	log.Printf("[provider: %s] voiding charge %s that lost the hedge", provider.GetName(), payment.ID)
+	_, err := s.execute(provider.GetID(), domain.OperationVoid, func() (interface{}, error) {
+		var voided *domain.Payment
+		err := s.retry(provider, domain.OperationVoid, func() error {
+			var err error
+			voided, err = s.call(provider, domain.OperationVoid, tracker, func() (*domain.Payment, error) {
+				return provider.RefundPayment(payment.ID, domain.RefundRequest{Amount: payment.OriginalAmount})
+			})
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("[provider: %s] failed after retries: %w", provider.GetName(), err)
+		}
+		return voided, nil
+	})
+	if err != nil {
+		log.Printf("[provider: %s] failed to void charge %s that lost the hedge: %v", provider.GetName(), payment.ID, err)
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"desafio-api/internal/domain"
+)
+
+// inFlight tracks the provider calls that are running, so the ones that
+// can't finish during shutdown can be recorded as unknown outcomes.
+type inFlight struct {
+	mutex      sync.Mutex
+	operations map[string]domain.InFlightOperation
+}
+
+func newInFlight() *inFlight {
+	return &inFlight{operations: make(map[string]domain.InFlightOperation)}
+}
+
+func (f *inFlight) start(operation domain.InFlightOperation) string {
+	operation.ID = uuid.New().String()
+	operation.StartedAt = time.Now()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.operations[operation.ID] = operation
+	return operation.ID
+}
+
+// setProvider records which provider the operation is currently sent to.
+func (f *inFlight) setProvider(id string, providerID string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if operation, exists := f.operations[id]; exists {
+		operation.ProviderID = providerID
+		f.operations[id] = operation
+	}
+}
+
+func (f *inFlight) finish(id string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.operations, id)
+}
+
+func (f *inFlight) list() []domain.InFlightOperation {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	operations := make([]domain.InFlightOperation, 0, len(f.operations))
+	for _, operation := range f.operations {
+		operations = append(operations, operation)
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		return operations[i].StartedAt.Before(operations[j].StartedAt)
+	})
+	return operations
+}
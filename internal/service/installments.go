@@ -0,0 +1,35 @@
+package service
+
+import (
+	"time"
+
+	"desafio-api/internal/domain"
+)
+
+type InstallmentCalculator interface {
+	Plan(amount float64, currency string, count int, start time.Time) (*domain.InstallmentPlan, error)
+}
+
+// WithInstallments computes the installment plan of payments split in more
+// than one installment. Without it card.installments is only passed on to
+// the providers.
+func WithInstallments(calculator InstallmentCalculator) Option {
+	return func(s *PaymentService) {
+		s.installments = calculator
+	}
+}
+
+// planInstallments returns the request with its installment plan and the
+// amount to charge, which includes the merchant's interest if any.
+func (s *PaymentService) planInstallments(request domain.PaymentRequest) (domain.PaymentRequest, error) {
+	if s.installments == nil || request.Card.Installments <= 1 {
+		return request, nil
+	}
+	plan, err := s.installments.Plan(request.Amount, request.Currency, request.Card.Installments, time.Now())
+	if err != nil {
+		return request, err
+	}
+	request.InstallmentPlan = plan
+	request.Amount = plan.ChargeAmount()
+	return request, nil
+}
@@ -1,153 +1,344 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"time"
 
-	"github.com/avast/retry-go/v4"
-	"github.com/sony/gobreaker"
+	"github.com/google/uuid"
 
 	"desafio-api/internal/config"
 	"desafio-api/internal/domain"
+	"desafio-api/internal/webhook"
 )
 
 type PaymentService struct {
-	providers      []domain.PaymentProvider
-	circuitBreaker *gobreaker.CircuitBreaker
-	transactions   map[string]*domain.Transaction
-	config         *config.Config
+	providers    []domain.PaymentProvider
+	breakers     *breakers
+	retryBudget  *retryBudget
+	bulkheads    *bulkheads
+	transactions domain.TransactionStore
+	risk         RiskEvaluator
+	health       HealthMonitor
+	audit        domain.AuditLog
+	jobs         domain.JobQueue
+	vault        CardVault
+	sealer       CardSealer
+	callbacks    *http.Client
+	installments InstallmentCalculator
+	fx           domain.FXRateProvider
+	inFlight     *inFlight
+	config       *config.Config
 }
 
-func NewPaymentService(providers []domain.PaymentProvider, cfg *config.Config) *PaymentService {
+type HealthMonitor interface {
+	Record(providerID string, latency time.Duration, err error)
+	Stats(providerID string) domain.ProviderStats
+}
+
+type RiskEvaluator interface {
+	Evaluate(merchantID string, request domain.PaymentRequest) domain.RiskAssessment
+	RecordOutcome(merchantID string, request domain.PaymentRequest, approved bool)
+}
+
+// Option configures optional collaborators of the PaymentService.
+type Option func(*PaymentService)
+
+// WithRiskEvaluator scores every payment before it is sent to a provider.
+func WithRiskEvaluator(evaluator RiskEvaluator) Option {
+	return func(s *PaymentService) {
+		s.risk = evaluator
+	}
+}
+
+// WithHealthMonitor records the outcome and latency of every provider call.
+func WithHealthMonitor(monitor HealthMonitor) Option {
+	return func(s *PaymentService) {
+		s.health = monitor
+	}
+}
+
+func NewPaymentService(providers []domain.PaymentProvider, transactions domain.TransactionStore, cfg *config.Config, opts ...Option) *PaymentService {
 	if len(providers) == 0 {
 		panic("At least one payment provider is required")
 	}
 
-	settings := gobreaker.Settings{
-		Name:        "payment-provider",
-		MaxRequests: cfg.CircuitBreaker.MaxRequests,
-		Interval:    cfg.GetCircuitBreakerInterval(),
-		Timeout:     cfg.GetCircuitBreakerTimeout(),
-		ReadyToTrip: func(counts gobreaker.Counts) bool {
-			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
-			return counts.Requests >= cfg.CircuitBreaker.MinRequests && failureRatio >= cfg.CircuitBreaker.FailureRatio
-		},
-		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
-			log.Printf("Circuit breaker state changed from %v to %v", from, to)
-		},
+	s := &PaymentService{
+		providers:    providers,
+		breakers:     newBreakers(cfg),
+		retryBudget:  newRetryBudget(cfg),
+		bulkheads:    newBulkheads(cfg),
+		transactions: transactions,
+		inFlight:     newInFlight(),
+		callbacks:    webhook.NewClient(cfg.GetAsyncCallbackTimeout()),
+		config:       cfg,
 	}
-
-	return &PaymentService{
-		providers:      providers,
-		circuitBreaker: gobreaker.NewCircuitBreaker(settings),
-		transactions:   make(map[string]*domain.Transaction),
-		config:         cfg,
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
+}
+
+func (s *PaymentService) ProcessPayment(actor domain.Actor, request domain.PaymentRequest) (*domain.Payment, error) {
+	return s.processPayment(actor, request, nil)
 }
 
-func (s *PaymentService) ProcessPayment(request domain.PaymentRequest) (*domain.Payment, error) {
+// processPayment charges the request. pending is the stored payment of an
+// async charge, which the result replaces; it is nil for synchronous ones.
+func (s *PaymentService) processPayment(actor domain.Actor, request domain.PaymentRequest, pending *domain.Transaction) (*domain.Payment, error) {
+	merchantID := actor.MerchantID
+	request, err := s.planInstallments(request)
+	if err != nil {
+		return nil, err
+	}
+	if request.Reference == "" {
+		request.Reference = uuid.New().String()
+	}
+	routes, err := s.routes(request)
+	if err != nil {
+		return nil, err
+	}
+
+	var assessment *domain.RiskAssessment
+	if s.risk != nil {
+		result := s.risk.Evaluate(merchantID, request)
+		assessment = &result
+		if result.Decision == domain.RiskReject {
+			return nil, s.rejectPayment(actor, request, result, pending)
+		}
+		if result.Decision == domain.RiskReview {
+			log.Printf("payment flagged for review: score %d, reasons %v", result.Score, result.Reasons)
+		}
+	}
+
+	operation := domain.InFlightOperation{
+		Type:       domain.OperationCharge,
+		MerchantID: merchantID,
+		Reference:  request.Reference,
+		Amount:     request.Amount,
+		Currency:   request.Currency,
+		CardLast4:  request.Card.Last4(),
+	}
+	operationID := s.inFlight.start(operation)
+	defer s.inFlight.finish(operationID)
+
+	tracker := &attempts{}
+	// Receives the ID of the stored payment for the losers of a hedge
+	stored := make(chan string, 1)
+	defer close(stored)
+
 	var lastErr error
-	for _, provider := range s.providers {
+	for i := 0; i < len(routes); i++ {
+		// Converted to the provider's currency if needed
+		provider, request := routes[i].provider, routes[i].request
 		log.Printf("[provider: %s] attempting to process payment", provider.GetName())
+		s.inFlight.setProvider(operationID, provider.GetID())
 
-		result, err := s.circuitBreaker.Execute(func() (interface{}, error) {
-			var payment *domain.Payment
-			err := retry.Do(
-				func() error {
-					var err error
-					payment, err = provider.ProcessPayment(request)
-					if err != nil {
-						log.Printf("[provider: %s] attempt failed: %v", provider.GetName(), err)
-						return err
-					}
-					return nil
-				},
-				retry.Attempts(uint(s.config.Retry.Attempts)),
-				retry.Delay(s.config.GetRetryDelay()),
-				retry.OnRetry(func(n uint, err error) {
-					log.Printf("[provider: %s] retry %d: %v", provider.GetName(), n+1, err)
-				}),
-			)
-			if err != nil {
-				return nil, fmt.Errorf("[provider: %s] failed after retries: %w", provider.GetName(), err)
+		var results []chargeResult
+		if secondary := s.hedgePartner(routes, i); secondary != nil {
+			var hedged bool
+			results, hedged = s.hedgedCharge(operation, provider, secondary, request, tracker, stored)
+			if hedged {
+				// Both providers were tried, the fallback goes on after the secondary
+				i++
 			}
-			return payment, nil
-		})
-
-		payment, ok := result.(*domain.Payment)
+		} else {
+			results = []chargeResult{s.chargeProvider(provider, request, tracker)}
+		}
 
-		if err != nil {
-			log.Printf("[provider: %s] failed: %v", provider.GetName(), err)
-			if ok && payment != nil {
-				payment.Status = domain.StatusFailed
-				failedProviderID := provider.GetID()
-				transaction := &domain.Transaction{
-					Payment:      payment,
-					ProviderID:   failedProviderID,
-					ProviderName: provider.GetName(),
+		for _, result := range results {
+			provider, payment, err := result.provider, result.payment, result.err
+			if err != nil {
+				log.Printf("[provider: %s] failed: %v", provider.GetName(), err)
+				// Falling back could charge the customer twice
+				if errors.Is(err, errOutcomeUnresolved) {
+					return s.unknownPayment(actor, provider, request, assessment, tracker, pending)
 				}
-				s.transactions[payment.ID] = transaction
+				if payment != nil {
+					payment.Status = domain.StatusFailed
+					payment.Installments = request.InstallmentPlan
+					payment.FX = request.FX
+					failedProviderID := provider.GetID()
+					transaction := &domain.Transaction{
+						Payment:           payment,
+						MerchantID:        merchantID,
+						ProviderID:        failedProviderID,
+						ProviderName:      provider.GetName(),
+						ProviderPaymentID: payment.ID,
+						Reference:         request.Reference,
+						Risk:              assessment,
+						Attempts:          tracker.snapshot(),
+						FX:                request.FX,
+						CardFingerprint:   request.Card.Fingerprint(),
+					}
+					if err := s.storeCharge(actor, pending, transaction); err != nil {
+						log.Printf("[provider: %s] failed to store transaction: %v", provider.GetName(), err)
+					}
+				}
+				lastErr = err
+				continue
+			}
+
+			log.Printf("[provider: %s] payment successfully processed", provider.GetName())
+			payment.Installments = request.InstallmentPlan
+			payment.FX = request.FX
+			successProviderID := provider.GetID()
+			transaction := &domain.Transaction{
+				Payment:           payment,
+				MerchantID:        merchantID,
+				ProviderID:        successProviderID,
+				ProviderName:      provider.GetName(),
+				ProviderPaymentID: payment.ID,
+				Reference:         request.Reference,
+				Risk:              assessment,
+				Attempts:          tracker.snapshot(),
+				FX:                request.FX,
+				CardFingerprint:   request.Card.Fingerprint(),
+			}
+			if err := s.storeCharge(actor, pending, transaction); err != nil {
+				return nil, fmt.Errorf("%w: %w", domain.ErrNotStored, err)
 			}
-			lastErr = err
-			continue
+			stored <- payment.ID
+			if s.risk != nil && payment.Status != domain.StatusRequiresAction {
+				s.risk.RecordOutcome(merchantID, request, payment.Status == domain.StatusAuthorized)
+			}
+			return payment, nil
 		}
+	}
+
+	return nil, fmt.Errorf("all providers failed, last error: %w", lastErr)
+}
 
-		log.Printf("[provider: %s] payment successfully processed", provider.GetName())
-		successProviderID := provider.GetID()
-		transaction := &domain.Transaction{
-			Payment:      payment,
-			ProviderID:   successProviderID,
-			ProviderName: provider.GetName(),
+type chargeResult struct {
+	provider domain.PaymentProvider
+	payment  *domain.Payment
+	err      error
+}
+
+// chargeProvider sends the charge to one provider through its breaker,
+// retrying failures and looking up charges whose outcome is unknown.
+func (s *PaymentService) chargeProvider(provider domain.PaymentProvider, request domain.PaymentRequest, tracker *attempts) chargeResult {
+	result, err := s.execute(provider.GetID(), domain.OperationCharge, func() (interface{}, error) {
+		var payment *domain.Payment
+		err := s.retry(provider, domain.OperationCharge, func() error {
+			var err error
+			payment, err = s.call(provider, domain.OperationCharge, tracker, func() (*domain.Payment, error) {
+				return provider.ProcessPayment(request)
+			})
+			if errors.Is(err, domain.ErrOutcomeUnknown) {
+				payment, err = s.resolveCharge(provider, tracker, request.Reference, err)
+			}
+			if err != nil {
+				log.Printf("[provider: %s] attempt failed: %v", provider.GetName(), err)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("[provider: %s] failed after retries: %w", provider.GetName(), err)
 		}
-		s.transactions[payment.ID] = transaction
 		return payment, nil
+	})
+
+	payment, ok := result.(*domain.Payment)
+	if ok && payment != nil {
+		payment.CardLast4 = request.Card.Last4()
+	} else {
+		payment = nil
 	}
+	return chargeResult{provider: provider, payment: payment, err: err}
+}
 
-	return nil, fmt.Errorf("all providers failed, last error: %w", lastErr)
+// rejectPayment stores the rejected payment so it can be searched, without
+// ever contacting a provider.
+func (s *PaymentService) rejectPayment(actor domain.Actor, request domain.PaymentRequest, assessment domain.RiskAssessment, pending *domain.Transaction) error {
+	payment := &domain.Payment{
+		ID:             uuid.New().String(),
+		CreatedAt:      assessment.EvaluatedAt,
+		Status:         domain.StatusRejected,
+		OriginalAmount: request.Amount,
+		CurrentAmount:  request.Amount,
+		Currency:       request.Currency,
+		Description:    request.Description,
+		PaymentMethod:  "card",
+		CardLast4:      request.Card.Last4(),
+		Installments:   request.InstallmentPlan,
+	}
+	transaction := &domain.Transaction{
+		Payment:    payment,
+		MerchantID: actor.MerchantID,
+		Risk:       &assessment,
+	}
+	if err := s.storeCharge(actor, pending, transaction); err != nil {
+		log.Printf("failed to store rejected payment %s: %v", payment.ID, err)
+	}
+	log.Printf("payment %s rejected by risk assessment: score %d, reasons %v", payment.ID, assessment.Score, assessment.Reasons)
+	return &domain.RiskRejectedError{PaymentID: payment.ID, Assessment: assessment}
 }
 
-func (s *PaymentService) RefundPayment(paymentID string, request domain.RefundRequest) (*domain.Payment, error) {
-	transaction, exists := s.transactions[paymentID]
-	if !exists {
-		return nil, fmt.Errorf("payment not found: %s", paymentID)
+func (s *PaymentService) RefundPayment(actor domain.Actor, paymentID string, request domain.RefundRequest) (*domain.Payment, error) {
+	transaction, err := s.merchantTransaction(actor.MerchantID, paymentID)
+	if err != nil {
+		return nil, err
 	}
+	before := snapshot(transaction)
 
 	if transaction.Payment.Status != domain.StatusAuthorized {
 		return nil, fmt.Errorf("payment cannot be refunded: status is %s", transaction.Payment.Status)
 	}
-
-	var provider domain.PaymentProvider
-	for _, p := range s.providers {
-		if p.GetID() == transaction.ProviderID {
-			provider = p
-			break
-		}
+	if request.Card != nil && (transaction.CardFingerprint == "" || request.Card.Fingerprint() != transaction.CardFingerprint) {
+		return nil, fmt.Errorf("%w: not the card of the payment", domain.ErrInvalidCard)
 	}
 
-	if provider == nil {
-		return nil, fmt.Errorf("provider not found: %s", transaction.ProviderID)
+	provider, err := s.providerByID(transaction.ProviderID)
+	if err != nil {
+		return nil, err
+	}
+	capabilities := provider.Capabilities()
+	if !capabilities.Supports(domain.OperationRefund) {
+		return nil, fmt.Errorf("%w: %s doesn't support refunds", domain.ErrOperationNotSupported, provider.GetName())
+	}
+	partial := request.Amount > 0 && request.Amount < transaction.Payment.CurrentAmount
+	if partial && !capabilities.Supports(domain.OperationPartialRefund) {
+		return nil, fmt.Errorf("%w: %s doesn't support partial refunds", domain.ErrOperationNotSupported, provider.GetName())
+	}
+	// Without an amount the whole payment is refunded, and providers are
+	// always sent the amount
+	if request.Amount <= 0 {
+		request.Amount = transaction.Payment.CurrentAmount
 	}
 
 	log.Printf("[provider: %s] attempting to refund payment", provider.GetName())
+	operationID := s.inFlight.start(domain.InFlightOperation{
+		Type:       domain.OperationRefund,
+		MerchantID: actor.MerchantID,
+		PaymentID:  paymentID,
+		ProviderID: provider.GetID(),
+		Amount:     request.Amount,
+		Currency:   transaction.Payment.Currency,
+		CardLast4:  transaction.Payment.CardLast4,
+	})
+	defer s.inFlight.finish(operationID)
 
-	result, err := s.circuitBreaker.Execute(func() (interface{}, error) {
+	tracker := &attempts{}
+	result, err := s.execute(provider.GetID(), domain.OperationRefund, func() (interface{}, error) {
 		var payment *domain.Payment
-		err := retry.Do(
-			func() error {
-				var err error
-				payment, err = provider.RefundPayment(paymentID, request)
-				if err != nil {
-					log.Printf("[provider: %s] attempt failed: %v", provider.GetName(), err)
-					return err
-				}
-				return nil
-			},
-			retry.Attempts(uint(s.config.Retry.Attempts)),
-			retry.Delay(s.config.GetRetryDelay()),
-			retry.OnRetry(func(n uint, err error) {
-				log.Printf("[provider: %s] retry %d: %v", provider.GetName(), n+1, err)
-			}),
-		)
+		err := s.retry(provider, domain.OperationRefund, func() error {
+			var err error
+			payment, err = s.call(provider, domain.OperationRefund, tracker, func() (*domain.Payment, error) {
+				return provider.RefundPayment(providerPaymentID(transaction), domain.RefundRequest{Amount: request.Amount})
+			})
+			if errors.Is(err, domain.ErrOutcomeUnknown) {
+				payment, err = s.resolveRefund(provider, tracker, transaction, err)
+			}
+			if err != nil {
+				log.Printf("[provider: %s] attempt failed: %v", provider.GetName(), err)
+				return err
+			}
+			return nil
+		})
 		if err != nil {
 			return nil, fmt.Errorf("[provider: %s] failed after retries: %w", provider.GetName(), err)
 		}
@@ -159,22 +350,265 @@ func (s *PaymentService) RefundPayment(paymentID string, request domain.RefundRe
 		log.Printf("[provider: %s] failed: %v", provider.GetName(), err)
 
 		if ok && payment != nil {
+			payment.ID = transaction.Payment.ID
 			payment.Status = domain.StatusFailed
+			payment.CardLast4 = transaction.Payment.CardLast4
 			transaction.Payment = payment
+			transaction.Attempts = append(transaction.Attempts, tracker.list...)
+			if err := s.saveTransaction(actor, domain.AuditPaymentRefundFailed, before, transaction); err != nil {
+				log.Printf("[provider: %s] failed to store transaction: %v", provider.GetName(), err)
+			}
+		} else if s.refundFallback(err) {
+			return s.fallbackRefund(actor, before, transaction, provider, request, tracker, err)
+		} else {
+			s.saveAttempts(transaction, tracker)
 		}
 		return nil, err
 	}
 
 	log.Printf("[provider: %s] refund successfully processed", provider.GetName())
+	now := time.Now()
+	transaction.Refunds = append(transaction.Refunds, domain.Refund{
+		ID:           uuid.New().String(),
+		Method:       domain.RefundMethodRefund,
+		Status:       domain.RefundSucceeded,
+		Amount:       request.Amount,
+		Currency:     transaction.Payment.Currency,
+		ProviderID:   provider.GetID(),
+		ProviderName: provider.GetName(),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	})
+	payment.ID = transaction.Payment.ID
 	payment.Status = domain.StatusRefunded
+	payment.CardLast4 = transaction.Payment.CardLast4
 	transaction.Payment = payment
+	transaction.Attempts = append(transaction.Attempts, tracker.list...)
+	if err := s.saveTransaction(actor, domain.AuditPaymentRefunded, before, transaction); err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrNotStored, err)
+	}
 	return payment, nil
 }
 
-func (s *PaymentService) GetPayment(paymentID string) (*domain.Payment, error) {
-	transaction, exists := s.transactions[paymentID]
-	if !exists {
-		return nil, fmt.Errorf("payment not found: %s", paymentID)
+// ConfirmPayment resumes a payment in requires_action status once the
+// customer completed the step-up authentication. It always goes to the
+// provider that started the authentication.
+func (s *PaymentService) ConfirmPayment(actor domain.Actor, paymentID string, request domain.ConfirmRequest) (*domain.Payment, error) {
+	transaction, err := s.merchantTransaction(actor.MerchantID, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	before := snapshot(transaction)
+
+	if transaction.Payment.Status != domain.StatusRequiresAction {
+		return nil, fmt.Errorf("%w: payment cannot be confirmed: status is %s", domain.ErrInvalidStatus, transaction.Payment.Status)
+	}
+
+	provider, err := s.providerByID(transaction.ProviderID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[provider: %s] attempting to confirm payment", provider.GetName())
+	operationID := s.inFlight.start(domain.InFlightOperation{
+		Type:       domain.OperationConfirm,
+		MerchantID: actor.MerchantID,
+		PaymentID:  paymentID,
+		ProviderID: provider.GetID(),
+		Amount:     transaction.Payment.OriginalAmount,
+		Currency:   transaction.Payment.Currency,
+		CardLast4:  transaction.Payment.CardLast4,
+	})
+	defer s.inFlight.finish(operationID)
+
+	tracker := &attempts{}
+	result, err := s.execute(provider.GetID(), domain.OperationConfirm, func() (interface{}, error) {
+		var payment *domain.Payment
+		err := s.retry(provider, domain.OperationConfirm, func() error {
+			var err error
+			payment, err = s.call(provider, domain.OperationConfirm, tracker, func() (*domain.Payment, error) {
+				return provider.ConfirmPayment(providerPaymentID(transaction), request)
+			})
+			if err != nil {
+				log.Printf("[provider: %s] attempt failed: %v", provider.GetName(), err)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("[provider: %s] failed after retries: %w", provider.GetName(), err)
+		}
+		return payment, nil
+	})
+	if err != nil {
+		log.Printf("[provider: %s] failed: %v", provider.GetName(), err)
+		s.saveAttempts(transaction, tracker)
+		return nil, err
+	}
+
+	payment := result.(*domain.Payment)
+	log.Printf("[provider: %s] confirmation processed with status %s", provider.GetName(), payment.Status)
+	payment.ID = transaction.Payment.ID
+	payment.CardLast4 = transaction.Payment.CardLast4
+	transaction.Payment = payment
+	transaction.Attempts = append(transaction.Attempts, tracker.list...)
+	if err := s.saveTransaction(actor, domain.AuditPaymentConfirmed, before, transaction); err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrNotStored, err)
+	}
+	return payment, nil
+}
+
+func (s *PaymentService) GetPayment(merchantID string, paymentID string) (*domain.Payment, error) {
+	transaction, err := s.merchantTransaction(merchantID, paymentID)
+	if err != nil {
+		return nil, err
 	}
 	return transaction.Payment, nil
 }
+
+func (s *PaymentService) ListPayments(merchantID string, filter domain.PaymentFilter) (*domain.PaymentPage, error) {
+	filter.MerchantID = merchantID
+	transactions, nextCursor, err := s.transactions.List(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &domain.PaymentPage{
+		Data:       make([]*domain.Payment, 0, len(transactions)),
+		NextCursor: nextCursor,
+		HasMore:    nextCursor != "",
+	}
+	for _, transaction := range transactions {
+		page.Data = append(page.Data, transaction.Payment)
+	}
+	return page, nil
+}
+
+// call runs a provider call, recording its outcome and latency and, with a
+// tracker, the attempt.
+func (s *PaymentService) call(provider domain.PaymentProvider, operation domain.OperationType, tracker *attempts, fn func() (*domain.Payment, error)) (*domain.Payment, error) {
+	start := time.Now()
+	payment, err := fn()
+	if tracker != nil {
+		tracker.add(provider, operation, start, payment, err)
+	}
+	if s.health != nil {
+		recorded := err
+		// The provider answered, the payment just doesn't exist there
+		if errors.Is(err, domain.ErrPaymentNotFound) {
+			recorded = nil
+		}
+		s.health.Record(provider.GetID(), time.Since(start), recorded)
+	}
+	return payment, err
+}
+
+// ProviderStatuses reports the breaker state and, with a health monitor,
+// the recent stats of every provider in fallback order.
+func (s *PaymentService) ProviderStatuses() []domain.ProviderStatus {
+	statuses := make([]domain.ProviderStatus, 0, len(s.providers))
+	for _, provider := range s.providers {
+		statuses = append(statuses, s.providerStatus(provider))
+	}
+	return statuses
+}
+
+func (s *PaymentService) providerStatus(provider domain.PaymentProvider) domain.ProviderStatus {
+	status := domain.ProviderStatus{
+		ID:           provider.GetID(),
+		Name:         provider.GetName(),
+		Mode:         s.breakers.mode(provider.GetID()),
+		Breaker:      s.breakers.get(provider.GetID()).State().String(),
+		Bulkhead:     s.bulkheads.stats(provider.GetID()),
+		Capabilities: provider.Capabilities(),
+	}
+	if s.health != nil {
+		status.ProviderStats = s.health.Stats(provider.GetID())
+	}
+	return status
+}
+
+// SetProviderMode overrides the circuit breaker of a provider. Every change
+// is audit logged with the admin that made it.
+func (s *PaymentService) SetProviderMode(actor domain.Actor, providerID string, mode domain.ProviderMode) (*domain.ProviderStatus, error) {
+	provider, err := s.providerByID(providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	before := s.providerStatus(provider)
+	previous := s.breakers.setMode(providerID, mode)
+	log.Printf("[audit] admin %s set provider %s mode from %s to %s", actor.ID, providerID, previous, mode)
+	status := s.providerStatus(provider)
+	s.record(actor, &domain.AuditEvent{
+		Action:       domain.AuditProviderModeSet,
+		ResourceType: domain.AuditResourceProvider,
+		ResourceID:   providerID,
+		Before:       snapshot(before),
+		After:        snapshot(status),
+	})
+	return &status, nil
+}
+
+// ResetProvider clears the admin mode of a provider and gives it a fresh
+// circuit breaker with zeroed counters.
+func (s *PaymentService) ResetProvider(actor domain.Actor, providerID string) (*domain.ProviderStatus, error) {
+	provider, err := s.providerByID(providerID)
+	if err != nil {
+		return nil, err
+	}
+
+	before := s.providerStatus(provider)
+	previous := s.breakers.reset(providerID)
+	log.Printf("[audit] admin %s reset provider %s (mode was %s)", actor.ID, providerID, previous)
+	status := s.providerStatus(provider)
+	s.record(actor, &domain.AuditEvent{
+		Action:       domain.AuditProviderReset,
+		ResourceType: domain.AuditResourceProvider,
+		ResourceID:   providerID,
+		Before:       snapshot(before),
+		After:        snapshot(status),
+	})
+	return &status, nil
+}
+
+// Ready reports whether payments can be processed: the store must be
+// reachable and at least one provider must take new charges.
+func (s *PaymentService) Ready() error {
+	if err := s.transactions.Ping(); err != nil {
+		return fmt.Errorf("transaction store unreachable: %w", err)
+	}
+	for _, provider := range s.providers {
+		if s.breakers.available(provider.GetID()) {
+			return nil
+		}
+	}
+	return errors.New("no provider available for charges")
+}
+
+// InFlight lists the provider calls that haven't returned yet, oldest first.
+func (s *PaymentService) InFlight() []domain.InFlightOperation {
+	return s.inFlight.list()
+}
+
+// merchantTransaction loads a transaction owned by the merchant. Payments of
+// other merchants are reported as not found so their existence isn't leaked.
+func (s *PaymentService) merchantTransaction(merchantID string, paymentID string) (*domain.Transaction, error) {
+	transaction, err := s.transactions.Get(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if transaction.MerchantID != merchantID {
+		return nil, fmt.Errorf("%w: %s", domain.ErrPaymentNotFound, paymentID)
+	}
+	return transaction, nil
+}
+
+func (s *PaymentService) providerByID(providerID string) (domain.PaymentProvider, error) {
+	for _, p := range s.providers {
+		if p.GetID() == providerID {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", domain.ErrProviderNotFound, providerID)
+}
@@ -1,15 +1,42 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/brianvoe/gofakeit/v6"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
+	"desafio-api/internal/audit"
 	"desafio-api/internal/config"
 	"desafio-api/internal/domain"
+	"desafio-api/internal/fx"
+	"desafio-api/internal/health"
+	"desafio-api/internal/installments"
+	"desafio-api/internal/jobs"
+	"desafio-api/internal/store"
+	"desafio-api/internal/vault"
+	"desafio-api/internal/webhook"
+)
+
+const testMerchantID = "merchant-test"
+
+var (
+	testActor = domain.Actor{Type: domain.ActorMerchant, ID: "key-test", MerchantID: testMerchantID}
+	testAdmin = domain.Actor{Type: domain.ActorAdmin, ID: "ops"}
 )
 
 func getTestConfig() *config.Config {
@@ -31,8 +58,21 @@ func getTestConfig() *config.Config {
 	}
 }
 
+// matchRequest matches a payment request ignoring the reference generated
+// by the service.
+func matchRequest(request domain.PaymentRequest) interface{} {
+	return mock.MatchedBy(func(actual domain.PaymentRequest) bool {
+		if actual.Reference == "" {
+			return false
+		}
+		actual.Reference = request.Reference
+		return actual == request
+	})
+}
+
 type MockProvider struct {
 	mock.Mock
+	capabilities domain.Capabilities
 }
 
 func (m *MockProvider) ProcessPayment(request domain.PaymentRequest) (*domain.Payment, error) {
@@ -51,6 +91,14 @@ func (m *MockProvider) RefundPayment(paymentID string, request domain.RefundRequ
 	return args.Get(0).(*domain.Payment), args.Error(1)
 }
 
+func (m *MockProvider) ConfirmPayment(paymentID string, request domain.ConfirmRequest) (*domain.Payment, error) {
+	args := m.Called(paymentID, request)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
 func (m *MockProvider) GetPayment(paymentID string) (*domain.Payment, error) {
 	args := m.Called(paymentID)
 	if args.Get(0) == nil {
@@ -59,6 +107,22 @@ func (m *MockProvider) GetPayment(paymentID string) (*domain.Payment, error) {
 	return args.Get(0).(*domain.Payment), args.Error(1)
 }
 
+func (m *MockProvider) FindPaymentByReference(reference string) (*domain.Payment, error) {
+	args := m.Called(reference)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
+func (m *MockProvider) Credit(request domain.CreditRequest) (*domain.Payment, error) {
+	args := m.Called(request)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Payment), args.Error(1)
+}
+
 func (m *MockProvider) GetID() string {
 	args := m.Called()
 	return args.String(0)
@@ -69,6 +133,10 @@ func (m *MockProvider) GetName() string {
 	return args.String(0)
 }
 
+func (m *MockProvider) Capabilities() domain.Capabilities {
+	return m.capabilities
+}
+
 func TestPaymentServiceRefund(t *testing.T) {
 	gofakeit.Seed(0)
 
@@ -113,14 +181,15 @@ func TestPaymentServiceRefund(t *testing.T) {
 
 		provider1.On("RefundPayment", originalPayment.ID, refundRequest).Return(refundedPayment, nil)
 
-		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, getTestConfig())
-		service.transactions[originalPayment.ID] = &domain.Transaction{
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), getTestConfig())
+		service.transactions.Save(&domain.Transaction{
 			Payment:      originalPayment,
+			MerchantID:   testMerchantID,
 			ProviderID:   "stripe",
 			ProviderName: "Stripe",
-		}
+		})
 
-		payment, err := service.RefundPayment(originalPayment.ID, refundRequest)
+		payment, err := service.RefundPayment(testActor, originalPayment.ID, refundRequest)
 
 		assert.NoError(t, err)
 		assert.Equal(t, domain.StatusRefunded, payment.Status)
@@ -130,13 +199,13 @@ func TestPaymentServiceRefund(t *testing.T) {
 
 	t.Run("refund non-existent payment", func(t *testing.T) {
 		provider := new(MockProvider)
-		service := NewPaymentService([]domain.PaymentProvider{provider}, getTestConfig())
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), getTestConfig())
 
 		refundRequest := domain.RefundRequest{
 			Amount: gofakeit.Price(50, 200),
 		}
 
-		payment, err := service.RefundPayment("non-existent", refundRequest)
+		payment, err := service.RefundPayment(testActor, "non-existent", refundRequest)
 
 		assert.Error(t, err)
 		assert.Nil(t, payment)
@@ -162,22 +231,1812 @@ func TestPaymentServiceRefund(t *testing.T) {
 			CardID:         cardID,
 		}
 
-		service := NewPaymentService([]domain.PaymentProvider{provider}, getTestConfig())
-		service.transactions[failedPayment.ID] = &domain.Transaction{
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), getTestConfig())
+		service.transactions.Save(&domain.Transaction{
 			Payment:      failedPayment,
+			MerchantID:   testMerchantID,
 			ProviderID:   "stripe",
 			ProviderName: "Stripe",
-		}
+		})
 
 		refundRequest := domain.RefundRequest{
 			Amount: gofakeit.Price(20, 50),
 		}
 
-		payment, err := service.RefundPayment(failedPayment.ID, refundRequest)
+		payment, err := service.RefundPayment(testActor, failedPayment.ID, refundRequest)
 
 		assert.Error(t, err)
 		assert.Nil(t, payment)
 		assert.Contains(t, err.Error(), "payment cannot be refunded")
 		provider.AssertNotCalled(t, "RefundPayment")
 	})
+
+	t.Run("refund payment of another merchant", func(t *testing.T) {
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe").Maybe()
+		provider.On("GetName").Return("Stripe").Maybe()
+
+		payment := &domain.Payment{
+			ID:             gofakeit.UUID(),
+			CreatedAt:      time.Now(),
+			Status:         domain.StatusAuthorized,
+			OriginalAmount: gofakeit.Price(50, 200),
+			CurrentAmount:  gofakeit.Price(50, 200),
+			Currency:       gofakeit.CurrencyShort(),
+			PaymentMethod:  "card",
+		}
+
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), getTestConfig())
+		service.transactions.Save(&domain.Transaction{
+			Payment:      payment,
+			MerchantID:   "another-merchant",
+			ProviderID:   "stripe",
+			ProviderName: "Stripe",
+		})
+
+		refunded, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 10})
+
+		assert.ErrorIs(t, err, domain.ErrPaymentNotFound)
+		assert.Nil(t, refunded)
+		provider.AssertNotCalled(t, "RefundPayment")
+
+		_, err = service.GetPayment(testMerchantID, payment.ID)
+		assert.ErrorIs(t, err, domain.ErrPaymentNotFound)
+	})
+}
+
+type stubRiskEvaluator struct {
+	assessment domain.RiskAssessment
+}
+
+func (e *stubRiskEvaluator) Evaluate(merchantID string, request domain.PaymentRequest) domain.RiskAssessment {
+	return e.assessment
+}
+
+func (e *stubRiskEvaluator) RecordOutcome(merchantID string, request domain.PaymentRequest, approved bool) {}
+
+func TestPaymentServiceRisk(t *testing.T) {
+	gofakeit.Seed(0)
+
+	t.Run("rejected payment never reaches a provider", func(t *testing.T) {
+		provider := new(MockProvider)
+		evaluator := &stubRiskEvaluator{assessment: domain.RiskAssessment{
+			Score:    100,
+			Decision: domain.RiskReject,
+			Reasons:  []string{"card is blocklisted"},
+		}}
+
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), getTestConfig(), WithRiskEvaluator(evaluator))
+
+		request := domain.PaymentRequest{
+			Amount:   gofakeit.Price(10, 100),
+			Currency: "BRL",
+			Card:     domain.Card{Number: "4111111111111111"},
+		}
+		payment, err := service.ProcessPayment(testActor, request)
+
+		assert.Nil(t, payment)
+		assert.ErrorIs(t, err, domain.ErrPaymentRejected)
+		provider.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+
+		var rejected *domain.RiskRejectedError
+		assert.ErrorAs(t, err, &rejected)
+		transaction, err := service.transactions.Get(rejected.PaymentID)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.StatusRejected, transaction.Payment.Status)
+		assert.Equal(t, "1111", transaction.Payment.CardLast4)
+		assert.Equal(t, domain.RiskReject, transaction.Risk.Decision)
+	})
+
+	t.Run("reviewed payment is processed and keeps the assessment", func(t *testing.T) {
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+
+		evaluator := &stubRiskEvaluator{assessment: domain.RiskAssessment{Score: 60, Decision: domain.RiskReview}}
+		request := domain.PaymentRequest{Amount: 50, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}}
+		provider.On("ProcessPayment", matchRequest(request)).Return(&domain.Payment{
+			ID:        gofakeit.UUID(),
+			CreatedAt: time.Now(),
+			Status:    domain.StatusAuthorized,
+			Currency:  "BRL",
+		}, nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), getTestConfig(), WithRiskEvaluator(evaluator))
+		payment, err := service.ProcessPayment(testActor, request)
+
+		assert.NoError(t, err)
+		transaction, _ := service.transactions.Get(payment.ID)
+		assert.Equal(t, domain.RiskReview, transaction.Risk.Decision)
+		assert.Equal(t, testMerchantID, transaction.MerchantID)
+		assert.Equal(t, request.Card.Fingerprint(), transaction.CardFingerprint)
+	})
+}
+
+func TestPaymentServiceThreeDSecure(t *testing.T) {
+	gofakeit.Seed(0)
+
+	newPayment := func(status domain.PaymentStatus) *domain.Payment {
+		return &domain.Payment{
+			ID:             gofakeit.UUID(),
+			CreatedAt:      time.Now(),
+			Status:         status,
+			OriginalAmount: 100,
+			CurrentAmount:  100,
+			Currency:       "EUR",
+			PaymentMethod:  "card",
+		}
+	}
+
+	t.Run("requires action stops fallback and confirm resumes it", func(t *testing.T) {
+		provider1 := new(MockProvider)
+		provider1.On("GetID").Return("stripe")
+		provider1.On("GetName").Return("Stripe")
+		provider2 := new(MockProvider)
+
+		request := domain.PaymentRequest{Amount: 100, Currency: "EUR", Card: domain.Card{Number: "4000000000003220"}}
+		pending := newPayment(domain.StatusRequiresAction)
+		pending.NextAction = &domain.NextAction{Type: domain.NextActionRedirect, RedirectURL: "http://acs/challenge"}
+		provider1.On("ProcessPayment", matchRequest(request)).Return(pending, nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), getTestConfig())
+		payment, err := service.ProcessPayment(testActor, request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, domain.StatusRequiresAction, payment.Status)
+		assert.Equal(t, "http://acs/challenge", payment.NextAction.RedirectURL)
+		provider2.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+
+		confirmRequest := domain.ConfirmRequest{AuthenticationResult: "ok"}
+		confirmed := newPayment(domain.StatusAuthorized)
+		confirmed.ID = pending.ID
+		provider1.On("ConfirmPayment", pending.ID, confirmRequest).Return(confirmed, nil)
+
+		payment, err = service.ConfirmPayment(testActor, pending.ID, confirmRequest)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.StatusAuthorized, payment.Status)
+
+		stored, _ := service.GetPayment(testMerchantID, pending.ID)
+		assert.Equal(t, domain.StatusAuthorized, stored.Status)
+		assert.Equal(t, "3220", stored.CardLast4)
+	})
+
+	t.Run("confirm payment not requiring action", func(t *testing.T) {
+		provider := new(MockProvider)
+		authorized := newPayment(domain.StatusAuthorized)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), getTestConfig())
+		service.transactions.Save(&domain.Transaction{
+			Payment:      authorized,
+			MerchantID:   testMerchantID,
+			ProviderID:   "stripe",
+			ProviderName: "Stripe",
+		})
+
+		payment, err := service.ConfirmPayment(testActor, authorized.ID, domain.ConfirmRequest{})
+		assert.Nil(t, payment)
+		assert.ErrorIs(t, err, domain.ErrInvalidStatus)
+		provider.AssertNotCalled(t, "ConfirmPayment", mock.Anything, mock.Anything)
+	})
+}
+
+func TestPaymentServiceInFlight(t *testing.T) {
+	provider := new(MockProvider)
+	provider.On("GetID").Return("stripe")
+	provider.On("GetName").Return("Stripe")
+
+	request := domain.PaymentRequest{Amount: 42, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	provider.On("ProcessPayment", matchRequest(request)).Run(func(mock.Arguments) {
+		close(started)
+		<-release
+	}).Return(&domain.Payment{ID: gofakeit.UUID(), Status: domain.StatusAuthorized, CreatedAt: time.Now()}, nil)
+
+	service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), getTestConfig())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := service.ProcessPayment(testActor, request)
+		assert.NoError(t, err)
+	}()
+
+	<-started
+	operations := service.InFlight()
+	assert.Len(t, operations, 1)
+	assert.Equal(t, domain.OperationCharge, operations[0].Type)
+	assert.Equal(t, testMerchantID, operations[0].MerchantID)
+	assert.Equal(t, "stripe", operations[0].ProviderID)
+	assert.Equal(t, "1111", operations[0].CardLast4)
+
+	close(release)
+	<-done
+	assert.Empty(t, service.InFlight())
+}
+
+func TestPaymentServiceUnknownOutcome(t *testing.T) {
+	gofakeit.Seed(0)
+	cfg := getTestConfig()
+	cfg.Retry.Attempts = 1
+
+	timeout := fmt.Errorf("[provider: Stripe] %w: i/o timeout", domain.ErrOutcomeUnknown)
+	request := domain.PaymentRequest{Amount: 80, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}}
+	newProviders := func() (*MockProvider, *MockProvider) {
+		provider1 := new(MockProvider)
+		provider1.On("GetID").Return("stripe")
+		provider1.On("GetName").Return("Stripe")
+		provider2 := new(MockProvider)
+		provider2.On("GetID").Return("braintree").Maybe()
+		provider2.On("GetName").Return("Braintree").Maybe()
+		return provider1, provider2
+	}
+	charged := func() *domain.Payment {
+		return &domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 80, CurrentAmount: 80, Currency: "BRL"}
+	}
+
+	t.Run("charge found by reference is not sent to the next provider", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		var reference string
+		provider1.On("ProcessPayment", matchRequest(request)).Run(func(args mock.Arguments) {
+			reference = args.Get(0).(domain.PaymentRequest).Reference
+		}).Return(nil, timeout)
+		found := charged()
+		provider1.On("FindPaymentByReference", mock.Anything).Return(found, nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		payment, err := service.ProcessPayment(testActor, request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, found.ID, payment.ID)
+		assert.Equal(t, domain.StatusAuthorized, payment.Status)
+		provider1.AssertCalled(t, "FindPaymentByReference", reference)
+		provider2.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	})
+
+	t.Run("charge not found falls back", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		provider1.On("ProcessPayment", matchRequest(request)).Return(nil, timeout)
+		provider1.On("FindPaymentByReference", mock.Anything).Return(nil, domain.ErrPaymentNotFound)
+		provider2.On("ProcessPayment", matchRequest(request)).Return(charged(), nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		payment, err := service.ProcessPayment(testActor, request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, domain.StatusAuthorized, payment.Status)
+		provider2.AssertNumberOfCalls(t, "ProcessPayment", 1)
+	})
+
+	t.Run("unresolved charge is stored as unknown and swept later", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		provider1.On("ProcessPayment", matchRequest(request)).Return(nil, timeout)
+		provider1.On("FindPaymentByReference", mock.Anything).Return(nil, errors.New("connection refused")).Once()
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		payment, err := service.ProcessPayment(testActor, request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, domain.StatusUnknown, payment.Status)
+		assert.Equal(t, "1111", payment.CardLast4)
+		provider2.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+
+		found := charged()
+		providerPaymentID := found.ID
+		provider1.On("FindPaymentByReference", payment.ID).Return(found, nil)
+		assert.Equal(t, 0, service.ResolveUnknownPayments(time.Hour))
+		assert.Equal(t, 1, service.ResolveUnknownPayments(0))
+
+		stored, _ := service.GetPayment(testMerchantID, payment.ID)
+		assert.Equal(t, domain.StatusAuthorized, stored.Status)
+
+		refunded := charged()
+		refunded.ID = providerPaymentID
+		refunded.Status = domain.StatusRefunded
+		provider1.On("RefundPayment", providerPaymentID, domain.RefundRequest{Amount: 80}).Return(refunded, nil)
+		payment, err = service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 80})
+		assert.NoError(t, err)
+		assert.Equal(t, stored.ID, payment.ID)
+	})
+
+	t.Run("sweeper fails charges the provider never saw", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		provider1.On("FindPaymentByReference", "ref-1").Return(nil, fmt.Errorf("[provider: Stripe] %w", domain.ErrPaymentNotFound))
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		recovered := service.RecoverUnknownOutcomes([]domain.UnknownOutcome{
+			{InFlightOperation: domain.InFlightOperation{ID: "op-1", Type: domain.OperationCharge, MerchantID: testMerchantID, Reference: "ref-1", ProviderID: "stripe", Amount: 80, StartedAt: time.Now()}},
+			{InFlightOperation: domain.InFlightOperation{ID: "op-2", Type: domain.OperationRefund, MerchantID: testMerchantID, PaymentID: "pay-1", ProviderID: "stripe"}},
+		})
+		assert.Equal(t, 1, recovered)
+
+		stored, err := service.GetPayment(testMerchantID, "ref-1")
+		assert.NoError(t, err)
+		assert.Equal(t, domain.StatusUnknown, stored.Status)
+
+		assert.Equal(t, 1, service.ResolveUnknownPayments(0))
+		stored, _ = service.GetPayment(testMerchantID, "ref-1")
+		assert.Equal(t, domain.StatusFailed, stored.Status)
+	})
+}
+
+func TestPaymentServiceProviderHealth(t *testing.T) {
+	gofakeit.Seed(0)
+	cfg := getTestConfig()
+	cfg.Retry.Attempts = 1
+
+	request := domain.PaymentRequest{Amount: 80, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}}
+	unavailable := errors.New("[provider: Stripe] unexpected status code: 503")
+	newProviders := func() (*MockProvider, *MockProvider) {
+		provider1 := new(MockProvider)
+		provider1.On("GetID").Return("stripe")
+		provider1.On("GetName").Return("Stripe")
+		provider2 := new(MockProvider)
+		provider2.On("GetID").Return("braintree")
+		provider2.On("GetName").Return("Braintree")
+		return provider1, provider2
+	}
+
+	t.Run("breakers are per provider", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		provider1.On("ProcessPayment", matchRequest(request)).Return(nil, unavailable)
+		for i := 0; i < 4; i++ {
+			provider2.On("ProcessPayment", matchRequest(request)).Return(&domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 80, CurrentAmount: 80, Currency: "BRL"}, nil).Once()
+		}
+
+		monitor := health.NewMonitor(10)
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg, WithHealthMonitor(monitor))
+		for i := 0; i < 4; i++ {
+			payment, err := service.ProcessPayment(testActor, request)
+			assert.NoError(t, err)
+			assert.Equal(t, domain.StatusAuthorized, payment.Status)
+		}
+
+		// The open breaker keeps further payments away from the failing provider
+		provider1.AssertNumberOfCalls(t, "ProcessPayment", 3)
+		assert.NoError(t, service.Ready())
+
+		statuses := service.ProviderStatuses()
+		assert.Len(t, statuses, 2)
+		assert.Equal(t, "stripe", statuses[0].ID)
+		assert.Equal(t, "open", statuses[0].Breaker)
+		assert.Equal(t, 3, statuses[0].Samples)
+		assert.Equal(t, 0.0, statuses[0].SuccessRate)
+		assert.Equal(t, unavailable.Error(), statuses[0].LastError)
+		assert.Equal(t, "closed", statuses[1].Breaker)
+		assert.Equal(t, 4, statuses[1].Samples)
+		assert.Equal(t, 1.0, statuses[1].SuccessRate)
+	})
+
+	t.Run("not ready without a closed breaker", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		provider1.On("ProcessPayment", matchRequest(request)).Return(nil, unavailable)
+		provider2.On("ProcessPayment", matchRequest(request)).Return(nil, unavailable)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		assert.NoError(t, service.Ready())
+		for i := 0; i < 3; i++ {
+			_, err := service.ProcessPayment(testActor, request)
+			assert.Error(t, err)
+		}
+
+		assert.ErrorContains(t, service.Ready(), "no provider available for charges")
+	})
+}
+
+func TestPaymentServiceProviderMode(t *testing.T) {
+	gofakeit.Seed(0)
+	cfg := getTestConfig()
+	cfg.Retry.Attempts = 1
+
+	request := domain.PaymentRequest{Amount: 80, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}}
+	charged := func() *domain.Payment {
+		return &domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 80, CurrentAmount: 80, Currency: "BRL"}
+	}
+	newProviders := func() (*MockProvider, *MockProvider) {
+		provider1 := new(MockProvider)
+		provider1.On("GetID").Return("stripe")
+		provider1.On("GetName").Return("Stripe")
+		provider2 := new(MockProvider)
+		provider2.On("GetID").Return("braintree")
+		provider2.On("GetName").Return("Braintree")
+		return provider1, provider2
+	}
+
+	t.Run("forced open provider is skipped by the fallback", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		provider2.On("ProcessPayment", matchRequest(request)).Return(charged(), nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		status, err := service.SetProviderMode(testAdmin, "stripe", domain.ProviderModeForcedOpen)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.ProviderModeForcedOpen, status.Mode)
+
+		payment, err := service.ProcessPayment(testActor, request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, domain.StatusAuthorized, payment.Status)
+		provider1.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	})
+
+	t.Run("draining provider refunds but takes no charges", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		original := charged()
+		refundRequest := domain.RefundRequest{Amount: 80}
+		provider1.On("RefundPayment", original.ID, refundRequest).Return(&domain.Payment{ID: original.ID, CreatedAt: time.Now(), Status: domain.StatusRefunded, OriginalAmount: 80, Currency: "BRL"}, nil)
+		provider2.On("ProcessPayment", matchRequest(request)).Return(charged(), nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		service.transactions.Save(&domain.Transaction{Payment: original, MerchantID: testMerchantID, ProviderID: "stripe", ProviderName: "Stripe"})
+		_, err := service.SetProviderMode(testAdmin, "stripe", domain.ProviderModeDraining)
+		assert.NoError(t, err)
+
+		_, err = service.ProcessPayment(testActor, request)
+		assert.NoError(t, err)
+		provider1.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+
+		refunded, err := service.RefundPayment(testActor, original.ID, refundRequest)
+		assert.NoError(t, err)
+		assert.Equal(t, domain.StatusRefunded, refunded.Status)
+	})
+
+	t.Run("forced closed provider ignores its breaker until reset", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		unavailable := errors.New("[provider: Stripe] unexpected status code: 503")
+		provider1.On("ProcessPayment", matchRequest(request)).Return(nil, unavailable)
+		provider2.On("ProcessPayment", matchRequest(request)).Return(nil, unavailable)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		for i := 0; i < 3; i++ {
+			_, err := service.ProcessPayment(testActor, request)
+			assert.Error(t, err)
+		}
+		assert.Error(t, service.Ready())
+
+		_, err := service.SetProviderMode(testAdmin, "stripe", domain.ProviderModeForcedClosed)
+		assert.NoError(t, err)
+		assert.NoError(t, service.Ready())
+		_, err = service.ProcessPayment(testActor, request)
+		assert.Error(t, err)
+		provider1.AssertNumberOfCalls(t, "ProcessPayment", 4)
+
+		status, err := service.ResetProvider(testAdmin, "stripe")
+		assert.NoError(t, err)
+		assert.Equal(t, domain.ProviderModeAuto, status.Mode)
+		assert.Equal(t, "closed", status.Breaker)
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+
+		_, err := service.SetProviderMode(testAdmin, "adyen", domain.ProviderModeForcedOpen)
+		assert.ErrorIs(t, err, domain.ErrProviderNotFound)
+		_, err = service.ResetProvider(testAdmin, "adyen")
+		assert.ErrorIs(t, err, domain.ErrProviderNotFound)
+	})
+}
+
+func TestPaymentServiceAudit(t *testing.T) {
+	gofakeit.Seed(0)
+	cfg := getTestConfig()
+	cfg.Retry.Attempts = 1
+
+	request := domain.PaymentRequest{Amount: 80, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}}
+	actor := testActor
+	actor.RequestID = "req-1"
+
+	t.Run("payment history with actors and snapshots", func(t *testing.T) {
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+		charged := &domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 80, CurrentAmount: 80, Currency: "BRL"}
+		providerPaymentID := charged.ID
+		provider.On("ProcessPayment", matchRequest(request)).Return(charged, nil)
+		provider.On("RefundPayment", providerPaymentID, domain.RefundRequest{Amount: 80}).Return(&domain.Payment{ID: providerPaymentID, CreatedAt: time.Now(), Status: domain.StatusRefunded, OriginalAmount: 80, Currency: "BRL"}, nil)
+
+		auditLog := audit.NewMemoryLog()
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithAuditLog(auditLog))
+
+		payment, err := service.ProcessPayment(actor, request)
+		require.NoError(t, err)
+		refundActor := testActor
+		refundActor.RequestID = "req-2"
+		_, err = service.RefundPayment(refundActor, payment.ID, domain.RefundRequest{Amount: 80})
+		require.NoError(t, err)
+
+		events, err := service.PaymentEvents(testMerchantID, payment.ID)
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+
+		assert.Equal(t, domain.AuditPaymentCreated, events[0].Action)
+		assert.Equal(t, testActor.ID, events[0].Actor.ID)
+		assert.Equal(t, "req-1", events[0].RequestID)
+		assert.Nil(t, events[0].Before)
+
+		var before, after domain.Transaction
+		require.NoError(t, json.Unmarshal(events[1].Before, &before))
+		require.NoError(t, json.Unmarshal(events[1].After, &after))
+		assert.Equal(t, domain.AuditPaymentRefunded, events[1].Action)
+		assert.Equal(t, "req-2", events[1].RequestID)
+		assert.Equal(t, domain.StatusAuthorized, before.Payment.Status)
+		assert.Equal(t, domain.StatusRefunded, after.Payment.Status)
+
+		_, err = service.PaymentEvents("another-merchant", payment.ID)
+		assert.ErrorIs(t, err, domain.ErrPaymentNotFound)
+	})
+
+	t.Run("sweeper changes are made by the system", func(t *testing.T) {
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+		provider.On("FindPaymentByReference", "ref-1").Return(nil, domain.ErrPaymentNotFound)
+
+		auditLog := audit.NewMemoryLog()
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithAuditLog(auditLog))
+		service.transactions.Save(&domain.Transaction{
+			Payment:    &domain.Payment{ID: "ref-1", CreatedAt: time.Now().Add(-time.Hour), Status: domain.StatusUnknown, OriginalAmount: 80, Currency: "BRL"},
+			MerchantID: testMerchantID,
+			ProviderID: "stripe",
+			Reference:  "ref-1",
+		})
+
+		assert.Equal(t, 1, service.ResolveUnknownPayments(time.Minute))
+
+		events, err := service.PaymentEvents(testMerchantID, "ref-1")
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, domain.AuditPaymentResolved, events[0].Action)
+		assert.Equal(t, domain.SystemActor("sweeper"), events[0].Actor)
+		assert.Contains(t, string(events[0].Before), `"status":"unknown"`)
+		assert.Contains(t, string(events[0].After), `"status":"failed"`)
+	})
+
+	t.Run("admin actions", func(t *testing.T) {
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+
+		auditLog := audit.NewMemoryLog()
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithAuditLog(auditLog))
+		_, err := service.SetProviderMode(testAdmin, "stripe", domain.ProviderModeDraining)
+		require.NoError(t, err)
+		_, err = service.ResetProvider(testAdmin, "stripe")
+		require.NoError(t, err)
+
+		events, err := service.AuditEvents(domain.AuditFilter{ResourceType: domain.AuditResourceProvider, ResourceID: "stripe"})
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, domain.AuditProviderModeSet, events[0].Action)
+		assert.Equal(t, testAdmin, events[0].Actor)
+		assert.Contains(t, string(events[0].After), `"mode":"draining"`)
+		assert.Equal(t, domain.AuditProviderReset, events[1].Action)
+		assert.Contains(t, string(events[1].After), `"mode":"auto"`)
+	})
+}
+
+func TestPaymentServiceAttempts(t *testing.T) {
+	gofakeit.Seed(0)
+	cfg := getTestConfig()
+	cfg.Retry.Attempts = 2
+	cfg.Retry.DelaySeconds = 0
+
+	request := domain.PaymentRequest{Amount: 80, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}}
+	unavailable := &domain.ProviderError{
+		Class:      domain.ErrorClassServerError,
+		HTTPStatus: 503,
+		Response:   `{"error":"unavailable"}`,
+		Err:        errors.New("[provider: Stripe] unexpected status code: 503"),
+	}
+
+	t.Run("fallback keeps the attempts of every provider", func(t *testing.T) {
+		provider1 := new(MockProvider)
+		provider1.On("GetID").Return("stripe")
+		provider1.On("GetName").Return("Stripe")
+		provider1.On("ProcessPayment", matchRequest(request)).Return(nil, unavailable)
+		provider2 := new(MockProvider)
+		provider2.On("GetID").Return("braintree")
+		provider2.On("GetName").Return("Braintree")
+		charged := &domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 80, CurrentAmount: 80, Currency: "BRL"}
+		providerPaymentID := charged.ID
+		provider2.On("ProcessPayment", matchRequest(request)).Return(charged, nil)
+		provider2.On("RefundPayment", providerPaymentID, domain.RefundRequest{Amount: 80}).Return(nil, errors.New("connection reset"))
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		payment, err := service.ProcessPayment(testActor, request)
+		require.NoError(t, err)
+
+		attempts, err := service.PaymentAttempts(testMerchantID, payment.ID)
+		require.NoError(t, err)
+		require.Len(t, attempts, 3)
+		for i, attempt := range attempts[:2] {
+			assert.Equal(t, "stripe", attempt.ProviderID)
+			assert.Equal(t, domain.OperationCharge, attempt.Operation)
+			assert.Equal(t, i+1, attempt.Number)
+			assert.Equal(t, 503, attempt.HTTPStatus)
+			assert.Equal(t, domain.ErrorClassServerError, attempt.ErrorClass)
+			assert.Equal(t, `{"error":"unavailable"}`, attempt.Response)
+			assert.False(t, attempt.EndedAt.Before(attempt.StartedAt))
+		}
+		assert.Equal(t, "braintree", attempts[2].ProviderID)
+		assert.Equal(t, 1, attempts[2].Number)
+		assert.Equal(t, 200, attempts[2].HTTPStatus)
+		assert.Equal(t, domain.StatusAuthorized, attempts[2].Status)
+		assert.Empty(t, attempts[2].ErrorClass)
+
+		// Failed refunds are recorded even though the payment didn't change
+		_, err = service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 80})
+		assert.Error(t, err)
+
+		attempts, err = service.PaymentAttempts(testMerchantID, payment.ID)
+		require.NoError(t, err)
+		require.Len(t, attempts, 5)
+		assert.Equal(t, domain.OperationRefund, attempts[4].Operation)
+		assert.Equal(t, 2, attempts[4].Number)
+		assert.Equal(t, domain.ErrorClassInternal, attempts[4].ErrorClass)
+		assert.Equal(t, "connection reset", attempts[4].Error)
+	})
+}
+
+func TestPaymentServiceHedging(t *testing.T) {
+	gofakeit.Seed(0)
+	cfg := getTestConfig()
+	cfg.Retry.Attempts = 1
+	cfg.Retry.DelaySeconds = 0
+	cfg.Hedging = config.HedgingConfig{Enabled: true, ThresholdMs: 50}
+	cfg.Providers = []config.ProviderConfig{{ID: "stripe", Idempotent: true}, {ID: "braintree", Idempotent: true}}
+
+	request := domain.PaymentRequest{Amount: 80, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}}
+	newProviders := func() (*MockProvider, *MockProvider) {
+		provider1 := new(MockProvider)
+		provider1.On("GetID").Return("stripe")
+		provider1.On("GetName").Return("Stripe")
+		provider2 := new(MockProvider)
+		provider2.On("GetID").Return("braintree")
+		provider2.On("GetName").Return("Braintree")
+		return provider1, provider2
+	}
+	charged := func() *domain.Payment {
+		return &domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 80, CurrentAmount: 80, Currency: "BRL"}
+	}
+
+	t.Run("slow primary is hedged and voided", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		slow := charged()
+		slowID := slow.ID
+		provider1.On("ProcessPayment", matchRequest(request)).After(300*time.Millisecond).Return(slow, nil)
+		provider1.On("RefundPayment", slowID, domain.RefundRequest{Amount: 80}).Return(&domain.Payment{ID: slowID, Status: domain.StatusRefunded}, nil)
+		fast := charged()
+		fastID := fast.ID
+		provider2.On("ProcessPayment", matchRequest(request)).Return(fast, nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		payment, err := service.ProcessPayment(testActor, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, fastID, payment.ID)
+		transaction, err := service.merchantTransaction(testMerchantID, fastID)
+		require.NoError(t, err)
+		assert.Equal(t, "braintree", transaction.ProviderID)
+
+		// The loser is voided and its attempts are added once it answers
+		assert.Eventually(t, func() bool {
+			attempts, err := service.PaymentAttempts(testMerchantID, fastID)
+			return err == nil && len(attempts) == 3
+		}, 2*time.Second, 20*time.Millisecond)
+		provider1.AssertCalled(t, "RefundPayment", slowID, domain.RefundRequest{Amount: 80})
+
+		attempts, err := service.PaymentAttempts(testMerchantID, fastID)
+		require.NoError(t, err)
+		assert.Equal(t, "braintree", attempts[0].ProviderID)
+		assert.Equal(t, "stripe", attempts[1].ProviderID)
+		assert.Equal(t, domain.OperationCharge, attempts[1].Operation)
+		assert.Equal(t, domain.OperationVoid, attempts[2].Operation)
+		assert.Empty(t, service.InFlight())
+	})
+
+	t.Run("fast primary is not hedged", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		provider1.On("ProcessPayment", matchRequest(request)).Return(charged(), nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		_, err := service.ProcessPayment(testActor, request)
+
+		require.NoError(t, err)
+		provider2.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	})
+
+	t.Run("provider that isn't idempotent is not hedged", func(t *testing.T) {
+		cfg := *cfg
+		cfg.Providers = []config.ProviderConfig{{ID: "stripe", Idempotent: true}, {ID: "braintree"}}
+		provider1, provider2 := newProviders()
+		provider1.On("ProcessPayment", matchRequest(request)).After(150*time.Millisecond).Return(charged(), nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), &cfg)
+		_, err := service.ProcessPayment(testActor, request)
+
+		require.NoError(t, err)
+		provider2.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	})
+
+	t.Run("decline doesn't win the hedge", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		slow := charged()
+		slowID := slow.ID
+		provider1.On("ProcessPayment", matchRequest(request)).After(200*time.Millisecond).Return(slow, nil)
+		provider2.On("ProcessPayment", matchRequest(request)).Return(&domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusFailed, OriginalAmount: 80, Currency: "BRL"}, nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		payment, err := service.ProcessPayment(testActor, request)
+
+		require.NoError(t, err)
+		assert.Equal(t, slowID, payment.ID)
+		assert.Equal(t, domain.StatusAuthorized, payment.Status)
+		provider1.AssertNotCalled(t, "RefundPayment", mock.Anything, mock.Anything)
+		provider2.AssertNotCalled(t, "RefundPayment", mock.Anything, mock.Anything)
+	})
+
+	t.Run("provider that can't refund is not hedged", func(t *testing.T) {
+		provider1, provider2 := newProviders()
+		provider2.capabilities = domain.Capabilities{Operations: []domain.OperationType{domain.OperationCharge}}
+		provider1.On("ProcessPayment", matchRequest(request)).After(150*time.Millisecond).Return(charged(), nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		_, err := service.ProcessPayment(testActor, request)
+
+		require.NoError(t, err)
+		provider2.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	})
+
+	t.Run("threshold follows the observed p95 within bounds", func(t *testing.T) {
+		cfg := *cfg
+		cfg.Hedging = config.HedgingConfig{Enabled: true, MinThresholdMs: 100, MaxThresholdMs: 1000}
+		provider1, _ := newProviders()
+		monitor := health.NewMonitor(100)
+		service := NewPaymentService([]domain.PaymentProvider{provider1}, store.NewMemoryStore(), &cfg, WithHealthMonitor(monitor))
+
+		assert.Equal(t, time.Second, service.hedgeThreshold(provider1))
+		for i := 1; i <= minHedgeSamples; i++ {
+			monitor.Record("stripe", time.Duration(i)*20*time.Millisecond, nil)
+		}
+		threshold := service.hedgeThreshold(provider1)
+		assert.Greater(t, threshold, 300*time.Millisecond)
+		assert.Less(t, threshold, 1000*time.Millisecond)
+	})
+}
+
+func TestPaymentServiceRetryPolicy(t *testing.T) {
+	gofakeit.Seed(0)
+	unavailable := &domain.ProviderError{
+		Class:      domain.ErrorClassServerError,
+		HTTPStatus: 503,
+		Err:        errors.New("[provider: Stripe] unexpected status code: 503"),
+	}
+	request := domain.PaymentRequest{Amount: 80, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}}
+	newProvider := func() *MockProvider {
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+		return provider
+	}
+
+	t.Run("operation and provider policies override the defaults", func(t *testing.T) {
+		cfg := getTestConfig()
+		cfg.Retry.DelaySeconds = 0
+		cfg.Retry.Policies = []config.RetryPolicyConfig{{Operation: "refund", Attempts: 5, DelayMs: 1}}
+		cfg.Providers = []config.ProviderConfig{{ID: "stripe", Retry: []config.RetryPolicyConfig{{Attempts: 2}}}}
+
+		policy := cfg.GetRetryPolicy("stripe", "refund")
+		assert.Equal(t, 2, policy.Attempts)
+		assert.Equal(t, time.Millisecond, policy.Delay)
+		assert.Equal(t, 5, cfg.GetRetryPolicy("braintree", "refund").Attempts)
+		assert.Equal(t, 3, cfg.GetRetryPolicy("braintree", "charge").Attempts)
+
+		cfg.Providers[0].Retry = append(cfg.Providers[0].Retry, config.RetryPolicyConfig{Operation: "refund", Attempts: 4})
+		assert.Equal(t, 4, cfg.GetRetryPolicy("stripe", "refund").Attempts)
+
+		provider := newProvider()
+		provider.On("ProcessPayment", matchRequest(request)).Return(nil, unavailable)
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg)
+		_, err := service.ProcessPayment(testActor, request)
+
+		assert.Error(t, err)
+		provider.AssertNumberOfCalls(t, "ProcessPayment", 2)
+	})
+
+	t.Run("delays follow the strategy", func(t *testing.T) {
+		policy := config.RetryPolicy{Strategy: config.RetryFixed, Delay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+		fixed := &backoff{policy: policy}
+		assert.Equal(t, 100*time.Millisecond, fixed.next(errors.New("boom")))
+		assert.Equal(t, 100*time.Millisecond, fixed.next(errors.New("boom")))
+
+		policy.Strategy = config.RetryExponential
+		exponential := &backoff{policy: policy}
+		assert.Equal(t, 100*time.Millisecond, exponential.next(errors.New("boom")))
+		assert.Equal(t, 200*time.Millisecond, exponential.next(errors.New("boom")))
+		assert.Equal(t, 300*time.Millisecond, exponential.next(errors.New("boom")))
+
+		policy.Strategy = config.RetryDecorrelatedJitter
+		jitter := &backoff{policy: policy}
+		for i := 0; i < 20; i++ {
+			delay := jitter.next(errors.New("boom"))
+			assert.GreaterOrEqual(t, delay, 100*time.Millisecond)
+			assert.LessOrEqual(t, delay, 300*time.Millisecond)
+		}
+
+		// Retry-After wins over a shorter delay, even above the max delay
+		throttled := &domain.ProviderError{Class: domain.ErrorClassClientError, HTTPStatus: 429, RetryAfter: time.Second, Err: errors.New("throttled")}
+		assert.Equal(t, time.Second, (&backoff{policy: policy}).next(fmt.Errorf("wrapped: %w", throttled)))
+	})
+
+	t.Run("Retry-After beyond the max elapsed time stops the retries", func(t *testing.T) {
+		cfg := getTestConfig()
+		cfg.Retry.DelaySeconds = 0
+		cfg.Retry.MaxElapsedMs = 500
+		throttled := &domain.ProviderError{Class: domain.ErrorClassClientError, HTTPStatus: 429, RetryAfter: time.Minute, Err: errors.New("[provider: Stripe] unexpected status code: 429")}
+		provider := newProvider()
+		provider.On("ProcessPayment", matchRequest(request)).Return(nil, throttled)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg)
+		started := time.Now()
+		_, err := service.ProcessPayment(testActor, request)
+
+		assert.Error(t, err)
+		assert.Less(t, time.Since(started), 500*time.Millisecond)
+		provider.AssertNumberOfCalls(t, "ProcessPayment", 1)
+	})
+
+	t.Run("retry budget limits retries to a share of the requests", func(t *testing.T) {
+		cfg := getTestConfig()
+		cfg.Retry.Budget = config.RetryBudgetConfig{Ratio: 0.5, WindowSeconds: 10}
+		budget := newRetryBudget(cfg)
+		now := time.Now()
+		budget.now = func() time.Time { return now }
+
+		for i := 0; i < 4; i++ {
+			budget.request("stripe")
+		}
+		assert.True(t, budget.allow("stripe"))
+		assert.True(t, budget.allow("stripe"))
+		assert.False(t, budget.allow("stripe"))
+		assert.False(t, budget.allow("braintree"))
+
+		// Requests and retries fall out of the window
+		now = now.Add(10 * time.Second)
+		budget.request("stripe")
+		budget.request("stripe")
+		assert.True(t, budget.allow("stripe"))
+
+		cfg.Retry.Budget.MinPerSecond = 1
+		assert.True(t, newRetryBudget(cfg).allow("braintree"))
+	})
+
+	t.Run("exhausted budget stops the retries", func(t *testing.T) {
+		cfg := getTestConfig()
+		cfg.Retry.DelaySeconds = 0
+		cfg.Retry.Budget = config.RetryBudgetConfig{Ratio: 0.1, WindowSeconds: 10}
+		provider := newProvider()
+		provider.On("ProcessPayment", matchRequest(request)).Return(nil, unavailable)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg)
+		_, err := service.ProcessPayment(testActor, request)
+
+		assert.Error(t, err)
+		provider.AssertNumberOfCalls(t, "ProcessPayment", 1)
+	})
+}
+
+func TestPaymentServiceBulkhead(t *testing.T) {
+	gofakeit.Seed(0)
+	cfg := getTestConfig()
+	cfg.Retry.Attempts = 1
+	cfg.Bulkhead = config.BulkheadConfig{MaxConcurrent: 1}
+
+	request := domain.PaymentRequest{Amount: 80, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}}
+	charged := func() *domain.Payment {
+		return &domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 80, CurrentAmount: 80, Currency: "BRL"}
+	}
+
+	t.Run("full bulkhead falls through to the next provider", func(t *testing.T) {
+		provider1 := new(MockProvider)
+		provider1.On("GetID").Return("stripe")
+		provider1.On("GetName").Return("Stripe")
+		provider1.On("ProcessPayment", matchRequest(request)).After(200*time.Millisecond).Return(charged(), nil).Once()
+		provider2 := new(MockProvider)
+		provider2.On("GetID").Return("braintree")
+		provider2.On("GetName").Return("Braintree")
+		fallback := charged()
+		fallbackID := fallback.ID
+		provider2.On("ProcessPayment", matchRequest(request)).Return(fallback, nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider1, provider2}, store.NewMemoryStore(), cfg)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, err := service.ProcessPayment(testActor, request)
+			assert.NoError(t, err)
+		}()
+		require.Eventually(t, func() bool {
+			return service.bulkheads.stats("stripe").InFlight == 1
+		}, time.Second, 5*time.Millisecond)
+
+		payment, err := service.ProcessPayment(testActor, request)
+		require.NoError(t, err)
+		assert.Equal(t, fallbackID, payment.ID)
+		<-done
+
+		statuses := service.ProviderStatuses()
+		require.NotNil(t, statuses[0].Bulkhead)
+		assert.Equal(t, 0, statuses[0].Bulkhead.InFlight)
+		assert.Equal(t, int64(1), statuses[0].Bulkhead.Rejected)
+		provider1.AssertNumberOfCalls(t, "ProcessPayment", 1)
+	})
+
+	t.Run("queued call waits for a free slot", func(t *testing.T) {
+		cfg := *cfg
+		cfg.Bulkhead = config.BulkheadConfig{MaxConcurrent: 1, MaxQueue: 1, QueueTimeoutMs: 1000}
+		service := NewPaymentService([]domain.PaymentProvider{new(MockProvider)}, store.NewMemoryStore(), &cfg)
+
+		release := make(chan struct{})
+		go service.bulkheads.execute("stripe", func() (interface{}, error) {
+			<-release
+			return nil, nil
+		})
+		require.Eventually(t, func() bool {
+			return service.bulkheads.stats("stripe").InFlight == 1
+		}, time.Second, 5*time.Millisecond)
+
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+		}()
+		_, err := service.bulkheads.execute("stripe", func() (interface{}, error) {
+			return nil, nil
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("refund is shed when the bulkhead is full", func(t *testing.T) {
+		cfg := *cfg
+		cfg.Bulkhead = config.BulkheadConfig{MaxConcurrent: 1, MaxQueue: 1, QueueTimeoutMs: 20}
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+		payment := charged()
+		paymentID := payment.ID
+		provider.On("ProcessPayment", matchRequest(request)).Return(payment, nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), &cfg)
+		_, err := service.ProcessPayment(testActor, request)
+		require.NoError(t, err)
+
+		release := make(chan struct{})
+		defer close(release)
+		go service.bulkheads.execute("stripe", func() (interface{}, error) {
+			<-release
+			return nil, nil
+		})
+		require.Eventually(t, func() bool {
+			return service.bulkheads.stats("stripe").InFlight == 1
+		}, time.Second, 5*time.Millisecond)
+
+		_, err = service.RefundPayment(testActor, paymentID, domain.RefundRequest{Amount: 80})
+		assert.ErrorIs(t, err, domain.ErrProviderUnavailable)
+		provider.AssertNotCalled(t, "RefundPayment", mock.Anything, mock.Anything)
+	})
+}
+
+// flakyStore fails the next failures loads.
+type flakyStore struct {
+	domain.TransactionStore
+	failures     atomic.Int32
+	saveFailures atomic.Int32
+}
+
+func (s *flakyStore) Save(transaction *domain.Transaction) error {
+	if s.saveFailures.Add(-1) >= 0 {
+		return errors.New("store unavailable")
+	}
+	return s.TransactionStore.Save(transaction)
+}
+
+func (s *flakyStore) Get(paymentID string) (*domain.Transaction, error) {
+	if s.failures.Add(-1) >= 0 {
+		return nil, errors.New("store unavailable")
+	}
+	return s.TransactionStore.Get(paymentID)
+}
+
+func TestPaymentServiceAsync(t *testing.T) {
+	cfg := getTestConfig()
+	cfg.Retry.Attempts = 1
+	cfg.Async = config.AsyncConfig{CallbackTimeoutSeconds: 1, CallbackAttempts: 1}
+	cfg.Merchants = []config.MerchantConfig{{ID: testMerchantID, WebhookSecret: "whsec_test"}}
+
+	request := domain.PaymentRequest{Amount: 50, Currency: "BRL", Card: domain.Card{Number: "4111111111111111", CVV: "123", ExpirationDate: "12/2030"}}
+
+	t.Run("queued payment is processed and reported", func(t *testing.T) {
+		callbacks := make(chan domain.Payment, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, webhook.Sign("whsec_test", body), r.Header.Get(webhook.SignatureHeader))
+			var payment domain.Payment
+			assert.NoError(t, json.Unmarshal(body, &payment))
+			callbacks <- payment
+		}))
+		defer server.Close()
+
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+		provider.On("ProcessPayment", matchRequest(request)).Return(&domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 50, CurrentAmount: 50, Currency: "BRL"}, nil)
+
+		queue, err := jobs.Open("", 10)
+		require.NoError(t, err)
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithJobQueue(queue, vault.NewMemoryVault()))
+		// The test server listens on loopback, which callbacks can't reach
+		service.callbacks = server.Client()
+
+		pending, err := service.SubmitPayment(testActor, request, server.URL)
+		require.NoError(t, err)
+		paymentID := pending.ID
+		assert.Equal(t, domain.StatusPending, pending.Status)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go service.RunWorkers(ctx, 2)
+
+		select {
+		case payment := <-callbacks:
+			assert.Equal(t, paymentID, payment.ID)
+			assert.Equal(t, domain.StatusAuthorized, payment.Status)
+		case <-time.After(2 * time.Second):
+			t.Fatal("callback not received")
+		}
+		payment, err := service.GetPayment(testMerchantID, paymentID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusAuthorized, payment.Status)
+		assert.Equal(t, 0, queue.Pending())
+	})
+
+	t.Run("payment fails when every provider fails", func(t *testing.T) {
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+		provider.On("ProcessPayment", matchRequest(request)).Return(nil, errors.New("provider error"))
+
+		queue, err := jobs.Open("", 10)
+		require.NoError(t, err)
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithJobQueue(queue, vault.NewMemoryVault()))
+
+		pending, err := service.SubmitPayment(testActor, request, "")
+		require.NoError(t, err)
+		paymentID := pending.ID
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go service.RunWorkers(ctx, 1)
+
+		require.Eventually(t, func() bool {
+			payment, err := service.GetPayment(testMerchantID, paymentID)
+			return err == nil && payment.Status == domain.StatusFailed
+		}, 2*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("journal holds no card data without a key", func(t *testing.T) {
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		path := filepath.Join(t.TempDir(), "jobs.ndjson")
+		queue, err := jobs.Open(path, 10)
+		require.NoError(t, err)
+		defer queue.Close()
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithJobQueue(queue, vault.NewMemoryVault()))
+
+		_, err = service.SubmitPayment(testActor, request, "")
+		require.NoError(t, err)
+		journal, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(journal), "4111111111111111")
+		assert.NotContains(t, string(journal), `"cvv":"123"`)
+		assert.Contains(t, string(journal), `"cardToken":"card_`)
+	})
+
+	t.Run("sealed card is charged after a restart, without the cvv", func(t *testing.T) {
+		sealer, err := vault.NewSealer(strings.Repeat("ab", 32))
+		require.NoError(t, err)
+		path := filepath.Join(t.TempDir(), "jobs.ndjson")
+		queue, err := jobs.Open(path, 10)
+		require.NoError(t, err)
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+		before := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithJobQueue(queue, vault.NewMemoryVault()), WithCardSealer(sealer))
+		pending, err := before.SubmitPayment(testActor, request, "")
+		require.NoError(t, err)
+		require.NoError(t, queue.Close())
+		journal, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.NotContains(t, string(journal), "4111111111111111")
+
+		restarted := request
+		restarted.Card.CVV = ""
+		provider.On("FindPaymentByReference", pending.ID).Return(nil, domain.ErrPaymentNotFound)
+		provider.On("ProcessPayment", matchRequest(restarted)).Return(&domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 50, CurrentAmount: 50, Currency: "BRL"}, nil)
+		queue, err = jobs.Open(path, 10)
+		require.NoError(t, err)
+		defer queue.Close()
+		after := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithJobQueue(queue, vault.NewMemoryVault()), WithCardSealer(sealer))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go after.RunWorkers(ctx, 1)
+
+		require.Eventually(t, func() bool {
+			payment, err := after.GetPayment(testMerchantID, pending.ID)
+			return err == nil && payment.Status == domain.StatusAuthorized
+		}, 2*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("resumed job already charged is not charged again", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.ndjson")
+		queue, err := jobs.Open(path, 10)
+		require.NoError(t, err)
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+		before := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithJobQueue(queue, vault.NewMemoryVault()))
+		pending, err := before.SubmitPayment(testActor, request, "")
+		require.NoError(t, err)
+		require.NoError(t, queue.Close())
+
+		provider.On("FindPaymentByReference", pending.ID).Return(&domain.Payment{ID: "ch_1", CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 50, CurrentAmount: 50, Currency: "BRL"}, nil)
+		queue, err = jobs.Open(path, 10)
+		require.NoError(t, err)
+		defer queue.Close()
+		after := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithJobQueue(queue, vault.NewMemoryVault()))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go after.RunWorkers(ctx, 1)
+
+		require.Eventually(t, func() bool {
+			payment, err := after.GetPayment(testMerchantID, pending.ID)
+			return err == nil && payment.Status == domain.StatusAuthorized
+		}, 2*time.Second, 10*time.Millisecond)
+		require.Eventually(t, func() bool { return queue.Pending() == 0 }, time.Second, 10*time.Millisecond)
+		provider.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	})
+
+	t.Run("resumed job is looked up at providers declaring their operations", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "jobs.ndjson")
+		queue, err := jobs.Open(path, 10)
+		require.NoError(t, err)
+		provider := &MockProvider{capabilities: domain.Capabilities{
+			Operations: []domain.OperationType{domain.OperationCharge, domain.OperationRefund},
+		}}
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+		before := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithJobQueue(queue, vault.NewMemoryVault()))
+		pending, err := before.SubmitPayment(testActor, request, "")
+		require.NoError(t, err)
+		require.NoError(t, queue.Close())
+
+		provider.On("FindPaymentByReference", pending.ID).Return(&domain.Payment{ID: "ch_1", CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 50, CurrentAmount: 50, Currency: "BRL"}, nil)
+		queue, err = jobs.Open(path, 10)
+		require.NoError(t, err)
+		defer queue.Close()
+		after := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithJobQueue(queue, vault.NewMemoryVault()))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go after.RunWorkers(ctx, 1)
+
+		require.Eventually(t, func() bool { return queue.Pending() == 0 }, 2*time.Second, 10*time.Millisecond)
+		payment, err := after.GetPayment(testMerchantID, pending.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusAuthorized, payment.Status)
+		provider.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	})
+
+	t.Run("job is retried when the store fails", func(t *testing.T) {
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+		provider.On("ProcessPayment", matchRequest(request)).Return(&domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 50, CurrentAmount: 50, Currency: "BRL"}, nil)
+
+		queue, err := jobs.Open("", 10)
+		require.NoError(t, err)
+		transactions := &flakyStore{TransactionStore: store.NewMemoryStore()}
+		service := NewPaymentService([]domain.PaymentProvider{provider}, transactions, cfg, WithJobQueue(queue, vault.NewMemoryVault()))
+
+		pending, err := service.SubmitPayment(testActor, request, "")
+		require.NoError(t, err)
+		provider.On("FindPaymentByReference", pending.ID).Return(nil, domain.ErrPaymentNotFound)
+		transactions.failures.Store(1)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go service.RunWorkers(ctx, 1)
+
+		require.Eventually(t, func() bool {
+			payment, err := service.GetPayment(testMerchantID, pending.ID)
+			return err == nil && payment.Status == domain.StatusAuthorized
+		}, 2*time.Second, 10*time.Millisecond)
+		require.Eventually(t, func() bool { return queue.Pending() == 0 }, time.Second, 10*time.Millisecond)
+		// Retried as resumed, so it was looked up before the charge
+		provider.AssertCalled(t, "FindPaymentByReference", pending.ID)
+	})
+
+	t.Run("charge that couldn't be stored is not charged again", func(t *testing.T) {
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+		charge := &domain.Payment{ID: "ch_1", CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 50, CurrentAmount: 50, Currency: "BRL"}
+		provider.On("ProcessPayment", matchRequest(request)).Return(charge, nil).Once()
+
+		queue, err := jobs.Open("", 10)
+		require.NoError(t, err)
+		transactions := &flakyStore{TransactionStore: store.NewMemoryStore()}
+		service := NewPaymentService([]domain.PaymentProvider{provider}, transactions, cfg, WithJobQueue(queue, vault.NewMemoryVault()))
+
+		pending, err := service.SubmitPayment(testActor, request, "")
+		require.NoError(t, err)
+		provider.On("FindPaymentByReference", pending.ID).Return(charge, nil)
+		transactions.saveFailures.Store(1)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go service.RunWorkers(ctx, 1)
+
+		require.Eventually(t, func() bool { return queue.Pending() == 0 }, 2*time.Second, 10*time.Millisecond)
+		payment, err := service.GetPayment(testMerchantID, pending.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusAuthorized, payment.Status)
+		provider.AssertNumberOfCalls(t, "ProcessPayment", 1)
+	})
+
+	t.Run("full queue rejects the payment", func(t *testing.T) {
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		queue, err := jobs.Open("", 1)
+		require.NoError(t, err)
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithJobQueue(queue, vault.NewMemoryVault()))
+
+		_, err = service.SubmitPayment(testActor, request, "")
+		require.NoError(t, err)
+		_, err = service.SubmitPayment(testActor, request, "")
+		assert.ErrorIs(t, err, domain.ErrQueueFull)
+	})
+}
+
+func TestPaymentServiceInstallments(t *testing.T) {
+	cfg := getTestConfig()
+	cfg.Retry.Attempts = 1
+	calculator, err := installments.NewCalculator([]config.InstallmentConfig{{
+		Currency:            "BRL",
+		MinInstallments:     2,
+		MaxInstallments:     12,
+		MinInstallmentValue: 5,
+		InterestFree:        3,
+		Interest:            "merchant",
+		MonthlyRate:         0.0199,
+	}})
+	require.NoError(t, err)
+
+	t.Run("merchant interest is charged", func(t *testing.T) {
+		provider := new(MockProvider)
+		provider.On("GetID").Return("stripe")
+		provider.On("GetName").Return("Stripe")
+		provider.On("ProcessPayment", mock.MatchedBy(func(request domain.PaymentRequest) bool {
+			return request.Amount == 1134 && request.Card.Installments == 12
+		})).Return(&domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 1134, CurrentAmount: 1134, Currency: "BRL"}, nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithInstallments(calculator))
+		payment, err := service.ProcessPayment(testActor, domain.PaymentRequest{Amount: 1000, Currency: "BRL", Card: domain.Card{Number: "4111111111111111", Installments: 12}})
+		require.NoError(t, err)
+		require.NotNil(t, payment.Installments)
+		assert.Equal(t, 1000.0, payment.Installments.Amount)
+		assert.Equal(t, 94.5, payment.Installments.InstallmentAmount)
+		assert.Len(t, payment.Installments.Schedule, 12)
+
+		stored, err := service.GetPayment(testMerchantID, payment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, payment.Installments, stored.Installments)
+	})
+
+	t.Run("invalid installments never reach the providers", func(t *testing.T) {
+		provider := new(MockProvider)
+		service := NewPaymentService([]domain.PaymentProvider{provider}, store.NewMemoryStore(), cfg, WithInstallments(calculator))
+
+		_, err := service.ProcessPayment(testActor, domain.PaymentRequest{Amount: 100, Currency: "USD", Card: domain.Card{Number: "4111111111111111", Installments: 2}})
+		assert.ErrorIs(t, err, domain.ErrInvalidInstallments)
+		provider.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	})
+}
+
+func TestPaymentServiceFX(t *testing.T) {
+	cfg := getTestConfig()
+	cfg.Retry.Attempts = 1
+	cfg.FX.Markup = 0.02
+	rates, err := fx.NewStaticRates("USD", map[string]float64{"BRL": 5})
+	require.NoError(t, err)
+
+	newProviders := func() (*MockProvider, *MockProvider) {
+		stripe := &MockProvider{capabilities: domain.Capabilities{Currencies: []string{"BRL"}, SettlementCurrency: "BRL"}}
+		stripe.On("GetID").Return("stripe")
+		stripe.On("GetName").Return("Stripe")
+		braintree := &MockProvider{capabilities: domain.Capabilities{Currencies: []string{"USD"}}}
+		braintree.On("GetID").Return("braintree")
+		braintree.On("GetName").Return("Braintree")
+		return stripe, braintree
+	}
+
+	t.Run("converted to the settlement currency", func(t *testing.T) {
+		stripe, braintree := newProviders()
+		stripe.On("ProcessPayment", mock.MatchedBy(func(request domain.PaymentRequest) bool {
+			return request.Currency == "BRL" && request.Amount == 510
+		})).Return(&domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 510, CurrentAmount: 510, Currency: "BRL"}, nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{stripe, braintree}, store.NewMemoryStore(), cfg, WithFXRates(rates))
+		payment, err := service.ProcessPayment(testActor, domain.PaymentRequest{Amount: 100, Currency: "USD", Card: domain.Card{Number: "4111111111111111"}})
+		require.NoError(t, err)
+		require.NotNil(t, payment.FX)
+		assert.Equal(t, domain.FXConversion{FromCurrency: "USD", FromAmount: 100, ToCurrency: "BRL", ToAmount: 510, Rate: 5, Markup: 0.02, RateAsOf: payment.FX.RateAsOf}, *payment.FX)
+
+		transaction, err := service.transactions.Get(payment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, payment.FX, transaction.FX)
+		braintree.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	})
+
+	t.Run("providers that can't charge the currency are skipped", func(t *testing.T) {
+		stripe, braintree := newProviders()
+		request := domain.PaymentRequest{Amount: 100, Currency: "USD", Card: domain.Card{Number: "4111111111111111"}}
+		braintree.On("ProcessPayment", matchRequest(request)).Return(&domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 100, CurrentAmount: 100, Currency: "USD"}, nil)
+
+		service := NewPaymentService([]domain.PaymentProvider{stripe, braintree}, store.NewMemoryStore(), cfg)
+		payment, err := service.ProcessPayment(testActor, request)
+		require.NoError(t, err)
+		assert.Nil(t, payment.FX)
+		stripe.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	})
+
+	t.Run("currency no provider can charge", func(t *testing.T) {
+		stripe, braintree := newProviders()
+		service := NewPaymentService([]domain.PaymentProvider{stripe, braintree}, store.NewMemoryStore(), cfg, WithFXRates(rates))
+
+		_, err := service.ProcessPayment(testActor, domain.PaymentRequest{Amount: 100, Currency: "JPY", Card: domain.Card{Number: "4111111111111111"}})
+		assert.ErrorIs(t, err, domain.ErrUnsupportedPayment)
+		stripe.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+		braintree.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	})
+}
+
+func TestPaymentServiceCapabilities(t *testing.T) {
+	cfg := getTestConfig()
+	cfg.Retry.Attempts = 1
+
+	newProviders := func() (*MockProvider, *MockProvider) {
+		stripe := &MockProvider{capabilities: domain.Capabilities{
+			Operations:      []domain.OperationType{domain.OperationCharge, domain.OperationRefund},
+			Brands:          []string{domain.BrandVisa},
+			MaxInstallments: 6,
+			MaxAmount:       1000,
+		}}
+		stripe.On("GetID").Return("stripe")
+		stripe.On("GetName").Return("Stripe")
+		braintree := &MockProvider{capabilities: domain.Capabilities{MinAmount: 10}}
+		braintree.On("GetID").Return("braintree")
+		braintree.On("GetName").Return("Braintree")
+		return stripe, braintree
+	}
+
+	for name, request := range map[string]domain.PaymentRequest{
+		"brand not supported":      {Amount: 100, Currency: "BRL", Card: domain.Card{Number: "5555555555554444"}},
+		"too many installments":    {Amount: 100, Currency: "BRL", Card: domain.Card{Number: "4111111111111111", Installments: 12}},
+		"amount above the maximum": {Amount: 5000, Currency: "BRL", Card: domain.Card{Number: "4111111111111111"}},
+	} {
+		t.Run(name+" skips the provider", func(t *testing.T) {
+			stripe, braintree := newProviders()
+			braintree.On("ProcessPayment", mock.Anything).Return(&domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: request.Amount, CurrentAmount: request.Amount, Currency: "BRL"}, nil)
+
+			service := NewPaymentService([]domain.PaymentProvider{stripe, braintree}, store.NewMemoryStore(), cfg)
+			_, err := service.ProcessPayment(testActor, request)
+			require.NoError(t, err)
+			stripe.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+			braintree.AssertNumberOfCalls(t, "ProcessPayment", 1)
+		})
+	}
+
+	t.Run("no capable provider", func(t *testing.T) {
+		stripe, braintree := newProviders()
+		service := NewPaymentService([]domain.PaymentProvider{stripe, braintree}, store.NewMemoryStore(), cfg)
+
+		_, err := service.ProcessPayment(testActor, domain.PaymentRequest{Amount: 5, Currency: "BRL", Card: domain.Card{Number: "5555555555554444"}})
+		assert.ErrorIs(t, err, domain.ErrUnsupportedPayment)
+		assert.ErrorContains(t, err, "card brand mastercard is not supported")
+		assert.ErrorContains(t, err, "amount below the minimum")
+		stripe.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+		braintree.AssertNotCalled(t, "ProcessPayment", mock.Anything)
+	})
+
+	t.Run("partial refund not supported", func(t *testing.T) {
+		stripe, braintree := newProviders()
+		service := NewPaymentService([]domain.PaymentProvider{stripe, braintree}, store.NewMemoryStore(), cfg)
+		payment := &domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 100, CurrentAmount: 100, Currency: "BRL"}
+		service.transactions.Save(&domain.Transaction{Payment: payment, MerchantID: testMerchantID, ProviderID: "stripe", ProviderName: "Stripe"})
+
+		_, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40})
+		assert.ErrorIs(t, err, domain.ErrOperationNotSupported)
+		stripe.AssertNotCalled(t, "RefundPayment", mock.Anything, mock.Anything)
+
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 100}).Return(&domain.Payment{ID: payment.ID, CreatedAt: time.Now(), Status: domain.StatusRefunded, OriginalAmount: 100, CurrentAmount: 0, Currency: "BRL"}, nil)
+		refunded, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 100})
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRefunded, refunded.Status)
+	})
+}
+
+func TestPaymentServiceRefundFallback(t *testing.T) {
+	card := domain.Card{Number: "4111111111111111", HolderName: "Test Holder", ExpirationDate: "12/2030"}
+	unavailable := &domain.ProviderError{Class: domain.ErrorClassServerError, HTTPStatus: http.StatusServiceUnavailable, Err: errors.New("unexpected status code: 503")}
+
+	newService := func(fallback string) (*PaymentService, *MockProvider, *MockProvider, *domain.Payment) {
+		cfg := getTestConfig()
+		cfg.Retry.Attempts = 1
+		cfg.Refunds.Fallback = fallback
+		// Operations are declared like in config.toml, where lookups can't be
+		stripe := &MockProvider{capabilities: domain.Capabilities{
+			Operations: []domain.OperationType{domain.OperationCharge, domain.OperationRefund, domain.OperationPartialRefund},
+		}}
+		stripe.On("GetID").Return("stripe")
+		stripe.On("GetName").Return("Stripe")
+		braintree := &MockProvider{capabilities: domain.Capabilities{
+			Operations: []domain.OperationType{domain.OperationCharge, domain.OperationRefund, domain.OperationCredit},
+		}}
+		braintree.On("GetID").Return("braintree")
+		braintree.On("GetName").Return("Braintree")
+
+		service := NewPaymentService([]domain.PaymentProvider{stripe, braintree}, store.NewMemoryStore(), cfg)
+		payment := &domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 100, CurrentAmount: 100, Currency: "BRL", CardLast4: "1111"}
+		service.transactions.Save(&domain.Transaction{Payment: payment, MerchantID: testMerchantID, ProviderID: "stripe", ProviderName: "Stripe", CardFingerprint: card.Fingerprint()})
+		return service, stripe, braintree, payment
+	}
+
+	t.Run("successful refunds are recorded", func(t *testing.T) {
+		service, stripe, _, payment := newService(config.RefundFallbackNone)
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 100}).Return(&domain.Payment{ID: payment.ID, CreatedAt: time.Now(), Status: domain.StatusRefunded, OriginalAmount: 100, Currency: "BRL"}, nil)
+
+		_, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 100})
+		require.NoError(t, err)
+		refunds, err := service.PaymentRefunds(testMerchantID, payment.ID)
+		require.NoError(t, err)
+		require.Len(t, refunds, 1)
+		assert.Equal(t, domain.RefundMethodRefund, refunds[0].Method)
+		assert.Equal(t, domain.RefundSucceeded, refunds[0].Status)
+		assert.Equal(t, "stripe", refunds[0].ProviderID)
+	})
+
+	t.Run("refunds without an amount send the current amount", func(t *testing.T) {
+		service, stripe, _, payment := newService(config.RefundFallbackNone)
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 100}).Return(&domain.Payment{ID: payment.ID, CreatedAt: time.Now(), Status: domain.StatusRefunded, OriginalAmount: 100, Currency: "BRL"}, nil)
+
+		_, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{})
+		require.NoError(t, err)
+		refunds, err := service.PaymentRefunds(testMerchantID, payment.ID)
+		require.NoError(t, err)
+		require.Len(t, refunds, 1)
+		assert.Equal(t, 100.0, refunds[0].Amount)
+	})
+
+	t.Run("without fallback the refund fails", func(t *testing.T) {
+		service, stripe, _, payment := newService(config.RefundFallbackNone)
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 40}).Return(nil, unavailable)
+
+		_, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40})
+		assert.Error(t, err)
+		refunds, err := service.PaymentRefunds(testMerchantID, payment.ID)
+		require.NoError(t, err)
+		assert.Empty(t, refunds)
+	})
+
+	t.Run("refund with a lost answer is looked up instead of sent again", func(t *testing.T) {
+		service, stripe, _, payment := newService(config.RefundFallbackNone)
+		service.config.Retry.Attempts = 3
+		lost := &domain.ProviderError{Class: domain.ErrorClassNetwork, Err: fmt.Errorf("%w: connection reset", domain.ErrOutcomeUnknown)}
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 100}).Return(nil, lost).Once()
+		stripe.On("GetPayment", payment.ID).Return(&domain.Payment{ID: payment.ID, CreatedAt: time.Now(), Status: domain.StatusRefunded, OriginalAmount: 100, Currency: "BRL"}, nil)
+
+		refunded, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 100})
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRefunded, refunded.Status)
+		stripe.AssertNumberOfCalls(t, "RefundPayment", 1)
+	})
+
+	t.Run("refund with a lost answer that can't be looked up is queued", func(t *testing.T) {
+		service, stripe, _, payment := newService(config.RefundFallbackCredit)
+		service.config.Retry.Attempts = 3
+		lost := &domain.ProviderError{Class: domain.ErrorClassInvalidResponse, HTTPStatus: http.StatusOK, Err: fmt.Errorf("%w: error transforming response", domain.ErrOutcomeUnknown)}
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 40}).Return(nil, lost).Once()
+		stripe.On("GetPayment", payment.ID).Return(nil, unavailable)
+
+		queued, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40, Card: &card})
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRefundPending, queued.Status, "never credited, the refund may have gone through")
+		stripe.AssertNumberOfCalls(t, "RefundPayment", 1)
+	})
+
+	t.Run("queued until the provider recovers", func(t *testing.T) {
+		service, stripe, _, payment := newService(config.RefundFallbackQueue)
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 40}).Return(nil, unavailable).Twice()
+		stripe.On("GetPayment", payment.ID).Return(nil, unavailable).Once()
+		stripe.On("GetPayment", payment.ID).Return(&domain.Payment{ID: payment.ID, Status: domain.StatusAuthorized}, nil)
+
+		queued, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40})
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRefundPending, queued.Status)
+		_, err = service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40})
+		assert.Error(t, err, "a payment with a queued refund can't be refunded again")
+
+		assert.Equal(t, 0, service.RetryQueuedRefunds(time.Hour), "the charge can't be looked up")
+		assert.Equal(t, 0, service.RetryQueuedRefunds(time.Hour), "the provider is still down")
+		stripe.AssertNumberOfCalls(t, "RefundPayment", 2)
+		refunds, err := service.PaymentRefunds(testMerchantID, payment.ID)
+		require.NoError(t, err)
+		require.Len(t, refunds, 1)
+		assert.Equal(t, domain.RefundQueued, refunds[0].Status)
+		assert.Equal(t, 2, refunds[0].Retries)
+
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 40}).Return(&domain.Payment{ID: payment.ID, CreatedAt: time.Now(), Status: domain.StatusRefunded, OriginalAmount: 100, CurrentAmount: 60, Currency: "BRL"}, nil)
+		assert.Equal(t, 1, service.RetryQueuedRefunds(time.Hour))
+
+		transaction, err := service.transactions.Get(payment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRefunded, transaction.Payment.Status)
+		assert.Equal(t, 60.0, transaction.Payment.CurrentAmount)
+		assert.Equal(t, domain.RefundSucceeded, transaction.Refunds[0].Status)
+	})
+
+	t.Run("queued refunds are given up", func(t *testing.T) {
+		service, stripe, _, payment := newService(config.RefundFallbackQueue)
+		stripe.On("RefundPayment", payment.ID, mock.Anything).Return(nil, unavailable).Once()
+		stripe.On("GetPayment", payment.ID).Return(&domain.Payment{ID: payment.ID, Status: domain.StatusAuthorized}, nil)
+
+		_, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, service.RetryQueuedRefunds(0))
+
+		transaction, err := service.transactions.Get(payment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusAuthorized, transaction.Payment.Status)
+		assert.Equal(t, domain.RefundFailed, transaction.Refunds[0].Status)
+		assert.Equal(t, 100.0, transaction.Refunds[0].Amount)
+		stripe.AssertNumberOfCalls(t, "RefundPayment", 1)
+	})
+
+	t.Run("queued refunds already made are not sent again", func(t *testing.T) {
+		service, stripe, _, payment := newService(config.RefundFallbackQueue)
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 40}).Return(nil, unavailable).Once()
+		stripe.On("GetPayment", payment.ID).Return(&domain.Payment{ID: payment.ID, CreatedAt: time.Now(), Status: domain.StatusRefunded, OriginalAmount: 100, CurrentAmount: 60, Currency: "BRL"}, nil)
+
+		_, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40})
+		require.NoError(t, err)
+		assert.Equal(t, 1, service.RetryQueuedRefunds(time.Hour))
+
+		stripe.AssertNumberOfCalls(t, "RefundPayment", 1)
+		transaction, err := service.transactions.Get(payment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRefunded, transaction.Payment.Status)
+		assert.Equal(t, 60.0, transaction.Payment.CurrentAmount)
+		assert.Equal(t, domain.RefundSucceeded, transaction.Refunds[0].Status)
+	})
+
+	t.Run("refunds that can't be looked up are parked", func(t *testing.T) {
+		service, stripe, _, payment := newService(config.RefundFallbackQueue)
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 40}).Return(nil, unavailable).Once()
+		stripe.On("GetPayment", payment.ID).Return(nil, unavailable).Twice()
+
+		_, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40})
+		require.NoError(t, err)
+		assert.Equal(t, 0, service.RetryQueuedRefunds(0))
+
+		transaction, err := service.transactions.Get(payment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRefundPending, transaction.Payment.Status)
+		assert.Equal(t, domain.RefundUnknown, transaction.Refunds[0].Status)
+		_, err = service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40})
+		assert.Error(t, err, "a payment with a refund of unknown outcome can't be refunded again")
+
+		assert.Equal(t, 0, service.RetryQueuedRefunds(0))
+		stripe.AssertNumberOfCalls(t, "RefundPayment", 1)
+
+		stripe.On("GetPayment", payment.ID).Return(&domain.Payment{ID: payment.ID, Status: domain.StatusAuthorized}, nil)
+		assert.Equal(t, 1, service.RetryQueuedRefunds(0))
+		stripe.AssertNumberOfCalls(t, "RefundPayment", 1)
+		transaction, err = service.transactions.Get(payment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusAuthorized, transaction.Payment.Status)
+		assert.Equal(t, domain.RefundFailed, transaction.Refunds[0].Status)
+	})
+
+	t.Run("credited through another provider", func(t *testing.T) {
+		service, stripe, braintree, payment := newService(config.RefundFallbackCredit)
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 40}).Return(nil, unavailable)
+		braintree.On("Credit", mock.MatchedBy(func(request domain.CreditRequest) bool {
+			return request.Amount == 40 && request.Currency == "BRL" && request.Card == card && request.Reference != ""
+		})).Return(&domain.Payment{ID: "credit-1", CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 40, CurrentAmount: 40, Currency: "BRL"}, nil)
+
+		refunded, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40, Card: &card})
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRefunded, refunded.Status)
+		assert.Equal(t, 60.0, refunded.CurrentAmount)
+
+		refunds, err := service.PaymentRefunds(testMerchantID, payment.ID)
+		require.NoError(t, err)
+		require.Len(t, refunds, 1)
+		assert.Equal(t, domain.RefundMethodCredit, refunds[0].Method)
+		assert.Equal(t, domain.RefundSucceeded, refunds[0].Status)
+		assert.Equal(t, "braintree", refunds[0].ProviderID)
+		assert.Equal(t, "credit-1", refunds[0].ProviderRefundID)
+		assert.Equal(t, unavailable.Error(), refunds[0].Reason)
+	})
+
+	t.Run("credits of unknown outcome block refunds until looked up", func(t *testing.T) {
+		service, stripe, braintree, payment := newService(config.RefundFallbackCredit)
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 40}).Return(nil, unavailable)
+		braintree.On("Credit", mock.Anything).Return(nil, fmt.Errorf("%w: i/o timeout", domain.ErrOutcomeUnknown))
+
+		pending, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40, Card: &card})
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRefundPending, pending.Status)
+		_, err = service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40, Card: &card})
+		assert.Error(t, err, "a payment with a credit of unknown outcome can't be refunded again")
+		braintree.AssertNumberOfCalls(t, "Credit", 1)
+
+		refunds, err := service.PaymentRefunds(testMerchantID, payment.ID)
+		require.NoError(t, err)
+		require.Len(t, refunds, 1)
+		assert.Equal(t, domain.RefundUnknown, refunds[0].Status)
+		assert.Equal(t, "braintree", refunds[0].ProviderID)
+
+		braintree.On("FindPaymentByReference", refunds[0].ID).Return(nil, unavailable).Once()
+		assert.Equal(t, 0, service.RetryQueuedRefunds(0))
+		braintree.On("FindPaymentByReference", refunds[0].ID).Return(&domain.Payment{ID: "credit-1", Status: domain.StatusAuthorized, OriginalAmount: 40, CurrentAmount: 40, Currency: "BRL"}, nil)
+		assert.Equal(t, 1, service.RetryQueuedRefunds(0))
+
+		transaction, err := service.transactions.Get(payment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRefunded, transaction.Payment.Status)
+		assert.Equal(t, 60.0, transaction.Payment.CurrentAmount)
+		assert.Equal(t, domain.RefundSucceeded, transaction.Refunds[0].Status)
+		assert.Equal(t, "credit-1", transaction.Refunds[0].ProviderRefundID)
+		braintree.AssertNumberOfCalls(t, "Credit", 1)
+		stripe.AssertNotCalled(t, "GetPayment", mock.Anything)
+	})
+
+	t.Run("credits the provider never made are given up", func(t *testing.T) {
+		service, stripe, braintree, payment := newService(config.RefundFallbackCredit)
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 40}).Return(nil, unavailable)
+		braintree.On("Credit", mock.Anything).Return(nil, fmt.Errorf("%w: i/o timeout", domain.ErrOutcomeUnknown))
+		braintree.On("FindPaymentByReference", mock.Anything).Return(nil, domain.ErrPaymentNotFound)
+
+		_, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40, Card: &card})
+		require.NoError(t, err)
+		assert.Equal(t, 1, service.RetryQueuedRefunds(time.Hour))
+
+		transaction, err := service.transactions.Get(payment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusAuthorized, transaction.Payment.Status)
+		assert.Equal(t, domain.RefundFailed, transaction.Refunds[0].Status)
+	})
+
+	t.Run("queued without the card", func(t *testing.T) {
+		service, stripe, braintree, payment := newService(config.RefundFallbackCredit)
+		stripe.On("RefundPayment", payment.ID, domain.RefundRequest{Amount: 40}).Return(nil, unavailable)
+
+		queued, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40})
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRefundPending, queued.Status)
+		braintree.AssertNotCalled(t, "Credit", mock.Anything)
+	})
+
+	t.Run("card of another payment", func(t *testing.T) {
+		service, stripe, _, payment := newService(config.RefundFallbackCredit)
+		other := domain.Card{Number: "5555555555554444"}
+
+		_, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40, Card: &other})
+		assert.ErrorIs(t, err, domain.ErrInvalidCard)
+		stripe.AssertNotCalled(t, "RefundPayment", mock.Anything, mock.Anything)
+	})
+
+	t.Run("card with the same last digits", func(t *testing.T) {
+		service, stripe, _, payment := newService(config.RefundFallbackCredit)
+		other := domain.Card{Number: "4000000000001111", HolderName: "Test Holder", ExpirationDate: "12/2030"}
+
+		_, err := service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40, Card: &other})
+		assert.ErrorIs(t, err, domain.ErrInvalidCard)
+		stripe.AssertNotCalled(t, "RefundPayment", mock.Anything, mock.Anything)
+	})
+
+	t.Run("payments without a known card take no card", func(t *testing.T) {
+		service, stripe, _, payment := newService(config.RefundFallbackCredit)
+		transaction, err := service.transactions.Get(payment.ID)
+		require.NoError(t, err)
+		transaction.CardFingerprint = ""
+		require.NoError(t, service.transactions.Save(transaction))
+
+		_, err = service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 40, Card: &card})
+		assert.ErrorIs(t, err, domain.ErrInvalidCard)
+		stripe.AssertNotCalled(t, "RefundPayment", mock.Anything, mock.Anything)
+	})
+}
+
+func TestPaymentServiceDisputes(t *testing.T) {
+	newService := func() (*PaymentService, *domain.Payment) {
+		stripe := new(MockProvider)
+		service := NewPaymentService([]domain.PaymentProvider{stripe}, store.NewMemoryStore(), getTestConfig())
+		payment := &domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, OriginalAmount: 100, CurrentAmount: 100, Currency: "BRL"}
+		service.transactions.Save(&domain.Transaction{Payment: payment, MerchantID: testMerchantID, ProviderID: "stripe", ProviderName: "Stripe"})
+		return service, payment
+	}
+	admin := domain.Actor{Type: domain.ActorAdmin, ID: "ops"}
+
+	t.Run("disputed payments can't be refunded", func(t *testing.T) {
+		service, payment := newService()
+		_, err := service.DisputePayment(admin, payment.ID, 150)
+		assert.ErrorIs(t, err, domain.ErrInvalidDispute)
+
+		transaction, err := service.DisputePayment(admin, payment.ID, 100)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusDisputed, transaction.Payment.Status)
+		_, err = service.RefundPayment(testActor, payment.ID, domain.RefundRequest{Amount: 10})
+		assert.Error(t, err)
+		_, err = service.DisputePayment(admin, payment.ID, 100)
+		assert.ErrorIs(t, err, domain.ErrInvalidStatus)
+	})
+
+	t.Run("won disputes reinstate the payment", func(t *testing.T) {
+		service, payment := newService()
+		_, err := service.DisputePayment(admin, payment.ID, 100)
+		require.NoError(t, err)
+
+		reinstated, err := service.CloseDispute(admin, payment.ID, true, 100)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusAuthorized, reinstated.Status)
+		assert.Equal(t, 100.0, reinstated.CurrentAmount)
+		_, err = service.CloseDispute(admin, payment.ID, true, 100)
+		assert.ErrorIs(t, err, domain.ErrInvalidStatus)
+	})
+
+	t.Run("lost disputes charge the amount back", func(t *testing.T) {
+		service, payment := newService()
+		_, err := service.DisputePayment(admin, payment.ID, 30)
+		require.NoError(t, err)
+		chargedBack, err := service.CloseDispute(admin, payment.ID, false, 30)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusAuthorized, chargedBack.Status)
+		assert.Equal(t, 70.0, chargedBack.CurrentAmount)
+
+		_, err = service.DisputePayment(admin, payment.ID, 0)
+		require.NoError(t, err)
+		chargedBack, err = service.CloseDispute(admin, payment.ID, false, 70)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusRefunded, chargedBack.Status)
+		assert.Equal(t, 0.0, chargedBack.CurrentAmount)
+	})
+
+	t.Run("payments are found by their ID at the provider", func(t *testing.T) {
+		service, payment := newService()
+		resolved := &domain.Payment{ID: gofakeit.UUID(), CreatedAt: time.Now(), Status: domain.StatusAuthorized, CurrentAmount: 10, Currency: "BRL"}
+		service.transactions.Save(&domain.Transaction{Payment: resolved, MerchantID: testMerchantID, ProviderID: "stripe", ProviderPaymentID: "ch_resolved"})
+
+		found, err := service.ProviderTransaction("stripe", payment.ID)
+		require.NoError(t, err)
+		assert.Equal(t, payment.ID, found.Payment.ID)
+		found, err = service.ProviderTransaction("stripe", "ch_resolved")
+		require.NoError(t, err)
+		assert.Equal(t, resolved.ID, found.Payment.ID)
+		_, err = service.ProviderTransaction("braintree", payment.ID)
+		assert.ErrorIs(t, err, domain.ErrPaymentNotFound)
+	})
 }
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+
+	"desafio-api/internal/domain"
+)
+
+// errOutcomeUnresolved means a charge timed out and the provider couldn't
+// tell whether it went through, so it must not be retried elsewhere.
+var errOutcomeUnresolved = errors.New("charge outcome could not be resolved")
+
+// resolveCharge looks up a charge whose request timed out. A charge the
+// provider never saw is safe to retry, so the original error is returned;
+// a failed lookup stops the retries and the fallback.
+func (s *PaymentService) resolveCharge(provider domain.PaymentProvider, tracker *attempts, reference string, cause error) (*domain.Payment, error) {
+	log.Printf("[provider: %s] outcome unknown, looking up charge %s", provider.GetName(), reference)
+
+	payment, err := s.call(provider, domain.OperationLookup, tracker, func() (*domain.Payment, error) {
+		return provider.FindPaymentByReference(reference)
+	})
+	switch {
+	case err == nil:
+		log.Printf("[provider: %s] charge %s found with status %s", provider.GetName(), reference, payment.Status)
+		return payment, nil
+	case errors.Is(err, domain.ErrPaymentNotFound):
+		log.Printf("[provider: %s] charge %s was not created", provider.GetName(), reference)
+		return nil, cause
+	default:
+		return nil, retry.Unrecoverable(fmt.Errorf("%w: %v (lookup failed: %v)", errOutcomeUnresolved, cause, err))
+	}
+}
+
+// resolveRefund looks up a charge whose refund request got no answer. A
+// charge found refunded is the refund's result, one still charged is safe
+// to refund again, so the original error is returned; a failed lookup stops
+// the retries and leaves the refund to the fallback.
+func (s *PaymentService) resolveRefund(provider domain.PaymentProvider, tracker *attempts, transaction *domain.Transaction, cause error) (*domain.Payment, error) {
+	log.Printf("[provider: %s] outcome unknown, looking up refunded payment %s", provider.GetName(), transaction.Payment.ID)
+
+	charge, err := s.call(provider, domain.OperationLookup, tracker, func() (*domain.Payment, error) {
+		return provider.GetPayment(providerPaymentID(transaction))
+	})
+	switch {
+	case err != nil:
+		return nil, retry.Unrecoverable(fmt.Errorf("%w (lookup failed: %v)", cause, err))
+	case charge.Status == domain.StatusRefunded:
+		log.Printf("[provider: %s] payment %s was refunded", provider.GetName(), transaction.Payment.ID)
+		return charge, nil
+	default:
+		return nil, cause
+	}
+}
+
+// unknownPayment stores a charge with unknown outcome under its reference,
+// so the client gets an ID it can poll while the sweeper resolves it.
+func (s *PaymentService) unknownPayment(actor domain.Actor, provider domain.PaymentProvider, request domain.PaymentRequest, assessment *domain.RiskAssessment, tracker *attempts, pending *domain.Transaction) (*domain.Payment, error) {
+	payment := &domain.Payment{
+		ID:             request.Reference,
+		CreatedAt:      time.Now(),
+		Status:         domain.StatusUnknown,
+		OriginalAmount: request.Amount,
+		CurrentAmount:  request.Amount,
+		Currency:       request.Currency,
+		Description:    request.Description,
+		PaymentMethod:  "card",
+		CardLast4:      request.Card.Last4(),
+		Installments:   request.InstallmentPlan,
+		FX:             request.FX,
+	}
+	log.Printf("[provider: %s] payment %s stored with unknown outcome", provider.GetName(), payment.ID)
+
+	transaction := &domain.Transaction{
+		Payment:      payment,
+		MerchantID:   actor.MerchantID,
+		ProviderID:   provider.GetID(),
+		ProviderName: provider.GetName(),
+		Reference:    request.Reference,
+		Risk:         assessment,
+		Attempts:     tracker.snapshot(),
+		FX:           request.FX,
+		// Kept for the credit fallback once the payment is resolved
+		CardFingerprint: request.Card.Fingerprint(),
+	}
+	if err := s.storeCharge(actor, pending, transaction); err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrNotStored, err)
+	}
+	return payment, nil
+}
+
+// ResolveUnknownPayments looks up every payment in unknown status older
+// than minAge at its provider. Found charges take the provider's status,
+// charges the provider never saw are marked as failed, and lookups that
+// fail are left for the next run. It returns how many were resolved.
+func (s *PaymentService) ResolveUnknownPayments(minAge time.Duration) int {
+	actor := domain.SystemActor("sweeper")
+	pending, err := s.transactionsWithStatus(domain.StatusUnknown)
+	if err != nil {
+		log.Printf("failed to list payments with unknown outcome: %v", err)
+		return 0
+	}
+
+	resolved := 0
+	for _, transaction := range pending {
+		if time.Since(transaction.Payment.CreatedAt) < minAge {
+			continue
+		}
+		provider, err := s.providerByID(transaction.ProviderID)
+		if err != nil {
+			log.Printf("cannot resolve payment %s: %v", transaction.Payment.ID, err)
+			continue
+		}
+
+		before := snapshot(transaction)
+		tracker := &attempts{}
+		payment, err := s.call(provider, domain.OperationLookup, tracker, func() (*domain.Payment, error) {
+			return provider.FindPaymentByReference(transaction.Reference)
+		})
+		switch {
+		case err == nil:
+			transaction.ProviderPaymentID = payment.ID
+			payment.ID = transaction.Payment.ID
+			payment.CardLast4 = transaction.Payment.CardLast4
+			transaction.Payment = payment
+		case errors.Is(err, domain.ErrPaymentNotFound):
+			transaction.Payment.Status = domain.StatusFailed
+		default:
+			log.Printf("[provider: %s] lookup of payment %s failed: %v", provider.GetName(), transaction.Payment.ID, err)
+			s.saveAttempts(transaction, tracker)
+			continue
+		}
+
+		transaction.Attempts = append(transaction.Attempts, tracker.list...)
+		if err := s.saveTransaction(actor, domain.AuditPaymentResolved, before, transaction); err != nil {
+			log.Printf("failed to store resolved payment %s: %v", transaction.Payment.ID, err)
+			continue
+		}
+		log.Printf("[provider: %s] payment %s resolved with status %s", provider.GetName(), transaction.Payment.ID, transaction.Payment.Status)
+		resolved++
+	}
+	return resolved
+}
+
+// RunSweeper resolves payments with unknown outcome every interval until
+// ctx is done.
+func (s *PaymentService) RunSweeper(ctx context.Context, interval, minAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.ResolveUnknownPayments(minAge)
+		}
+	}
+}
+
+// RecoverUnknownOutcomes stores the charges interrupted by a previous
+// shutdown as payments in unknown status, so the sweeper resolves them.
+// Refunds and confirmations can't be recovered since their payments were
+// only kept in memory. It returns how many charges were recovered.
+func (s *PaymentService) RecoverUnknownOutcomes(outcomes []domain.UnknownOutcome) int {
+	actor := domain.SystemActor("recovery")
+	recovered := 0
+	for _, outcome := range outcomes {
+		if outcome.Type != domain.OperationCharge || outcome.Reference == "" {
+			log.Printf("cannot recover %s operation %s with unknown outcome", outcome.Type, outcome.ID)
+			continue
+		}
+		if _, err := s.transactions.Get(outcome.Reference); err == nil {
+			continue
+		}
+		provider, err := s.providerByID(outcome.ProviderID)
+		if err != nil {
+			log.Printf("cannot recover charge %s: %v", outcome.Reference, err)
+			continue
+		}
+
+		transaction := &domain.Transaction{
+			Payment: &domain.Payment{
+				ID:             outcome.Reference,
+				CreatedAt:      outcome.StartedAt,
+				Status:         domain.StatusUnknown,
+				OriginalAmount: outcome.Amount,
+				CurrentAmount:  outcome.Amount,
+				Currency:       outcome.Currency,
+				PaymentMethod:  "card",
+				CardLast4:      outcome.CardLast4,
+			},
+			MerchantID:   outcome.MerchantID,
+			ProviderID:   provider.GetID(),
+			ProviderName: provider.GetName(),
+			Reference:    outcome.Reference,
+		}
+		if err := s.saveTransaction(actor, domain.AuditPaymentRecovered, nil, transaction); err != nil {
+			log.Printf("failed to store recovered charge %s: %v", outcome.Reference, err)
+			continue
+		}
+		recovered++
+	}
+	return recovered
+}
+
+func providerPaymentID(transaction *domain.Transaction) string {
+	if transaction.ProviderPaymentID != "" {
+		return transaction.ProviderPaymentID
+	}
+	return transaction.Payment.ID
+}
@@ -0,0 +1,368 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/google/uuid"
+	"github.com/sony/gobreaker"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+// providerDown reports whether a refund failed because its provider is
+// unavailable, rather than because the provider refused it.
+func providerDown(err error) bool {
+	if errors.Is(err, domain.ErrProviderUnavailable) || errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) || errors.Is(err, domain.ErrOutcomeUnknown) {
+		return true
+	}
+	switch domain.ClassifyError(err) {
+	case domain.ErrorClassTimeout, domain.ErrorClassNetwork, domain.ErrorClassServerError:
+		return true
+	}
+	return false
+}
+
+// failureReason is the error of the last provider call behind err, without
+// the retries wrapping it.
+func failureReason(err error) string {
+	var providerErr *domain.ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Error()
+	}
+	return err.Error()
+}
+
+// refundFallback reports whether refunds failed with err get a fallback.
+func (s *PaymentService) refundFallback(err error) bool {
+	switch s.config.Refunds.Fallback {
+	case config.RefundFallbackQueue, config.RefundFallbackCredit:
+		return providerDown(err)
+	default:
+		return false
+	}
+}
+
+// fallbackRefund handles a refund whose provider is down: it is credited
+// through another provider when the policy allows it and the request
+// carries the card, otherwise queued. A refund of unknown outcome is never
+// credited, since it may have gone through. A credit whose outcome is
+// unknown is recorded as such and the payment left in refund_pending, so it
+// can't be refunded again until the credit is looked up.
+func (s *PaymentService) fallbackRefund(actor domain.Actor, before json.RawMessage, transaction *domain.Transaction, provider domain.PaymentProvider, request domain.RefundRequest, tracker *attempts, cause error) (*domain.Payment, error) {
+	now := time.Now()
+	refund := domain.Refund{
+		ID:           uuid.New().String(),
+		Method:       domain.RefundMethodRefund,
+		Status:       domain.RefundQueued,
+		Amount:       request.Amount,
+		Currency:     transaction.Payment.Currency,
+		ProviderID:   provider.GetID(),
+		ProviderName: provider.GetName(),
+		Reason:       failureReason(cause),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if refund.Amount <= 0 {
+		refund.Amount = transaction.Payment.CurrentAmount
+	}
+
+	var creditErr error
+	if s.config.Refunds.Fallback == config.RefundFallbackCredit && request.Card != nil && !errors.Is(cause, domain.ErrOutcomeUnknown) {
+		creditErr = s.credit(transaction, *request.Card, &refund, tracker)
+	}
+
+	transaction.Attempts = append(transaction.Attempts, tracker.list...)
+	payment := *transaction.Payment
+	var action domain.AuditAction
+	switch {
+	case creditErr != nil:
+		log.Printf("[provider: %s] credit of payment %s has an unknown outcome, refunds are blocked until it is looked up", refund.ProviderName, payment.ID)
+		refund.Method = domain.RefundMethodCredit
+		refund.Status = domain.RefundUnknown
+		refund.Error = creditErr.Error()
+		payment.Status = domain.StatusRefundPending
+		action = domain.AuditPaymentRefundQueued
+	case refund.Status == domain.RefundSucceeded:
+		log.Printf("[provider: %s] payment %s refunded with a credit", refund.ProviderName, payment.ID)
+		payment.Status = domain.StatusRefunded
+		payment.CurrentAmount = math.Round((payment.CurrentAmount-refund.Amount)*100) / 100
+		action = domain.AuditPaymentCredited
+	default:
+		log.Printf("[provider: %s] refund of payment %s queued until the provider recovers", provider.GetName(), payment.ID)
+		payment.Status = domain.StatusRefundPending
+		action = domain.AuditPaymentRefundQueued
+	}
+	transaction.Refunds = append(transaction.Refunds, refund)
+	transaction.Payment = &payment
+	if err := s.saveTransaction(actor, action, before, transaction); err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrNotStored, err)
+	}
+	return &payment, nil
+}
+
+// credit sends refund as a credit to card through the first other provider
+// able to make it, updating refund when one does. Providers failing or
+// declining it are skipped, except when a credit outcome is unknown: it may
+// have gone through, so no other provider is tried and the error is
+// returned with refund pointing at that provider. When no provider makes
+// it, refund is left queued.
+func (s *PaymentService) credit(transaction *domain.Transaction, card domain.Card, refund *domain.Refund, tracker *attempts) error {
+	request := domain.CreditRequest{
+		Amount:      refund.Amount,
+		Currency:    refund.Currency,
+		Description: "Refund of payment " + transaction.Payment.ID,
+		Reference:   refund.ID,
+		Card:        card,
+	}
+	for _, provider := range s.providers {
+		capabilities := provider.Capabilities()
+		if provider.GetID() == transaction.ProviderID || !capabilities.Credits() || !capabilities.ChargesCurrency(request.Currency) {
+			continue
+		}
+
+		log.Printf("[provider: %s] attempting to credit refund of payment %s", provider.GetName(), transaction.Payment.ID)
+		result, err := s.execute(provider.GetID(), domain.OperationCredit, func() (interface{}, error) {
+			var credit *domain.Payment
+			err := s.retry(provider, domain.OperationCredit, func() error {
+				var err error
+				credit, err = s.call(provider, domain.OperationCredit, tracker, func() (*domain.Payment, error) {
+					return provider.Credit(request)
+				})
+				if errors.Is(err, domain.ErrOutcomeUnknown) {
+					// Looked up by its reference once queued, never sent again
+					return retry.Unrecoverable(err)
+				}
+				return err
+			})
+			return credit, err
+		})
+		if errors.Is(err, domain.ErrOutcomeUnknown) {
+			refund.ProviderID = provider.GetID()
+			refund.ProviderName = provider.GetName()
+			return fmt.Errorf("[provider: %s] credit outcome unknown: %w", provider.GetName(), err)
+		}
+		if err != nil {
+			log.Printf("[provider: %s] credit failed: %v", provider.GetName(), err)
+			continue
+		}
+		credit := result.(*domain.Payment)
+		if credit.Status == domain.StatusFailed {
+			log.Printf("[provider: %s] credit declined", provider.GetName())
+			continue
+		}
+
+		refund.Method = domain.RefundMethodCredit
+		refund.Status = domain.RefundSucceeded
+		refund.ProviderID = provider.GetID()
+		refund.ProviderName = provider.GetName()
+		refund.ProviderRefundID = credit.ID
+		refund.UpdatedAt = time.Now()
+		return nil
+	}
+	return nil
+}
+
+// pendingRefund returns the entry of the refund ledger still waiting for
+// its outcome, queued or unknown.
+func pendingRefund(transaction *domain.Transaction) *domain.Refund {
+	for i := len(transaction.Refunds) - 1; i >= 0; i-- {
+		status := transaction.Refunds[i].Status
+		if status == domain.RefundQueued || status == domain.RefundUnknown {
+			return &transaction.Refunds[i]
+		}
+	}
+	return nil
+}
+
+// RetryQueuedRefunds settles the pending refunds of the payments in
+// refund_pending status. It returns how many were completed or given up.
+func (s *PaymentService) RetryQueuedRefunds(maxAge time.Duration) int {
+	actor := domain.SystemActor("refund-retrier")
+	pending, err := s.transactionsWithStatus(domain.StatusRefundPending)
+	if err != nil {
+		log.Printf("failed to list payments with queued refunds: %v", err)
+		return 0
+	}
+
+	done := 0
+	for _, transaction := range pending {
+		refund := pendingRefund(transaction)
+		if refund == nil {
+			log.Printf("payment %s has no pending refund", transaction.Payment.ID)
+			continue
+		}
+
+		before := snapshot(transaction)
+		tracker := &attempts{}
+		action := s.retryRefund(transaction, refund, tracker, maxAge)
+		refund.UpdatedAt = time.Now()
+		transaction.Attempts = append(transaction.Attempts, tracker.list...)
+		if action == "" {
+			refund.Retries++
+			if err := s.transactions.Save(transaction); err != nil {
+				log.Printf("failed to store queued refund of payment %s: %v", transaction.Payment.ID, err)
+			}
+			continue
+		}
+
+		if err := s.saveTransaction(actor, action, before, transaction); err != nil {
+			log.Printf("failed to store refund of payment %s: %v", transaction.Payment.ID, err)
+			continue
+		}
+		log.Printf("[provider: %s] pending refund of payment %s %s", refund.ProviderName, transaction.Payment.ID, refund.Status)
+		done++
+	}
+	return done
+}
+
+// retryRefund settles a pending refund at the provider of its payment. A
+// refund that timed out may have gone through, so the charge is looked up
+// first and the refund is only sent again when the provider shows it isn't
+// refunded. Refunds the provider refuses, or not refunded after maxAge, are
+// given up and the payment goes back to authorized. When the charge still
+// can't be looked up after maxAge the refund is parked as unknown: it is
+// only looked up from then on. It returns the audit action of the outcome,
+// or an empty one while the refund stays pending.
+func (s *PaymentService) retryRefund(transaction *domain.Transaction, refund *domain.Refund, tracker *attempts, maxAge time.Duration) domain.AuditAction {
+	if refund.Method == domain.RefundMethodCredit {
+		return s.reconcileCredit(transaction, refund, tracker)
+	}
+	provider, err := s.providerByID(transaction.ProviderID)
+	if err != nil {
+		log.Printf("cannot retry refund of payment %s: %v", transaction.Payment.ID, err)
+		return ""
+	}
+	expired := time.Since(refund.CreatedAt) >= maxAge
+
+	charge, err := s.call(provider, domain.OperationLookup, tracker, func() (*domain.Payment, error) {
+		return provider.GetPayment(providerPaymentID(transaction))
+	})
+	switch {
+	case err != nil:
+		refund.Error = err.Error()
+		if expired && refund.Status != domain.RefundUnknown {
+			log.Printf("[provider: %s] refund of payment %s parked, its outcome can't be looked up: %v", provider.GetName(), transaction.Payment.ID, err)
+			refund.Status = domain.RefundUnknown
+		}
+		return ""
+	case charge.Status == domain.StatusRefunded:
+		log.Printf("[provider: %s] payment %s was already refunded", provider.GetName(), transaction.Payment.ID)
+		return refundSucceeded(transaction, refund, charge)
+	case expired || refund.Status == domain.RefundUnknown:
+		return refundGivenUp(transaction, refund, "not refunded by the provider")
+	}
+
+	result, err := s.execute(provider.GetID(), domain.OperationRefund, func() (interface{}, error) {
+		return s.call(provider, domain.OperationRefund, tracker, func() (*domain.Payment, error) {
+			return provider.RefundPayment(providerPaymentID(transaction), domain.RefundRequest{Amount: refund.Amount})
+		})
+	})
+	switch {
+	case err == nil:
+		return refundSucceeded(transaction, refund, result.(*domain.Payment))
+	case providerDown(err):
+		refund.Error = err.Error()
+		return ""
+	default:
+		return refundGivenUp(transaction, refund, err.Error())
+	}
+}
+
+// reconcileCredit looks up a credit of unknown outcome by its reference at
+// the provider that was sent it. It stays unknown until the provider shows
+// it or says it has no such credit.
+func (s *PaymentService) reconcileCredit(transaction *domain.Transaction, refund *domain.Refund, tracker *attempts) domain.AuditAction {
+	provider, err := s.providerByID(refund.ProviderID)
+	if err != nil {
+		log.Printf("cannot look up credit of payment %s: %v", transaction.Payment.ID, err)
+		return ""
+	}
+	credit, err := s.call(provider, domain.OperationLookup, tracker, func() (*domain.Payment, error) {
+		return provider.FindPaymentByReference(refund.ID)
+	})
+	switch {
+	case errors.Is(err, domain.ErrPaymentNotFound):
+		return refundGivenUp(transaction, refund, "credit not made by the provider")
+	case err != nil:
+		refund.Error = err.Error()
+		return ""
+	case credit.Status == domain.StatusFailed:
+		return refundGivenUp(transaction, refund, "credit declined by the provider")
+	}
+	transaction.Payment.Status = domain.StatusRefunded
+	transaction.Payment.CurrentAmount = math.Round((transaction.Payment.CurrentAmount-refund.Amount)*100) / 100
+	refund.Status = domain.RefundSucceeded
+	refund.ProviderRefundID = credit.ID
+	refund.Error = ""
+	return domain.AuditPaymentCredited
+}
+
+func refundSucceeded(transaction *domain.Transaction, refund *domain.Refund, payment *domain.Payment) domain.AuditAction {
+	payment.ID = transaction.Payment.ID
+	payment.Status = domain.StatusRefunded
+	payment.CardLast4 = transaction.Payment.CardLast4
+	transaction.Payment = payment
+	refund.Status = domain.RefundSucceeded
+	refund.Error = ""
+	return domain.AuditPaymentRefunded
+}
+
+func refundGivenUp(transaction *domain.Transaction, refund *domain.Refund, reason string) domain.AuditAction {
+	transaction.Payment.Status = domain.StatusAuthorized
+	refund.Status = domain.RefundFailed
+	refund.Error = reason
+	return domain.AuditPaymentRefundFailed
+}
+
+// RunRefundRetrier settles the pending refunds every interval until ctx is
+// done.
+func (s *PaymentService) RunRefundRetrier(ctx context.Context, interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RetryQueuedRefunds(maxAge)
+		}
+	}
+}
+
+// PaymentRefunds returns the refund ledger of a payment of the merchant,
+// oldest first.
+func (s *PaymentService) PaymentRefunds(merchantID string, paymentID string) ([]domain.Refund, error) {
+	transaction, err := s.merchantTransaction(merchantID, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if transaction.Refunds == nil {
+		return []domain.Refund{}, nil
+	}
+	return transaction.Refunds, nil
+}
+
+// transactionsWithStatus lists every stored transaction in status.
+func (s *PaymentService) transactionsWithStatus(status domain.PaymentStatus) ([]*domain.Transaction, error) {
+	var all []*domain.Transaction
+	cursor := ""
+	for {
+		transactions, next, err := s.transactions.List(domain.PaymentFilter{Status: status, Cursor: cursor})
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, transactions...)
+		if next == "" {
+			return all, nil
+		}
+		cursor = next
+	}
+}
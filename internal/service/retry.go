@@ -0,0 +1,174 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+
+	"desafio-api/internal/config"
+	"desafio-api/internal/domain"
+)
+
+// retry calls fn until it succeeds, following the retry policy of the
+// operation at provider. A retry is skipped when it would go over the
+// provider's retry budget or over the policy's max elapsed time.
+func (s *PaymentService) retry(provider domain.PaymentProvider, operation domain.OperationType, fn func() error) error {
+	policy := s.config.GetRetryPolicy(provider.GetID(), string(operation))
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+	s.retryBudget.request(provider.GetID())
+
+	started := time.Now()
+	delays := &backoff{policy: policy}
+	attempt := 0
+	var wait time.Duration
+	return retry.Do(
+		func() error {
+			attempt++
+			return fn()
+		},
+		retry.Attempts(uint(policy.Attempts)),
+		retry.DelayType(func(uint, error, *retry.Config) time.Duration {
+			return wait
+		}),
+		retry.RetryIf(func(err error) bool {
+			if !retry.IsRecoverable(err) || attempt >= policy.Attempts {
+				return false
+			}
+			wait = delays.next(err)
+			if policy.MaxElapsed > 0 && time.Since(started)+wait > policy.MaxElapsed {
+				log.Printf("[provider: %s] not retrying %s, max elapsed time of %v reached", provider.GetName(), operation, policy.MaxElapsed)
+				return false
+			}
+			if !s.retryBudget.allow(provider.GetID()) {
+				log.Printf("[provider: %s] not retrying %s, retry budget exhausted", provider.GetName(), operation)
+				return false
+			}
+			return true
+		}),
+		retry.OnRetry(func(n uint, err error) {
+			log.Printf("[provider: %s] retry %d in %v: %v", provider.GetName(), n+1, wait, err)
+		}),
+	)
+}
+
+// backoff computes the delays between the attempts of one operation. A
+// Retry-After sent by the provider wins over a shorter delay.
+type backoff struct {
+	policy   config.RetryPolicy
+	retries  int
+	previous time.Duration
+}
+
+func (b *backoff) next(err error) time.Duration {
+	b.retries++
+	delay := b.policy.Delay
+	switch b.policy.Strategy {
+	case config.RetryExponential:
+		for i := 1; i < b.retries && (b.policy.MaxDelay == 0 || delay < b.policy.MaxDelay); i++ {
+			delay *= 2
+		}
+	case config.RetryDecorrelatedJitter:
+		// Random between the base delay and three times the previous one
+		upper := 3 * b.previous
+		if upper <= b.policy.Delay {
+			upper = 3 * b.policy.Delay
+		}
+		if upper > b.policy.Delay {
+			delay = b.policy.Delay + time.Duration(rand.Int63n(int64(upper-b.policy.Delay)))
+		}
+	}
+	if b.policy.MaxDelay > 0 && delay > b.policy.MaxDelay {
+		delay = b.policy.MaxDelay
+	}
+	b.previous = delay
+
+	var providerErr *domain.ProviderError
+	if errors.As(err, &providerErr) && providerErr.RetryAfter > delay {
+		delay = providerErr.RetryAfter
+	}
+	return delay
+}
+
+// retryBudget limits the retries to each provider to a ratio of its
+// requests over a sliding window, counted in one second buckets.
+type retryBudget struct {
+	mutex   sync.Mutex
+	config  config.RetryBudgetConfig
+	now     func() time.Time
+	windows map[string][]budgetBucket
+}
+
+type budgetBucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+func newRetryBudget(cfg *config.Config) *retryBudget {
+	return &retryBudget{
+		config:  cfg.Retry.Budget,
+		now:     time.Now,
+		windows: make(map[string][]budgetBucket),
+	}
+}
+
+func (b *retryBudget) enabled() bool {
+	return b.config.Ratio > 0 && b.config.WindowSeconds > 0
+}
+
+// request counts a new operation sent to the provider.
+func (b *retryBudget) request(providerID string) {
+	if !b.enabled() {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.bucket(providerID).requests++
+}
+
+// allow reports whether the provider's budget has room for one more retry,
+// and takes it if so.
+func (b *retryBudget) allow(providerID string) bool {
+	if !b.enabled() {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	current := b.bucket(providerID)
+	requests, retries := 0, 0
+	for _, bucket := range b.windows[providerID] {
+		if bucket.second > current.second-int64(b.config.WindowSeconds) {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+	allowed := b.config.Ratio*float64(requests) + b.config.MinPerSecond*float64(b.config.WindowSeconds)
+	if float64(retries+1) > allowed {
+		return false
+	}
+	current.retries++
+	return true
+}
+
+// bucket returns the bucket of the current second, reusing the slot of one
+// that fell out of the window.
+func (b *retryBudget) bucket(providerID string) *budgetBucket {
+	window, ok := b.windows[providerID]
+	if !ok {
+		window = make([]budgetBucket, b.config.WindowSeconds)
+		b.windows[providerID] = window
+	}
+	second := b.now().Unix()
+	bucket := &window[second%int64(len(window))]
+	if bucket.second != second {
+		*bucket = budgetBucket{second: second}
+	}
+	return bucket
+}
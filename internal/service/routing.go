@@ -0,0 +1,89 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"desafio-api/internal/domain"
+)
+
+// WithFXRates converts charges to the settlement currency of providers that
+// don't charge the currency of the request. Without it those providers are
+// skipped.
+func WithFXRates(rates domain.FXRateProvider) Option {
+	return func(s *PaymentService) {
+		s.fx = rates
+	}
+}
+
+// route is a provider able to make a charge and the request it is sent.
+type route struct {
+	provider domain.PaymentProvider
+	request  domain.PaymentRequest
+}
+
+// routes returns the providers whose capabilities allow the charge, in
+// fallback order, each with the request to send it. It fails with
+// ErrUnsupportedPayment, telling why, when there are none.
+func (s *PaymentService) routes(request domain.PaymentRequest) ([]route, error) {
+	var routes []route
+	var reasons []string
+	for _, provider := range s.providers {
+		routed, err := s.route(provider.Capabilities(), request)
+		if err != nil {
+			if !slices.Contains(reasons, err.Error()) {
+				reasons = append(reasons, err.Error())
+			}
+			continue
+		}
+		routes = append(routes, route{provider: provider, request: routed})
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("%w: %s", domain.ErrUnsupportedPayment, strings.Join(reasons, "; "))
+	}
+	return routes, nil
+}
+
+// route returns the request to send to a provider with the given
+// capabilities: request itself, or converted to the provider's settlement
+// currency when it doesn't charge the currency of request.
+func (s *PaymentService) route(capabilities domain.Capabilities, request domain.PaymentRequest) (domain.PaymentRequest, error) {
+	if !capabilities.ChargesCurrency(request.Currency) {
+		converted, err := s.convert(capabilities.SettlementCurrency, request)
+		if err != nil {
+			return request, err
+		}
+		request = converted
+	}
+	if err := capabilities.CheckCharge(request); err != nil {
+		return request, err
+	}
+	return request, nil
+}
+
+func (s *PaymentService) convert(currency string, request domain.PaymentRequest) (domain.PaymentRequest, error) {
+	if currency == "" || s.fx == nil {
+		return request, fmt.Errorf("%w: %s", domain.ErrUnsupportedCurrency, request.Currency)
+	}
+
+	rate, err := s.fx.Rate(request.Currency, currency)
+	if err != nil {
+		return request, err
+	}
+	markup := s.config.FX.Markup
+	converted := request
+	converted.Currency = rate.To
+	converted.Amount = math.Round(request.Amount*rate.Rate*(1+markup)*100) / 100
+	converted.FX = &domain.FXConversion{
+		FromCurrency: request.Currency,
+		FromAmount:   request.Amount,
+		ToCurrency:   converted.Currency,
+		ToAmount:     converted.Amount,
+		Rate:         rate.Rate,
+		Markup:       markup,
+		RateAsOf:     rate.AsOf,
+	}
+	return converted, nil
+}
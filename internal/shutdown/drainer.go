@@ -0,0 +1,87 @@
+package shutdown
+
+import (
+	"context"
+	"sync"
+)
+
+// Drainer counts the requests being served and, once draining starts,
+// turns new ones away so the running ones can finish before the server
+// stops.
+type Drainer struct {
+	mutex    sync.Mutex
+	draining bool
+	active   int
+	idle     chan struct{}
+}
+
+func NewDrainer() *Drainer {
+	return &Drainer{idle: make(chan struct{})}
+}
+
+// Begin registers a request. It returns false while draining, in which case
+// the request must be rejected and End must not be called.
+func (d *Drainer) Begin() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.draining {
+		return false
+	}
+	d.active++
+	return true
+}
+
+func (d *Drainer) End() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.active--
+	if d.draining && d.active == 0 {
+		close(d.idle)
+	}
+}
+
+// Go runs fn in the background counted as an active request, so Drain also
+// waits for background work such as queue workers. Once draining fn is not
+// run and false is returned.
+func (d *Drainer) Go(fn func()) bool {
+	if !d.Begin() {
+		return false
+	}
+	go func() {
+		defer d.End()
+		fn()
+	}()
+	return true
+}
+
+func (d *Drainer) Draining() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.draining
+}
+
+func (d *Drainer) Active() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.active
+}
+
+// Drain stops accepting requests and waits for the active ones to finish,
+// or for ctx to be done, in which case ctx.Err() is returned.
+func (d *Drainer) Drain(ctx context.Context) error {
+	d.mutex.Lock()
+	if !d.draining {
+		d.draining = true
+		if d.active == 0 {
+			close(d.idle)
+		}
+	}
+	d.mutex.Unlock()
+
+	select {
+	case <-d.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
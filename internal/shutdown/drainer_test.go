@@ -0,0 +1,55 @@
+package shutdown
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainer(t *testing.T) {
+	t.Run("waits for active requests and rejects new ones", func(t *testing.T) {
+		d := NewDrainer()
+		assert.True(t, d.Begin())
+
+		drained := make(chan error)
+		go func() { drained <- d.Drain(context.Background()) }()
+
+		assert.Eventually(t, d.Draining, time.Second, time.Millisecond)
+		assert.False(t, d.Begin())
+
+		d.End()
+		assert.NoError(t, <-drained)
+	})
+
+	t.Run("waits for background work", func(t *testing.T) {
+		d := NewDrainer()
+		release := make(chan struct{})
+		assert.True(t, d.Go(func() { <-release }))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, d.Drain(ctx), context.DeadlineExceeded)
+		assert.False(t, d.Go(func() { t.Error("ran while draining") }))
+
+		close(release)
+		assert.NoError(t, d.Drain(context.Background()))
+	})
+
+	t.Run("returns immediately when idle", func(t *testing.T) {
+		d := NewDrainer()
+		assert.NoError(t, d.Drain(context.Background()))
+		assert.NoError(t, d.Drain(context.Background()))
+	})
+
+	t.Run("gives up at the deadline", func(t *testing.T) {
+		d := NewDrainer()
+		assert.True(t, d.Begin())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, d.Drain(ctx), context.DeadlineExceeded)
+		assert.Equal(t, 1, d.Active())
+	})
+}
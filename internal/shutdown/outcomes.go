@@ -0,0 +1,66 @@
+package shutdown
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"desafio-api/internal/domain"
+)
+
+// RecordUnknownOutcomes appends one JSON line per operation to path, so
+// the operations can be reconciled with the providers after a restart.
+func RecordUnknownOutcomes(path string, operations []domain.InFlightOperation, reason string) error {
+	if len(operations) == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening unknown outcome file: %w", err)
+	}
+	defer file.Close()
+
+	now := time.Now()
+	encoder := json.NewEncoder(file)
+	for _, operation := range operations {
+		outcome := domain.UnknownOutcome{InFlightOperation: operation, Reason: reason, RecordedAt: now}
+		if err := encoder.Encode(outcome); err != nil {
+			return fmt.Errorf("error writing unknown outcome: %w", err)
+		}
+	}
+	return file.Sync()
+}
+
+// LoadUnknownOutcomes reads the operations recorded by RecordUnknownOutcomes.
+// A missing file means there is nothing to reconcile.
+func LoadUnknownOutcomes(path string) ([]domain.UnknownOutcome, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening unknown outcome file: %w", err)
+	}
+	defer file.Close()
+
+	var outcomes []domain.UnknownOutcome
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var outcome domain.UnknownOutcome
+		if err := json.Unmarshal(scanner.Bytes(), &outcome); err != nil {
+			return nil, fmt.Errorf("error parsing unknown outcome on line %d: %w", line, err)
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading unknown outcome file: %w", err)
+	}
+	return outcomes, nil
+}
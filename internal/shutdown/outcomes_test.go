@@ -0,0 +1,34 @@
+package shutdown
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"desafio-api/internal/domain"
+)
+
+func TestUnknownOutcomes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "unknown_outcomes.ndjson")
+
+	outcomes, err := LoadUnknownOutcomes(path)
+	assert.NoError(t, err)
+	assert.Empty(t, outcomes)
+
+	charge := domain.InFlightOperation{ID: "op-1", Type: domain.OperationCharge, MerchantID: "merchant", ProviderID: "stripe", Amount: 10, StartedAt: time.Now()}
+	refund := domain.InFlightOperation{ID: "op-2", Type: domain.OperationRefund, MerchantID: "merchant", PaymentID: "pay-1", Amount: 5, StartedAt: time.Now()}
+	assert.NoError(t, RecordUnknownOutcomes(path, []domain.InFlightOperation{charge}, "interrupted by shutdown"))
+	assert.NoError(t, RecordUnknownOutcomes(path, []domain.InFlightOperation{refund}, "interrupted by shutdown"))
+	assert.NoError(t, RecordUnknownOutcomes(path, nil, "nothing"))
+
+	outcomes, err = LoadUnknownOutcomes(path)
+	assert.NoError(t, err)
+	assert.Len(t, outcomes, 2)
+	assert.Equal(t, "op-1", outcomes[0].ID)
+	assert.Equal(t, "stripe", outcomes[0].ProviderID)
+	assert.Equal(t, domain.OperationRefund, outcomes[1].Type)
+	assert.Equal(t, "pay-1", outcomes[1].PaymentID)
+	assert.Equal(t, "interrupted by shutdown", outcomes[1].Reason)
+}
@@ -0,0 +1,361 @@
+package store
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"desafio-api/internal/domain"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// indexKeys holds the values a transaction was indexed under, so the
+// indexes can be updated when a saved transaction changes.
+type indexKeys struct {
+	merchant  string
+	status    domain.PaymentStatus
+	provider  string
+	currency  string
+	last4     string
+	createdAt time.Time
+}
+
+type entry struct {
+	id        string
+	createdAt time.Time
+}
+
+func (e entry) before(other entry) bool {
+	if e.createdAt.Equal(other.createdAt) {
+		return e.id < other.id
+	}
+	return e.createdAt.Before(other.createdAt)
+}
+
+// MemoryStore keeps transactions in memory with secondary indexes on the
+// fields used for searching, plus a slice ordered by creation time that
+// backs range queries and cursor pagination.
+type MemoryStore struct {
+	mutex        sync.RWMutex
+	transactions map[string]*domain.Transaction
+	keys         map[string]indexKeys
+	byMerchant   map[string]map[string]struct{}
+	byStatus     map[domain.PaymentStatus]map[string]struct{}
+	byProvider   map[string]map[string]struct{}
+	byCurrency   map[string]map[string]struct{}
+	byLast4      map[string]map[string]struct{}
+	ordered      []entry
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		transactions: make(map[string]*domain.Transaction),
+		keys:         make(map[string]indexKeys),
+		byMerchant:   make(map[string]map[string]struct{}),
+		byStatus:     make(map[domain.PaymentStatus]map[string]struct{}),
+		byProvider:   make(map[string]map[string]struct{}),
+		byCurrency:   make(map[string]map[string]struct{}),
+		byLast4:      make(map[string]map[string]struct{}),
+	}
+}
+
+func (s *MemoryStore) Save(transaction *domain.Transaction) error {
+	if transaction == nil || transaction.Payment == nil || transaction.Payment.ID == "" {
+		return fmt.Errorf("transaction must have a payment with an ID")
+	}
+
+	stored := cloneTransaction(transaction)
+	id := stored.Payment.ID
+	keys := indexKeys{
+		merchant:  stored.MerchantID,
+		status:    stored.Payment.Status,
+		provider:  stored.ProviderID,
+		currency:  strings.ToUpper(stored.Payment.Currency),
+		last4:     stored.Payment.CardLast4,
+		createdAt: stored.Payment.CreatedAt,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if previous, exists := s.keys[id]; exists {
+		s.unindex(id, previous)
+	}
+	s.index(id, keys)
+	s.keys[id] = keys
+	s.transactions[id] = stored
+	return nil
+}
+
+func (s *MemoryStore) Get(paymentID string) (*domain.Transaction, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	transaction, exists := s.transactions[paymentID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", domain.ErrPaymentNotFound, paymentID)
+	}
+	return cloneTransaction(transaction), nil
+}
+
+// Ping always succeeds, the store lives in memory.
+func (s *MemoryStore) Ping() error {
+	return nil
+}
+
+// List returns the transactions matching the filter in creation order,
+// together with the cursor for the next page (empty on the last page).
+func (s *MemoryStore) List(filter domain.PaymentFilter) ([]*domain.Transaction, string, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	descending := filter.Order == domain.SortDesc
+
+	var after *entry
+	if filter.Cursor != "" {
+		cursor, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = &cursor
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	candidates := s.candidates(filter)
+	if descending {
+		for i, j := 0, len(candidates)-1; i < j; i, j = i+1, j-1 {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		}
+	}
+
+	results := make([]*domain.Transaction, 0, limit)
+	var last entry
+	hasMore := false
+	for _, e := range candidates {
+		if after != nil {
+			if !descending && !after.before(e) {
+				continue
+			}
+			if descending && !e.before(*after) {
+				continue
+			}
+		}
+
+		transaction := s.transactions[e.id]
+		if !matches(transaction, filter) {
+			continue
+		}
+		if len(results) == limit {
+			hasMore = true
+			break
+		}
+		results = append(results, cloneTransaction(transaction))
+		last = e
+	}
+
+	if !hasMore {
+		return results, "", nil
+	}
+	return results, encodeCursor(last), nil
+}
+
+// candidates picks the smallest index matching one of the equality filters
+// and returns its entries ordered by creation time. Without an equality
+// filter it falls back to the ordered slice, narrowed by the created-at range.
+func (s *MemoryStore) candidates(filter domain.PaymentFilter) []entry {
+	var smallest map[string]struct{}
+	indexed := false
+	pick := func(index map[string]struct{}) {
+		if !indexed || len(index) < len(smallest) {
+			smallest = index
+		}
+		indexed = true
+	}
+
+	if filter.MerchantID != "" {
+		pick(s.byMerchant[filter.MerchantID])
+	}
+	if filter.Status != "" {
+		pick(s.byStatus[filter.Status])
+	}
+	if filter.ProviderID != "" {
+		pick(s.byProvider[filter.ProviderID])
+	}
+	if filter.Currency != "" {
+		pick(s.byCurrency[strings.ToUpper(filter.Currency)])
+	}
+	if filter.CardLast4 != "" {
+		pick(s.byLast4[filter.CardLast4])
+	}
+
+	if indexed {
+		entries := make([]entry, 0, len(smallest))
+		for id := range smallest {
+			entries = append(entries, entry{id: id, createdAt: s.keys[id].createdAt})
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].before(entries[j]) })
+		return entries
+	}
+
+	start, end := 0, len(s.ordered)
+	if filter.CreatedFrom != nil {
+		start = sort.Search(len(s.ordered), func(i int) bool {
+			return !s.ordered[i].createdAt.Before(*filter.CreatedFrom)
+		})
+	}
+	if filter.CreatedTo != nil {
+		end = sort.Search(len(s.ordered), func(i int) bool {
+			return !s.ordered[i].createdAt.Before(*filter.CreatedTo)
+		})
+	}
+	if start >= end {
+		return nil
+	}
+	entries := make([]entry, end-start)
+	copy(entries, s.ordered[start:end])
+	return entries
+}
+
+func (s *MemoryStore) index(id string, keys indexKeys) {
+	addToIndex(s.byMerchant, keys.merchant, id)
+	addToIndex(s.byStatus, keys.status, id)
+	addToIndex(s.byProvider, keys.provider, id)
+	addToIndex(s.byCurrency, keys.currency, id)
+	if keys.last4 != "" {
+		addToIndex(s.byLast4, keys.last4, id)
+	}
+
+	e := entry{id: id, createdAt: keys.createdAt}
+	i := sort.Search(len(s.ordered), func(i int) bool { return e.before(s.ordered[i]) })
+	s.ordered = append(s.ordered, entry{})
+	copy(s.ordered[i+1:], s.ordered[i:])
+	s.ordered[i] = e
+}
+
+func (s *MemoryStore) unindex(id string, keys indexKeys) {
+	removeFromIndex(s.byMerchant, keys.merchant, id)
+	removeFromIndex(s.byStatus, keys.status, id)
+	removeFromIndex(s.byProvider, keys.provider, id)
+	removeFromIndex(s.byCurrency, keys.currency, id)
+	removeFromIndex(s.byLast4, keys.last4, id)
+
+	e := entry{id: id, createdAt: keys.createdAt}
+	i := sort.Search(len(s.ordered), func(i int) bool { return !s.ordered[i].before(e) })
+	if i < len(s.ordered) && s.ordered[i].id == id {
+		s.ordered = append(s.ordered[:i], s.ordered[i+1:]...)
+	}
+}
+
+func addToIndex[K comparable](index map[K]map[string]struct{}, key K, id string) {
+	ids, exists := index[key]
+	if !exists {
+		ids = make(map[string]struct{})
+		index[key] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+func removeFromIndex[K comparable](index map[K]map[string]struct{}, key K, id string) {
+	ids, exists := index[key]
+	if !exists {
+		return
+	}
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(index, key)
+	}
+}
+
+func matches(transaction *domain.Transaction, filter domain.PaymentFilter) bool {
+	payment := transaction.Payment
+	if filter.MerchantID != "" && transaction.MerchantID != filter.MerchantID {
+		return false
+	}
+	if filter.Status != "" && payment.Status != filter.Status {
+		return false
+	}
+	if filter.ProviderID != "" && transaction.ProviderID != filter.ProviderID {
+		return false
+	}
+	if filter.Currency != "" && !strings.EqualFold(payment.Currency, filter.Currency) {
+		return false
+	}
+	if filter.CardLast4 != "" && payment.CardLast4 != filter.CardLast4 {
+		return false
+	}
+	if filter.MinAmount != nil && payment.OriginalAmount < *filter.MinAmount {
+		return false
+	}
+	if filter.MaxAmount != nil && payment.OriginalAmount > *filter.MaxAmount {
+		return false
+	}
+	if filter.CreatedFrom != nil && payment.CreatedAt.Before(*filter.CreatedFrom) {
+		return false
+	}
+	if filter.CreatedTo != nil && !payment.CreatedAt.Before(*filter.CreatedTo) {
+		return false
+	}
+	if filter.Description != "" && !strings.Contains(strings.ToLower(payment.Description), strings.ToLower(filter.Description)) {
+		return false
+	}
+	return true
+}
+
+func cloneTransaction(transaction *domain.Transaction) *domain.Transaction {
+	clone := *transaction
+	if transaction.Payment != nil {
+		payment := *transaction.Payment
+		if payment.NextAction != nil {
+			nextAction := *payment.NextAction
+			if nextAction.Data != nil {
+				nextAction.Data = make(map[string]string, len(payment.NextAction.Data))
+				for k, v := range payment.NextAction.Data {
+					nextAction.Data[k] = v
+				}
+			}
+			payment.NextAction = &nextAction
+		}
+		clone.Payment = &payment
+	}
+	if transaction.Risk != nil {
+		risk := *transaction.Risk
+		risk.Reasons = append([]string(nil), transaction.Risk.Reasons...)
+		clone.Risk = &risk
+	}
+	return &clone
+}
+
+func encodeCursor(e entry) string {
+	raw := strconv.FormatInt(e.createdAt.UnixNano(), 10) + ":" + e.id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (entry, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return entry{}, fmt.Errorf("%w: %v", domain.ErrInvalidCursor, err)
+	}
+	nanos, id, found := strings.Cut(string(raw), ":")
+	if !found || id == "" {
+		return entry{}, domain.ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return entry{}, fmt.Errorf("%w: %v", domain.ErrInvalidCursor, err)
+	}
+	return entry{id: id, createdAt: time.Unix(0, n)}, nil
+}
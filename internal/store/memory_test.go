@@ -0,0 +1,138 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+
+	"desafio-api/internal/domain"
+)
+
+func newTransaction(createdAt time.Time, status domain.PaymentStatus, providerID, currency string, amount float64) *domain.Transaction {
+	return &domain.Transaction{
+		Payment: &domain.Payment{
+			ID:             gofakeit.UUID(),
+			CreatedAt:      createdAt,
+			Status:         status,
+			OriginalAmount: amount,
+			CurrentAmount:  amount,
+			Currency:       currency,
+			Description:    gofakeit.Sentence(3),
+			PaymentMethod:  "card",
+			CardLast4:      "4242",
+		},
+		ProviderID:   providerID,
+		ProviderName: providerID,
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	gofakeit.Seed(0)
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("get returns a copy", func(t *testing.T) {
+		s := NewMemoryStore()
+		transaction := newTransaction(base, domain.StatusAuthorized, "stripe", "BRL", 100)
+		assert.NoError(t, s.Save(transaction))
+
+		stored, err := s.Get(transaction.Payment.ID)
+		assert.NoError(t, err)
+		stored.Payment.Status = domain.StatusFailed
+
+		again, _ := s.Get(transaction.Payment.ID)
+		assert.Equal(t, domain.StatusAuthorized, again.Payment.Status)
+	})
+
+	t.Run("get non-existent payment", func(t *testing.T) {
+		s := NewMemoryStore()
+		_, err := s.Get("non-existent")
+		assert.ErrorIs(t, err, domain.ErrPaymentNotFound)
+	})
+
+	t.Run("filters by indexed fields and reindexes on save", func(t *testing.T) {
+		s := NewMemoryStore()
+		failed := newTransaction(base, domain.StatusFailed, "braintree", "BRL", 50)
+		other := newTransaction(base.Add(time.Minute), domain.StatusFailed, "stripe", "BRL", 60)
+		authorized := newTransaction(base.Add(2*time.Minute), domain.StatusAuthorized, "braintree", "USD", 70)
+		for _, transaction := range []*domain.Transaction{failed, other, authorized} {
+			assert.NoError(t, s.Save(transaction))
+		}
+
+		results, cursor, err := s.List(domain.PaymentFilter{Status: domain.StatusFailed, ProviderID: "braintree", Currency: "brl"})
+		assert.NoError(t, err)
+		assert.Empty(t, cursor)
+		assert.Len(t, results, 1)
+		assert.Equal(t, failed.Payment.ID, results[0].Payment.ID)
+
+		authorized.Payment.Status = domain.StatusFailed
+		assert.NoError(t, s.Save(authorized))
+
+		results, _, _ = s.List(domain.PaymentFilter{Status: domain.StatusFailed})
+		assert.Len(t, results, 3)
+		results, _, _ = s.List(domain.PaymentFilter{Status: domain.StatusAuthorized})
+		assert.Empty(t, results)
+	})
+
+	t.Run("filters by amount, date range and description", func(t *testing.T) {
+		s := NewMemoryStore()
+		for i := 0; i < 5; i++ {
+			transaction := newTransaction(base.Add(time.Duration(i)*time.Hour), domain.StatusAuthorized, "stripe", "BRL", float64(10*(i+1)))
+			if i == 3 {
+				transaction.Payment.Description = "Recall refund batch"
+			}
+			assert.NoError(t, s.Save(transaction))
+		}
+
+		minAmount, maxAmount := 20.0, 40.0
+		results, _, err := s.List(domain.PaymentFilter{MinAmount: &minAmount, MaxAmount: &maxAmount})
+		assert.NoError(t, err)
+		assert.Len(t, results, 3)
+
+		from, to := base.Add(time.Hour), base.Add(3*time.Hour)
+		results, _, _ = s.List(domain.PaymentFilter{CreatedFrom: &from, CreatedTo: &to})
+		assert.Len(t, results, 2)
+
+		results, _, _ = s.List(domain.PaymentFilter{Description: "recall"})
+		assert.Len(t, results, 1)
+	})
+
+	t.Run("paginates with cursor in both orders", func(t *testing.T) {
+		s := NewMemoryStore()
+		ids := make([]string, 0, 5)
+		for i := 0; i < 5; i++ {
+			transaction := newTransaction(base.Add(time.Duration(i)*time.Second), domain.StatusAuthorized, "stripe", "BRL", 10)
+			ids = append(ids, transaction.Payment.ID)
+			assert.NoError(t, s.Save(transaction))
+		}
+
+		var seen []string
+		cursor := ""
+		for {
+			results, next, err := s.List(domain.PaymentFilter{Limit: 2, Cursor: cursor})
+			assert.NoError(t, err)
+			for _, transaction := range results {
+				seen = append(seen, transaction.Payment.ID)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		assert.Equal(t, ids, seen)
+
+		results, next, err := s.List(domain.PaymentFilter{Limit: 2, Order: domain.SortDesc})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{ids[4], ids[3]}, []string{results[0].Payment.ID, results[1].Payment.ID})
+
+		results, _, _ = s.List(domain.PaymentFilter{Limit: 2, Order: domain.SortDesc, Cursor: next})
+		assert.Equal(t, []string{ids[2], ids[1]}, []string{results[0].Payment.ID, results[1].Payment.ID})
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		s := NewMemoryStore()
+		_, _, err := s.List(domain.PaymentFilter{Cursor: "not a cursor"})
+		assert.ErrorIs(t, err, domain.ErrInvalidCursor)
+	})
+}
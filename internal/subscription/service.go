@@ -0,0 +1,423 @@
+package subscription
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"desafio-api/internal/domain"
+)
+
+type PaymentService interface {
+	ProcessPayment(actor domain.Actor, request domain.PaymentRequest) (*domain.Payment, error)
+}
+
+type CardVault interface {
+	Get(merchantID string, token string) (*domain.StoredCard, error)
+}
+
+// Service keeps plans and subscriptions and charges the subscriptions that
+// are due through the payment service. A failed charge is retried after
+// each delay of the dunning schedule; once they are exhausted the
+// subscription is unpaid and no longer charged until resumed.
+type Service struct {
+	mutex         sync.Mutex
+	billing       sync.Mutex
+	payments      PaymentService
+	vault         CardVault
+	dunning       []time.Duration
+	plans         map[string]*domain.Plan
+	subscriptions map[string]*subscription
+	now           func() time.Time
+}
+
+// subscription is a stored subscription with the number of periods billed
+// since its anchor, which places the next billing date.
+type subscription struct {
+	domain.Subscription
+	cycles int
+}
+
+func NewService(payments PaymentService, vault CardVault, dunning []time.Duration) *Service {
+	return &Service{
+		payments:      payments,
+		vault:         vault,
+		dunning:       dunning,
+		plans:         make(map[string]*domain.Plan),
+		subscriptions: make(map[string]*subscription),
+		now:           time.Now,
+	}
+}
+
+func (s *Service) CreatePlan(merchantID string, request domain.PlanRequest) (*domain.Plan, error) {
+	if request.Amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", domain.ErrInvalidSubscription)
+	}
+	if request.Currency == "" {
+		return nil, fmt.Errorf("%w: currency is required", domain.ErrInvalidSubscription)
+	}
+	switch request.Interval {
+	case domain.IntervalDay, domain.IntervalWeek, domain.IntervalMonth, domain.IntervalYear:
+	default:
+		return nil, fmt.Errorf("%w: interval must be day, week, month or year", domain.ErrInvalidSubscription)
+	}
+	if request.IntervalCount < 0 {
+		return nil, fmt.Errorf("%w: intervalCount must be positive", domain.ErrInvalidSubscription)
+	}
+	if request.IntervalCount == 0 {
+		request.IntervalCount = 1
+	}
+
+	plan := &domain.Plan{
+		ID:            uuid.New().String(),
+		MerchantID:    merchantID,
+		Name:          request.Name,
+		Amount:        request.Amount,
+		Currency:      request.Currency,
+		Interval:      request.Interval,
+		IntervalCount: request.IntervalCount,
+		CreatedAt:     s.now(),
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.plans[plan.ID] = plan
+	result := *plan
+	return &result, nil
+}
+
+func (s *Service) GetPlan(merchantID string, planID string) (*domain.Plan, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	plan, err := s.plan(merchantID, planID)
+	if err != nil {
+		return nil, err
+	}
+	result := *plan
+	return &result, nil
+}
+
+func (s *Service) ListPlans(merchantID string) ([]*domain.Plan, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	plans := make([]*domain.Plan, 0)
+	for _, plan := range s.plans {
+		if plan.MerchantID == merchantID {
+			result := *plan
+			plans = append(plans, &result)
+		}
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].CreatedAt.Before(plans[j].CreatedAt) })
+	return plans, nil
+}
+
+func (s *Service) plan(merchantID string, planID string) (*domain.Plan, error) {
+	plan, ok := s.plans[planID]
+	if !ok || plan.MerchantID != merchantID {
+		return nil, domain.ErrPlanNotFound
+	}
+	return plan, nil
+}
+
+// CreateSubscription subscribes a stored card to a plan. The first period
+// is charged by the scheduler at StartAt, or right away.
+func (s *Service) CreateSubscription(merchantID string, request domain.SubscriptionRequest) (*domain.Subscription, error) {
+	if _, err := s.vault.Get(merchantID, request.CardToken); err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidSubscription, err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, err := s.plan(merchantID, request.PlanID); err != nil {
+		return nil, fmt.Errorf("%w: %w", domain.ErrInvalidSubscription, err)
+	}
+
+	now := s.now()
+	anchor := now
+	if request.StartAt != nil && request.StartAt.After(now) {
+		anchor = *request.StartAt
+	}
+	sub := &subscription{Subscription: domain.Subscription{
+		ID:            uuid.New().String(),
+		MerchantID:    merchantID,
+		PlanID:        request.PlanID,
+		CardToken:     request.CardToken,
+		Status:        domain.SubscriptionActive,
+		BillingAnchor: anchor,
+		NextBillingAt: anchor,
+		Charges:       []domain.SubscriptionCharge{},
+		CreatedAt:     now,
+	}}
+	s.subscriptions[sub.ID] = sub
+	log.Printf("subscription %s created for plan %s, first charge at %s", sub.ID, sub.PlanID, anchor.Format(time.RFC3339))
+	return sub.snapshot(), nil
+}
+
+func (s *Service) GetSubscription(merchantID string, subscriptionID string) (*domain.Subscription, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sub, err := s.subscription(merchantID, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	return sub.snapshot(), nil
+}
+
+func (s *Service) ListSubscriptions(merchantID string) ([]*domain.Subscription, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	subscriptions := make([]*domain.Subscription, 0)
+	for _, sub := range s.subscriptions {
+		if sub.MerchantID == merchantID {
+			subscriptions = append(subscriptions, sub.snapshot())
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt) })
+	return subscriptions, nil
+}
+
+func (s *Service) subscription(merchantID string, subscriptionID string) (*subscription, error) {
+	sub, ok := s.subscriptions[subscriptionID]
+	if !ok || sub.MerchantID != merchantID {
+		return nil, domain.ErrSubscriptionNotFound
+	}
+	return sub, nil
+}
+
+// Pause stops charging a subscription until it is resumed.
+func (s *Service) Pause(merchantID string, subscriptionID string) (*domain.Subscription, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sub, err := s.subscription(merchantID, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	switch sub.Status {
+	case domain.SubscriptionPaused:
+		return sub.snapshot(), nil
+	case domain.SubscriptionCanceled:
+		return nil, domain.ErrSubscriptionCanceled
+	}
+	now := s.now()
+	sub.Status = domain.SubscriptionPaused
+	sub.PausedAt = &now
+	sub.NextRetryAt = nil
+	log.Printf("subscription %s paused", sub.ID)
+	return sub.snapshot(), nil
+}
+
+// Resume reactivates a paused or unpaid subscription. If its billing date
+// went by in the meantime the billing restarts now, otherwise the next
+// charge keeps its date.
+func (s *Service) Resume(merchantID string, subscriptionID string) (*domain.Subscription, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sub, err := s.subscription(merchantID, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	switch sub.Status {
+	case domain.SubscriptionActive, domain.SubscriptionPastDue:
+		return sub.snapshot(), nil
+	case domain.SubscriptionCanceled:
+		return nil, domain.ErrSubscriptionCanceled
+	}
+	now := s.now()
+	if sub.Status == domain.SubscriptionUnpaid || sub.NextBillingAt.Before(now) {
+		sub.BillingAnchor = now
+		sub.NextBillingAt = now
+		sub.cycles = 0
+	}
+	sub.Status = domain.SubscriptionActive
+	sub.PausedAt = nil
+	sub.FailedAttempts = 0
+	sub.NextRetryAt = nil
+	log.Printf("subscription %s resumed, next charge at %s", sub.ID, sub.NextBillingAt.Format(time.RFC3339))
+	return sub.snapshot(), nil
+}
+
+// Cancel ends a subscription for good.
+func (s *Service) Cancel(merchantID string, subscriptionID string) (*domain.Subscription, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sub, err := s.subscription(merchantID, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if sub.Status != domain.SubscriptionCanceled {
+		now := s.now()
+		sub.Status = domain.SubscriptionCanceled
+		sub.CanceledAt = &now
+		sub.NextRetryAt = nil
+		log.Printf("subscription %s canceled", sub.ID)
+	}
+	return sub.snapshot(), nil
+}
+
+// RunScheduler charges the subscriptions that are due every interval until
+// ctx is done.
+func (s *Service) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if charged := s.BillDue(); charged > 0 {
+				log.Printf("scheduler charged %d subscriptions", charged)
+			}
+		}
+	}
+}
+
+// BillDue charges every subscription whose billing date or dunning retry
+// is due, returning how many were charged.
+func (s *Service) BillDue() int {
+	s.billing.Lock()
+	defer s.billing.Unlock()
+
+	now := s.now()
+	s.mutex.Lock()
+	var due []*subscription
+	for _, sub := range s.subscriptions {
+		switch {
+		case sub.Status == domain.SubscriptionActive && !sub.NextBillingAt.After(now):
+			due = append(due, sub)
+		case sub.Status == domain.SubscriptionPastDue && sub.NextRetryAt != nil && !sub.NextRetryAt.After(now):
+			due = append(due, sub)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextBillingAt.Before(due[j].NextBillingAt) })
+	s.mutex.Unlock()
+
+	for _, sub := range due {
+		s.bill(sub)
+	}
+	return len(due)
+}
+
+func (s *Service) bill(sub *subscription) {
+	s.mutex.Lock()
+	plan, err := s.plan(sub.MerchantID, sub.PlanID)
+	merchantID, subscriptionID, cardToken := sub.MerchantID, sub.ID, sub.CardToken
+	charge := domain.SubscriptionCharge{PeriodStart: sub.NextBillingAt, Attempt: sub.FailedAttempts + 1}
+	var request domain.PaymentRequest
+	if err == nil {
+		request = domain.PaymentRequest{
+			Amount:      plan.Amount,
+			Currency:    plan.Currency,
+			Description: fmt.Sprintf("Subscription %s (%s)", subscriptionID, plan.Name),
+		}
+	}
+	s.mutex.Unlock()
+
+	var payment *domain.Payment
+	if err == nil {
+		var card *domain.StoredCard
+		card, err = s.vault.Get(merchantID, cardToken)
+		if err == nil {
+			request.Card = card.Card
+			actor := domain.Actor{Type: domain.ActorSystem, ID: "subscriptions", MerchantID: merchantID}
+			payment, err = s.payments.ProcessPayment(actor, request)
+		}
+	}
+	if err == nil && payment.Status == domain.StatusRequiresAction {
+		err = fmt.Errorf("card requires authentication by the customer")
+	} else if err == nil && payment.Status != domain.StatusAuthorized && payment.Status != domain.StatusUnknown {
+		err = fmt.Errorf("payment %s", payment.Status)
+	}
+	// Charged but not stored: like an unknown outcome, charging again
+	// could charge twice
+	notStored := errors.Is(err, domain.ErrNotStored)
+	if notStored {
+		log.Printf("subscription %s charged but its payment wasn't stored: %v", subscriptionID, err)
+		err = nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	charge.ChargedAt = s.now()
+	if payment != nil {
+		charge.PaymentID = payment.ID
+		charge.Status = payment.Status
+	} else if notStored {
+		charge.Status = domain.StatusUnknown
+	}
+	if err != nil {
+		charge.Error = err.Error()
+	}
+	sub.Charges = append(sub.Charges, charge)
+	// Paused or canceled while being charged
+	billable := sub.Status == domain.SubscriptionActive || sub.Status == domain.SubscriptionPastDue
+
+	if err == nil {
+		// An unknown outcome counts as paid: charging again could charge twice.
+		// The period is paid even if the subscription was paused or canceled
+		// meanwhile, so Resume doesn't bill it again.
+		sub.cycles++
+		sub.NextBillingAt = periodStart(sub.BillingAnchor, plan.Interval, plan.IntervalCount, sub.cycles)
+		if !billable {
+			log.Printf("subscription %s charged with payment %s while %s", sub.ID, charge.PaymentID, sub.Status)
+			return
+		}
+		sub.Status = domain.SubscriptionActive
+		sub.FailedAttempts = 0
+		sub.NextRetryAt = nil
+		log.Printf("subscription %s charged with payment %s, next charge at %s", sub.ID, charge.PaymentID, sub.NextBillingAt.Format(time.RFC3339))
+		return
+	}
+	if !billable {
+		return
+	}
+
+	sub.FailedAttempts++
+	if sub.FailedAttempts > len(s.dunning) {
+		sub.Status = domain.SubscriptionUnpaid
+		sub.NextRetryAt = nil
+		log.Printf("subscription %s unpaid after %d failed charges: %v", sub.ID, sub.FailedAttempts, err)
+		return
+	}
+	retryAt := charge.ChargedAt.Add(s.dunning[sub.FailedAttempts-1])
+	sub.Status = domain.SubscriptionPastDue
+	sub.NextRetryAt = &retryAt
+	log.Printf("subscription %s charge failed, retrying at %s: %v", sub.ID, retryAt.Format(time.RFC3339), err)
+}
+
+func (sub *subscription) snapshot() *domain.Subscription {
+	result := sub.Subscription
+	result.Charges = append([]domain.SubscriptionCharge{}, sub.Charges...)
+	return &result
+}
+
+// periodStart returns the start of the period that begins cycles intervals
+// after anchor. Months keep the anchor's day, or the last day of shorter
+// months, so a subscription anchored on the 31st isn't pushed into the next
+// month.
+func periodStart(anchor time.Time, interval domain.PlanInterval, count int, cycles int) time.Time {
+	switch interval {
+	case domain.IntervalDay:
+		return anchor.AddDate(0, 0, count*cycles)
+	case domain.IntervalWeek:
+		return anchor.AddDate(0, 0, 7*count*cycles)
+	case domain.IntervalYear:
+		return addMonths(anchor, 12*count*cycles)
+	default:
+		return addMonths(anchor, count*cycles)
+	}
+}
+
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	if day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
@@ -0,0 +1,213 @@
+package subscription
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/internal/domain"
+	"desafio-api/internal/vault"
+)
+
+const testMerchantID = "merchant-test"
+
+type stubPayments struct {
+	mutex    sync.Mutex
+	requests []domain.PaymentRequest
+	results  []error
+	// charging runs while a charge is in flight
+	charging func()
+}
+
+// ProcessPayment fails with the next queued error, or authorizes.
+func (p *stubPayments) ProcessPayment(actor domain.Actor, request domain.PaymentRequest) (*domain.Payment, error) {
+	if p.charging != nil {
+		p.charging()
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.requests = append(p.requests, request)
+	if len(p.results) > 0 {
+		err := p.results[0]
+		p.results = p.results[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &domain.Payment{ID: "pay-" + time.Now().String(), Status: domain.StatusAuthorized, OriginalAmount: request.Amount}, nil
+}
+
+func newTestService(t *testing.T, payments *stubPayments, dunning []time.Duration) (*Service, *time.Time, string) {
+	cards := vault.NewMemoryVault()
+	card, err := cards.Store(testMerchantID, domain.Card{Number: "4111 1111 1111 1111", CVV: "123", ExpirationDate: "12/2030"})
+	require.NoError(t, err)
+
+	now := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	service := NewService(payments, cards, dunning)
+	service.now = func() time.Time { return now }
+	return service, &now, card.Token
+}
+
+func TestService(t *testing.T) {
+	t.Run("monthly charges keep the anchor day", func(t *testing.T) {
+		payments := &stubPayments{}
+		service, now, token := newTestService(t, payments, nil)
+		plan, err := service.CreatePlan(testMerchantID, domain.PlanRequest{Name: "Pro", Amount: 29.9, Currency: "BRL", Interval: domain.IntervalMonth})
+		require.NoError(t, err)
+		sub, err := service.CreateSubscription(testMerchantID, domain.SubscriptionRequest{PlanID: plan.ID, CardToken: token})
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, service.BillDue())
+		sub, err = service.GetSubscription(testMerchantID, sub.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.SubscriptionActive, sub.Status)
+		assert.Equal(t, time.Date(2024, 2, 29, 10, 0, 0, 0, time.UTC), sub.NextBillingAt)
+		require.Len(t, sub.Charges, 1)
+		assert.Equal(t, domain.StatusAuthorized, sub.Charges[0].Status)
+
+		// Not due until the next billing date
+		assert.Equal(t, 0, service.BillDue())
+		*now = sub.NextBillingAt
+		assert.Equal(t, 1, service.BillDue())
+		sub, _ = service.GetSubscription(testMerchantID, sub.ID)
+		assert.Equal(t, time.Date(2024, 3, 31, 10, 0, 0, 0, time.UTC), sub.NextBillingAt)
+
+		require.Len(t, payments.requests, 2)
+		assert.Equal(t, 29.9, payments.requests[0].Amount)
+		assert.Equal(t, "4111 1111 1111 1111", payments.requests[0].Card.Number)
+		assert.Empty(t, payments.requests[0].Card.CVV)
+	})
+
+	t.Run("failed charges follow the dunning schedule", func(t *testing.T) {
+		declined := errors.New("all providers failed")
+		payments := &stubPayments{results: []error{declined, declined, declined}}
+		service, now, token := newTestService(t, payments, []time.Duration{24 * time.Hour, 72 * time.Hour})
+		plan, _ := service.CreatePlan(testMerchantID, domain.PlanRequest{Amount: 10, Currency: "BRL", Interval: domain.IntervalWeek})
+		sub, err := service.CreateSubscription(testMerchantID, domain.SubscriptionRequest{PlanID: plan.ID, CardToken: token})
+		require.NoError(t, err)
+		start := *now
+
+		service.BillDue()
+		sub, _ = service.GetSubscription(testMerchantID, sub.ID)
+		assert.Equal(t, domain.SubscriptionPastDue, sub.Status)
+		require.NotNil(t, sub.NextRetryAt)
+		assert.Equal(t, start.Add(24*time.Hour), *sub.NextRetryAt)
+
+		*now = start.Add(24 * time.Hour)
+		service.BillDue()
+		sub, _ = service.GetSubscription(testMerchantID, sub.ID)
+		assert.Equal(t, start.Add(96*time.Hour), *sub.NextRetryAt)
+
+		*now = start.Add(96 * time.Hour)
+		service.BillDue()
+		sub, _ = service.GetSubscription(testMerchantID, sub.ID)
+		assert.Equal(t, domain.SubscriptionUnpaid, sub.Status)
+		assert.Nil(t, sub.NextRetryAt)
+		assert.Len(t, sub.Charges, 3)
+		assert.Equal(t, 3, sub.Charges[2].Attempt)
+
+		// Resuming charges again right away
+		*now = start.Add(200 * time.Hour)
+		sub, err = service.Resume(testMerchantID, sub.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.SubscriptionActive, sub.Status)
+		assert.Equal(t, *now, sub.NextBillingAt)
+		assert.Equal(t, 1, service.BillDue())
+		sub, _ = service.GetSubscription(testMerchantID, sub.ID)
+		assert.Equal(t, now.AddDate(0, 0, 7), sub.NextBillingAt)
+	})
+
+	t.Run("charges that couldn't be stored count as paid", func(t *testing.T) {
+		notStored := fmt.Errorf("%w: disk full", domain.ErrNotStored)
+		payments := &stubPayments{results: []error{notStored}}
+		service, now, token := newTestService(t, payments, []time.Duration{24 * time.Hour})
+		plan, _ := service.CreatePlan(testMerchantID, domain.PlanRequest{Amount: 10, Currency: "BRL", Interval: domain.IntervalWeek})
+		sub, err := service.CreateSubscription(testMerchantID, domain.SubscriptionRequest{PlanID: plan.ID, CardToken: token})
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, service.BillDue())
+		sub, _ = service.GetSubscription(testMerchantID, sub.ID)
+		assert.Equal(t, domain.SubscriptionActive, sub.Status)
+		assert.Nil(t, sub.NextRetryAt)
+		assert.Equal(t, now.AddDate(0, 0, 7), sub.NextBillingAt)
+		require.Len(t, sub.Charges, 1)
+		assert.Equal(t, domain.StatusUnknown, sub.Charges[0].Status)
+
+		assert.Equal(t, 0, service.BillDue(), "not charged again")
+		assert.Len(t, payments.requests, 1)
+	})
+
+	t.Run("paused and canceled subscriptions aren't charged", func(t *testing.T) {
+		payments := &stubPayments{}
+		service, _, token := newTestService(t, payments, nil)
+		plan, _ := service.CreatePlan(testMerchantID, domain.PlanRequest{Amount: 10, Currency: "BRL", Interval: domain.IntervalDay})
+		sub, _ := service.CreateSubscription(testMerchantID, domain.SubscriptionRequest{PlanID: plan.ID, CardToken: token})
+
+		sub, err := service.Pause(testMerchantID, sub.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.SubscriptionPaused, sub.Status)
+		assert.Equal(t, 0, service.BillDue())
+
+		sub, err = service.Cancel(testMerchantID, sub.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, sub.CanceledAt)
+		assert.Equal(t, 0, service.BillDue())
+
+		_, err = service.Resume(testMerchantID, sub.ID)
+		assert.ErrorIs(t, err, domain.ErrSubscriptionCanceled)
+		assert.Empty(t, payments.requests)
+	})
+
+	t.Run("periods charged while pausing aren't charged again", func(t *testing.T) {
+		payments := &stubPayments{}
+		service, now, token := newTestService(t, payments, nil)
+		plan, _ := service.CreatePlan(testMerchantID, domain.PlanRequest{Amount: 10, Currency: "BRL", Interval: domain.IntervalMonth})
+		sub, _ := service.CreateSubscription(testMerchantID, domain.SubscriptionRequest{PlanID: plan.ID, CardToken: token})
+		payments.charging = func() {
+			_, err := service.Pause(testMerchantID, sub.ID)
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, 1, service.BillDue())
+		payments.charging = nil
+		sub, err := service.GetSubscription(testMerchantID, sub.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.SubscriptionPaused, sub.Status)
+		assert.Equal(t, time.Date(2024, 2, 29, 10, 0, 0, 0, time.UTC), sub.NextBillingAt)
+
+		*now = now.Add(time.Hour)
+		sub, err = service.Resume(testMerchantID, sub.ID)
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2024, 2, 29, 10, 0, 0, 0, time.UTC), sub.NextBillingAt)
+		assert.Equal(t, 0, service.BillDue())
+		assert.Len(t, payments.requests, 1)
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		service, _, token := newTestService(t, &stubPayments{}, nil)
+		_, err := service.CreatePlan(testMerchantID, domain.PlanRequest{Amount: 10, Currency: "BRL", Interval: "fortnight"})
+		assert.ErrorIs(t, err, domain.ErrInvalidSubscription)
+
+		_, err = service.CreateSubscription(testMerchantID, domain.SubscriptionRequest{PlanID: "missing", CardToken: token})
+		assert.ErrorIs(t, err, domain.ErrInvalidSubscription)
+		assert.ErrorIs(t, err, domain.ErrPlanNotFound)
+
+		plan, _ := service.CreatePlan(testMerchantID, domain.PlanRequest{Amount: 10, Currency: "BRL", Interval: domain.IntervalDay})
+		_, err = service.CreateSubscription("other-merchant", domain.SubscriptionRequest{PlanID: plan.ID, CardToken: token})
+		assert.ErrorIs(t, err, domain.ErrCardNotFound)
+	})
+}
+
+func TestPeriodStart(t *testing.T) {
+	anchor := time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), periodStart(anchor, domain.IntervalMonth, 1, 1))
+	assert.Equal(t, time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC), periodStart(anchor, domain.IntervalMonth, 1, 3))
+	assert.Equal(t, time.Date(2024, 7, 31, 0, 0, 0, 0, time.UTC), periodStart(anchor, domain.IntervalMonth, 3, 2))
+	assert.Equal(t, time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC), periodStart(anchor, domain.IntervalYear, 1, 1))
+	assert.Equal(t, time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC), periodStart(anchor, domain.IntervalWeek, 2, 1))
+}
@@ -0,0 +1,79 @@
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"desafio-api/internal/domain"
+)
+
+// Tokens have the form "card_<random hex>".
+const tokenPrefix = "card_"
+
+// MemoryVault keeps cards in memory, by token, for merchant-initiated
+// charges such as subscriptions. The CVV is dropped when a card is stored.
+type MemoryVault struct {
+	mutex sync.RWMutex
+	cards map[string]*domain.StoredCard
+	now   func() time.Time
+}
+
+func NewMemoryVault() *MemoryVault {
+	return &MemoryVault{cards: make(map[string]*domain.StoredCard), now: time.Now}
+}
+
+func (v *MemoryVault) Store(merchantID string, card domain.Card) (*domain.StoredCard, error) {
+	if card.Fingerprint() == "" {
+		return nil, fmt.Errorf("%w: number is required", domain.ErrInvalidCard)
+	}
+	if card.ExpirationDate == "" {
+		return nil, fmt.Errorf("%w: expirationDate is required", domain.ErrInvalidCard)
+	}
+	card.CVV = ""
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("error generating card token: %w", err)
+	}
+	stored := &domain.StoredCard{
+		Token:          tokenPrefix + hex.EncodeToString(random),
+		MerchantID:     merchantID,
+		Card:           card,
+		Last4:          card.Last4(),
+		HolderName:     card.HolderName,
+		ExpirationDate: card.ExpirationDate,
+		CreatedAt:      v.now(),
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.cards[stored.Token] = stored
+	result := *stored
+	return &result, nil
+}
+
+// Get returns the stored card of a merchant, card data included.
+func (v *MemoryVault) Get(merchantID string, token string) (*domain.StoredCard, error) {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	stored, ok := v.cards[token]
+	if !ok || stored.MerchantID != merchantID {
+		return nil, domain.ErrCardNotFound
+	}
+	result := *stored
+	return &result, nil
+}
+
+func (v *MemoryVault) Delete(merchantID string, token string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	stored, ok := v.cards[token]
+	if !ok || stored.MerchantID != merchantID {
+		return domain.ErrCardNotFound
+	}
+	delete(v.cards, token)
+	return nil
+}
@@ -0,0 +1,44 @@
+package vault
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/internal/domain"
+)
+
+func TestMemoryVault(t *testing.T) {
+	vault := NewMemoryVault()
+
+	stored, err := vault.Store("m1", domain.Card{Number: "4111-1111-1111-1111", HolderName: "Ana", CVV: "123", ExpirationDate: "12/2030"})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Token, "card_"))
+	assert.Equal(t, "1111", stored.Last4)
+
+	t.Run("card data without the cvv", func(t *testing.T) {
+		card, err := vault.Get("m1", stored.Token)
+		require.NoError(t, err)
+		assert.Equal(t, "4111-1111-1111-1111", card.Card.Number)
+		assert.Empty(t, card.Card.CVV)
+	})
+
+	t.Run("cards of other merchants are not found", func(t *testing.T) {
+		_, err := vault.Get("m2", stored.Token)
+		assert.ErrorIs(t, err, domain.ErrCardNotFound)
+		assert.ErrorIs(t, vault.Delete("m2", stored.Token), domain.ErrCardNotFound)
+	})
+
+	t.Run("invalid card", func(t *testing.T) {
+		_, err := vault.Store("m1", domain.Card{Number: "4111111111111111"})
+		assert.ErrorIs(t, err, domain.ErrInvalidCard)
+	})
+
+	t.Run("deleted card", func(t *testing.T) {
+		require.NoError(t, vault.Delete("m1", stored.Token))
+		_, err := vault.Get("m1", stored.Token)
+		assert.ErrorIs(t, err, domain.ErrCardNotFound)
+	})
+}
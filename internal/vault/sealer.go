@@ -0,0 +1,69 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"desafio-api/internal/domain"
+)
+
+// Sealer encrypts cards with AES-256-GCM, for the few places where card
+// data must outlive the vault, such as the journal of queued payments. The
+// CVV is dropped before sealing.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer takes a hex encoded 32 byte key.
+func NewSealer(key string) (*Sealer, error) {
+	raw, err := hex.DecodeString(key)
+	if err != nil || len(raw) != 32 {
+		return nil, errors.New("card sealing key must be 32 bytes, hex encoded")
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error creating card cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating card cipher: %w", err)
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+func (s *Sealer) Seal(card domain.Card) (string, error) {
+	card.CVV = ""
+	plaintext, err := json.Marshal(card)
+	if err != nil {
+		return "", fmt.Errorf("error encoding card: %w", err)
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating nonce: %w", err)
+	}
+	sealed := s.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Sealer) Open(sealed string) (domain.Card, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < s.aead.NonceSize() {
+		return domain.Card{}, fmt.Errorf("%w: malformed sealed card", domain.ErrInvalidCard)
+	}
+	nonce, ciphertext := raw[:s.aead.NonceSize()], raw[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return domain.Card{}, fmt.Errorf("%w: sealed card can't be opened", domain.ErrInvalidCard)
+	}
+	var card domain.Card
+	if err := json.Unmarshal(plaintext, &card); err != nil {
+		return domain.Card{}, fmt.Errorf("%w: sealed card can't be decoded", domain.ErrInvalidCard)
+	}
+	return card, nil
+}
@@ -0,0 +1,45 @@
+package vault
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"desafio-api/internal/domain"
+)
+
+const testKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestSealer(t *testing.T) {
+	sealer, err := NewSealer(testKey)
+	require.NoError(t, err)
+	card := domain.Card{Number: "4111111111111111", HolderName: "Ana", CVV: "123", ExpirationDate: "12/2030"}
+
+	t.Run("sealed card opens without the cvv", func(t *testing.T) {
+		sealed, err := sealer.Seal(card)
+		require.NoError(t, err)
+		assert.NotContains(t, sealed, "4111")
+
+		opened, err := sealer.Open(sealed)
+		require.NoError(t, err)
+		assert.Equal(t, "4111111111111111", opened.Number)
+		assert.Equal(t, "12/2030", opened.ExpirationDate)
+		assert.Empty(t, opened.CVV)
+	})
+
+	t.Run("another key can't open it", func(t *testing.T) {
+		sealed, err := sealer.Seal(card)
+		require.NoError(t, err)
+		other, err := NewSealer(strings.Repeat("ff", 32))
+		require.NoError(t, err)
+		_, err = other.Open(sealed)
+		assert.ErrorIs(t, err, domain.ErrInvalidCard)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		_, err := NewSealer("short")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,104 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// SignatureHeader carries the signature of the webhooks and callbacks the
+// gateway sends and of the webhooks it receives from providers.
+const SignatureHeader = "X-Webhook-Signature"
+
+// Sign returns the signature of a webhook body: the hex encoded
+// HMAC-SHA256 of the body with the secret, prefixed by "sha256=".
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// TimestampHeader carries the time, in Unix seconds, at which a provider
+// sent a webhook signed with SignTimestamped.
+const TimestampHeader = "X-Webhook-Timestamp"
+
+// SignTimestamped returns the signature of a webhook sent at timestamp: as
+// Sign, over the timestamp, a dot and the body, so the timestamp can't be
+// changed to replay the webhook later.
+func SignTimestamped(secret string, timestamp string, body []byte) string {
+	return Sign(secret, append([]byte(timestamp+"."), body...))
+}
+
+var ErrForbiddenAddress = errors.New("address is not public")
+
+// sharedAddressSpace is the carrier-grade NAT range, not covered by
+// net.IP.IsPrivate.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Public reports whether ip can be reached by requests to URLs supplied by
+// merchants: loopback, private, link-local (cloud metadata included),
+// multicast and unspecified addresses can't.
+func Public(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// CheckURL returns an error unless rawURL is an absolute http or https URL
+// whose host resolves to public addresses only.
+func CheckURL(ctx context.Context, rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return errors.New("must be an absolute http or https URL")
+	}
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Hostname())
+	if err != nil {
+		return fmt.Errorf("host %s can't be resolved", parsed.Hostname())
+	}
+	for _, address := range addresses {
+		if !Public(address.IP) {
+			return fmt.Errorf("host %s: %w", parsed.Hostname(), ErrForbiddenAddress)
+		}
+	}
+	return nil
+}
+
+// NewClient returns an HTTP client for URLs supplied by merchants. It
+// checks every address it connects to, after DNS resolution, so a host
+// resolving to an internal address later is still refused, and it doesn't
+// follow redirects.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !Public(ip) {
+				return fmt.Errorf("%s: %w", host, ErrForbiddenAddress)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// A proxy would make the connection checks useless
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
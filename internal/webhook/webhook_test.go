@@ -0,0 +1,43 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublic(t *testing.T) {
+	for _, address := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "fe80::1", "fd00::1", "100.64.0.1", "0.0.0.0", "::ffff:127.0.0.1"} {
+		assert.False(t, Public(net.ParseIP(address)), address)
+	}
+	for _, address := range []string{"203.0.113.10", "8.8.8.8", "2001:4860:4860::8888"} {
+		assert.True(t, Public(net.ParseIP(address)), address)
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	assert.NoError(t, CheckURL(context.Background(), "https://203.0.113.10/callback"))
+	assert.ErrorIs(t, CheckURL(context.Background(), "http://localhost:3001/__admin/scenario"), ErrForbiddenAddress)
+	assert.ErrorIs(t, CheckURL(context.Background(), "http://169.254.169.254/latest/meta-data"), ErrForbiddenAddress)
+	assert.Error(t, CheckURL(context.Background(), "file:///etc/passwd"))
+}
+
+func TestNewClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := NewClient(time.Second).Post(server.URL, "application/json", nil)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrForbiddenAddress)
+}
+
+func TestSign(t *testing.T) {
+	assert.Equal(t, "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", Sign("key", []byte("The quick brown fox jumps over the lazy dog")))
+	assert.Equal(t, Sign("key", []byte("1700000000.{}")), SignTimestamped("key", "1700000000", []byte("{}")))
+}
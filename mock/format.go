@@ -0,0 +1,17 @@
+package mock
+
+import "github.com/gin-gonic/gin"
+
+// wireFormat translates between the HTTP API of a provider and the charge
+// operations of the mock server.
+type wireFormat interface {
+	register(api *gin.RouterGroup)
+	// chargeEndpoint is the route that creates charges.
+	chargeEndpoint() string
+	// standardEndpoint maps a route to its standard format equivalent, so
+	// scenario files written for "/charges" work with every format.
+	standardEndpoint(route string) string
+	// chargeDetails extracts the card number and amount of a charge request
+	// so scenario rules can match on them.
+	chargeDetails(body []byte) (string, float64)
+}
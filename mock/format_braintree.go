@@ -0,0 +1,201 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"desafio-api/internal/providers"
+)
+
+// braintreeFormat emulates a Braintree-like API: JSON requests and responses
+// wrapped in a "transaction" object with decimal string amounts.
+type braintreeFormat struct {
+	server *MockServer
+}
+
+func (f *braintreeFormat) register(api *gin.RouterGroup) {
+	api.POST("/transactions", f.handleCharge)
+	api.GET("/transactions", f.handleSearch)
+	api.POST("/transactions/:id/refund", f.handleRefund)
+	api.GET("/transactions/:id", f.handleGetCharge)
+	api.POST("/transactions/:id/confirm", f.handleConfirm)
+}
+
+func (f *braintreeFormat) chargeEndpoint() string {
+	return "/transactions"
+}
+
+func (f *braintreeFormat) standardEndpoint(route string) string {
+	switch route {
+	case "/transactions":
+		return "/charges"
+	case "/transactions/:id/refund":
+		return "/refund/:id"
+	case "/transactions/:id":
+		return "/charges/:id"
+	case "/transactions/:id/confirm":
+		return "/charges/:id/confirm"
+	default:
+		return route
+	}
+}
+
+func (f *braintreeFormat) chargeDetails(body []byte) (string, float64) {
+	var req providers.BraintreeEnvelope
+	if err := json.Unmarshal(body, &req); err != nil || req.Transaction.CreditCard == nil {
+		return "", 0
+	}
+	amount, _ := strconv.ParseFloat(req.Transaction.Amount, 64)
+	return req.Transaction.CreditCard.Number, amount
+}
+
+func (f *braintreeFormat) handleCharge(c *gin.Context) {
+	var req providers.BraintreeEnvelope
+	if err := c.ShouldBindJSON(&req); err != nil {
+		braintreeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	transaction := req.Transaction
+	amount, err := strconv.ParseFloat(transaction.Amount, 64)
+	if err != nil || amount <= 0 {
+		braintreeError(c, http.StatusUnprocessableEntity, "amount must be a positive decimal")
+		return
+	}
+	if transaction.CreditCard == nil || transaction.CreditCard.Number == "" {
+		braintreeError(c, http.StatusUnprocessableEntity, "creditCard.number is required")
+		return
+	}
+
+	input := chargeInput{
+		Amount:      amount,
+		Currency:    transaction.CurrencyIsoCode,
+		Description: transaction.CustomFields["description"],
+		Reference:   transaction.OrderID,
+		CardNumber:  transaction.CreditCard.Number,
+		Declined:    c.GetBool(declineKey),
+		Host:        c.Request.Host,
+	}
+	if transaction.Type == "credit" {
+		c.JSON(http.StatusOK, toBraintreeEnvelope(f.server.createCredit(input)))
+		return
+	}
+	resp := f.server.createCharge(input)
+	c.JSON(http.StatusOK, toBraintreeEnvelope(resp))
+}
+
+func (f *braintreeFormat) handleRefund(c *gin.Context) {
+	var req providers.BraintreeEnvelope
+	if err := c.ShouldBindJSON(&req); err != nil {
+		braintreeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Like Braintree, a refund without an amount refunds the whole charge
+	var amount float64
+	if req.Transaction.Amount != "" {
+		var err error
+		amount, err = strconv.ParseFloat(req.Transaction.Amount, 64)
+		if err != nil || amount <= 0 {
+			braintreeError(c, http.StatusUnprocessableEntity, "amount must be a positive decimal")
+			return
+		}
+	}
+
+	payment, err := f.server.refundCharge(c.Param("id"), amount)
+	if err != nil {
+		braintreeError(c, errorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toBraintreeEnvelope(payment))
+}
+
+func (f *braintreeFormat) handleConfirm(c *gin.Context) {
+	var req providers.BraintreeEnvelope
+	if err := c.ShouldBindJSON(&req); err != nil {
+		braintreeError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	result := ""
+	if req.Transaction.ThreeDSecureInfo != nil {
+		result = req.Transaction.ThreeDSecureInfo.Result
+	}
+
+	payment, err := f.server.confirmCharge(c.Param("id"), result)
+	if err != nil {
+		braintreeError(c, errorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toBraintreeEnvelope(payment))
+}
+
+func (f *braintreeFormat) handleGetCharge(c *gin.Context) {
+	payment, err := f.server.getCharge(c.Param("id"))
+	if err != nil {
+		braintreeError(c, errorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toBraintreeEnvelope(payment))
+}
+
+// handleSearch only supports searching by orderId.
+func (f *braintreeFormat) handleSearch(c *gin.Context) {
+	result := providers.BraintreeSearchResult{Transactions: []providers.BraintreeTransaction{}}
+	if payment, err := f.server.findCharge(c.Query("orderId")); err == nil {
+		result.Transactions = append(result.Transactions, toBraintreeEnvelope(payment).Transaction)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func braintreeError(c *gin.Context, status int, message string) {
+	c.JSON(status, gin.H{"apiErrorResponse": gin.H{"message": message}})
+}
+
+func toBraintreeEnvelope(payment providers.MockPaymentResponse) providers.BraintreeEnvelope {
+	transaction := providers.BraintreeTransaction{
+		ID:              payment.ID,
+		Type:            "sale",
+		Amount:          strconv.FormatFloat(payment.OriginalAmount, 'f', 2, 64),
+		CurrencyIsoCode: payment.Currency,
+		OrderID:         payment.Reference,
+		CreatedAt:       payment.CreatedAt.Format(time.RFC3339Nano),
+		CreditCard:      &providers.BraintreeCreditCard{Token: payment.CardID},
+	}
+	if payment.Description != "" {
+		transaction.CustomFields = map[string]string{"description": payment.Description}
+	}
+
+	switch payment.Status {
+	case "authorized":
+		transaction.Status = providers.BraintreeAuthorized
+	case "paid":
+		// Only credits are stored as paid
+		transaction.Type = "credit"
+		transaction.Status = "submitted_for_settlement"
+	case "refunded":
+		transaction.Status = providers.BraintreeVoided
+		transaction.RefundedAmount = strconv.FormatFloat(payment.OriginalAmount-payment.CurrentAmount, 'f', 2, 64)
+	case "requires_action":
+		transaction.Status = providers.BraintreeAuthenticationRequired
+	case "declined":
+		transaction.Status = providers.BraintreeProcessorDeclined
+	default:
+		transaction.Status = providers.BraintreeFailed
+	}
+
+	switch {
+	case payment.NextAction != nil:
+		transaction.ThreeDSecureInfo = &providers.BraintreeThreeDSecureInfo{
+			Status: "challenge_required",
+			AcsURL: payment.NextAction.RedirectURL,
+			Lookup: payment.NextAction.Data,
+		}
+	case payment.Authentication == "frictionless":
+		transaction.ThreeDSecureInfo = &providers.BraintreeThreeDSecureInfo{Status: "authenticate_frictionless_successful"}
+	case payment.Authentication == "challenge":
+		transaction.ThreeDSecureInfo = &providers.BraintreeThreeDSecureInfo{Status: "authenticate_successful"}
+	}
+	return providers.BraintreeEnvelope{Transaction: transaction}
+}
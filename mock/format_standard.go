@@ -0,0 +1,129 @@
+package mock
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"desafio-api/internal/domain"
+	"desafio-api/internal/providers"
+)
+
+// standardFormat speaks the JSON API of providers.MockPaymentRequest and
+// providers.MockPaymentResponse.
+type standardFormat struct {
+	server *MockServer
+}
+
+func (f *standardFormat) register(api *gin.RouterGroup) {
+	api.POST("/charges", f.handleCharge)
+	api.GET("/charges", f.handleFindCharge)
+	api.POST("/refund/:id", f.handleRefund)
+	api.GET("/charges/:id", f.handleGetCharge)
+	api.POST("/charges/:id/confirm", f.handleConfirm)
+	api.POST("/credits", f.handleCredit)
+}
+
+func (f *standardFormat) chargeEndpoint() string {
+	return "/charges"
+}
+
+func (f *standardFormat) standardEndpoint(route string) string {
+	return route
+}
+
+func (f *standardFormat) chargeDetails(body []byte) (string, float64) {
+	var req providers.MockPaymentRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", 0
+	}
+	return req.Card.Number, req.Amount
+}
+
+func (f *standardFormat) handleCharge(c *gin.Context) {
+	var req providers.MockPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := f.server.createCharge(chargeInput{
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		Description: req.Description,
+		Reference:   req.Reference,
+		CardNumber:  req.Card.Number,
+		Declined:    c.GetBool(declineKey),
+		Host:        c.Request.Host,
+	})
+	c.JSON(http.StatusOK, resp)
+}
+
+func (f *standardFormat) handleCredit(c *gin.Context) {
+	var req providers.MockPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := f.server.createCredit(chargeInput{
+		Amount:      req.Amount,
+		Currency:    req.Currency,
+		Description: req.Description,
+		Reference:   req.Reference,
+		CardNumber:  req.Card.Number,
+		Declined:    c.GetBool(declineKey),
+	})
+	c.JSON(http.StatusOK, resp)
+}
+
+func (f *standardFormat) handleRefund(c *gin.Context) {
+	var req struct {
+		Amount float64 `json:"amount"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payment, err := f.server.refundCharge(c.Param("id"), req.Amount)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, payment)
+}
+
+func (f *standardFormat) handleConfirm(c *gin.Context) {
+	var req domain.ConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payment, err := f.server.confirmCharge(c.Param("id"), req.AuthenticationResult)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, payment)
+}
+
+func (f *standardFormat) handleGetCharge(c *gin.Context) {
+	payment, err := f.server.getCharge(c.Param("id"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, payment)
+}
+
+func (f *standardFormat) handleFindCharge(c *gin.Context) {
+	payment, err := f.server.findCharge(c.Query("reference"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, payment)
+}
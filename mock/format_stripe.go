@@ -0,0 +1,173 @@
+package mock
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"desafio-api/internal/providers"
+)
+
+// stripeFormat emulates a Stripe-like API: form encoded requests, amounts in
+// cents and charge objects in the responses.
+type stripeFormat struct {
+	server *MockServer
+}
+
+func (f *stripeFormat) register(api *gin.RouterGroup) {
+	api.POST("/v1/charges", f.handleCharge)
+	api.GET("/v1/charges", f.handleListCharges)
+	api.POST("/v1/charges/:id/refund", f.handleRefund)
+	api.GET("/v1/charges/:id", f.handleGetCharge)
+	api.POST("/v1/charges/:id/confirm", f.handleConfirm)
+}
+
+func (f *stripeFormat) chargeEndpoint() string {
+	return "/v1/charges"
+}
+
+func (f *stripeFormat) standardEndpoint(route string) string {
+	switch route {
+	case "/v1/charges":
+		return "/charges"
+	case "/v1/charges/:id/refund":
+		return "/refund/:id"
+	case "/v1/charges/:id":
+		return "/charges/:id"
+	case "/v1/charges/:id/confirm":
+		return "/charges/:id/confirm"
+	default:
+		return route
+	}
+}
+
+func (f *stripeFormat) chargeDetails(body []byte) (string, float64) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return "", 0
+	}
+	amount, _ := strconv.ParseInt(form.Get("amount"), 10, 64)
+	return form.Get("source[number]"), providers.FromMinorUnits(amount)
+}
+
+func (f *stripeFormat) handleCharge(c *gin.Context) {
+	amount, err := strconv.ParseInt(c.PostForm("amount"), 10, 64)
+	if err != nil || amount <= 0 {
+		stripeError(c, http.StatusBadRequest, "invalid_request_error", "amount must be a positive integer in cents")
+		return
+	}
+	if c.PostForm("source[number]") == "" {
+		stripeError(c, http.StatusBadRequest, "invalid_request_error", "source[number] is required")
+		return
+	}
+
+	resp := f.server.createCharge(chargeInput{
+		Amount:      providers.FromMinorUnits(amount),
+		Currency:    strings.ToUpper(c.PostForm("currency")),
+		Description: c.PostForm("description"),
+		Reference:   c.PostForm("metadata[reference]"),
+		CardNumber:  c.PostForm("source[number]"),
+		Declined:    c.GetBool(declineKey),
+		Host:        c.Request.Host,
+	})
+	c.JSON(http.StatusOK, toStripeCharge(resp))
+}
+
+func (f *stripeFormat) handleRefund(c *gin.Context) {
+	// Like Stripe, a refund without an amount refunds the whole charge
+	var amount int64
+	if value, ok := c.GetPostForm("amount"); ok {
+		var err error
+		amount, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || amount <= 0 {
+			stripeError(c, http.StatusBadRequest, "invalid_request_error", "amount must be a positive integer in cents")
+			return
+		}
+	}
+
+	payment, err := f.server.refundCharge(c.Param("id"), providers.FromMinorUnits(amount))
+	if err != nil {
+		stripeError(c, errorStatus(err), "invalid_request_error", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toStripeCharge(payment))
+}
+
+func (f *stripeFormat) handleConfirm(c *gin.Context) {
+	payment, err := f.server.confirmCharge(c.Param("id"), c.PostForm("authentication_result"))
+	if err != nil {
+		stripeError(c, errorStatus(err), "invalid_request_error", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toStripeCharge(payment))
+}
+
+func (f *stripeFormat) handleGetCharge(c *gin.Context) {
+	payment, err := f.server.getCharge(c.Param("id"))
+	if err != nil {
+		stripeError(c, errorStatus(err), "invalid_request_error", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toStripeCharge(payment))
+}
+
+// handleListCharges only supports filtering by reference, which is all the
+// gateway needs to resolve charges with an unknown outcome.
+func (f *stripeFormat) handleListCharges(c *gin.Context) {
+	list := providers.StripeList{Object: "list", Data: []providers.StripeCharge{}}
+	if payment, err := f.server.findCharge(c.Query("reference")); err == nil {
+		list.Data = append(list.Data, toStripeCharge(payment))
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func stripeError(c *gin.Context, status int, errorType, message string) {
+	c.JSON(status, gin.H{"error": gin.H{"type": errorType, "message": message}})
+}
+
+func toStripeCharge(payment providers.MockPaymentResponse) providers.StripeCharge {
+	charge := providers.StripeCharge{
+		ID:             payment.ID,
+		Object:         "charge",
+		Amount:         providers.ToMinorUnits(payment.OriginalAmount),
+		AmountRefunded: providers.ToMinorUnits(payment.OriginalAmount - payment.CurrentAmount),
+		Currency:       strings.ToLower(payment.Currency),
+		Description:    payment.Description,
+		Created:        payment.CreatedAt.Unix(),
+		PaymentMethod:  payment.CardID,
+	}
+	if payment.Reference != "" {
+		charge.Metadata = map[string]string{"reference": payment.Reference}
+	}
+
+	switch payment.Status {
+	case "authorized":
+		charge.Status = "succeeded"
+	case "refunded":
+		charge.Status = "succeeded"
+		charge.Refunded = true
+	case "requires_action":
+		charge.Status = "requires_action"
+	case "declined":
+		charge.Status = "failed"
+		charge.FailureCode = "card_declined"
+	default:
+		charge.Status = "failed"
+		charge.FailureCode = "authentication_failed"
+	}
+
+	if payment.Authentication != "" {
+		charge.ThreeDSecure = &providers.StripeThreeDSecure{AuthenticationFlow: payment.Authentication}
+	}
+	if payment.NextAction != nil {
+		charge.NextAction = &providers.StripeNextAction{
+			Type:          "redirect_to_url",
+			RedirectToURL: &providers.StripeRedirect{URL: payment.NextAction.RedirectURL},
+			UseStripeSDK:  payment.NextAction.Data,
+		}
+	}
+	return charge
+}
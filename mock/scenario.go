@@ -0,0 +1,466 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DistributionFixed   = "fixed"
+	DistributionUniform = "uniform"
+	DistributionNormal  = "normal"
+
+	// declineKey is set in the gin context when a rule declines the charge.
+	declineKey = "mock.decline"
+	// maxTimeout bounds how long a timeout rule holds a request open.
+	maxTimeout = time.Minute
+)
+
+// Scenario is an ordered list of rules applied to every request before it
+// reaches the provider handlers.
+type Scenario struct {
+	Rules []Rule `json:"rules" yaml:"rules" toml:"rules"`
+}
+
+// Rule describes how the mock misbehaves on an endpoint.
+//
+// Endpoint is a route pattern such as "/charges" or "/refund/:id"; empty or
+// "*" matches every endpoint. Method restricts the rule to one HTTP method.
+// Latency is always added. The failure mode (Timeout, LoseResponse,
+// MalformedJSON or StatusCode, in that order) is applied to the
+// next FailNext calls when FailNext is set (the rule is then removed), with
+// probability ErrorRate when ErrorRate is set, and on every call otherwise.
+// Charges whose card number or amount is listed in DeclineCards or
+// DeclineAmounts are declined. LoseResponse processes the request and then
+// hangs like Timeout, as when a response is lost on the way back.
+// RetryAfterSeconds adds a Retry-After header to StatusCode failures.
+type Rule struct {
+	Name              string    `json:"name,omitempty" yaml:"name,omitempty" toml:"name,omitempty"`
+	Endpoint          string    `json:"endpoint,omitempty" yaml:"endpoint,omitempty" toml:"endpoint,omitempty"`
+	Method            string    `json:"method,omitempty" yaml:"method,omitempty" toml:"method,omitempty"`
+	Latency           *Latency  `json:"latency,omitempty" yaml:"latency,omitempty" toml:"latency,omitempty"`
+	ErrorRate         float64   `json:"errorRate,omitempty" yaml:"errorRate,omitempty" toml:"errorRate,omitempty"`
+	StatusCode        int       `json:"statusCode,omitempty" yaml:"statusCode,omitempty" toml:"statusCode,omitempty"`
+	Timeout           bool      `json:"timeout,omitempty" yaml:"timeout,omitempty" toml:"timeout,omitempty"`
+	LoseResponse      bool      `json:"loseResponse,omitempty" yaml:"loseResponse,omitempty" toml:"loseResponse,omitempty"`
+	MalformedJSON     bool      `json:"malformedJson,omitempty" yaml:"malformedJson,omitempty" toml:"malformedJson,omitempty"`
+	DeclineCards      []string  `json:"declineCards,omitempty" yaml:"declineCards,omitempty" toml:"declineCards,omitempty"`
+	DeclineAmounts    []float64 `json:"declineAmounts,omitempty" yaml:"declineAmounts,omitempty" toml:"declineAmounts,omitempty"`
+	FailNext          int       `json:"failNext,omitempty" yaml:"failNext,omitempty" toml:"failNext,omitempty"`
+	RetryAfterSeconds int       `json:"retryAfterSeconds,omitempty" yaml:"retryAfterSeconds,omitempty" toml:"retryAfterSeconds,omitempty"`
+}
+
+// Latency in milliseconds. Fixed uses FixedMs, uniform picks between MinMs
+// and MaxMs, normal uses MeanMs and StdDevMs (never below zero).
+type Latency struct {
+	Distribution string  `json:"distribution" yaml:"distribution" toml:"distribution"`
+	FixedMs      float64 `json:"fixedMs,omitempty" yaml:"fixedMs,omitempty" toml:"fixedMs,omitempty"`
+	MinMs        float64 `json:"minMs,omitempty" yaml:"minMs,omitempty" toml:"minMs,omitempty"`
+	MaxMs        float64 `json:"maxMs,omitempty" yaml:"maxMs,omitempty" toml:"maxMs,omitempty"`
+	MeanMs       float64 `json:"meanMs,omitempty" yaml:"meanMs,omitempty" toml:"meanMs,omitempty"`
+	StdDevMs     float64 `json:"stdDevMs,omitempty" yaml:"stdDevMs,omitempty" toml:"stdDevMs,omitempty"`
+}
+
+func (r Rule) Validate() error {
+	if r.ErrorRate < 0 || r.ErrorRate > 1 {
+		return fmt.Errorf("rule %q: errorRate must be between 0 and 1", r.Name)
+	}
+	if r.StatusCode != 0 && (r.StatusCode < 100 || r.StatusCode > 599) {
+		return fmt.Errorf("rule %q: invalid statusCode %d", r.Name, r.StatusCode)
+	}
+	if r.FailNext < 0 {
+		return fmt.Errorf("rule %q: failNext cannot be negative", r.Name)
+	}
+	if r.Latency != nil {
+		switch r.Latency.Distribution {
+		case DistributionFixed, DistributionUniform, DistributionNormal:
+		default:
+			return fmt.Errorf("rule %q: unknown latency distribution %q", r.Name, r.Latency.Distribution)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the rule applies to a request, whose route is
+// given by its path in the mock's wire format and in the standard format.
+func (r Rule) matches(method, route, standard string) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+	return r.Endpoint == "" || r.Endpoint == "*" || r.Endpoint == route || r.Endpoint == standard
+}
+
+func (r Rule) hasFailure() bool {
+	return r.Timeout || r.LoseResponse || r.MalformedJSON || r.StatusCode != 0 || r.ErrorRate > 0 || r.FailNext > 0
+}
+
+func (r Rule) declines(card string, amount float64) bool {
+	card = strings.NewReplacer(" ", "", "-", "").Replace(card)
+	for _, declined := range r.DeclineCards {
+		if card != "" && declined == card {
+			return true
+		}
+	}
+	for _, declined := range r.DeclineAmounts {
+		if math.Abs(declined-amount) < 0.005 {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Latency) sample(random *rand.Rand) time.Duration {
+	if l == nil {
+		return 0
+	}
+	var ms float64
+	switch l.Distribution {
+	case DistributionFixed:
+		ms = l.FixedMs
+	case DistributionUniform:
+		ms = l.MinMs
+		if l.MaxMs > l.MinMs {
+			ms += random.Float64() * (l.MaxMs - l.MinMs)
+		}
+	case DistributionNormal:
+		ms = random.NormFloat64()*l.StdDevMs + l.MeanMs
+	}
+	if ms < 0 {
+		ms = 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// LoadScenario reads a scenario from a YAML, TOML or JSON file, chosen by
+// the file extension.
+func LoadScenario(path string) (Scenario, error) {
+	var scenario Scenario
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scenario, fmt.Errorf("error reading scenario file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &scenario)
+	case ".toml":
+		err = toml.Unmarshal(data, &scenario)
+	case ".json":
+		err = json.Unmarshal(data, &scenario)
+	default:
+		return scenario, fmt.Errorf("unsupported scenario file format: %s", path)
+	}
+	if err != nil {
+		return scenario, fmt.Errorf("error parsing scenario file: %w", err)
+	}
+
+	for _, rule := range scenario.Rules {
+		if err := rule.Validate(); err != nil {
+			return scenario, err
+		}
+	}
+	return scenario, nil
+}
+
+// outcome is what the scenario decided for a single request.
+type outcome struct {
+	delay        time.Duration
+	timeout      bool
+	loseResponse bool
+	malformed    bool
+	status       int
+	retryAfter   int
+	decline      bool
+}
+
+type scenarioEngine struct {
+	mutex  sync.Mutex
+	rules  []Rule
+	random *rand.Rand
+}
+
+func newScenarioEngine() *scenarioEngine {
+	return &scenarioEngine{random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (e *scenarioEngine) set(scenario Scenario) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.rules = append([]Rule(nil), scenario.Rules...)
+}
+
+func (e *scenarioEngine) add(rule Rule) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.rules = append(e.rules, rule)
+}
+
+func (e *scenarioEngine) remove(name string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	rules := e.rules[:0]
+	for _, rule := range e.rules {
+		if rule.Name != name {
+			rules = append(rules, rule)
+		}
+	}
+	e.rules = rules
+}
+
+func (e *scenarioEngine) get() Scenario {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return Scenario{Rules: append([]Rule(nil), e.rules...)}
+}
+
+func (e *scenarioEngine) needsBody(method, route, standard string) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, rule := range e.rules {
+		if rule.matches(method, route, standard) && (len(rule.DeclineCards) > 0 || len(rule.DeclineAmounts) > 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *scenarioEngine) evaluate(method, route, standard string, card string, amount float64) outcome {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var result outcome
+	failed := false
+	rules := e.rules[:0]
+	for _, rule := range e.rules {
+		if !rule.matches(method, route, standard) {
+			rules = append(rules, rule)
+			continue
+		}
+
+		result.delay += rule.Latency.sample(e.random)
+		if rule.declines(card, amount) {
+			result.decline = true
+		}
+
+		countdown := rule.FailNext > 0
+		if !failed && rule.hasFailure() && e.triggers(&rule) {
+			failed = true
+			result.timeout = rule.Timeout
+			result.loseResponse = rule.LoseResponse
+			result.malformed = rule.MalformedJSON
+			result.status = rule.StatusCode
+			result.retryAfter = rule.RetryAfterSeconds
+			if result.status == 0 && !rule.Timeout && !rule.LoseResponse && !rule.MalformedJSON {
+				result.status = http.StatusServiceUnavailable
+			}
+		}
+
+		// Rules failing the next N calls are dropped once used up
+		if countdown && rule.FailNext == 0 {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	e.rules = rules
+	return result
+}
+
+// triggers decides whether the rule's failure mode applies to this call,
+// consuming one of the FailNext calls.
+func (e *scenarioEngine) triggers(rule *Rule) bool {
+	switch {
+	case rule.FailNext > 0:
+		rule.FailNext--
+		return true
+	case rule.ErrorRate > 0:
+		return e.random.Float64() < rule.ErrorRate
+	default:
+		return rule.Timeout || rule.LoseResponse || rule.MalformedJSON || rule.StatusCode != 0
+	}
+}
+
+// applyScenario is the middleware that runs the scenario in front of the
+// provider endpoints.
+func (s *MockServer) applyScenario(c *gin.Context) {
+	route := c.FullPath()
+	standard := s.format.standardEndpoint(route)
+
+	var card string
+	var amount float64
+	if c.Request.Body != nil && s.scenario.needsBody(c.Request.Method, route, standard) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		card, amount = s.format.chargeDetails(body)
+	}
+
+	result := s.scenario.evaluate(c.Request.Method, route, standard, card, amount)
+
+	if result.delay > 0 {
+		select {
+		case <-time.After(result.delay):
+		case <-c.Request.Context().Done():
+			c.Abort()
+			return
+		}
+	}
+
+	switch {
+	case result.timeout:
+		// The server only notices the client hanging up once the body was read
+		if c.Request.Body != nil {
+			io.Copy(io.Discard, c.Request.Body)
+		}
+		select {
+		case <-time.After(maxTimeout):
+		case <-c.Request.Context().Done():
+		}
+		c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "timeout"})
+	case result.loseResponse:
+		if result.decline {
+			c.Set(declineKey, true)
+		}
+		// Run the handler without sending its response, then hang
+		writer := c.Writer
+		c.Writer = &discardWriter{ResponseWriter: writer}
+		c.Next()
+		c.Writer = writer
+		select {
+		case <-time.After(maxTimeout):
+		case <-c.Request.Context().Done():
+		}
+		c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "timeout"})
+	case result.malformed:
+		c.Data(http.StatusOK, "application/json", []byte(`{"id": "`))
+		c.Abort()
+	case result.status != 0:
+		if result.retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(result.retryAfter))
+		}
+		c.AbortWithStatusJSON(result.status, gin.H{"error": http.StatusText(result.status)})
+	default:
+		if result.decline {
+			c.Set(declineKey, true)
+		}
+		c.Next()
+	}
+}
+
+// discardWriter swallows the response of a handler.
+type discardWriter struct {
+	gin.ResponseWriter
+}
+
+func (w *discardWriter) WriteHeader(int)                      {}
+func (w *discardWriter) WriteHeaderNow()                      {}
+func (w *discardWriter) Write(data []byte) (int, error)       { return len(data), nil }
+func (w *discardWriter) WriteString(data string) (int, error) { return len(data), nil }
+func (w *discardWriter) Written() bool                        { return false }
+
+func (s *MockServer) setupAdminRoutes() {
+	admin := s.router.Group("/__admin")
+	admin.GET("/scenario", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.scenario.get())
+	})
+	admin.PUT("/scenario", func(c *gin.Context) {
+		var scenario Scenario
+		if err := c.ShouldBindJSON(&scenario); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := s.SetScenario(scenario); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, s.scenario.get())
+	})
+	admin.DELETE("/scenario", func(c *gin.Context) {
+		s.ResetScenario()
+		c.Status(http.StatusNoContent)
+	})
+	admin.POST("/scenario/rules", func(c *gin.Context) {
+		var rule Rule
+		if err := c.ShouldBindJSON(&rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := s.AddRule(rule); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, s.scenario.get())
+	})
+	admin.DELETE("/scenario/rules/:name", func(c *gin.Context) {
+		s.scenario.remove(c.Param("name"))
+		c.Status(http.StatusNoContent)
+	})
+	admin.POST("/fail-next", func(c *gin.Context) {
+		var req struct {
+			Endpoint   string `json:"endpoint"`
+			Count      int    `json:"count" binding:"required,min=1"`
+			StatusCode int    `json:"statusCode"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := s.FailNext(req.Endpoint, req.Count, req.StatusCode); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, s.scenario.get())
+	})
+}
+
+// SetScenario replaces every rule of the running scenario.
+func (s *MockServer) SetScenario(scenario Scenario) error {
+	for _, rule := range scenario.Rules {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	s.scenario.set(scenario)
+	return nil
+}
+
+func (s *MockServer) AddRule(rule Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	s.scenario.add(rule)
+	return nil
+}
+
+func (s *MockServer) ResetScenario() {
+	s.scenario.set(Scenario{})
+}
+
+func (s *MockServer) Scenario() Scenario {
+	return s.scenario.get()
+}
+
+// FailNext makes the next count calls to the endpoint fail with the status
+// code (503 when zero).
+func (s *MockServer) FailNext(endpoint string, count int, statusCode int) error {
+	return s.AddRule(Rule{
+		Name:       "fail-next",
+		Endpoint:   endpoint,
+		StatusCode: statusCode,
+		FailNext:   count,
+	})
+}
@@ -0,0 +1,163 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"desafio-api/internal/providers"
+)
+
+func charge(server *MockServer, request providers.MockPaymentRequest) *httptest.ResponseRecorder {
+	jsonData, _ := json.Marshal(request)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/charges", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestScenario(t *testing.T) {
+	request := providers.MockPaymentRequest{Amount: 10, Currency: "BRL"}
+
+	t.Run("fail next N calls", func(t *testing.T) {
+		server := NewMockServer()
+		assert.NoError(t, server.FailNext("/charges", 2, http.StatusBadGateway))
+
+		assert.Equal(t, http.StatusBadGateway, charge(server, request).Code)
+		assert.Equal(t, http.StatusBadGateway, charge(server, request).Code)
+		assert.Equal(t, http.StatusOK, charge(server, request).Code)
+		assert.Empty(t, server.Scenario().Rules)
+	})
+
+	t.Run("rules only apply to their endpoint", func(t *testing.T) {
+		server := NewMockServer()
+		assert.NoError(t, server.AddRule(Rule{Endpoint: "/refund/:id", StatusCode: http.StatusInternalServerError}))
+
+		assert.Equal(t, http.StatusOK, charge(server, request).Code)
+	})
+
+	t.Run("malformed json", func(t *testing.T) {
+		server := NewMockServer()
+		assert.NoError(t, server.AddRule(Rule{Endpoint: "/charges", MalformedJSON: true}))
+
+		w := charge(server, request)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response providers.MockPaymentResponse
+		assert.Error(t, json.Unmarshal(w.Body.Bytes(), &response))
+	})
+
+	t.Run("fixed latency", func(t *testing.T) {
+		server := NewMockServer()
+		assert.NoError(t, server.AddRule(Rule{Latency: &Latency{Distribution: DistributionFixed, FixedMs: 50}}))
+
+		start := time.Now()
+		assert.Equal(t, http.StatusOK, charge(server, request).Code)
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("declines by card and amount", func(t *testing.T) {
+		server := NewMockServer()
+		assert.NoError(t, server.AddRule(Rule{
+			Endpoint:       "/charges",
+			DeclineCards:   []string{"4000000000009995"},
+			DeclineAmounts: []float64{666.66},
+		}))
+
+		var response providers.MockPaymentResponse
+		declinedCard := request
+		declinedCard.Card.Number = "4000 0000 0000 9995"
+		json.Unmarshal(charge(server, declinedCard).Body.Bytes(), &response)
+		assert.Equal(t, "declined", response.Status)
+
+		declinedAmount := request
+		declinedAmount.Amount = 666.66
+		json.Unmarshal(charge(server, declinedAmount).Body.Bytes(), &response)
+		assert.Equal(t, "declined", response.Status)
+
+		json.Unmarshal(charge(server, request).Body.Bytes(), &response)
+		assert.Equal(t, "authorized", response.Status)
+	})
+
+	t.Run("standard endpoints match other formats", func(t *testing.T) {
+		server, err := NewMockServerWithFormat(providers.FormatStripe)
+		assert.NoError(t, err)
+		assert.NoError(t, server.AddRule(Rule{Endpoint: "/charges", DeclineAmounts: []float64{666.66}}))
+
+		form := url.Values{"amount": {"66666"}, "currency": {"brl"}, "source[number]": {"4111111111111111"}}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/v1/charges", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		server.router.ServeHTTP(w, req)
+
+		var response providers.StripeCharge
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "failed", response.Status)
+		assert.Equal(t, "card_declined", response.FailureCode)
+	})
+
+	t.Run("timeout ends when the client gives up", func(t *testing.T) {
+		server := NewMockServer()
+		assert.NoError(t, server.AddRule(Rule{Endpoint: "/charges", Timeout: true}))
+
+		httpServer := httptest.NewServer(server.router)
+		defer httpServer.Close()
+
+		client := &http.Client{Timeout: 100 * time.Millisecond}
+		_, err := client.Post(httpServer.URL+"/charges", "application/json", bytes.NewBufferString(`{"amount":10}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("admin api replaces and resets the scenario", func(t *testing.T) {
+		server := NewMockServer()
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/__admin/scenario", bytes.NewBufferString(`{"rules":[{"name":"down","endpoint":"/charges","statusCode":500}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, http.StatusInternalServerError, charge(server, request).Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("PUT", "/__admin/scenario", bytes.NewBufferString(`{"rules":[{"errorRate":2}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("DELETE", "/__admin/scenario", nil)
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, http.StatusOK, charge(server, request).Code)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/__admin/fail-next", bytes.NewBufferString(`{"endpoint":"/charges","count":1}`))
+		req.Header.Set("Content-Type", "application/json")
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, http.StatusServiceUnavailable, charge(server, request).Code)
+		assert.Equal(t, http.StatusOK, charge(server, request).Code)
+	})
+
+	t.Run("load scenario files", func(t *testing.T) {
+		for _, file := range []string{"scenarios/degraded.yaml", "scenarios/outage.toml"} {
+			scenario, err := LoadScenario(file)
+			assert.NoError(t, err, file)
+			assert.NotEmpty(t, scenario.Rules, file)
+		}
+
+		path := filepath.Join(t.TempDir(), "scenario.json")
+		os.WriteFile(path, []byte(`{"rules":[{"latency":{"distribution":"poisson"}}]}`), 0o600)
+		_, err := LoadScenario(path)
+		assert.Error(t, err)
+	})
+}
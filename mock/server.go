@@ -1,38 +1,98 @@
 package mock
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"desafio-api/internal/domain"
 	"desafio-api/internal/providers"
 )
 
+// Card numbers that emulate declines and 3-D Secure outcomes, following the
+// test cards used by most acquirers. Any other card is authorized without
+// 3-D Secure unless a scenario rule says otherwise.
+const (
+	CardDeclined            = "4000000000000002"
+	CardThreeDSChallenge    = "4000000000003220"
+	CardThreeDSFrictionless = "4000000000003055"
+)
+
+// ChallengeResponseFail makes a confirm call fail the authentication.
+const ChallengeResponseFail = "fail"
+
+var (
+	errPaymentNotFound = errors.New("payment not found")
+	errNoActionNeeded  = errors.New("payment does not require action")
+)
+
+// Payments are stored in the standard format and translated by the wire
+// format on the way in and out.
 type MockServer struct {
-	router      *gin.Engine
-	payments    map[string]providers.MockPaymentResponse
-	mutex       sync.RWMutex
-	failureMode bool
+	router     *gin.Engine
+	payments   map[string]providers.MockPaymentResponse
+	references map[string]string
+	mutex      sync.RWMutex
+	scenario   *scenarioEngine
+	format     wireFormat
+}
+
+// chargeInput is a charge request decoded from any wire format.
+type chargeInput struct {
+	Amount      float64
+	Currency    string
+	Description string
+	Reference   string
+	CardNumber  string
+	Declined    bool
+	Host        string
 }
 
 func NewMockServer() *MockServer {
+	server, _ := NewMockServerWithFormat(providers.FormatStandard)
+	return server
+}
+
+// NewMockServerWithFormat starts a mock that speaks the wire format of one
+// of the provider transformers: "standard", "stripe" or "braintree".
+func NewMockServerWithFormat(format string) (*MockServer, error) {
 	server := &MockServer{
-		router:      gin.Default(),
-		payments:    make(map[string]providers.MockPaymentResponse),
-		failureMode: false,
+		router:     gin.Default(),
+		payments:   make(map[string]providers.MockPaymentResponse),
+		references: make(map[string]string),
+		scenario:   newScenarioEngine(),
 	}
+
+	switch format {
+	case providers.FormatStandard, "":
+		server.format = &standardFormat{server: server}
+	case providers.FormatStripe:
+		server.format = &stripeFormat{server: server}
+	case providers.FormatBraintree:
+		server.format = &braintreeFormat{server: server}
+	default:
+		return nil, fmt.Errorf("unknown mock format: %s", format)
+	}
+
 	server.setupRoutes()
-	return server
+	server.setupAdminRoutes()
+	return server, nil
 }
 
 func (s *MockServer) setupRoutes() {
-	s.router.POST("/charges", s.handleCharge)
-	s.router.POST("/refund/:id", s.handleRefund)
-	s.router.GET("/charges/:id", s.handleGetCharge)
+	api := s.router.Group("/", s.applyScenario)
+	api.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	s.format.register(api)
+	s.router.GET("/3ds/:id", s.handleChallenge)
 }
 
 func (s *MockServer) Run(addr string) error {
@@ -40,19 +100,16 @@ func (s *MockServer) Run(addr string) error {
 	return s.router.Run(addr)
 }
 
-func (s *MockServer) handleCharge(c *gin.Context) {
-	s.mutex.RLock()
-	if s.failureMode {
-		s.mutex.RUnlock()
-		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "service unavailable"})
-		return
-	}
-	s.mutex.RUnlock()
+// Handler exposes the router so the mock can be served by httptest.
+func (s *MockServer) Handler() http.Handler {
+	return s.router
+}
 
-	var req providers.MockPaymentRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+// createCharge is idempotent on the reference: charging it again returns
+// the existing charge, like the idempotency keys of real providers.
+func (s *MockServer) createCharge(input chargeInput) providers.MockPaymentResponse {
+	if existing, err := s.findCharge(input.Reference); err == nil {
+		return existing
 	}
 
 	// Simulate processing delay
@@ -63,67 +120,183 @@ func (s *MockServer) handleCharge(c *gin.Context) {
 		ID:             uuid.New().String(),
 		CreatedAt:      time.Now(),
 		Status:         "authorized",
-		OriginalAmount: req.Amount,
-		CurrentAmount:  req.Amount,
-		Currency:       req.Currency,
-		Description:    req.Description,
+		OriginalAmount: input.Amount,
+		CurrentAmount:  input.Amount,
+		Currency:       input.Currency,
+		Description:    input.Description,
+		Reference:      input.Reference,
 		PaymentMethod:  "card",
 		CardID:         uuid.New().String(),
 	}
 
+	cardNumber := strings.NewReplacer(" ", "", "-", "").Replace(input.CardNumber)
+	if input.Declined || cardNumber == CardDeclined {
+		resp.Status = "declined"
+	}
+
+	switch cardNumber {
+	case CardThreeDSChallenge:
+		if resp.Status == "declined" {
+			break
+		}
+		resp.Status = "requires_action"
+		resp.NextAction = &domain.NextAction{
+			Type:        domain.NextActionRedirect,
+			RedirectURL: fmt.Sprintf("http://%s/3ds/%s", input.Host, resp.ID),
+			Data:        map[string]string{"acsTransactionId": uuid.New().String()},
+		}
+	case CardThreeDSFrictionless:
+		resp.Authentication = "frictionless"
+	}
+
 	// Store payment
 	s.mutex.Lock()
 	s.payments[resp.ID] = resp
+	if resp.Reference != "" {
+		s.references[resp.Reference] = resp.ID
+	}
 	s.mutex.Unlock()
 
-	c.JSON(http.StatusOK, resp)
+	return resp
 }
 
-func (s *MockServer) handleRefund(c *gin.Context) {
-	id := c.Param("id")
-	var req struct {
-		Amount float64 `json:"amount"`
+// createCredit sends money to a card. Credits are idempotent on the
+// reference like charges, and only the declined test card fails them.
+func (s *MockServer) createCredit(input chargeInput) providers.MockPaymentResponse {
+	if existing, err := s.findCharge(input.Reference); err == nil {
+		return existing
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+
+	resp := providers.MockPaymentResponse{
+		ID:             uuid.New().String(),
+		CreatedAt:      time.Now(),
+		Status:         "paid",
+		OriginalAmount: input.Amount,
+		CurrentAmount:  input.Amount,
+		Currency:       input.Currency,
+		Description:    input.Description,
+		Reference:      input.Reference,
+		PaymentMethod:  "card",
+		CardID:         uuid.New().String(),
+	}
+	cardNumber := strings.NewReplacer(" ", "", "-", "").Replace(input.CardNumber)
+	if input.Declined || cardNumber == CardDeclined {
+		resp.Status = "declined"
 	}
 
 	s.mutex.Lock()
+	s.payments[resp.ID] = resp
+	if resp.Reference != "" {
+		s.references[resp.Reference] = resp.ID
+	}
+	s.mutex.Unlock()
+
+	return resp
+}
+
+func (s *MockServer) refundCharge(id string, amount float64) (providers.MockPaymentResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	payment, exists := s.payments[id]
 	if !exists {
-		s.mutex.Unlock()
-		c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
-		return
+		return payment, errPaymentNotFound
 	}
 
+	// Without an amount the whole payment is refunded
+	if amount <= 0 {
+		amount = payment.CurrentAmount
+	}
 	// Update payment status and amount
 	payment.Status = "refunded"
-	payment.CurrentAmount -= req.Amount
+	payment.CurrentAmount -= amount
 	s.payments[id] = payment
-	s.mutex.Unlock()
-
-	c.JSON(http.StatusOK, payment)
+	return payment, nil
 }
 
-func (s *MockServer) handleGetCharge(c *gin.Context) {
-	id := c.Param("id")
+func (s *MockServer) confirmCharge(id string, authenticationResult string) (providers.MockPaymentResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	s.mutex.RLock()
 	payment, exists := s.payments[id]
-	s.mutex.RUnlock()
+	if !exists {
+		return payment, errPaymentNotFound
+	}
+	if payment.Status != "requires_action" {
+		return payment, errNoActionNeeded
+	}
+
+	payment.NextAction = nil
+	payment.Authentication = "challenge"
+	if authenticationResult == ChallengeResponseFail {
+		payment.Status = "failed"
+	} else {
+		payment.Status = "authorized"
+	}
+	s.payments[id] = payment
+	return payment, nil
+}
+
+func (s *MockServer) getCharge(id string) (providers.MockPaymentResponse, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
+	payment, exists := s.payments[id]
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+		return payment, errPaymentNotFound
+	}
+	return payment, nil
+}
+
+func (s *MockServer) findCharge(reference string) (providers.MockPaymentResponse, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	id, exists := s.references[reference]
+	if reference == "" || !exists {
+		return providers.MockPaymentResponse{}, errPaymentNotFound
+	}
+	return s.payments[id], nil
+}
+
+// errorStatus maps the errors of the charge operations to HTTP statuses.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, errPaymentNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errNoActionNeeded):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleChallenge stands in for the issuer's authentication page.
+func (s *MockServer) handleChallenge(c *gin.Context) {
+	id := c.Param("id")
+
+	if _, err := s.getCharge(id); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, payment)
+	c.JSON(http.StatusOK, gin.H{
+		"paymentId": id,
+		"message":   "challenge completed, confirm the payment with any authenticationResult other than \"" + ChallengeResponseFail + "\"",
+	})
 }
 
-// SimulateFailure allows controlling the mock server's behavior for testing
+// SimulateFailure allows controlling the mock server's behavior for testing.
+// While enabled every charge fails with 503.
 func (s *MockServer) SimulateFailure(enabled bool) {
-	s.mutex.Lock()
-	s.failureMode = enabled
-	s.mutex.Unlock()
+	s.scenario.remove(simulateFailureRule)
+	if enabled {
+		s.scenario.add(Rule{
+			Name:       simulateFailureRule,
+			Endpoint:   s.format.chargeEndpoint(),
+			StatusCode: http.StatusServiceUnavailable,
+		})
+	}
 }
+
+const simulateFailureRule = "simulate-failure"
@@ -122,6 +122,63 @@ func TestMockServer(t *testing.T) {
 		assert.Equal(t, payment.CurrentAmount, retrievedPayment.CurrentAmount)
 	})
 
+	t.Run("3-D Secure challenge and confirm", func(t *testing.T) {
+		request := providers.MockPaymentRequest{
+			Amount:   gofakeit.Price(10, 1000),
+			Currency: "EUR",
+		}
+		request.Card.Number = CardThreeDSChallenge
+
+		w := httptest.NewRecorder()
+		jsonData, _ := json.Marshal(request)
+		req, _ := http.NewRequest("POST", "/charges", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		server.router.ServeHTTP(w, req)
+
+		var payment providers.MockPaymentResponse
+		json.Unmarshal(w.Body.Bytes(), &payment)
+		assert.Equal(t, "requires_action", payment.Status)
+		assert.NotNil(t, payment.NextAction)
+		assert.Contains(t, payment.NextAction.RedirectURL, "/3ds/"+payment.ID)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/charges/"+payment.ID+"/confirm", bytes.NewBufferString(`{"authenticationResult":"ok"}`))
+		req.Header.Set("Content-Type", "application/json")
+		server.router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var confirmed providers.MockPaymentResponse
+		json.Unmarshal(w.Body.Bytes(), &confirmed)
+		assert.Equal(t, "authorized", confirmed.Status)
+		assert.Equal(t, "challenge", confirmed.Authentication)
+		assert.Nil(t, confirmed.NextAction)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/charges/"+payment.ID+"/confirm", bytes.NewBufferString(`{"authenticationResult":"ok"}`))
+		req.Header.Set("Content-Type", "application/json")
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("3-D Secure frictionless", func(t *testing.T) {
+		request := providers.MockPaymentRequest{
+			Amount:   gofakeit.Price(10, 1000),
+			Currency: "EUR",
+		}
+		request.Card.Number = CardThreeDSFrictionless
+
+		w := httptest.NewRecorder()
+		jsonData, _ := json.Marshal(request)
+		req, _ := http.NewRequest("POST", "/charges", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		server.router.ServeHTTP(w, req)
+
+		var payment providers.MockPaymentResponse
+		json.Unmarshal(w.Body.Bytes(), &payment)
+		assert.Equal(t, "authorized", payment.Status)
+		assert.Equal(t, "frictionless", payment.Authentication)
+	})
+
 	t.Run("simulate failure", func(t *testing.T) {
 		server.SimulateFailure(true)
 		defer server.SimulateFailure(false)